@@ -2,6 +2,15 @@ module proxy_server
 
 go 1.23.0
 
-require github.com/cloudwego/netpoll v0.6.4
+require (
+	github.com/cloudwego/netpoll v0.6.4
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.21.0
+	golang.org/x/sys v0.31.0
+)
 
-require github.com/bytedance/gopkg v0.1.0 // indirect
+require (
+	github.com/bytedance/gopkg v0.1.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+)
@@ -0,0 +1,75 @@
+package proxyserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	accessLogFile   *os.File
+	accessLogPath   string
+	accessLogOpened time.Time // Lúc mở file hiện tại, tính chu kỳ xoay
+	accessLogMutex  sync.Mutex
+)
+
+// Mở file access log
+func openAccessLog(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	accessLogMutex.Lock()
+	accessLogFile = file
+	accessLogPath = filePath
+	accessLogOpened = time.Now()
+	accessLogMutex.Unlock()
+	return nil
+}
+
+// Ghi một dòng access log khi tunnel kết thúc và đếm lý do kết thúc
+func logAccess(user *User, client, listener, dest, session string, unmetered bool, up, down int64, started time.Time, reason string) {
+	countCloseReason(reason)
+
+	username, owner := "-", ""
+	var tags map[string]string
+	if user != nil {
+		username, owner, tags = user.Username, user.Owner, user.Tags
+	}
+	duration := time.Since(started)
+	record := AccessRecord{
+		Time: time.Now(), Username: username, Client: client, Listener: listener, Dest: dest, Session: session,
+		Up: up, Down: down, DurationMs: duration.Milliseconds(), Reason: reason, Unmetered: unmetered, Tags: tags, owner: owner,
+	}
+	publishAccess(record)
+	queueAnalytics(record)
+
+	line := fmt.Sprintf("access user=%q client=%s dest=%s up=%d down=%d duration=%s reason=%s",
+		username, client, dest, up, down, duration.Round(time.Millisecond), reason)
+	if listener != "" {
+		line += " listener=" + listener
+	}
+	if session != "" {
+		line += " session=" + session
+	}
+	if unmetered {
+		line += " unmetered"
+	}
+	if len(tags) > 0 {
+		line += " tags=" + formatTags(tags)
+	}
+
+	accessLogMutex.Lock()
+	defer accessLogMutex.Unlock()
+
+	if accessLogFile == nil {
+		log.Print(line)
+		return
+	}
+	if _, err := fmt.Fprintf(accessLogFile, "%s %s\n", time.Now().Format(time.RFC3339), line); err != nil {
+		log.Printf("Access log write error: %v", err)
+	}
+}
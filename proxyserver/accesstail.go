@@ -0,0 +1,160 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Theo dõi access log trực tiếp qua admin API (server-sent events)
+const (
+	accessTailBuffer    = 256
+	accessTailHeartbeat = 15 * time.Second
+	maxAccessTails      = 32
+)
+
+// Một dòng access log gửi tới người theo dõi
+type AccessRecord struct {
+	Time       time.Time         `json:"time"`
+	Username   string            `json:"user"`
+	Client     string            `json:"client"`
+	Listener   string            `json:"listener,omitempty"`
+	Dest       string            `json:"dest"`
+	Session    string            `json:"session,omitempty"`
+	Up         int64             `json:"up"`
+	Down       int64             `json:"down"`
+	DurationMs int64             `json:"duration_ms"`
+	Reason     string            `json:"reason"`
+	Unmetered  bool              `json:"unmetered,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"` // Tag của user
+	owner      string
+}
+
+// Một người theo dõi và bộ lọc của họ
+type accessTail struct {
+	records chan AccessRecord
+	dropped atomic.Int64 // Số dòng bị bỏ vì người theo dõi đọc không kịp
+	match   func(AccessRecord) bool
+}
+
+var (
+	accessTails      = make(map[*accessTail]struct{})
+	accessTailsMutex sync.Mutex
+	accessTailCount  atomic.Int32
+)
+
+// Gửi dòng access log tới những người theo dõi có bộ lọc khớp; không bao giờ chặn tunnel
+func publishAccess(record AccessRecord) {
+	if accessTailCount.Load() == 0 {
+		return
+	}
+	accessTailsMutex.Lock()
+	defer accessTailsMutex.Unlock()
+
+	for tail := range accessTails {
+		if !tail.match(record) {
+			continue
+		}
+		select {
+		case tail.records <- record:
+		default:
+			tail.dropped.Add(1)
+		}
+	}
+}
+
+func addAccessTail(tail *accessTail) bool {
+	accessTailsMutex.Lock()
+	defer accessTailsMutex.Unlock()
+
+	if len(accessTails) >= maxAccessTails {
+		return false
+	}
+	accessTails[tail] = struct{}{}
+	accessTailCount.Store(int32(len(accessTails)))
+	return true
+}
+
+func removeAccessTail(tail *accessTail) {
+	accessTailsMutex.Lock()
+	delete(accessTails, tail)
+	accessTailCount.Store(int32(len(accessTails)))
+	accessTailsMutex.Unlock()
+}
+
+// GET /api/access/tail?user=&dest=: stream các dòng access log mới dạng server-sent events.
+// dest lọc theo chuỗi con của đích (không phân biệt hoa thường); reseller chỉ thấy user của mình
+func handleAdminAccessTail(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.URL.Query().Get("user")
+	dest := strings.ToLower(r.URL.Query().Get("dest"))
+
+	if username != "" {
+		usersMutex.RLock()
+		user, exists := users[username]
+		usersMutex.RUnlock()
+		if !exists || !canAccessUser(token, user) {
+			admin.WriteError(w, http.StatusNotFound, "user not found")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		admin.WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	tail := &accessTail{
+		records: make(chan AccessRecord, accessTailBuffer),
+		match: func(record AccessRecord) bool {
+			switch {
+			case username != "" && record.Username != username:
+				return false
+			case token.Role == admin.RoleReseller && record.owner != token.Scope:
+				return false
+			case dest != "" && !strings.Contains(strings.ToLower(record.Dest), dest):
+				return false
+			}
+			return true
+		},
+	}
+	if !addAccessTail(tail) {
+		admin.WriteError(w, http.StatusServiceUnavailable, "too many access log streams")
+		return
+	}
+	defer removeAccessTail(tail)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Tắt buffer của nginx khi admin API đứng sau reverse proxy
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": streaming access log\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(accessTailHeartbeat)
+	defer heartbeat.Stop()
+	var reportedDrops int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case record := <-tail.records:
+			data, _ := json.Marshal(record)
+			fmt.Fprintf(w, "event: access\ndata: %s\n\n", data)
+		}
+		// Báo số dòng bị bỏ để người theo dõi biết luồng không đầy đủ
+		if dropped := tail.dropped.Load(); dropped != reportedDrops {
+			fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped-reportedDrops)
+			reportedDrops = dropped
+		}
+		flusher.Flush()
+	}
+}
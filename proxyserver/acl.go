@@ -0,0 +1,363 @@
+package proxyserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Hành động của quy tắc ACL và acl_default
+const (
+	aclAllow     = "allow"
+	aclDeny      = "deny"
+	aclUnmetered = "unmetered" // Không chặn, chỉ đánh dấu đích không tính vào quota của user
+)
+
+var errACLDenied = errors.New("destination denied by ACL")
+
+// Tập port của một mục ACL; all = mọi port
+type portSet struct {
+	all    bool
+	ranges []portRange
+}
+
+func (s *portSet) add(ranges []portRange) {
+	if len(ranges) == 0 {
+		s.all, s.ranges = true, nil
+		return
+	}
+	if !s.all {
+		s.ranges = append(s.ranges, ranges...)
+	}
+}
+
+func (s *portSet) contains(port int) bool {
+	if s == nil {
+		return false
+	}
+	if s.all {
+		return true
+	}
+	for _, r := range s.ranges {
+		if port >= r.low && port <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// Cây hậu tố domain theo nhãn từ phải sang trái: "*.ads.example.com" nằm ở nút com -> example -> ads
+type domainTrie struct {
+	children map[string]*domainTrie
+	ports    *portSet // Quy tắc *.<hậu tố của nút này>
+}
+
+func (t *domainTrie) insert(suffix string, ranges []portRange) {
+	node := t
+	labels := strings.Split(suffix, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if node.children == nil {
+			node.children = make(map[string]*domainTrie)
+		}
+		child := node.children[labels[i]]
+		if child == nil {
+			child = &domainTrie{}
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+	if node.ports == nil {
+		node.ports = &portSet{}
+	}
+	node.ports.add(ranges)
+}
+
+// Host là subdomain của một hậu tố trong cây với port khớp; mỗi lần tra đi qua tối đa số nhãn của host
+func (t *domainTrie) match(host string, port int) bool {
+	node := t
+	for end := len(host); end > 0; {
+		start := strings.LastIndexByte(host[:end], '.') + 1
+		node = node.children[host[start:end]]
+		if node == nil {
+			return false
+		}
+		// Còn nhãn ở bên trái: host là subdomain của hậu tố tại nút này
+		if start > 0 && node.ports.contains(port) {
+			return true
+		}
+		end = start - 1
+	}
+	return false
+}
+
+// Cây nhị phân theo bit địa chỉ IP, mỗi dải CIDR là một nút ở độ sâu bằng độ dài prefix
+type ipTrie struct {
+	children [2]*ipTrie
+	ports    *portSet
+}
+
+func (t *ipTrie) insert(ip net.IP, prefix int, ranges []portRange) {
+	node := t
+	for i := 0; i < prefix; i++ {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrie{}
+		}
+		node = node.children[bit]
+	}
+	if node.ports == nil {
+		node.ports = &portSet{}
+	}
+	node.ports.add(ranges)
+}
+
+func (t *ipTrie) match(ip net.IP, port int) bool {
+	node := t
+	for i := 0; ; i++ {
+		if node.ports.contains(port) {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		node = node.children[ip[i/8]>>(7-i%8)&1]
+		if node == nil {
+			return false
+		}
+	}
+}
+
+// Các quy tắc cùng một hành động
+type aclMatcher struct {
+	exact  map[string]*portSet
+	suffix domainTrie
+	any    *portSet // Mẫu *
+	ipv4   ipTrie
+	ipv6   ipTrie
+}
+
+func (m *aclMatcher) add(pattern string, ranges []portRange) error {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	switch {
+	case pattern == "*":
+		if m.any == nil {
+			m.any = &portSet{}
+		}
+		m.any.add(ranges)
+	case strings.HasPrefix(pattern, "*."):
+		if strings.Contains(pattern[2:], "*") || pattern == "*." {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+		m.suffix.insert(pattern[2:], ranges)
+	case strings.Contains(pattern, "/"):
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", pattern)
+		}
+		prefix, _ := network.Mask.Size()
+		if ip4 := network.IP.To4(); ip4 != nil {
+			m.ipv4.insert(ip4, prefix, ranges)
+		} else {
+			m.ipv6.insert(network.IP, prefix, ranges)
+		}
+	case strings.Contains(pattern, "*") || pattern == "":
+		return fmt.Errorf("invalid pattern %q", pattern)
+	default:
+		if ip := net.ParseIP(pattern); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				m.ipv4.insert(ip4, 32, ranges)
+			} else {
+				m.ipv6.insert(ip, 128, ranges)
+			}
+			return nil
+		}
+		if m.exact == nil {
+			m.exact = make(map[string]*portSet)
+		}
+		if m.exact[pattern] == nil {
+			m.exact[pattern] = &portSet{}
+		}
+		m.exact[pattern].add(ranges)
+	}
+	return nil
+}
+
+// Host (domain hoặc IP, đã chuẩn hóa chữ thường) và port khớp một quy tắc
+func (m *aclMatcher) match(host string, port int) bool {
+	if m.any.contains(port) {
+		return true
+	}
+	if ip := hostIP(host); ip != nil {
+		return m.matchIP(ip, port)
+	}
+	return m.exact[host].contains(port) || m.suffix.match(host, port)
+}
+
+func (m *aclMatcher) matchIP(ip net.IP, port int) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		return m.ipv4.match(ip4, port)
+	}
+	return m.ipv6.match(ip.To16(), port)
+}
+
+// Bộ quy tắc của acl_file
+type aclRules struct {
+	allow     aclMatcher
+	deny      aclMatcher
+	unmetered aclMatcher
+}
+
+var (
+	aclCurrent atomic.Pointer[aclRules]
+	aclDenied  atomic.Int64 // Số yêu cầu CONNECT bị ACL từ chối
+
+	unmeteredBytes atomic.Int64 // Số byte tới các đích unmetered
+)
+
+// Đọc file ACL, mỗi dòng "allow|deny|unmetered <mẫu> [port,dải port]"; dòng trống và dòng bắt đầu bằng # được bỏ qua
+func parseACL(r io.Reader) (*aclRules, error) {
+	rules := &aclRules{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected \"allow|deny|unmetered <pattern> [ports]\"", lineNumber)
+		}
+		var ranges []portRange
+		if len(fields) == 3 {
+			var err error
+			if ranges, err = parsePortRanges(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+		}
+		var matcher *aclMatcher
+		switch fields[0] {
+		case aclAllow:
+			matcher = &rules.allow
+		case aclDeny:
+			matcher = &rules.deny
+		case aclUnmetered:
+			matcher = &rules.unmetered
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", lineNumber, fields[0])
+		}
+		if err := matcher.add(fields[1], ranges); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// Nạp lại acl_file; file lỗi thì giữ bộ quy tắc đang dùng
+func reloadACL() error {
+	rules, err := loadACL(systemConfig().ACLFile)
+	if err != nil {
+		return err
+	}
+	aclCurrent.Store(rules)
+	return nil
+}
+
+// Đọc acl_file, nil khi path rỗng
+func loadACL(path string) (*aclRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rules, err := parseACL(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+func splitDest(dest string) (string, int, bool) {
+	host, portText, err := net.SplitHostPort(dest)
+	if err != nil {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.TrimSuffix(strings.ToLower(host), "."), port, true
+}
+
+// Kiểm tra đích client yêu cầu: quy tắc allow thắng deny, không khớp quy tắc nào thì theo acl_default
+func checkACL(dest string) error {
+	rules := aclCurrent.Load()
+	if rules == nil {
+		return nil
+	}
+	host, port, ok := splitDest(dest)
+	if !ok {
+		return nil
+	}
+	if rules.allow.match(host, port) {
+		return nil
+	}
+	if rules.deny.match(host, port) || systemConfig().ACLDefault == aclDeny {
+		aclDenied.Add(1)
+		return errACLDenied
+	}
+	return nil
+}
+
+// Đích dạng domain: kiểm tra thêm IP đã phân giải với các quy tắc IP/CIDR deny,
+// trừ khi domain được cho phép rõ ràng bằng quy tắc allow. Qua upstream proxy thì IP do upstream phân giải
+func checkACLResolved(dest string, target net.Conn) error {
+	rules := aclCurrent.Load()
+	if rules == nil || upstreamsEnabled() {
+		return nil
+	}
+	host, port, ok := splitDest(dest)
+	if !ok || hostIP(host) != nil || rules.allow.match(host, port) {
+		return nil
+	}
+	addr, ok := target.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if rules.deny.matchIP(addr.IP, port) && !rules.allow.matchIP(addr.IP, port) {
+		aclDenied.Add(1)
+		return errACLDenied
+	}
+	return nil
+}
+
+// Đích khớp quy tắc unmetered theo host client yêu cầu hoặc IP đã kết nối (khi không qua upstream proxy).
+// Dữ liệu tới các đích này không bị giới hạn theo max_bandwidth và không cộng vào dữ liệu đã dùng của user
+func unmeteredDest(dest string, target net.Conn) bool {
+	rules := aclCurrent.Load()
+	if rules == nil {
+		return false
+	}
+	host, port, ok := splitDest(dest)
+	if !ok {
+		return false
+	}
+	if rules.unmetered.match(host, port) {
+		return true
+	}
+	if upstreamsEnabled() {
+		return false
+	}
+	addr, ok := target.RemoteAddr().(*net.TCPAddr)
+	return ok && rules.unmetered.matchIP(addr.IP, port)
+}
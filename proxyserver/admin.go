@@ -0,0 +1,858 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Giới hạn kích thước request tới admin API (import user có giới hạn riêng)
+const (
+	maxAdminHeader = 64 << 10
+	maxAdminBody   = 1 << 20
+)
+
+// Dữ liệu user trả về qua admin API (không bao gồm password)
+type userView struct {
+	Username         string            `json:"username"`
+	StartDate        string            `json:"start_date"`
+	EndDate          string            `json:"end_date"`
+	ConnectionLimit  int               `json:"connection_limit"`
+	MaxData          int64             `json:"max_data"`
+	MaxBandwidth     int64             `json:"max_bandwidth"`
+	CurrentDataUsage int64             `json:"current_data_usage"`
+	CurrentConns     int               `json:"current_conns"`
+	Owner            string            `json:"owner,omitempty"`
+	Group            string            `json:"group,omitempty"`
+	MaxTransfer      int64             `json:"max_transfer,omitempty"`
+	Email            string            `json:"email,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+	Suspended        bool              `json:"suspended,omitempty"`
+	SuspendReason    string            `json:"suspend_reason,omitempty"`
+	LastLogin        *LastLogin        `json:"last_login,omitempty"`
+}
+
+// Dữ liệu nhận vào khi tạo/sửa user
+type userRequest struct {
+	Username        string            `json:"username"`
+	Password        string            `json:"password"`
+	StartDate       string            `json:"start_date"`
+	EndDate         string            `json:"end_date"`
+	ConnectionLimit int               `json:"connection_limit"`
+	MaxData         int64             `json:"max_data"`
+	MaxBandwidth    int64             `json:"max_bandwidth"`
+	Owner           string            `json:"owner"`
+	Group           string            `json:"group"`
+	MaxTransfer     int64             `json:"max_transfer"`
+	Email           string            `json:"email"`
+	Tags            map[string]string `json:"tags"`
+}
+
+func newUserView(user *User) userView {
+	return userView{
+		Username:         user.Username,
+		StartDate:        user.StartDate.Format("2006-01-02"),
+		EndDate:          user.EndDate.Format("2006-01-02"),
+		ConnectionLimit:  user.ConnectionLimit,
+		MaxData:          user.MaxData,
+		MaxBandwidth:     user.MaxBandwidth,
+		CurrentDataUsage: user.dataUsage(),
+		CurrentConns:     user.activeConns(),
+		Owner:            user.Owner,
+		Group:            user.Group,
+		MaxTransfer:      user.MaxTransfer,
+		Email:            user.Email,
+		Tags:             user.Tags,
+		Suspended:        user.isSuspended(),
+		SuspendReason:    user.suspendReason(),
+		LastLogin:        lastLoginOf(user.Username),
+	}
+}
+
+func startAdminServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", withToken(nil, handleAdminStatus))
+	mux.HandleFunc("GET /api/users", withToken(nil, handleAdminListUsers))
+	mux.HandleFunc("GET /api/users/{username}", withToken(nil, handleAdminGetUser))
+	mux.HandleFunc("POST /api/users", withToken((*admin.Token).CanManageUsers, handleAdminCreateUser))
+	mux.HandleFunc("POST /api/users/import", withToken((*admin.Token).CanManageUsers, handleAdminImportUsers))
+	mux.HandleFunc("PUT /api/users/{username}", withToken((*admin.Token).CanManageUsers, handleAdminUpdateUser))
+	mux.HandleFunc("DELETE /api/users/{username}", withToken((*admin.Token).CanManageUsers, handleAdminDeleteUser))
+	mux.HandleFunc("POST /api/provision/accounts", withToken((*admin.Token).CanManageUsers, withIdempotency(handleProvisionCreate)))
+	mux.HandleFunc("POST /api/provision/accounts/{username}/suspend", withToken((*admin.Token).CanManageUsers, withIdempotency(handleProvisionSuspend)))
+	mux.HandleFunc("POST /api/provision/accounts/{username}/unsuspend", withToken((*admin.Token).CanManageUsers, withIdempotency(handleProvisionUnsuspend)))
+	mux.HandleFunc("POST /api/provision/accounts/{username}/terminate", withToken((*admin.Token).CanManageUsers, withIdempotency(handleProvisionTerminate)))
+	mux.HandleFunc("GET /api/provision/accounts/{username}/usage", withToken(nil, handleProvisionUsage))
+	mux.HandleFunc("GET /api/provision/usage", withToken(nil, handleProvisionUsageList))
+	mux.HandleFunc("POST /api/webhooks/stripe", handleStripeWebhook)
+	mux.HandleFunc("POST /api/reload", withToken((*admin.Token).CanManageSystem, handleAdminReload))
+	mux.HandleFunc("POST /api/secrets/refresh", withToken((*admin.Token).CanManageSystem, handleAdminRefreshSecrets))
+	mux.HandleFunc("GET /api/audit", withToken((*admin.Token).CanManageSystem, handleAdminAudit))
+
+	mux.HandleFunc("GET /api/captures", withToken((*admin.Token).CanManageSystem, handleAdminListCaptures))
+	mux.HandleFunc("POST /api/captures", withToken((*admin.Token).CanManageSystem, handleAdminCreateCapture))
+	mux.HandleFunc("DELETE /api/captures/{id}", withToken((*admin.Token).CanManageSystem, handleAdminDeleteCapture))
+	mux.HandleFunc("GET /api/stats/destinations", withToken(nil, handleAdminDestinationStats))
+	mux.HandleFunc("GET /api/stats/top", withToken(nil, handleAdminTopTalkers))
+	mux.HandleFunc("GET /api/egress", withToken(nil, handleAdminEgress))
+	mux.HandleFunc("GET /api/sessions", withToken(nil, handleAdminSessions))
+	mux.HandleFunc("GET /api/connections", withToken(nil, handleAdminConnections))
+	mux.HandleFunc("DELETE /api/connections/{id}", withToken((*admin.Token).CanManageUsers, handleAdminCloseConnection))
+	mux.HandleFunc("GET /api/access/tail", withToken(nil, handleAdminAccessTail))
+	mux.HandleFunc("GET /api/upstreams", withToken(nil, handleAdminUpstreams))
+	mux.HandleFunc("GET /api/probe", withToken((*admin.Token).CanManageSystem, handleAdminProbe))
+	mux.HandleFunc("GET /api/maintenance", withToken(nil, handleAdminMaintenance))
+	mux.HandleFunc("PUT /api/maintenance", withToken((*admin.Token).CanManageSystem, handleAdminSetMaintenance))
+	mux.HandleFunc("GET /api/sharing", withToken(nil, handleAdminSharing))
+	mux.HandleFunc("GET /api/cluster", withToken(nil, handleAdminCluster))
+	mux.HandleFunc("GET /api/cluster/users", withToken(nil, handleAdminClusterUsers))
+	mux.HandleFunc("GET /api/agent", withToken(nil, handleAdminAgent))
+	mux.HandleFunc("GET /api/agents", withToken(nil, handleAdminAgents))
+	mux.HandleFunc("GET /api/agent/v1/bundle", withToken((*admin.Token).CanServeAgents, handleAgentBundle))
+	mux.HandleFunc("POST /api/agent/v1/usage", withToken((*admin.Token).CanServeAgents, handleAgentUsage))
+	mux.HandleFunc("GET /api/alerts", withToken(nil, handleAdminAlerts))
+	mux.HandleFunc("DELETE /api/sharing/{username}", withToken((*admin.Token).CanManageUsers, handleAdminResetSharing))
+	mux.HandleFunc("GET /api/listeners", withToken(nil, handleAdminListListeners))
+	mux.HandleFunc("POST /api/listeners", withToken((*admin.Token).CanManageSystem, handleAdminStartListener))
+	mux.HandleFunc("DELETE /api/listeners/{address}", withToken((*admin.Token).CanManageSystem, handleAdminStopListener))
+	mux.HandleFunc("GET /metrics", withToken(nil, handleMetrics))
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("POST /api/config/apply", withToken((*admin.Token).CanManageSystem, handleAdminConfigApply))
+	mux.HandleFunc("POST /api/upgrade", withToken((*admin.Token).CanManageSystem, handleAdminUpgrade))
+	mux.HandleFunc("GET /api/backups", withToken((*admin.Token).CanManageSystem, handleAdminListBackups))
+	mux.HandleFunc("POST /api/backups", withToken((*admin.Token).CanManageSystem, handleAdminCreateBackup))
+
+	listener, err := listenTCP(listenerAdmin, addr)
+	if err != nil {
+		log.Printf("Admin API error: %v", err)
+		return
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, MaxHeaderBytes: maxAdminHeader}
+
+	useTLS := systemConfig().AdminTLSCert != "" || systemConfig().AdminTLSACME
+	if !useTLS {
+		if systemConfig().AdminClientCA != "" {
+			log.Printf("Admin API: admin_client_ca requires TLS (admin_tls_cert/admin_tls_key or admin_tls_acme), API not started")
+			closeListener(addr)
+			return
+		}
+		log.Printf("Admin API started on %s", addr)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Admin API error: %v", err)
+		}
+		return
+	}
+
+	tlsConfig, err := adminTLSConfig()
+	if err != nil {
+		log.Printf("Admin API TLS error: %v", err)
+		closeListener(addr)
+		return
+	}
+	server.TLSConfig = tlsConfig
+
+	log.Printf("Admin API started on %s (TLS, client certificates required: %t)", addr, systemConfig().AdminClientCA != "")
+	if err := server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Admin API error: %v", err)
+	}
+}
+
+// Cấu hình TLS cho admin API, bắt buộc chứng chỉ client nếu có CA bundle
+func adminTLSConfig() (*tls.Config, error) {
+	tlsConfig, err := listenerTLSConfig(systemConfig().AdminTLSCert, systemConfig().AdminTLSKey, systemConfig().AdminTLSACME)
+	if err != nil {
+		return nil, err
+	}
+	if systemConfig().AdminClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	caPEM, err := os.ReadFile(systemConfig().AdminClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", systemConfig().AdminClientCA)
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+// Xác thực token, kiểm tra rate limit và quyền trước khi gọi handler
+func withToken(permitted func(*admin.Token) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if wait := adminAuthBlocked(ip); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			admin.WriteError(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+			return
+		}
+
+		value, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			logAuthFailure(r.RemoteAddr, "admin", "", AuthMissingToken)
+			recordAdminAuthFailure(ip)
+			waitAuthFailure(started)
+			admin.WriteError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		token, exists := lookupAPIToken(value)
+		if !exists {
+			logAuthFailure(r.RemoteAddr, "admin", "", AuthInvalidToken)
+			recordAdminAuthFailure(ip)
+			waitAuthFailure(started)
+			admin.WriteError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		if !token.Allow() {
+			admin.WriteError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		// Token agent không đọc được các API còn lại
+		if (permitted != nil && !permitted(token)) || (permitted == nil && token.Role == admin.RoleAgent) {
+			admin.WriteError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+
+		ctx := admin.WithToken(r.Context(), token)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, currentServerStatus())
+}
+
+func handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	list := make([]userView, 0, len(users))
+	for _, user := range users {
+		if canAccessUser(token, user) {
+			list = append(list, newUserView(user))
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, list)
+}
+
+func handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	user, exists := users[r.PathValue("username")]
+	if !exists || !canAccessUser(token, user) {
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, newUserView(user))
+}
+
+// Chuyển dữ liệu request thành User, reseller luôn bị gán Owner là scope của token
+func (req *userRequest) toUser(token *admin.Token) (*User, error) {
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, err
+	}
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := req.Owner
+	if token.Role == admin.RoleReseller {
+		owner = token.Scope
+	}
+
+	return &User{
+		Username:        req.Username,
+		Password:        req.Password,
+		StartDate:       startDate,
+		EndDate:         endDate,
+		ConnectionLimit: req.ConnectionLimit,
+		MaxData:         req.MaxData,
+		MaxBandwidth:    req.MaxBandwidth,
+		Owner:           owner,
+		Group:           req.Group,
+		MaxTransfer:     req.MaxTransfer,
+		Email:           req.Email,
+		Tags:            req.Tags,
+		state:           new(userState),
+	}, nil
+}
+
+func handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	var req userRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Username == "" || req.Password == "" || strings.Contains(req.Username, ",") || strings.ContainsAny(req.Password, "\r\n") {
+		admin.WriteError(w, http.StatusBadRequest, "invalid username or password")
+		return
+	}
+	if !validUserEmail(req.Email) {
+		admin.WriteError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+	if err := validateTags(req.Tags); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := req.toUser(token)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid date, expected YYYY-MM-DD")
+		return
+	}
+
+	usersMutex.Lock()
+	if _, exists := users[user.Username]; exists {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusConflict, "user already exists")
+		return
+	}
+	users[user.Username] = user
+	queueUserEmail(emailAccountCreated, user, user.Password)
+	view := newUserView(user)
+	usersMutex.Unlock()
+
+	recordAudit(token.Name, "user.create", user.Username, nil, view)
+	log.Printf("Admin API: user %s created by token %s", user.Username, token.Name)
+	if writeBackFailed(w, user.Username) {
+		return
+	}
+	admin.WriteJSON(w, http.StatusCreated, view)
+}
+
+// Nhập nhiều user từ body CSV hoặc JSON; các dòng hợp lệ được thêm cùng lúc, trừ khi ?dry_run=true
+func handleAdminImportUsers(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBody))
+	if err != nil {
+		admin.WriteError(w, http.StatusRequestEntityTooLarge, "import body too large")
+		return
+	}
+	rows, err := readImportRows(data)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	owner := ""
+	if token.Role == admin.RoleReseller {
+		owner = token.Scope
+	}
+
+	usersMutex.Lock()
+	valid, report := validateImport(rows, func(name string) bool {
+		_, exists := users[name]
+		return exists
+	}, owner)
+	if !dryRun && len(valid) > 0 {
+		for _, user := range valid {
+			users[user.Username] = user
+			queueUserEmail(emailAccountCreated, user, user.Password)
+		}
+		report.Applied = true
+	}
+	usersMutex.Unlock()
+
+	if report.Applied {
+		recordAudit(token.Name, "user.import", fmt.Sprintf("%d users", len(valid)), nil, report)
+		log.Printf("Admin API: %d users imported by token %s", len(valid), token.Name)
+		if writeBackFailed(w, report.Imported...) {
+			return
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, report)
+}
+
+func handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.PathValue("username")
+
+	var req userRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Username = username
+
+	usersMutex.Lock()
+	existing, exists := users[username]
+	if !exists || !canAccessUser(token, existing) {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if req.Password == "" {
+		req.Password = existing.Password
+	}
+	if strings.ContainsAny(req.Password, "\r\n") {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusBadRequest, "invalid password")
+		return
+	}
+	if !validUserEmail(req.Email) {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+	if err := validateTags(req.Tags); err != nil {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := req.toUser(token)
+	if err != nil {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusBadRequest, "invalid date, expected YYYY-MM-DD")
+		return
+	}
+
+	// Giữ lại các bộ đếm đang chạy và trạng thái khóa của user
+	user.keepState(existing)
+	users[username] = user
+	old, view := newUserView(existing), newUserView(user)
+	usersMutex.Unlock()
+
+	recordAudit(token.Name, "user.update", username, old, view)
+	log.Printf("Admin API: user %s updated by token %s", username, token.Name)
+	if writeBackFailed(w, username) {
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, view)
+}
+
+func handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.PathValue("username")
+
+	usersMutex.Lock()
+	user, exists := users[username]
+	if !exists || !canAccessUser(token, user) {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	delete(users, username)
+	view := newUserView(user)
+	usersMutex.Unlock()
+
+	recordAudit(token.Name, "user.delete", username, view, nil)
+	log.Printf("Admin API: user %s deleted by token %s", username, token.Name)
+	if writeBackFailed(w, username) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	// Kiểm tra cả hai file trước khi thay cấu hình hoặc danh sách user
+	newConfig, newUsers, err := readConfigFiles()
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	usersMutex.Lock()
+	oldConfig := systemConfig()
+	replaceUsers(newUsers)
+	setSystemConfig(newConfig)
+	usersMutex.Unlock()
+	log.Println("User list reloaded successfully.")
+
+	syncMaintenanceConfig(*oldConfig, newConfig)
+
+	// Chỉ ghi các khóa thay đổi, secret đã được ẩn như config apply
+	changes, _ := diffSystemConfig(*oldConfig, newConfig)
+	recordAudit(token.Name, "config.reload", systemFile, nil, changes)
+	log.Printf("Admin API: configuration reloaded by token %s", token.Name)
+	admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// Kiểm tra system.conf/users.conf trên đĩa, trả về khác biệt và áp dụng nếu không có ?dry_run=true
+func handleAdminConfigApply(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	newConfig, newUsers, err := readConfigFiles()
+	if err != nil {
+		admin.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	diff, err := applyConfig(newConfig, newUsers, dryRun)
+	if err != nil {
+		admin.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if diff.Applied {
+		recordAudit(token.Name, "config.apply", systemFile+","+userFile, nil, diff)
+		log.Printf("Admin API: configuration applied by token %s", token.Name)
+	}
+	admin.WriteJSON(w, http.StatusOK, diff)
+}
+
+// Thống kê độ trễ và lỗi kết nối theo đích: ?sort=failures|failure_rate|latency|attempts&limit=
+func handleAdminDestinationStats(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	admin.WriteJSON(w, http.StatusOK, destinationReports(r.URL.Query().Get("sort"), limit))
+}
+
+// Top đích theo byte hoặc số kết nối: ?by=bytes|connections&user=&limit=&window=<phút>.
+// Reseller chỉ thấy lưu lượng của các user mình sở hữu
+func handleAdminTopTalkers(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	query := r.URL.Query()
+
+	by := query.Get("by")
+	if by != "" && by != "bytes" && by != "connections" {
+		admin.WriteError(w, http.StatusBadRequest, "invalid by, expected bytes or connections")
+		return
+	}
+	limit := 20
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	window := topTalkersWindow()
+	if value := query.Get("window"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 1 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid window")
+			return
+		}
+		window = min(window, time.Duration(minutes)*time.Minute)
+	}
+
+	var include func(string) bool
+	if username := query.Get("user"); username != "" {
+		usersMutex.RLock()
+		user, exists := users[username]
+		usersMutex.RUnlock()
+		if !exists || !canAccessUser(token, user) {
+			admin.WriteError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		include = func(name string) bool { return name == username }
+	} else if token.Role == admin.RoleReseller {
+		owned := make(map[string]bool)
+		usersMutex.RLock()
+		for name, user := range users {
+			if canAccessUser(token, user) {
+				owned[name] = true
+			}
+		}
+		usersMutex.RUnlock()
+		include = func(name string) bool { return owned[name] }
+	}
+	admin.WriteJSON(w, http.StatusOK, topTalkers(window, by, limit, include))
+}
+
+// Danh sách phiên, lọc theo ?user=; reseller chỉ thấy phiên của user mình sở hữu
+func handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	list := sessionStatuses(r.URL.Query().Get("user"))
+
+	usersMutex.RLock()
+	visible := list[:0]
+	for _, session := range list {
+		if user, exists := users[session.Username]; exists && canAccessUser(token, user) {
+			visible = append(visible, session)
+		}
+	}
+	usersMutex.RUnlock()
+	admin.WriteJSON(w, http.StatusOK, visible)
+}
+
+// Tunnel đang mở, lọc theo ?user=&client=<IP hoặc CIDR>&dest=<chuỗi con>&listener=&min_age=<giây>,
+// sắp theo ?sort=age|bytes|idle, tối đa ?limit= (mặc định 1000, 0 = tất cả). Reseller chỉ thấy tunnel của user mình
+func handleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	query := r.URL.Query()
+
+	filter, sortBy, err := parseTunnelQuery(query)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 1000
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	if token.Role == admin.RoleReseller {
+		filter.Include = func(user *User) bool { return canAccessUser(token, user) }
+	}
+
+	list := tunnelStatuses(filter, sortBy)
+	total := len(list)
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]any{"total": total, "connections": list})
+}
+
+// Đóng một tunnel theo ID
+func handleAdminCloseConnection(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	var tunnel *activeTunnel
+	if err == nil {
+		tunnel = findTunnel(id)
+	}
+	if tunnel != nil && token.Role == admin.RoleReseller {
+		usersMutex.RLock()
+		var user *User
+		if tunnel.user != nil {
+			user = users[tunnel.user.Username]
+		}
+		if user == nil || !canAccessUser(token, user) {
+			tunnel = nil
+		}
+		usersMutex.RUnlock()
+	}
+	if tunnel == nil {
+		admin.WriteError(w, http.StatusNotFound, "connection not found")
+		return
+	}
+
+	status := tunnel.status(time.Now())
+	tunnel.close(CloseAdminKick)
+	recordAudit(token.Name, "connection.close", strconv.FormatUint(id, 10), nil, status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminSharing(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	list := sharingStatuses(r.URL.Query().Get("user"))
+
+	usersMutex.RLock()
+	visible := list[:0]
+	for _, status := range list {
+		if user, exists := users[status.Username]; exists && canAccessUser(token, user) {
+			visible = append(visible, status)
+		}
+	}
+	usersMutex.RUnlock()
+	admin.WriteJSON(w, http.StatusOK, visible)
+}
+
+// Gỡ khóa chia sẻ tài khoản và xóa các mạng client đã ghi nhận của user
+func handleAdminResetSharing(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.PathValue("username")
+
+	usersMutex.RLock()
+	user, exists := users[username]
+	allowed := exists && canAccessUser(token, user)
+	usersMutex.RUnlock()
+	if !allowed || !resetSharing(username) {
+		admin.WriteError(w, http.StatusNotFound, "no sharing state for this user")
+		return
+	}
+
+	recordAudit(token.Name, "sharing.reset", username, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminEgress(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, egressStatuses())
+}
+
+func handleAdminUpstreams(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, upstreamStatuses())
+}
+
+func handleAdminListListeners(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, listenerStatuses())
+}
+
+// Mở listener: {"address": "0.0.0.0:1081", "protocol": "socks" hoặc "compress"}
+func handleAdminStartListener(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	var req struct {
+		Address  string `json:"address"`
+		Protocol string `json:"protocol"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Protocol == "" {
+		req.Protocol = listenerSocks
+	}
+	if _, _, err := net.SplitHostPort(req.Address); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "address must be host:port")
+		return
+	}
+	if req.Protocol != listenerSocks && req.Protocol != listenerCompress {
+		admin.WriteError(w, http.StatusBadRequest, "protocol must be socks or compress")
+		return
+	}
+	err := startInstanceRetrying(req.Protocol, "tcp", req.Address)
+	if err != nil && !errors.Is(err, errListenerRetrying) {
+		admin.WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	recordAudit(token.Name, "listener.start", req.Address, nil, req.Protocol)
+	log.Printf("Admin API: %s listener %s started by token %s", req.Protocol, req.Address, token.Name)
+	if err != nil {
+		// Địa chỉ đang bị chiếm: listener được mở khi địa chỉ được giải phóng
+		admin.WriteJSON(w, http.StatusAccepted, map[string]string{"status": listenerStateRetrying, "error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Dừng listener SOCKS hoặc SOCKS nén; listener của admin API và TLS offload không dừng được ở đây
+func handleAdminStopListener(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	address := r.PathValue("address")
+	if err := stopInstance(address); err != nil {
+		admin.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	recordAudit(token.Name, "listener.stop", address, nil, nil)
+	log.Printf("Admin API: listener %s stopped by token %s", address, token.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminListCaptures(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, listCaptureRules())
+}
+
+// Tạo quy tắc capture: {"username": "...", "connections": N, "max_bytes": 0, "expires_in": giây}
+func handleAdminCreateCapture(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	var req struct {
+		Username    string `json:"username"`
+		Connections int    `json:"connections"`
+		MaxBytes    int64  `json:"max_bytes"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Username == "" || req.Connections <= 0 || req.MaxBytes < 0 {
+		admin.WriteError(w, http.StatusBadRequest, "username and a positive connections count are required")
+		return
+	}
+	if req.ExpiresIn <= 0 {
+		req.ExpiresIn = 3600
+	}
+
+	rule := addCaptureRule(req.Username, req.Connections, req.MaxBytes, time.Duration(req.ExpiresIn)*time.Second, token.Name)
+	recordAudit(token.Name, "capture.create", req.Username, nil, rule)
+	log.Printf("Admin API: capture %d for user %s created by token %s", rule.ID, req.Username, token.Name)
+	admin.WriteJSON(w, http.StatusCreated, rule)
+}
+
+func handleAdminDeleteCapture(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || !removeCaptureRule(id) {
+		admin.WriteError(w, http.StatusNotFound, "capture not found")
+		return
+	}
+
+	recordAudit(token.Name, "capture.delete", r.PathValue("id"), nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Khởi chạy binary mới (nâng cấp nóng), process hiện tại sẽ drain và thoát
+func handleAdminUpgrade(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	if err := performUpgrade(); err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	recordAudit(token.Name, "server.upgrade", "", nil, nil)
+	log.Printf("Admin API: upgrade started by token %s", token.Name)
+	admin.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "upgrading"})
+}
+
+// Truy vấn audit log: ?actor=&action=&target=&since=RFC3339&limit=
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, "invalid since, expected RFC3339")
+			return
+		}
+		since = parsed
+	}
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	admin.WriteJSON(w, http.StatusOK, queryAudit(query.Get("actor"), query.Get("action"), query.Get("target"), since, limit))
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+type tokenContextKey struct{}
+
+// Gắn token đã xác thực vào context của request
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// Token đã xác thực của request, chỉ gọi trong handler đã qua bước xác thực
+func RequestToken(r *http.Request) *Token {
+	return r.Context().Value(tokenContextKey{}).(*Token)
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,140 @@
+// Package admin chứa phần không phụ thuộc trạng thái của admin API: token và vai trò, đọc file token,
+// và các helper trả lời JSON. Các handler nằm trong server vì chúng đọc và sửa user, cấu hình, listener
+package admin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Các vai trò của token admin API
+const (
+	RoleReadOnly  = "read-only"
+	RoleUserAdmin = "user-admin"
+	RoleFullAdmin = "full-admin"
+	RoleReseller  = "reseller"
+	RoleAgent     = "agent" // Chỉ dùng được API cho agent: lấy cấu hình và gửi dữ liệu đã dùng
+)
+
+// Token mẫu trong tokens.conf.example bắt đầu bằng tiền tố này và không được dùng thật
+const exampleTokenPrefix = "change-me"
+
+// Cấu trúc token của admin API
+type Token struct {
+	Token     string
+	Name      string // Tên gợi nhớ, dùng cho log
+	Role      string
+	Scope     string // Với reseller: chỉ quản lý các user có Owner trùng Scope
+	RateLimit int    // Số request tối đa mỗi phút (0 = không giới hạn)
+
+	mu        sync.Mutex
+	allowance float64
+	lastCheck time.Time
+}
+
+// Đọc danh sách token, mỗi dòng: token,name,role,scope,rate_limit. resolve đọc giá trị token (secret mã hóa
+// hoặc tham chiếu); kết quả được đánh chỉ mục theo giá trị token
+func ParseTokens(r io.Reader, resolve func(value string) (string, error)) (map[string]*Token, error) {
+	tokens := make(map[string]*Token)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ",")
+		if len(parts) != 5 {
+			continue
+		}
+
+		role := parts[2]
+		switch role {
+		case RoleReadOnly, RoleUserAdmin, RoleFullAdmin, RoleReseller, RoleAgent:
+		default:
+			log.Printf("Unknown token role for %s: %s", parts[1], role)
+			continue
+		}
+		if role == RoleReseller && parts[3] == "" {
+			log.Printf("Reseller token %s has no scope, skipped", parts[1])
+			continue
+		}
+
+		rateLimit, err := strconv.Atoi(parts[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate_limit for token %s: %v", parts[1], err)
+		}
+
+		value, err := resolve(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %v", parts[1], err)
+		}
+		if strings.HasPrefix(value, exampleTokenPrefix) {
+			return nil, fmt.Errorf("token %s still has the example value, generate a random token", parts[1])
+		}
+
+		tokens[value] = &Token{
+			Token:     value,
+			Name:      parts[1],
+			Role:      role,
+			Scope:     parts[3],
+			RateLimit: rateLimit,
+			allowance: float64(rateLimit),
+			lastCheck: time.Now(),
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+// Kiểm tra giới hạn số request của token (token bucket theo phút)
+func (t *Token) Allow() bool {
+	if t.RateLimit <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(t.lastCheck).Seconds()
+	t.lastCheck = now
+
+	t.allowance += elapsed * float64(t.RateLimit) / 60
+	if t.allowance > float64(t.RateLimit) {
+		t.allowance = float64(t.RateLimit)
+	}
+	if t.allowance < 1 {
+		return false
+	}
+	t.allowance--
+	return true
+}
+
+// Token được phép tạo/sửa/xóa user
+func (t *Token) CanManageUsers() bool {
+	return t.Role == RoleUserAdmin || t.Role == RoleFullAdmin || t.Role == RoleReseller
+}
+
+// Token được phép thay đổi cấu hình hệ thống và server
+func (t *Token) CanManageSystem() bool {
+	return t.Role == RoleFullAdmin
+}
+
+// Token được phép dùng API cho agent
+func (t *Token) CanServeAgents() bool {
+	return t.Role == RoleAgent || t.Role == RoleFullAdmin
+}
+
+// Kiểm tra token có quyền với user thuộc owner không (giới hạn theo reseller)
+func (t *Token) CanAccessOwner(owner string) bool {
+	if t.Role != RoleReseller {
+		return true
+	}
+	return owner == t.Scope
+}
@@ -0,0 +1,143 @@
+package proxyserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/socks4"
+)
+
+// Số kết nối bị từ chối được trả lời đồng thời; vượt quá thì đóng ngay không trả lời
+const maxRefusalReplies = 64
+
+// Credit cho kết nối đang xử lý, giới hạn bởi max_connections
+type connCredits struct {
+	mu       sync.Mutex
+	active   int
+	released chan struct{} // Đóng mỗi khi có credit được trả lại
+}
+
+var (
+	credits      = connCredits{released: make(chan struct{})}
+	refusalSlots = make(chan struct{}, maxRefusalReplies)
+
+	acceptQueuedTotal  atomic.Int64 // Số kết nối phải chờ credit
+	acceptRefusedTotal atomic.Int64 // Số kết nối bị từ chối vì hết credit
+)
+
+// Lấy một credit, chờ tối đa accept_queue_timeout_ms khi đã đạt max_connections
+func acquireConnCredit() bool {
+	var timer *time.Timer
+	for {
+		credits.mu.Lock()
+		limit := systemConfig().MaxConnections
+		if limit <= 0 || credits.active < limit {
+			credits.active++
+			credits.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return true
+		}
+		released := credits.released
+		credits.mu.Unlock()
+
+		if timer == nil {
+			if systemConfig().AcceptQueueTimeout <= 0 {
+				acceptRefusedTotal.Add(1)
+				return false
+			}
+			acceptQueuedTotal.Add(1)
+			timer = time.NewTimer(time.Duration(systemConfig().AcceptQueueTimeout) * time.Millisecond)
+		}
+		select {
+		case <-released:
+		case <-timer.C:
+			acceptRefusedTotal.Add(1)
+			return false
+		}
+	}
+}
+
+func releaseConnCredit() {
+	credits.mu.Lock()
+	credits.active--
+	close(credits.released)
+	credits.released = make(chan struct{})
+	credits.mu.Unlock()
+}
+
+func activeConnCredits() int {
+	credits.mu.Lock()
+	defer credits.mu.Unlock()
+	return credits.active
+}
+
+// Từ chối kết nối SOCKS khi quá tải: trả lời đúng giao thức nếu còn slot, ngược lại đóng ngay
+func refuseSocksConn(conn net.Conn) {
+	select {
+	case refusalSlots <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+
+	go func() {
+		defer func() { <-refusalSlots }()
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		switch header[0] {
+		case 0x04:
+			conn.Write(socks4.Reply(socks4.Rejected))
+		case 0x05:
+			// Đọc hết danh sách phương thức rồi báo không có phương thức nào được chấp nhận
+			if _, err := io.ReadFull(conn, make([]byte, int(header[1]))); err != nil {
+				return
+			}
+			conn.Write([]byte{0x05, 0xFF})
+		}
+	}()
+}
+
+// Xử lý một kết nối trên listener SOCKS tại listener: phân biệt SOCKS4/SOCKS5 theo byte đầu tiên
+func handleSocksConn(conn net.Conn, listener string) {
+	// IP client nằm trong danh sách uy tín xấu và reputation_action=deny: đóng trước khi bắt tay
+	if !allowClientReputation(conn) {
+		conn.Close()
+		return
+	}
+
+	handshake := &handshakeReader{r: conn, client: conn.RemoteAddr()}
+	reader := bufio.NewReader(handshake)
+	version, err := reader.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	// Handler đọc lại từ đầu, kể cả byte phiên bản
+	conn = &bufferedConn{Conn: conn, reader: reader, handshake: handshake}
+	switch version[0] {
+	case 0x04:
+		handleSocks4(conn, nil, listener) // SOCKS4
+	case 0x05:
+		handleSocks5(conn, nil, listener) // SOCKS5 với xác thực username/password
+	default:
+		// Trong lúc bảo trì, client HTTP (trình duyệt, proxy HTTP) nhận trang thông báo thay vì bị ngắt
+		if maintenanceActive() && looksLikeHTTP(version[0]) {
+			handshake.done = true // Trang bảo trì có hạn đọc riêng, request HTTP có thể dài hơn bắt tay SOCKS
+			serveMaintenancePage(conn)
+			return
+		}
+		conn.Close() // Không hỗ trợ phiên bản khác
+	}
+}
@@ -0,0 +1,423 @@
+package proxyserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Chế độ agent, thay cho cluster khi quản lý nhiều exit node từ một nơi: server lấy user, trạng thái
+// tạm khóa và các file quy tắc từ controller_url, ghi vào file cấu hình của mình rồi áp dụng như
+// config apply, và gửi phần dữ liệu đã dùng tăng thêm lên controller. Khi không liên lạc được controller,
+// agent chạy tiếp với cấu hình đã ghi và giữ lại dữ liệu chưa gửi
+const (
+	defaultControllerInterval = 30 // Giây
+	agentRequestTimeout       = 30 * time.Second
+	agentBundlePath           = "/api/agent/v1/bundle"
+	agentUsagePath            = "/api/agent/v1/usage"
+)
+
+// Trạng thái đồng bộ trong GET /api/agent
+type AgentSyncStatus struct {
+	Enabled      bool      `json:"enabled"`
+	Name         string    `json:"name,omitempty"`
+	Controller   string    `json:"controller,omitempty"`
+	Version      string    `json:"version,omitempty"` // Phiên bản bộ cấu hình đang dùng
+	LastSync     time.Time `json:"last_sync"`
+	LastError    string    `json:"last_error,omitempty"`
+	PendingBytes int64     `json:"pending_bytes"` // Dữ liệu chưa được controller xác nhận
+}
+
+var (
+	agentIncarnation int64
+	agentSeq         int64            // Seq của lần gửi đang chờ xác nhận
+	agentBatch       map[string]int64 // Lần gửi chưa được xác nhận, gửi lại nguyên vẹn với cùng Seq
+	agentPending     = make(map[string]int64)
+	agentVersion     string
+	agentLastSync    time.Time
+	agentLastError   string
+	agentStopped     bool // Đã chuyển dữ liệu chưa gửi cho process mới
+	agentMutex       sync.Mutex
+
+	agentClient *http.Client
+)
+
+func controllerInterval() time.Duration {
+	if systemConfig().ControllerInterval > 0 {
+		return time.Duration(systemConfig().ControllerInterval) * time.Second
+	}
+	return defaultControllerInterval * time.Second
+}
+
+func agentName() string {
+	if systemConfig().AgentName != "" {
+		return systemConfig().AgentName
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// Token agent, đọc mỗi lần dùng để đổi được bằng config apply hoặc xoay vòng secret
+func controllerToken() (string, error) {
+	token, err := resolveSecret(systemConfig().ControllerToken)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", errors.New("controller_token is not set")
+	}
+	return token, nil
+}
+
+// Bắt đầu đồng bộ với controller khi có controller_url
+func startAgent() error {
+	if systemConfig().ControllerURL == "" {
+		return nil
+	}
+	if systemConfig().ClusterListen != "" {
+		return errors.New("controller_url and cluster_listen cannot be used together")
+	}
+	if _, err := controllerToken(); err != nil {
+		return fmt.Errorf("controller_token: %v", err)
+	}
+	if !validTagKey(agentName()) {
+		return errors.New("cannot determine agent_name")
+	}
+
+	transport := &http.Transport{}
+	if systemConfig().ControllerCA != "" {
+		pem, err := readSecretFile(systemConfig().ControllerCA)
+		if err != nil {
+			return fmt.Errorf("controller_ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("controller_ca: no certificate found in %s", systemConfig().ControllerCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	agentClient = &http.Client{Timeout: agentRequestTimeout, Transport: transport}
+
+	agentMutex.Lock()
+	agentIncarnation, agentSeq = time.Now().UnixNano(), 1
+	agentMutex.Unlock()
+
+	log.Printf("Agent %s syncing with controller %s every %v", agentName(), systemConfig().ControllerURL, controllerInterval())
+	// Đồng bộ lần đầu trước khi mở listener để không phục vụ bằng cấu hình cũ và có sẵn các file quy tắc
+	agentSync()
+	go runAgent()
+	return nil
+}
+
+func runAgent() {
+	for {
+		time.Sleep(controllerInterval())
+		if !agentSync() {
+			return
+		}
+	}
+}
+
+// Một vòng đồng bộ, lỗi chỉ được ghi log khi thay đổi. Trả về false khi agent đã dừng
+func agentSync() bool {
+	err := syncWithController()
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+
+	agentMutex.Lock()
+	defer agentMutex.Unlock()
+	if message != "" && message != agentLastError {
+		log.Printf("Agent: cannot sync with controller: %s", message)
+	} else if message == "" && agentLastError != "" {
+		log.Println("Agent: controller reachable again")
+	}
+	agentLastError = message
+	if err == nil {
+		agentLastSync = time.Now()
+	}
+	return !agentStopped
+}
+
+// Một vòng đồng bộ: gửi dữ liệu đã dùng, lấy bộ cấu hình mới nếu có, rồi đặt dữ liệu đã dùng theo controller
+func syncWithController() error {
+	totals, pushErr := pushAgentUsage()
+	pullErr := pullAgentBundle()
+	if pushErr == nil {
+		applyControllerUsage(totals)
+	}
+	var problems []string
+	if pushErr != nil {
+		problems = append(problems, "usage: "+pushErr.Error())
+	}
+	if pullErr != nil {
+		problems = append(problems, "configuration: "+pullErr.Error())
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func controllerRequest(method, path string, body []byte) (*http.Request, error) {
+	token, err := controllerToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, systemConfig().ControllerURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// Gửi dữ liệu đã dùng từ lần gửi trước, trả về tổng dữ liệu đã dùng trên controller
+func pushAgentUsage() (map[string]int64, error) {
+	status := currentServerStatus()
+	stats := ClusterNodeStats{
+		ConnectionsActive: status.ConnectionsActive,
+		TunnelsActive:     status.TunnelsActive,
+		BytesUp:           status.BytesUp,
+		BytesDown:         status.BytesDown,
+		Users:             status.Users,
+		Maintenance:       status.Maintenance != nil,
+	}
+
+	agentMutex.Lock()
+	if agentStopped {
+		agentMutex.Unlock()
+		return nil, errors.New("usage handed over to the new process")
+	}
+	if agentBatch == nil {
+		agentBatch, agentPending = agentPending, make(map[string]int64)
+	}
+	seq := agentSeq
+	body, err := json.Marshal(agentUsageReport{
+		Agent:       agentName(),
+		Incarnation: agentIncarnation,
+		Seq:         seq,
+		Usage:       agentBatch,
+		Stats:       stats,
+		Version:     agentVersion,
+		Interval:    int(controllerInterval() / time.Second),
+	})
+	agentMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := controllerRequest(http.MethodPost, agentUsagePath, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var reply agentUsageReply
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAgentRequest)).Decode(&reply); err != nil {
+		return nil, err
+	}
+
+	agentMutex.Lock()
+	if agentSeq == seq {
+		agentBatch = nil
+		agentSeq++
+	}
+	agentMutex.Unlock()
+	return reply.Usage, nil
+}
+
+// Dữ liệu đã dùng của mỗi user = tổng trên controller + phần chưa gửi của agent này
+func applyControllerUsage(totals map[string]int64) {
+	agentMutex.Lock()
+	unsent := agentUnsent()
+	agentMutex.Unlock()
+
+	usersMutex.Lock()
+	for username, total := range totals {
+		if user, exists := users[username]; exists {
+			user.setDataUsage(total + unsent[username])
+		}
+	}
+	usersMutex.Unlock()
+}
+
+// Lấy bộ cấu hình từ controller và áp dụng khi phiên bản thay đổi
+func pullAgentBundle() error {
+	req, err := controllerRequest(http.MethodGet, agentBundlePath, nil)
+	if err != nil {
+		return err
+	}
+	agentMutex.Lock()
+	if agentVersion != "" {
+		req.Header.Set("If-None-Match", `"`+agentVersion+`"`)
+	}
+	agentMutex.Unlock()
+
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var bundle agentBundle
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAgentRequest)).Decode(&bundle); err != nil {
+		return err
+	}
+	return applyAgentBundle(bundle)
+}
+
+// Ghi users.conf và các file quy tắc theo bộ cấu hình rồi áp dụng như config apply.
+// File quy tắc chỉ được ghi khi agent cũng cấu hình đường dẫn cho loại quy tắc đó
+func applyAgentBundle(bundle agentBundle) error {
+	var content strings.Builder
+	content.WriteString("# Managed by controller " + systemConfig().ControllerURL + ", local changes are overwritten\n")
+	for _, line := range bundle.Users {
+		content.WriteString(line + "\n")
+	}
+	if err := writeFileAtomic(userFile, []byte(content.String())); err != nil {
+		return fmt.Errorf("%s: %v", userFile, err)
+	}
+
+	paths := agentRuleFiles()
+	for name, data := range bundle.Files {
+		path, known := paths[name]
+		if !known {
+			continue
+		}
+		if path == "" {
+			log.Printf("Agent: controller sends %s rules but this server has no %s_file", name, name)
+			continue
+		}
+		if err := writeFileAtomic(path, []byte(data)); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	newConfig, newUsers, err := readConfigFiles()
+	if err != nil {
+		return err
+	}
+	diff, err := applyConfig(newConfig, newUsers, false)
+	if err != nil {
+		return err
+	}
+	recordAudit("controller", "config.apply", systemConfig().ControllerURL, nil, diff)
+
+	var kicked []string
+	usersMutex.Lock()
+	for username, user := range users {
+		reason, suspended := bundle.Suspended[username]
+		if user.isSuspended() == suspended && user.suspendReason() == reason {
+			continue
+		}
+		if suspended && !user.isSuspended() {
+			kicked = append(kicked, username)
+			log.Printf("Agent: user %s suspended by controller", username)
+		} else if !suspended {
+			log.Printf("Agent: user %s unsuspended by controller", username)
+		}
+		user.setSuspended(suspended, reason)
+	}
+	usersMutex.Unlock()
+	for _, username := range kicked {
+		closeUserTunnels(username, CloseAdminKick)
+	}
+
+	agentMutex.Lock()
+	agentVersion = bundle.Version
+	agentMutex.Unlock()
+	log.Printf("Agent: configuration %s from controller applied", bundle.Version)
+	return nil
+}
+
+// Cộng dữ liệu user vừa dùng qua agent này vào lần gửi tiếp theo
+func agentCountUsage(username string, n int64) {
+	if n <= 0 || systemConfig().ControllerURL == "" {
+		return
+	}
+	agentMutex.Lock()
+	agentPending[username] += n
+	agentMutex.Unlock()
+}
+
+// Dừng gửi dữ liệu và trả về phần chưa được controller xác nhận để process mới gửi tiếp khi nâng cấp nóng
+func agentUsageHandoff() map[string]int64 {
+	if systemConfig().ControllerURL == "" {
+		return nil
+	}
+	agentMutex.Lock()
+	defer agentMutex.Unlock()
+	agentStopped = true
+	return agentUnsent()
+}
+
+// Gọi khi giữ agentMutex
+func agentUnsent() map[string]int64 {
+	unsent := make(map[string]int64, len(agentPending)+len(agentBatch))
+	for username, n := range agentPending {
+		unsent[username] += n
+	}
+	for username, n := range agentBatch {
+		unsent[username] += n
+	}
+	return unsent
+}
+
+// Nhận phần chưa gửi từ process cũ vào lần gửi tiếp theo
+func importAgentUsage(unsent map[string]int64) {
+	agentMutex.Lock()
+	defer agentMutex.Unlock()
+	for username, n := range unsent {
+		agentPending[username] += n
+	}
+}
+
+// GET /api/agent
+func handleAdminAgent(w http.ResponseWriter, r *http.Request) {
+	if systemConfig().ControllerURL == "" {
+		admin.WriteJSON(w, http.StatusOK, AgentSyncStatus{})
+		return
+	}
+	agentMutex.Lock()
+	status := AgentSyncStatus{
+		Enabled:    true,
+		Name:       agentName(),
+		Controller: systemConfig().ControllerURL,
+		Version:    agentVersion,
+		LastSync:   agentLastSync,
+		LastError:  agentLastError,
+	}
+	for _, n := range agentUnsent() {
+		status.PendingBytes += n
+	}
+	agentMutex.Unlock()
+	admin.WriteJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,211 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Phía controller của chế độ agent: server có admin API nào cũng làm controller được. Agent dùng token
+// vai trò agent để lấy bộ cấu hình (user, trạng thái tạm khóa, file ACL/rewrite/DNS) và gửi dữ liệu đã dùng
+const (
+	agentFileACL          = "acl"
+	agentFileRewrite      = "rewrite"
+	agentFileDNSOverrides = "dns_overrides"
+
+	maxAgentRequest   = 32 << 20 // Kích thước tối đa của bộ cấu hình và báo cáo dữ liệu
+	agentOfflineAfter = 3        // Số chu kỳ đồng bộ không liên lạc trước khi agent bị coi là offline
+)
+
+// Bộ cấu hình controller gửi cho agent
+type agentBundle struct {
+	Version   string            `json:"version"`
+	Users     []string          `json:"users"`     // Các dòng của users.conf, password chưa mã hóa
+	Suspended map[string]string `json:"suspended"` // User bị tạm khóa -> lý do
+	Files     map[string]string `json:"files"`     // acl, rewrite, dns_overrides -> nội dung file
+}
+
+// Dữ liệu đã dùng agent gửi lên. Seq chỉ tăng sau khi controller xác nhận nên lần gửi lại
+// cùng Seq không bị cộng hai lần
+type agentUsageReport struct {
+	Agent       string           `json:"agent"`
+	Incarnation int64            `json:"incarnation"` // Thời điểm process agent khởi động (unix nano)
+	Seq         int64            `json:"seq"`
+	Usage       map[string]int64 `json:"usage"`
+	Stats       ClusterNodeStats `json:"stats"`
+	Version     string           `json:"version"`  // Phiên bản bộ cấu hình agent đang dùng
+	Interval    int              `json:"interval"` // Chu kỳ đồng bộ của agent (giây)
+}
+
+type agentUsageReply struct {
+	Usage map[string]int64 `json:"usage"` // Tổng dữ liệu đã dùng của mỗi user trên controller
+}
+
+// Một agent trong GET /api/agents
+type AgentStatus struct {
+	Name     string           `json:"name"`
+	Address  string           `json:"address"`
+	Online   bool             `json:"online"`
+	Current  bool             `json:"current"` // Đang dùng bộ cấu hình mới nhất
+	Version  string           `json:"version"`
+	Started  time.Time        `json:"started"`
+	LastSeen time.Time        `json:"last_seen"`
+	Stats    ClusterNodeStats `json:"stats"`
+
+	interval    time.Duration
+	incarnation int64
+	seq         int64
+}
+
+var (
+	agentStatuses      = make(map[string]*AgentStatus)
+	agentStatusesMutex sync.Mutex
+)
+
+// File quy tắc của server này theo tên trong bộ cấu hình
+func agentRuleFiles() map[string]string {
+	return map[string]string{
+		agentFileACL:          systemConfig().ACLFile,
+		agentFileRewrite:      systemConfig().RewriteFile,
+		agentFileDNSOverrides: systemConfig().DNSOverridesFile,
+	}
+}
+
+// Tạo bộ cấu hình từ user đang chạy và các file quy tắc; Version là hash của nội dung
+func buildAgentBundle() (agentBundle, error) {
+	bundle := agentBundle{Users: []string{}, Suspended: make(map[string]string), Files: make(map[string]string)}
+	usersMutex.RLock()
+	for username, user := range users {
+		bundle.Users = append(bundle.Users, formatUserLine(user))
+		if user.isSuspended() {
+			bundle.Suspended[username] = user.suspendReason()
+		}
+	}
+	usersMutex.RUnlock()
+	sort.Strings(bundle.Users)
+
+	for name, path := range agentRuleFiles() {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return bundle, err
+		}
+		bundle.Files[name] = string(data)
+	}
+
+	encoded, err := json.Marshal(bundle)
+	if err != nil {
+		return bundle, err
+	}
+	bundle.Version = sha256Hex(encoded)[:16]
+	return bundle, nil
+}
+
+// GET /api/agent/v1/bundle, trả 304 khi agent đã có phiên bản hiện tại (If-None-Match)
+func handleAgentBundle(w http.ResponseWriter, r *http.Request) {
+	bundle, err := buildAgentBundle()
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	etag := `"` + bundle.Version + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, bundle)
+}
+
+// POST /api/agent/v1/usage
+func handleAgentUsage(w http.ResponseWriter, r *http.Request) {
+	var report agentUsageReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAgentRequest)).Decode(&report); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid usage report")
+		return
+	}
+	if !validTagKey(report.Agent) {
+		admin.WriteError(w, http.StatusBadRequest, "invalid agent name")
+		return
+	}
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+
+	// Giữ khóa khi cộng dữ liệu để hai lần gửi đồng thời của cùng agent không cùng được cộng
+	agentStatusesMutex.Lock()
+	status := agentStatuses[report.Agent]
+	if status == nil {
+		status = &AgentStatus{Name: report.Agent}
+		agentStatuses[report.Agent] = status
+		log.Printf("Agent %s connected from %s", report.Agent, address)
+	} else if status.incarnation != report.Incarnation {
+		log.Printf("Agent %s restarted on %s", report.Agent, address)
+	}
+	duplicate := status.incarnation == report.Incarnation && report.Seq <= status.seq
+	if !duplicate {
+		status.incarnation, status.seq = report.Incarnation, report.Seq
+	}
+	status.Address, status.LastSeen, status.Started = address, time.Now(), time.Unix(0, report.Incarnation)
+	status.Version, status.Stats = report.Version, report.Stats
+	status.interval = time.Duration(report.Interval) * time.Second
+	if !duplicate {
+		for username, n := range report.Usage {
+			if n <= 0 {
+				continue
+			}
+			usersMutex.RLock()
+			user := users[username]
+			usersMutex.RUnlock()
+			if user != nil {
+				addDataUsage(user, n)
+				clusterCountUsage(username, n)
+			}
+		}
+	}
+	agentStatusesMutex.Unlock()
+
+	usersMutex.RLock()
+	reply := agentUsageReply{Usage: make(map[string]int64, len(users))}
+	for username, user := range users {
+		reply.Usage[username] = user.dataUsage()
+	}
+	usersMutex.RUnlock()
+	admin.WriteJSON(w, http.StatusOK, reply)
+}
+
+// GET /api/agents
+func handleAdminAgents(w http.ResponseWriter, r *http.Request) {
+	current := ""
+	if bundle, err := buildAgentBundle(); err == nil {
+		current = bundle.Version
+	}
+
+	now := time.Now()
+	agentStatusesMutex.Lock()
+	agents := make([]AgentStatus, 0, len(agentStatuses))
+	for _, status := range agentStatuses {
+		view := *status
+		interval := view.interval
+		if interval <= 0 {
+			interval = defaultControllerInterval * time.Second
+		}
+		view.Online = now.Sub(view.LastSeen) < agentOfflineAfter*interval
+		view.Current = view.Version == current
+		agents = append(agents, view)
+	}
+	agentStatusesMutex.Unlock()
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	admin.WriteJSON(w, http.StatusOK, map[string]any{"version": current, "agents": agents})
+}
@@ -0,0 +1,342 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Cảnh báo nội bộ cho nhà vận hành không có Prometheus/Alertmanager: các rule được đánh giá định kỳ,
+// trạng thái xem qua GET /api/alerts và đẩy tới alert_webhook (định dạng webhook của Alertmanager)
+// hoặc alertmanager_url khi thay đổi
+const (
+	alertEvalInterval       = 15 * time.Second
+	alertErrorWindow        = 5 * time.Minute
+	alertErrorMinTunnels    = 20 // Số tunnel tối thiểu trong cửa sổ để tính tỉ lệ lỗi
+	defaultAlertErrorRate   = 20 // Phần trăm
+	defaultAlertFDPercent   = 90
+	alertmanagerResendEvery = time.Minute // Alertmanager cần nhận lại cảnh báo đang kích hoạt định kỳ
+)
+
+// Trạng thái của một cảnh báo
+const (
+	alertInactive = "inactive"
+	alertFiring   = "firing"
+	alertResolved = "resolved" // Chỉ dùng trong payload webhook
+)
+
+// Một cảnh báo, các trường theo định dạng của Alertmanager
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	State       string            `json:"state"`
+	Value       float64           `json:"value"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Payload gửi tới alert_webhook, tương thích với receiver webhook của Alertmanager
+type alertWebhookPayload struct {
+	Version     string             `json:"version"`
+	Status      string             `json:"status"`
+	Receiver    string             `json:"receiver"`
+	GroupLabels map[string]string  `json:"groupLabels"`
+	Alerts      []alertWebhookItem `json:"alerts"`
+}
+
+type alertWebhookItem struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Rule cảnh báo: check trả về giá trị đo được, có kích hoạt hay không và mô tả
+type alertRule struct {
+	name     string
+	severity string
+	check    func(now time.Time) (value float64, firing bool, summary string)
+}
+
+// Mẫu bộ đếm tunnel dùng để tính tỉ lệ lỗi trong alertErrorWindow
+type tunnelSample struct {
+	at     time.Time
+	total  int64
+	errors int64
+}
+
+var (
+	alertStates     = make(map[string]*Alert)
+	alertsMutex     sync.Mutex
+	alertInstance   = alertHostname()
+	tunnelSamples   []tunnelSample
+	listenerEverRan bool
+)
+
+var alertRules = []alertRule{
+	{"ProxyListenerDown", "critical", checkListenerDown},
+	{"ProxyErrorRateHigh", "warning", checkErrorRate},
+	{"ProxyFDExhaustion", "critical", checkFDExhaustion},
+	{"ProxyMemoryHigh", "warning", checkMemoryHigh},
+}
+
+func alertHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "proxy"
+	}
+	return name
+}
+
+// Không còn listener SOCKS nào nhận kết nối sau khi server đã chạy
+func checkListenerDown(now time.Time) (float64, bool, string) {
+	running := len(runningInstances(listenerSocks))
+	if running > 0 {
+		listenerEverRan = true
+	}
+	return float64(running), listenerEverRan && running == 0, "No SOCKS listener is accepting connections"
+}
+
+// Lý do kết thúc tính là lỗi phía đích, lỗi phía client không tính
+func isErrorCloseReason(reason string) bool {
+	return reason == CloseTargetError || strings.HasPrefix(reason, "dial_")
+}
+
+// Tỉ lệ tunnel kết thúc do lỗi kết nối tới đích trong alertErrorWindow vượt alert_error_rate
+func checkErrorRate(now time.Time) (float64, bool, string) {
+	var sample tunnelSample
+	sample.at = now
+	for _, entry := range closeReasonSnapshot() {
+		sample.total += entry.Count
+		if isErrorCloseReason(entry.Reason) {
+			sample.errors += entry.Count
+		}
+	}
+	tunnelSamples = append(tunnelSamples, sample)
+	for len(tunnelSamples) > 1 && now.Sub(tunnelSamples[1].at) >= alertErrorWindow {
+		tunnelSamples = tunnelSamples[1:]
+	}
+
+	oldest := tunnelSamples[0]
+	total, errors := sample.total-oldest.total, sample.errors-oldest.errors
+	if total < alertErrorMinTunnels {
+		return 0, false, ""
+	}
+	rate := float64(errors) * 100 / float64(total)
+	threshold := systemConfig().AlertErrorRate
+	if threshold <= 0 {
+		threshold = defaultAlertErrorRate
+	}
+	return rate, rate >= float64(threshold), fmt.Sprintf("%.0f%% of %d tunnels in the last %s failed to reach their destination", rate, total, alertErrorWindow)
+}
+
+// Số fd đang mở gần tới soft limit
+func checkFDExhaustion(now time.Time) (float64, bool, string) {
+	limit := fdLimitValue.Load()
+	if limit <= 0 {
+		return 0, false, ""
+	}
+	percent := float64(estimatedOpenFDs()) * 100 / float64(limit)
+	threshold := systemConfig().AlertFDPercent
+	if threshold <= 0 {
+		threshold = defaultAlertFDPercent
+	}
+	return percent, percent >= float64(threshold), fmt.Sprintf("%.0f%% of %d file descriptors in use", percent, limit)
+}
+
+func checkMemoryHigh(now time.Time) (float64, bool, string) {
+	usage := memoryUsage.Load()
+	return float64(usage), memoryHigh.Load(), fmt.Sprintf("Memory usage %d MB above memory_limit_mb, new tunnels are refused", usage>>20)
+}
+
+// Đánh giá các rule theo chu kỳ và gửi các thay đổi trạng thái
+func runAlerts() {
+	var lastResend time.Time
+	for ; ; time.Sleep(alertEvalInterval) {
+		now := time.Now()
+		changed := evaluateAlerts(now)
+		if len(changed) > 0 {
+			sendAlertWebhook(changed)
+		}
+		if systemConfig().AlertmanagerURL != "" && (len(changed) > 0 || now.Sub(lastResend) >= alertmanagerResendEvery) {
+			lastResend = now
+			pushAlertmanager(changed)
+		}
+	}
+}
+
+// Cập nhật trạng thái các cảnh báo, trả về các cảnh báo vừa kích hoạt hoặc vừa hết
+func evaluateAlerts(now time.Time) []Alert {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	var changed []Alert
+	for _, rule := range alertRules {
+		value, firing, summary := rule.check(now)
+		alert, exists := alertStates[rule.name]
+		if !exists {
+			alert = &Alert{
+				Labels:      map[string]string{"alertname": rule.name, "severity": rule.severity, "instance": alertInstance},
+				Annotations: map[string]string{},
+				State:       alertInactive,
+			}
+			alertStates[rule.name] = alert
+		}
+		alert.Value = value
+		if summary != "" {
+			alert.Annotations["summary"] = summary
+		}
+		switch {
+		case firing && alert.State != alertFiring:
+			alert.State, alert.StartsAt, alert.EndsAt = alertFiring, now, time.Time{}
+			log.Printf("Alert %s firing: %s", rule.name, summary)
+			notifyOperators("Alert %s firing: %s", rule.name, summary)
+			changed = append(changed, *alert)
+		case !firing && alert.State == alertFiring:
+			alert.State, alert.EndsAt = alertInactive, now
+			log.Printf("Alert %s resolved", rule.name)
+			notifyOperators("Alert %s resolved", rule.name)
+			changed = append(changed, *alert)
+		}
+	}
+	return changed
+}
+
+// Trạng thái mọi cảnh báo, sắp theo tên
+func alertSnapshot() []Alert {
+	alertsMutex.Lock()
+	list := make([]Alert, 0, len(alertStates))
+	for _, alert := range alertStates {
+		copied := *alert
+		copied.Labels = cloneLabels(alert.Labels)
+		copied.Annotations = cloneLabels(alert.Annotations)
+		list = append(list, copied)
+	}
+	alertsMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Labels["alertname"] < list[j].Labels["alertname"] })
+	return list
+}
+
+func cloneLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
+
+func webhookItems(alerts []Alert) []alertWebhookItem {
+	items := make([]alertWebhookItem, 0, len(alerts))
+	for _, alert := range alerts {
+		status := alertFiring
+		if alert.State != alertFiring {
+			status = alertResolved
+		}
+		items = append(items, alertWebhookItem{status, alert.Labels, alert.Annotations, alert.StartsAt, alert.EndsAt})
+	}
+	return items
+}
+
+// Gửi các cảnh báo vừa thay đổi tới alert_webhook
+func sendAlertWebhook(changed []Alert) {
+	if systemConfig().AlertWebhook == "" {
+		return
+	}
+	payload := alertWebhookPayload{
+		Version:     "4",
+		Status:      alertResolved,
+		Receiver:    "proxy-server",
+		GroupLabels: map[string]string{"instance": alertInstance},
+		Alerts:      webhookItems(changed),
+	}
+	for _, alert := range changed {
+		if alert.State == alertFiring {
+			payload.Status = alertFiring
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	go func() {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(systemConfig().AlertWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Alert webhook error: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// Đẩy tới Alertmanager (API v2) các cảnh báo đang kích hoạt và các cảnh báo vừa hết
+func pushAlertmanager(changed []Alert) {
+	var alerts []Alert
+	for _, alert := range alertSnapshot() {
+		if alert.State == alertFiring {
+			alerts = append(alerts, alert)
+		}
+	}
+	for _, alert := range changed {
+		if alert.State != alertFiring {
+			alerts = append(alerts, alert)
+		}
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	type postableAlert struct {
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		StartsAt    time.Time         `json:"startsAt"`
+		EndsAt      *time.Time        `json:"endsAt,omitempty"` // Rỗng khi đang kích hoạt
+	}
+	items := make([]postableAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		item := postableAlert{Labels: alert.Labels, Annotations: alert.Annotations, StartsAt: alert.StartsAt}
+		if alert.State != alertFiring {
+			item.EndsAt = &alert.EndsAt
+		}
+		items = append(items, item)
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		return
+	}
+	url := strings.TrimRight(systemConfig().AlertmanagerURL, "/") + "/api/v2/alerts"
+	go func() {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Alertmanager push error: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Alertmanager push error: unexpected status %s", resp.Status)
+		}
+	}()
+}
+
+// GET /api/alerts, ?state=firing chỉ trả về các cảnh báo đang kích hoạt
+func handleAdminAlerts(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	list := []Alert{}
+	for _, alert := range alertSnapshot() {
+		if state == "" || alert.State == state {
+			list = append(list, alert)
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,377 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ghi bản ghi kết nối (như access log) vào ClickHouse hoặc TimescaleDB để phân tích lượng dùng bằng SQL.
+// Bản ghi được đưa vào hàng đợi có giới hạn và ghi theo lô từ một goroutine riêng, nên tunnel không bao giờ
+// chờ cơ sở dữ liệu. Khi ghi lỗi, lô hiện tại được thử lại với thời gian chờ tăng dần; trong lúc đó hàng đợi
+// đầy thì bản ghi mới bị bỏ và được đếm
+const (
+	analyticsClickHouse    = "clickhouse"
+	analyticsTimescaleDB   = "timescaledb"
+	defaultAnalyticsTable  = "proxy_connections"
+	defaultAnalyticsBatch  = 1000
+	defaultAnalyticsFlush  = 5 // Giây
+	defaultAnalyticsBuffer = 100000
+	analyticsMaxBackoff    = time.Minute
+	analyticsWriteTimeout  = 30 * time.Second
+	analyticsDrainTimeout  = 10 * time.Second // Thời gian ghi nốt hàng đợi trước khi process cũ thoát khi nâng cấp nóng
+	analyticsTimeFormat    = "2006-01-02 15:04:05.000"
+)
+
+// Các cột của bảng, theo thứ tự ghi
+var analyticsColumns = []string{
+	"time", "node", "username", "client", "listener", "dest", "session",
+	"bytes_up", "bytes_down", "duration_ms", "reason", "unmetered", "tags",
+}
+
+// Nơi ghi một lô bản ghi
+type analyticsSink interface {
+	write(records []AccessRecord) error
+	close()
+}
+
+var (
+	analyticsQueue    chan AccessRecord
+	analyticsDrain    = make(chan chan struct{})
+	analyticsNode     string
+	analyticsOnce     sync.Once
+	analyticsWritten  atomic.Int64
+	analyticsDropped  atomic.Int64
+	analyticsErrors   atomic.Int64
+	analyticsDropping atomic.Bool // Đang bỏ bản ghi vì hàng đợi đầy, chỉ ghi log một lần
+)
+
+func validAnalyticsSink(sink string) bool {
+	return sink == analyticsClickHouse || sink == analyticsTimescaleDB
+}
+
+// Tên bảng hợp lệ: chữ, số, '_' và một dấu chấm ngăn cách database/schema
+func validAnalyticsTable(table string) bool {
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			c := part[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func analyticsTable() string {
+	if systemConfig().AnalyticsTable == "" {
+		return defaultAnalyticsTable
+	}
+	return systemConfig().AnalyticsTable
+}
+
+func analyticsBatchSize() int {
+	if systemConfig().AnalyticsBatchSize <= 0 {
+		return defaultAnalyticsBatch
+	}
+	return systemConfig().AnalyticsBatchSize
+}
+
+func analyticsFlushInterval() time.Duration {
+	if systemConfig().AnalyticsFlushInterval <= 0 {
+		return defaultAnalyticsFlush * time.Second
+	}
+	return time.Duration(systemConfig().AnalyticsFlushInterval) * time.Second
+}
+
+// Tạo hàng đợi và bắt đầu ghi khi analytics_sink được đặt
+func startAnalytics() error {
+	if systemConfig().AnalyticsSink == "" {
+		return nil
+	}
+	if systemConfig().AnalyticsDSN == "" {
+		return errors.New("analytics_dsn is not set")
+	}
+	analyticsOnce.Do(func() {
+		size := systemConfig().AnalyticsBuffer
+		if size <= 0 {
+			size = defaultAnalyticsBuffer
+		}
+		analyticsNode = systemConfig().ClusterNode
+		if analyticsNode == "" {
+			analyticsNode = alertInstance
+		}
+		analyticsQueue = make(chan AccessRecord, size)
+		go runAnalyticsWriter()
+	})
+	return nil
+}
+
+// Đưa bản ghi vào hàng đợi; không bao giờ chặn
+func queueAnalytics(record AccessRecord) {
+	if analyticsQueue == nil {
+		return
+	}
+	select {
+	case analyticsQueue <- record:
+	default:
+		analyticsDropped.Add(1)
+		if !analyticsDropping.Swap(true) {
+			log.Printf("Analytics queue full (%d records), dropping new records", cap(analyticsQueue))
+		}
+	}
+}
+
+func runAnalyticsWriter() {
+	var sink analyticsSink
+	var sinkDSN string
+	batch := make([]AccessRecord, 0, analyticsBatchSize())
+	backoff := time.Second
+
+	// Ghi lô hiện tại; trả về false khi lỗi, lô được giữ lại để thử lại
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		dsn, err := resolveSecret(systemConfig().AnalyticsDSN)
+		if err == nil && (sink == nil || dsn != sinkDSN) {
+			if sink != nil {
+				sink.close()
+			}
+			sink, err = openAnalyticsSink(systemConfig().AnalyticsSink, dsn)
+			sinkDSN = dsn
+		}
+		if err == nil {
+			err = sink.write(batch)
+		}
+		if err != nil {
+			analyticsErrors.Add(1)
+			log.Printf("Analytics sink error (%d records pending): %v", len(batch)+len(analyticsQueue), err)
+			if sink != nil {
+				sink.close()
+				sink = nil
+			}
+			return false
+		}
+		analyticsWritten.Add(int64(len(batch)))
+		batch = batch[:0]
+		backoff = time.Second
+		if analyticsDropping.Swap(false) {
+			log.Printf("Analytics sink caught up, %d records dropped so far", analyticsDropped.Load())
+		}
+		return true
+	}
+
+	ticker := time.NewTicker(analyticsFlushInterval())
+	defer ticker.Stop()
+	for {
+		// Lô đầy mà chưa ghi được thì ngừng nhận, bản ghi mới nằm trong hàng đợi
+		queue := analyticsQueue
+		if len(batch) >= analyticsBatchSize() {
+			queue = nil
+		}
+		select {
+		case record := <-queue:
+			batch = append(batch, record)
+			if len(batch) < analyticsBatchSize() {
+				continue
+			}
+		case <-ticker.C:
+			ticker.Reset(analyticsFlushInterval())
+		case done := <-analyticsDrain:
+			// Ghi nốt hàng đợi, không thử lại
+			ok := flush()
+			for ok && len(analyticsQueue) > 0 {
+				for len(batch) < analyticsBatchSize() && len(analyticsQueue) > 0 {
+					batch = append(batch, <-analyticsQueue)
+				}
+				ok = flush()
+			}
+			close(done)
+			continue
+		}
+		if !flush() {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, analyticsMaxBackoff)
+		}
+	}
+}
+
+// Ghi nốt các bản ghi đang chờ trước khi process thoát, tối đa analyticsDrainTimeout
+func drainAnalytics() {
+	if analyticsQueue == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case analyticsDrain <- done:
+	case <-time.After(analyticsDrainTimeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(analyticsDrainTimeout):
+		log.Printf("Analytics drain timed out, %d records not written", len(analyticsQueue))
+	}
+}
+
+func openAnalyticsSink(kind, dsn string) (analyticsSink, error) {
+	switch kind {
+	case analyticsClickHouse:
+		return newClickHouseSink(dsn)
+	case analyticsTimescaleDB:
+		conn, err := pgConnect(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &timescaleSink{conn: conn}, nil
+	}
+	return nil, fmt.Errorf("unknown analytics_sink %q", kind)
+}
+
+// Một dòng của bảng, dùng cho JSONEachRow của ClickHouse
+type analyticsRow struct {
+	Time       string            `json:"time"`
+	Node       string            `json:"node"`
+	Username   string            `json:"username"`
+	Client     string            `json:"client"`
+	Listener   string            `json:"listener"`
+	Dest       string            `json:"dest"`
+	Session    string            `json:"session"`
+	BytesUp    int64             `json:"bytes_up"`
+	BytesDown  int64             `json:"bytes_down"`
+	DurationMs int64             `json:"duration_ms"`
+	Reason     string            `json:"reason"`
+	Unmetered  bool              `json:"unmetered"`
+	Tags       map[string]string `json:"tags"`
+}
+
+func newAnalyticsRow(record AccessRecord) analyticsRow {
+	tags := record.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return analyticsRow{
+		Time: record.Time.UTC().Format(analyticsTimeFormat), Node: analyticsNode, Username: record.Username,
+		Client: record.Client, Listener: record.Listener, Dest: record.Dest, Session: record.Session,
+		BytesUp: record.Up, BytesDown: record.Down, DurationMs: record.DurationMs, Reason: record.Reason,
+		Unmetered: record.Unmetered, Tags: tags,
+	}
+}
+
+// ClickHouse qua giao diện HTTP: mỗi lô là một INSERT ... FORMAT JSONEachRow
+type clickHouseSink struct {
+	endpoint string // URL không kèm thông tin đăng nhập, giữ các tham số như database
+	user     string
+	password string
+	client   *http.Client
+}
+
+// DSN dạng http(s)://user:password@host:8123/?database=db
+func newClickHouseSink(dsn string) (*clickHouseSink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid ClickHouse URL, expected http(s)://user:password@host:8123/?database=db")
+	}
+	sink := &clickHouseSink{client: &http.Client{Timeout: analyticsWriteTimeout}}
+	if u.User != nil {
+		sink.user = u.User.Username()
+		sink.password, _ = u.User.Password()
+		u.User = nil
+	}
+	query := u.Query()
+	query.Set("query", fmt.Sprintf("INSERT INTO %s (%s) FORMAT JSONEachRow", analyticsTable(), strings.Join(analyticsColumns, ", ")))
+	u.RawQuery = query.Encode()
+	sink.endpoint = u.String()
+	return sink, nil
+}
+
+func (c *clickHouseSink) write(records []AccessRecord) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, record := range records {
+		if err := encoder.Encode(newAnalyticsRow(record)); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	if c.user != "" {
+		req.Header.Set("X-ClickHouse-User", c.user)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (c *clickHouseSink) close() {
+	c.client.CloseIdleConnections()
+}
+
+// TimescaleDB (hoặc PostgreSQL) qua giao thức PostgreSQL: mỗi lô là một COPY FROM STDIN
+type timescaleSink struct {
+	conn *pgConn
+}
+
+func (t *timescaleSink) write(records []AccessRecord) error {
+	var data bytes.Buffer
+	for _, record := range records {
+		row := newAnalyticsRow(record)
+		tags := `\N`
+		if len(row.Tags) > 0 {
+			encoded, _ := json.Marshal(row.Tags)
+			tags = pgCopyText(string(encoded))
+		}
+		unmetered := "f"
+		if row.Unmetered {
+			unmetered = "t"
+		}
+		fields := []string{
+			row.Time + "+00", pgCopyText(row.Node), pgCopyText(row.Username), pgCopyText(row.Client),
+			pgCopyText(row.Listener), pgCopyText(row.Dest), pgCopyText(row.Session),
+			fmt.Sprint(row.BytesUp), fmt.Sprint(row.BytesDown), fmt.Sprint(row.DurationMs),
+			pgCopyText(row.Reason), unmetered, tags,
+		}
+		data.WriteString(strings.Join(fields, "\t"))
+		data.WriteByte('\n')
+	}
+	query := fmt.Sprintf("COPY %s (%s) FROM STDIN", analyticsTable(), strings.Join(analyticsColumns, ", "))
+	return t.conn.copyIn(query, data.Bytes(), analyticsWriteTimeout)
+}
+
+func (t *timescaleSink) close() {
+	t.conn.Close()
+}
+
+// Thoát ký tự đặc biệt cho định dạng text của COPY
+var pgCopyReplacer = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", "")
+
+func pgCopyText(value string) string {
+	return pgCopyReplacer.Replace(value)
+}
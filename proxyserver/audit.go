@@ -0,0 +1,196 @@
+package proxyserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Số bản ghi audit tối đa giữ trong bộ nhớ để truy vấn qua API
+const maxAuditEntries = 10000
+
+// Một bản ghi thao tác quản trị
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`  // Tên token hoặc "console"
+	Action   string    `json:"action"` // Ví dụ: user.create, user.update, config.reload
+	Target   string    `json:"target,omitempty"`
+	OldValue any       `json:"old_value,omitempty"`
+	NewValue any       `json:"new_value,omitempty"`
+}
+
+var (
+	auditEntries []AuditEntry
+	auditMutex   sync.Mutex
+	auditFile    *os.File
+)
+
+// Mở file audit (JSON lines) và nạp lại các bản ghi cũ để có thể truy vấn
+func openAuditLog(filePath string) error {
+	if existing, err := os.Open(filePath); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			entry.OldValue, entry.NewValue = redactAuditValue(entry.OldValue), redactAuditValue(entry.NewValue)
+			appendAuditEntry(entry)
+		}
+		existing.Close()
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	auditMutex.Lock()
+	auditFile = file
+	auditMutex.Unlock()
+	return nil
+}
+
+func appendAuditEntry(entry AuditEntry) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	auditEntries = append(auditEntries, entry)
+	if len(auditEntries) > maxAuditEntries {
+		auditEntries = auditEntries[len(auditEntries)-maxAuditEntries:]
+	}
+}
+
+// Ghi lại một thao tác quản trị
+func recordAudit(actor, action, target string, oldValue, newValue any) {
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Actor:    actor,
+		Action:   action,
+		Target:   target,
+		OldValue: redactAuditValue(oldValue),
+		NewValue: redactAuditValue(newValue),
+	}
+	appendAuditEntry(entry)
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	if auditFile == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Audit encode error: %v", err)
+		return
+	}
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		log.Printf("Audit write error: %v", err)
+	}
+}
+
+// Ghi audit log xuống đĩa và đóng file trước khi process thoát
+func closeAuditLog() {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditFile == nil {
+		return
+	}
+	if err := auditFile.Sync(); err != nil {
+		log.Printf("Audit write error: %v", err)
+	}
+	auditFile.Close()
+	auditFile = nil
+}
+
+// Ẩn secret trong giá trị audit. Cấu hình đầy đủ không bao giờ được ghi với secret; bản ghi config.reload
+// cũ chứa cả SystemConfig được nạp lại dưới dạng map và cũng bị ẩn trước khi trả qua /api/audit
+func redactAuditValue(value any) any {
+	switch v := value.(type) {
+	case SystemConfig:
+		return redactSystemConfig(v)
+	case []any:
+		redactFieldChanges(v)
+	case map[string]any:
+		// Diff của config.apply
+		if changes, ok := v["system_changed"].([]any); ok {
+			redactFieldChanges(changes)
+		}
+		for name, field := range v {
+			switch field := field.(type) {
+			case string:
+				if secretConfigFields[name] && field != "" {
+					v[name] = "***"
+				} else {
+					v[name] = redactURL(field)
+				}
+			case []any:
+				for i, item := range field {
+					if item, ok := item.(string); ok {
+						field[i] = redactURL(item)
+					}
+				}
+			}
+		}
+		listeners, _ := v["ObfsListeners"].([]any)
+		for _, listener := range listeners {
+			fields, _ := listener.(map[string]any)
+			if options, ok := fields["Options"].(map[string]any); ok && options["key"] != nil {
+				options["key"] = "***"
+			}
+		}
+	}
+	return value
+}
+
+// Ẩn các thay đổi cấu hình ({field, old, new}) được nạp lại từ audit log cũ
+func redactFieldChanges(changes []any) {
+	for _, change := range changes {
+		fields, _ := change.(map[string]any)
+		name, _ := fields["field"].(string)
+		if name == "" {
+			continue
+		}
+		for _, key := range []string{"old", "new"} {
+			if value, ok := fields[key].(string); ok {
+				if secretConfigFields[name] {
+					fields[key] = "***"
+				} else {
+					fields[key] = redactURL(value)
+				}
+			}
+		}
+	}
+}
+
+// Lọc bản ghi audit theo actor, action, target và thời gian; trả về tối đa limit bản ghi mới nhất
+func queryAudit(actor, action, target string, since time.Time, limit int) []AuditEntry {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	result := make([]AuditEntry, 0)
+	for i := len(auditEntries) - 1; i >= 0; i-- {
+		entry := auditEntries[i]
+		if actor != "" && entry.Actor != actor {
+			continue
+		}
+		if action != "" && entry.Action != action {
+			continue
+		}
+		if target != "" && entry.Target != target {
+			continue
+		}
+		if !since.IsZero() && entry.Time.Before(since) {
+			continue
+		}
+		result = append(result, entry)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+// Package auth chứa phần xác thực không phụ thuộc trạng thái của server: so sánh password với thời gian
+// không đổi, kiểm tra thông tin đăng nhập SOCKS, giới hạn số lần sai theo IP và đọc tham số gắn trong
+// username. Danh sách user, cấu hình và ghi log do server giữ
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"time"
+)
+
+// So sánh trên hash nên thời gian không phụ thuộc vào vị trí ký tự sai hay độ dài password
+func PasswordEqual(stored, given string) bool {
+	storedHash := sha256.Sum256([]byte(stored))
+	givenHash := sha256.Sum256([]byte(given))
+	return subtle.ConstantTimeCompare(storedHash[:], givenHash[:]) == 1
+}
+
+// Username hợp lệ: không rỗng, không có ký tự điều khiển (tránh chèn dòng vào log)
+func ValidUsername(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Password hợp lệ: không rỗng, không có NUL, CR, LF (users.conf không lưu được các ký tự này)
+func ValidPassword(password []byte) bool {
+	return len(password) > 0 && !bytes.ContainsAny(password, "\x00\r\n")
+}
+
+// Chờ tới started + delay trước khi trả lời xác thực thất bại; nếu đã quá thì trả lời ngay. Mọi lần thất bại
+// được trả lời sau cùng một khoảng, bất kể lý do, để thời gian phản hồi không tiết lộ user có tồn tại không
+func WaitFailure(started time.Time, delay time.Duration) {
+	if wait := time.Until(started.Add(delay)); wait > 0 {
+		time.Sleep(wait)
+	}
+}
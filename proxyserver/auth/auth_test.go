@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseUsernameParams(t *testing.T) {
+	checkCountry := func(country string) error {
+		if country != "us" && country != "de" {
+			return errors.New("no egress in " + country)
+		}
+		return nil
+	}
+	tests := []struct {
+		username string
+		user     string
+		params   UsernameParams
+		ok       bool
+	}{
+		{"alice", "alice", UsernameParams{}, true},
+		{"alice-smith", "alice-smith", UsernameParams{}, true},
+		{"alice-session-x1", "alice", UsernameParams{Session: "x1"}, true},
+		{"alice-smith-session-x1-ttl-10-country-US", "alice-smith", UsernameParams{Session: "x1", TTL: 10 * time.Minute, Country: "us"}, true},
+		{"alice-country-de", "alice", UsernameParams{Country: "de"}, true},
+		{"alice-session", "", UsernameParams{}, false},
+		{"alice-session-x1-session-x2", "", UsernameParams{}, false},
+		{"alice-session-x1-zone-a", "", UsernameParams{}, false},
+		{"alice-session-bad.name", "", UsernameParams{}, false},
+		{"alice-ttl-10", "", UsernameParams{}, false},
+		{"alice-session-x1-ttl-0", "", UsernameParams{}, false},
+		{"alice-country-fr", "", UsernameParams{}, false},
+	}
+	for _, test := range tests {
+		user, params, err := ParseUsernameParams(test.username, checkCountry)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v", test.username, err)
+			continue
+		}
+		if test.ok && (user != test.user || params != test.params) {
+			t.Errorf("%s: got %q %+v, want %q %+v", test.username, user, params, test.user, test.params)
+		}
+	}
+}
+
+func TestFailureLimiter(t *testing.T) {
+	limiter := NewFailureLimiter(time.Minute)
+	for i := 1; i <= 3; i++ {
+		if limiter.Blocked("192.0.2.1", 3) > 0 {
+			t.Fatalf("blocked after %d failures", i-1)
+		}
+		if reached := limiter.Record("192.0.2.1", 3); reached != (i == 3) {
+			t.Fatalf("failure %d: limit reached = %v", i, reached)
+		}
+	}
+	if wait := limiter.Blocked("192.0.2.1", 3); wait <= 0 || wait > time.Minute {
+		t.Fatalf("blocked for %s after 3 failures", wait)
+	}
+	if limiter.Blocked("192.0.2.2", 3) > 0 {
+		t.Fatal("other key blocked")
+	}
+	if limiter.Record("192.0.2.3", -1) || limiter.Blocked("192.0.2.1", -1) > 0 {
+		t.Fatal("negative limit is enforced")
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Vượt quá số cửa sổ này thì dọn các cửa sổ đã hết hạn
+const maxFailureWindows = 4096
+
+type failureWindow struct {
+	count int
+	start time.Time
+}
+
+// Đếm số lần xác thực sai của mỗi key (thường là IP) trong một cửa sổ thời gian cố định. Key đã sai đủ
+// limit lần bị từ chối tới hết cửa sổ kể cả khi gửi đúng, để việc dò không tăng tốc được bằng nhiều
+// request song song. limit âm là không giới hạn
+type FailureLimiter struct {
+	window  time.Duration
+	windows map[string]*failureWindow
+	mutex   sync.Mutex
+}
+
+func NewFailureLimiter(window time.Duration) *FailureLimiter {
+	return &FailureLimiter{window: window, windows: make(map[string]*failureWindow)}
+}
+
+// Độ dài cửa sổ
+func (l *FailureLimiter) Window() time.Duration {
+	return l.window
+}
+
+// Thời gian key còn bị từ chối, 0 nếu được thử tiếp
+func (l *FailureLimiter) Blocked(key string, limit int) time.Duration {
+	if limit < 0 {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	window := l.windows[key]
+	if window == nil || window.count < limit {
+		return 0
+	}
+	remaining := time.Until(window.start.Add(l.window))
+	if remaining <= 0 {
+		delete(l.windows, key)
+		return 0
+	}
+	return remaining
+}
+
+// Ghi nhận một lần sai của key; true khi lần này vừa chạm limit (key bắt đầu bị từ chối)
+func (l *FailureLimiter) Record(key string, limit int) bool {
+	if limit < 0 {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	window := l.windows[key]
+	if window == nil || now.Sub(window.start) >= l.window {
+		if window == nil && len(l.windows) >= maxFailureWindows {
+			for other, expired := range l.windows {
+				if now.Sub(expired.start) >= l.window {
+					delete(l.windows, other)
+				}
+			}
+		}
+		window = &failureWindow{start: now}
+		l.windows[key] = window
+	}
+	window.count++
+	return window.count == limit
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tham số gắn trong username SOCKS5, như các nhà cung cấp proxy dân cư: alice-session-x1-ttl-10-country-us.
+// Phần trước tham số đầu tiên là username thật
+const (
+	usernameParamSession = "session" // Phiên dính theo ID do client đặt, không phụ thuộc IP client
+	usernameParamTTL     = "ttl"     // Thời gian sống tối đa của phiên (phút)
+	usernameParamCountry = "country" // Chỉ dùng IP egress của quốc gia này
+
+	maxSessionNameLen = 64
+	maxSessionTTL     = 1440 // Phút
+)
+
+// Các tham số đọc được từ username
+type UsernameParams struct {
+	Session string
+	TTL     time.Duration
+	Country string // Mã quốc gia chữ thường
+}
+
+func isUsernameParam(key string) bool {
+	return key == usernameParamSession || key == usernameParamTTL || key == usernameParamCountry
+}
+
+// Tách username thật và các tham số. Username không có tham số nào được trả về như cũ. checkCountry kiểm
+// tra mã quốc gia (đã đổi sang chữ thường), ví dụ có IP egress của quốc gia đó không
+func ParseUsernameParams(username string, checkCountry func(country string) error) (string, UsernameParams, error) {
+	var params UsernameParams
+	parts := strings.Split(username, "-")
+	start := 1
+	for start < len(parts) && !isUsernameParam(parts[start]) {
+		start++
+	}
+	if start == len(parts) {
+		return username, params, nil
+	}
+	rest := parts[start:]
+	if len(rest)%2 != 0 {
+		return "", params, fmt.Errorf("parameter %s has no value", rest[len(rest)-1])
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < len(rest); i += 2 {
+		key, value := rest[i], rest[i+1]
+		if !isUsernameParam(key) {
+			return "", params, fmt.Errorf("unknown parameter %s", key)
+		}
+		if seen[key] {
+			return "", params, fmt.Errorf("duplicate parameter %s", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case usernameParamSession:
+			if !validSessionName(value) {
+				return "", params, fmt.Errorf("invalid session %q", value)
+			}
+			params.Session = value
+		case usernameParamTTL:
+			minutes, err := strconv.Atoi(value)
+			if err != nil || minutes < 1 || minutes > maxSessionTTL {
+				return "", params, fmt.Errorf("invalid ttl %q", value)
+			}
+			params.TTL = time.Duration(minutes) * time.Minute
+		case usernameParamCountry:
+			country := strings.ToLower(value)
+			if err := checkCountry(country); err != nil {
+				return "", params, err
+			}
+			params.Country = country
+		}
+	}
+	if params.TTL > 0 && params.Session == "" {
+		return "", params, fmt.Errorf("ttl requires a session")
+	}
+	return strings.Join(parts[:start], "-"), params, nil
+}
+
+// ID phiên do client đặt: chữ, số và dấu gạch dưới
+func validSessionName(name string) bool {
+	if name == "" || len(name) > maxSessionNameLen {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
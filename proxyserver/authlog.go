@@ -0,0 +1,88 @@
+package proxyserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	authLogFile  *os.File
+	authLogMutex sync.Mutex
+
+	authFailureCounts = make(map[authFailureKey]int64)
+	authFailureMutex  sync.Mutex
+)
+
+// Nhãn của proxy_auth_failures_total
+type authFailureKey struct {
+	Proto  string
+	Reason string
+}
+
+// Mở file log riêng cho các lần xác thực thất bại
+func openAuthLog(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	authLogMutex.Lock()
+	authLogFile = file
+	authLogMutex.Unlock()
+	return nil
+}
+
+// Ghi một dòng xác thực thất bại theo định dạng cố định để dùng với fail2ban:
+// proxy-auth-failure client=<ip> proto=<socks5|admin> user="<username>" reason=<reason>
+func logAuthFailure(remoteAddr, proto, username, reason string) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	line := fmt.Sprintf("proxy-auth-failure client=%s proto=%s user=%q reason=%s", host, proto, username, reason)
+	log.Print(line)
+
+	authFailureMutex.Lock()
+	authFailureCounts[authFailureKey{proto, reason}]++
+	authFailureMutex.Unlock()
+	publishEvent(BusEvent{Type: eventAuthFailure, Username: username, Client: remoteAddr, Protocol: proto, Reason: reason})
+
+	authLogMutex.Lock()
+	defer authLogMutex.Unlock()
+
+	if authLogFile == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(authLogFile, "%s %s\n", time.Now().Format(time.RFC3339), line); err != nil {
+		log.Printf("Auth log write error: %v", err)
+	}
+}
+
+type authFailureCount struct {
+	authFailureKey
+	Count int64
+}
+
+// Bộ đếm xác thực thất bại theo giao thức và lý do, sắp xếp theo nhãn
+func authFailureSnapshot() []authFailureCount {
+	authFailureMutex.Lock()
+	defer authFailureMutex.Unlock()
+
+	snapshot := make([]authFailureCount, 0, len(authFailureCounts))
+	for key, count := range authFailureCounts {
+		snapshot = append(snapshot, authFailureCount{key, count})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Proto != snapshot[j].Proto {
+			return snapshot[i].Proto < snapshot[j].Proto
+		}
+		return snapshot[i].Reason < snapshot[j].Reason
+	})
+	return snapshot
+}
@@ -0,0 +1,215 @@
+package proxyserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Xác thực với AWS không cần SDK: lấy credentials theo thứ tự biến môi trường, endpoint của ECS,
+// instance metadata (IMDSv2) và ký request bằng Signature Version 4
+const (
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	ecsCredentialsHost  = "http://169.254.170.2"
+	awsMetadataTimeout  = 2 * time.Second
+	awsCredentialsSkew  = 5 * time.Minute // Lấy credentials mới trước khi hết hạn
+)
+
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"` // Rỗng với credentials từ biến môi trường
+}
+
+var (
+	awsCredentialsCache awsCredentials
+	awsCredentialsMutex sync.Mutex
+)
+
+// Region AWS: aws_region, sau đó AWS_REGION và AWS_DEFAULT_REGION
+func awsRegion() string {
+	if systemConfig().AWSRegion != "" {
+		return systemConfig().AWSRegion
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Credentials hiện tại, lấy lại khi credentials tạm thời sắp hết hạn
+func getAWSCredentials() (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	awsCredentialsMutex.Lock()
+	defer awsCredentialsMutex.Unlock()
+
+	if awsCredentialsCache.AccessKeyID != "" && time.Until(awsCredentialsCache.Expiration) > awsCredentialsSkew {
+		return awsCredentialsCache, nil
+	}
+	var creds awsCredentials
+	var err error
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		creds, err = ecsCredentials()
+	} else {
+		creds, err = instanceCredentials()
+	}
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials: %v", err)
+	}
+	awsCredentialsCache = creds
+	return creds, nil
+}
+
+// Credentials của task role trên ECS/Fargate
+func ecsCredentials() (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = ecsCredentialsHost + relative
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var creds awsCredentials
+	if err := awsMetadataJSON(req, &creds); err != nil {
+		return awsCredentials{}, err
+	}
+	return creds, nil
+}
+
+// Credentials của instance role qua IMDSv2
+func instanceCredentials() (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := awsMetadataText(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance metadata: %v", err)
+	}
+
+	rolePath := endpoint + "/latest/meta-data/iam/security-credentials/"
+	req, _ = http.NewRequest(http.MethodGet, rolePath, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := awsMetadataText(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance role: %v", err)
+	}
+	role, _, _ = strings.Cut(strings.TrimSpace(role), "\n")
+
+	req, _ = http.NewRequest(http.MethodGet, rolePath+role, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	var creds awsCredentials
+	if err := awsMetadataJSON(req, &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("instance role %s: %v", role, err)
+	}
+	return creds, nil
+}
+
+func awsMetadataText(req *http.Request) (string, error) {
+	client := http.Client{Timeout: awsMetadataTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return string(body), nil
+}
+
+func awsMetadataJSON(req *http.Request, out *awsCredentials) error {
+	text, err := awsMetadataText(req)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(text), out); err != nil {
+		return err
+	}
+	if out.AccessKeyID == "" || out.SecretAccessKey == "" {
+		return errors.New("response has no access key")
+	}
+	return nil
+}
+
+// Ký request bằng AWS Signature Version 4; mọi header đã đặt trên req đều được ký
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{req.Method, path, query, canonicalHeaders.String(), signedHeaders, sha256Hex(body)}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,682 @@
+package proxyserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Sao lưu định kỳ: file cấu hình, users.conf, token admin API, journal dữ liệu đã dùng và các file quy tắc
+// được đóng gói thành một file tar.gz (kèm ảnh chụp dữ liệu đã dùng của mọi user) và lưu vào backup_target,
+// là thư mục hoặc bucket S3 (s3://bucket/prefix). Chỉ giữ backup_keep bản mới nhất
+const (
+	defaultBackupInterval = 24 // Giờ
+	defaultBackupKeep     = 7
+	backupCheckInterval   = time.Minute
+	backupHTTPTimeout     = 5 * time.Minute
+	backupManifestName    = "backup/manifest.json"
+	backupUsageName       = "backup/usage.json"
+	backupFilesPrefix     = "files/"
+	backupNamePrefix      = "backup-"
+	backupNameSuffix      = ".tar.gz"
+	backupTimeFormat      = "20060102T150405Z"
+)
+
+// Mô tả bản sao lưu, nằm trong file tar.gz
+type BackupManifest struct {
+	Created           time.Time    `json:"created"`
+	Host              string       `json:"host"`
+	Version           string       `json:"version"`
+	ConfigFingerprint string       `json:"config_fingerprint"`
+	Files             []BackupFile `json:"files"`
+}
+
+// Một file trong bản sao lưu, khôi phục về đúng đường dẫn lúc sao lưu
+type BackupFile struct {
+	Role string `json:"role"` // system, users, tokens, usage_journal...
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Kết quả của một lần sao lưu, trả về qua admin API
+type BackupResult struct {
+	Name   string       `json:"name"`
+	Target string       `json:"target"`
+	Size   int64        `json:"size"`
+	Files  []BackupFile `json:"files"`
+}
+
+// Một bản sao lưu trong backup_target
+type BackupEntry struct {
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+// Nơi lưu bản sao lưu: thư mục hoặc bucket S3
+type backupStore interface {
+	put(name string, data []byte) error
+	get(name string) ([]byte, error)
+	list() ([]BackupEntry, error)
+	remove(name string) error
+}
+
+var (
+	backupMutex      sync.Mutex // Mỗi lúc chỉ một lần sao lưu
+	lastBackup       time.Time
+	lastBackupAt     atomic.Int64 // Unix, lần sao lưu thành công gần nhất
+	backupsSucceeded atomic.Int64
+	backupsFailed    atomic.Int64
+)
+
+func backupInterval() time.Duration {
+	if systemConfig().BackupInterval <= 0 {
+		return defaultBackupInterval * time.Hour
+	}
+	return time.Duration(systemConfig().BackupInterval) * time.Hour
+}
+
+func backupKeep() int {
+	if systemConfig().BackupKeep <= 0 {
+		return defaultBackupKeep
+	}
+	return systemConfig().BackupKeep
+}
+
+// backup_target: s3://bucket/prefix hoặc đường dẫn thư mục
+func openBackupStore(target string) (backupStore, error) {
+	if target == "" {
+		return nil, errors.New("backup_target is not set")
+	}
+	if rest, found := strings.CutPrefix(target, "s3://"); found {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid backup_target: %s", target)
+		}
+		return newS3Store(bucket, prefix, systemConfig().BackupS3Endpoint), nil
+	}
+	return localBackupStore(target), nil
+}
+
+// Tên file của bản sao lưu hợp lệ: không chứa đường dẫn
+func validBackupName(name string) bool {
+	return strings.HasPrefix(name, backupNamePrefix) && strings.HasSuffix(name, backupNameSuffix) &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+// Các file được sao lưu theo cấu hình hiện tại; file không tồn tại được bỏ qua khi đóng gói
+func backupSources() []BackupFile {
+	sources := []BackupFile{
+		{Role: "system", Path: systemFile},
+		{Role: "users", Path: userFile},
+		{Role: "tokens", Path: adminTokensPath()},
+	}
+	optional := []struct{ role, path string }{
+		{"usage_journal", systemConfig().UsageJournal},
+		{"last_seen", systemConfig().LastSeenFile},
+		{"acl", systemConfig().ACLFile},
+		{"dns_overrides", systemConfig().DNSOverridesFile},
+		{"upstreams", systemConfig().UpstreamFile},
+		{"rewrite", systemConfig().RewriteFile},
+		{"policy_script", systemConfig().PolicyScript},
+		{"maintenance_page", systemConfig().MaintenancePage},
+		{"expired_user_archive", systemConfig().ExpiredUserArchive},
+	}
+	for _, file := range optional {
+		if file.path != "" {
+			sources = append(sources, BackupFile{Role: file.role, Path: file.path})
+		}
+	}
+	for event, template := range systemConfig().EmailEvents {
+		if template != "" {
+			sources = append(sources, BackupFile{Role: "email_template_" + event, Path: template})
+		}
+	}
+	return sources
+}
+
+// Tạo bản sao lưu và lưu vào backup_target; by là nguồn yêu cầu (schedule hoặc tên token)
+func runBackup(by string) (BackupResult, error) {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+
+	result, err := createBackup()
+	if err != nil {
+		backupsFailed.Add(1)
+		log.Printf("Backup error: %v", err)
+		notifyOperators("Proxy backup failed: %v", err)
+		return result, err
+	}
+	backupsSucceeded.Add(1)
+	lastBackup = time.Now()
+	lastBackupAt.Store(lastBackup.Unix())
+	log.Printf("Backup %s written to %s (%d bytes, %d files, by %s)", result.Name, result.Target, result.Size, len(result.Files), by)
+	return result, nil
+}
+
+func createBackup() (BackupResult, error) {
+	store, err := openBackupStore(systemConfig().BackupTarget)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	// Ghi phần dữ liệu đang gom trong bộ nhớ xuống file trước khi đọc
+	if err := flushUsageJournal(); err != nil {
+		return BackupResult{}, fmt.Errorf("usage journal: %v", err)
+	}
+	if err := saveLastSeen(); err != nil {
+		return BackupResult{}, fmt.Errorf("last seen file: %v", err)
+	}
+
+	now := time.Now().UTC()
+	host, _ := os.Hostname()
+	manifest := BackupManifest{Created: now, Host: host, Version: Version, ConfigFingerprint: configFingerprint()}
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	for _, source := range backupSources() {
+		data, err := os.ReadFile(source.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return BackupResult{}, err
+		}
+		source.Size = int64(len(data))
+		if err := writeTarFile(tw, backupFilesPrefix+source.Role, data, now); err != nil {
+			return BackupResult{}, err
+		}
+		manifest.Files = append(manifest.Files, source)
+	}
+	usage, _ := json.MarshalIndent(usageSnapshot(), "", "  ")
+	meta, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := writeTarFile(tw, backupUsageName, usage, now); err != nil {
+		return BackupResult{}, err
+	}
+	if err := writeTarFile(tw, backupManifestName, meta, now); err != nil {
+		return BackupResult{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return BackupResult{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return BackupResult{}, err
+	}
+
+	name := backupNamePrefix + now.Format(backupTimeFormat) + backupNameSuffix
+	if err := store.put(name, archive.Bytes()); err != nil {
+		return BackupResult{}, err
+	}
+	if err := pruneBackups(store); err != nil {
+		log.Printf("Backup retention error: %v", err)
+	}
+	return BackupResult{Name: name, Target: systemConfig().BackupTarget, Size: int64(archive.Len()), Files: manifest.Files}, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Dữ liệu đã dùng của mọi user, dùng để dựng lại journal khi khôi phục lên server chưa có usage_journal
+func usageSnapshot() map[string]int64 {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	usage := make(map[string]int64, len(users))
+	for username, user := range users {
+		if n := user.dataUsage(); n > 0 {
+			usage[username] = n
+		}
+	}
+	return usage
+}
+
+// Xóa các bản cũ, chỉ giữ backup_keep bản mới nhất
+func pruneBackups(store backupStore) error {
+	entries, err := store.list()
+	if err != nil {
+		return err
+	}
+	for len(entries) > backupKeep() {
+		if err := store.remove(entries[0].Name); err != nil {
+			return err
+		}
+		entries = entries[1:]
+	}
+	return nil
+}
+
+// Sao lưu theo backup_interval; lần sao lưu gần nhất được lấy từ backup_target nên khởi động lại không
+// tạo bản mới ngay
+func runBackupScheduler() {
+	for {
+		time.Sleep(backupCheckInterval)
+		if systemConfig().BackupTarget == "" || systemConfig().BackupInterval < 0 {
+			continue
+		}
+		backupMutex.Lock()
+		last := lastBackup
+		backupMutex.Unlock()
+		if last.IsZero() {
+			if store, err := openBackupStore(systemConfig().BackupTarget); err == nil {
+				if entries, err := store.list(); err == nil && len(entries) > 0 {
+					last = entries[len(entries)-1].Time
+				}
+			}
+			backupMutex.Lock()
+			if lastBackup.IsZero() {
+				lastBackup = last
+			}
+			backupMutex.Unlock()
+		}
+		if time.Since(last) >= backupInterval() {
+			runBackup("schedule")
+		}
+	}
+}
+
+// Các bản sao lưu theo thứ tự thời gian (tên bắt đầu bằng thời điểm tạo)
+func sortBackupEntries(entries []BackupEntry) []BackupEntry {
+	valid := entries[:0]
+	for _, entry := range entries {
+		created, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(entry.Name, backupNamePrefix), backupNameSuffix))
+		if err == nil && validBackupName(entry.Name) {
+			entry.Time = created
+			valid = append(valid, entry)
+		}
+	}
+	sort.Slice(valid, func(i, j int) bool { return valid[i].Name < valid[j].Name })
+	return valid
+}
+
+type localBackupStore string
+
+func (dir localBackupStore) put(name string, data []byte) error {
+	if err := os.MkdirAll(string(dir), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(string(dir), name), data)
+}
+
+func (dir localBackupStore) get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(dir), name))
+}
+
+func (dir localBackupStore) list() ([]BackupEntry, error) {
+	files, err := os.ReadDir(string(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []BackupEntry
+	for _, file := range files {
+		if info, err := file.Info(); err == nil && info.Mode().IsRegular() {
+			entries = append(entries, BackupEntry{Name: file.Name(), Size: info.Size()})
+		}
+	}
+	return sortBackupEntries(entries), nil
+}
+
+func (dir localBackupStore) remove(name string) error {
+	return os.Remove(filepath.Join(string(dir), name))
+}
+
+// Bucket S3 hoặc dịch vụ tương thích S3 (MinIO, R2...), địa chỉ dạng path-style endpoint/bucket/key
+type s3BackupStore struct {
+	bucket   string
+	prefix   string
+	endpoint string // Rỗng = AWS S3 theo aws_region
+}
+
+func newS3Store(bucket, prefix, endpoint string) *s3BackupStore {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3BackupStore{bucket: bucket, prefix: prefix, endpoint: endpoint}
+}
+
+func (s *s3BackupStore) baseURL() string {
+	if s.endpoint != "" {
+		return strings.TrimRight(s.endpoint, "/")
+	}
+	return "https://s3." + awsRegion() + ".amazonaws.com"
+}
+
+// Gửi request đã ký tới S3; trả về body khi thành công
+func (s *s3BackupStore) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	creds, err := getAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	target := s.baseURL() + "/" + s.bucket + "/" + key
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	signAWSRequest(req, body, creds, awsRegion(), "s3", time.Now())
+
+	client := &http.Client{Timeout: backupHTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3Error struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(data, &s3Error) == nil && s3Error.Code != "" {
+			return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, s3Error.Code, s3Error.Message)
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s", method, key, resp.Status)
+	}
+	return data, nil
+}
+
+func (s *s3BackupStore) put(name string, data []byte) error {
+	_, err := s.do(http.MethodPut, s.prefix+name, nil, data)
+	return err
+}
+
+func (s *s3BackupStore) get(name string) ([]byte, error) {
+	return s.do(http.MethodGet, s.prefix+name, nil, nil)
+}
+
+func (s *s3BackupStore) list() ([]BackupEntry, error) {
+	var entries []BackupEntry
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + backupNamePrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			entries = append(entries, BackupEntry{Name: path.Base(object.Key), Size: object.Size})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return sortBackupEntries(entries), nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3BackupStore) remove(name string) error {
+	_, err := s.do(http.MethodDelete, s.prefix+name, nil, nil)
+	return err
+}
+
+// Đọc bản sao lưu: các file theo vai trò, manifest và ảnh chụp dữ liệu đã dùng
+func readBackup(data []byte) (BackupManifest, map[string][]byte, map[string]int64, error) {
+	var manifest BackupManifest
+	var usage map[string]int64
+	files := make(map[string][]byte)
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return manifest, nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return manifest, nil, nil, err
+		}
+		switch {
+		case header.Name == backupManifestName:
+			err = json.Unmarshal(content, &manifest)
+		case header.Name == backupUsageName:
+			err = json.Unmarshal(content, &usage)
+		case strings.HasPrefix(header.Name, backupFilesPrefix):
+			files[strings.TrimPrefix(header.Name, backupFilesPrefix)] = content
+		}
+		if err != nil {
+			return manifest, nil, nil, err
+		}
+	}
+	if manifest.Created.IsZero() {
+		return manifest, nil, nil, errors.New("not a backup archive: manifest missing")
+	}
+	return manifest, files, usage, nil
+}
+
+// POST /api/backups: sao lưu ngay
+func handleAdminCreateBackup(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	result, err := runBackup("token " + token.Name)
+	recordAudit(token.Name, "backup.create", result.Name, nil, err == nil)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, result)
+}
+
+// GET /api/backups: các bản sao lưu trong backup_target, cũ nhất trước
+func handleAdminListBackups(w http.ResponseWriter, r *http.Request) {
+	store, err := openBackupStore(systemConfig().BackupTarget)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, err := store.list()
+	if err != nil {
+		admin.WriteError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []BackupEntry{}
+	}
+	admin.WriteJSON(w, http.StatusOK, entries)
+}
+
+// proxy-server backup now|list: sao lưu qua admin API của server đang chạy, để ảnh chụp dữ liệu đã dùng
+// là của server đó
+func runBackupCommand(args []string) int {
+	usage := "Usage: proxy-server backup now|list [--api URL] [--token TOKEN]"
+	if len(args) < 1 || (args[0] != "now" && args[0] != "list") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("backup "+args[0], flag.ContinueOnError)
+	var client apiClientFlags
+	client.register(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	if args[0] == "list" {
+		var entries []BackupEntry
+		if err := client.call(http.MethodGet, "/api/backups", &entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to list backups: %v\n", err)
+			return 1
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s  %10d  %s\n", entry.Time.Format(time.RFC3339), entry.Size, entry.Name)
+		}
+		return 0
+	}
+
+	var result BackupResult
+	if err := client.call(http.MethodPost, "/api/backups", &result); err != nil {
+		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Backup %s written to %s (%d bytes)\n", result.Name, result.Target, result.Size)
+	for _, file := range result.Files {
+		fmt.Printf("  %-20s %s\n", file.Role, file.Path)
+	}
+	return 0
+}
+
+// proxy-server restore [--dry-run] [--from TARGET] <tên bản sao lưu|file>: ghi các file về đường dẫn lúc sao
+// lưu, file hiện có được giữ lại với đuôi .before-restore. Chạy khi server đã dừng
+func runRestoreCommand(args []string) int {
+	usage := "Usage: proxy-server restore [--dry-run] [--from DIR|s3://bucket/prefix] <backup name|file>"
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only show what would be restored")
+	from := flags.String("from", "", "backup location (default: backup_target in system.conf)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	name := flags.Arg(0)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) && validBackupName(name) {
+		target := *from
+		if target == "" {
+			if err := loadSystemConfig(systemFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to load system configuration, use --from: %v\n", err)
+				return 1
+			}
+			target = systemConfig().BackupTarget
+		} else if err := loadSystemConfig(systemFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Unable to load system configuration: %v\n", err)
+			return 1
+		}
+		store, storeErr := openBackupStore(target)
+		if storeErr != nil {
+			fmt.Fprintf(os.Stderr, "Restore failed: %v\n", storeErr)
+			return 1
+		}
+		data, err = store.get(name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read backup %s: %v\n", name, err)
+		return 1
+	}
+	manifest, files, usageTotals, err := readBackup(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read backup %s: %v\n", name, err)
+		return 1
+	}
+
+	fmt.Printf("Backup of %s taken %s by version %s\n", manifest.Host, manifest.Created.Format(time.RFC3339), manifest.Version)
+	restored := make(map[string]bool)
+	for _, file := range manifest.Files {
+		content, exists := files[file.Role]
+		if !exists {
+			fmt.Fprintf(os.Stderr, "Backup %s is missing %s\n", name, file.Role)
+			return 1
+		}
+		fmt.Printf("  %-20s %s (%d bytes)\n", file.Role, file.Path, len(content))
+		restored[file.Role] = true
+		if *dryRun {
+			continue
+		}
+		if err := restoreFile(file.Path, content); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to restore %s: %v\n", file.Path, err)
+			return 1
+		}
+	}
+
+	// Không có journal trong bản sao lưu: dựng journal từ ảnh chụp dữ liệu đã dùng nếu cấu hình khôi phục có usage_journal
+	if !restored["usage_journal"] && len(usageTotals) > 0 && files["system"] != nil {
+		config, err := parseSystemConfig(bytes.NewReader(files["system"]))
+		if err == nil && config.UsageJournal != "" {
+			fmt.Printf("  %-20s %s (data usage of %d users)\n", "usage_journal", config.UsageJournal, len(usageTotals))
+			if !*dryRun {
+				usernames := make([]string, 0, len(usageTotals))
+				for username := range usageTotals {
+					usernames = append(usernames, username)
+				}
+				sort.Strings(usernames)
+				var journal strings.Builder
+				for _, username := range usernames {
+					journal.WriteString(journalRecord(username, usageTotals[username]))
+				}
+				if err := restoreFile(config.UsageJournal, []byte(journal.String())); err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to restore %s: %v\n", config.UsageJournal, err)
+					return 1
+				}
+			}
+		}
+	}
+
+	if *dryRun {
+		fmt.Println("Dry run: nothing restored.")
+		return 0
+	}
+	fmt.Println("Restore complete. Start the server to load the restored files.")
+	return 0
+}
+
+// Ghi file khôi phục; file hiện có với nội dung khác được giữ lại với đuôi .before-restore
+func restoreFile(path string, data []byte) error {
+	if current, err := os.ReadFile(path); err == nil && !bytes.Equal(current, data) {
+		if err := writeFileAtomic(path+".before-restore", current); err != nil {
+			return err
+		}
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data)
+}
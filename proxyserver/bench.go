@@ -0,0 +1,205 @@
+package proxyserver
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/socks5"
+)
+
+// Kết quả của một client trong bài đo
+type benchResult struct {
+	tunnels    int64
+	errors     int64
+	bytes      int64
+	handshakes []time.Duration
+}
+
+// Lệnh bench: chạy N client SOCKS5 song song qua proxy tới một echo server nội bộ
+func runBenchCommand(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	proxyAddr := flags.String("proxy", "", "SOCKS5 proxy to benchmark (default: start one in-process)")
+	username := flags.String("user", "", "username for --proxy")
+	password := flags.String("pass", "", "password for --proxy")
+	clients := flags.Int("clients", 50, "number of concurrent clients")
+	duration := flags.Duration("duration", 10*time.Second, "benchmark duration")
+	size := flags.Int("size", 64*1024, "bytes echoed through each tunnel")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *proxyAddr != "" && *username == "" {
+		fmt.Fprintln(os.Stderr, "--user and --pass are required with --proxy")
+		return 2
+	}
+
+	echo, err := startEchoServer(int64(*size))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to start echo server: %v\n", err)
+		return 1
+	}
+	defer echo.Close()
+
+	allocScope := "client"
+	if *proxyAddr == "" {
+		listener, err := startLocalProxy()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to start in-process proxy: %v\n", err)
+			return 1
+		}
+		defer listener.Close()
+		*proxyAddr = listener.Addr().String()
+		*username, *password = "bench", "bench"
+		allocScope = "client+server"
+	}
+
+	target := echo.Addr().(*net.TCPAddr)
+	var memBefore runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+
+	deadline := time.Now().Add(*duration)
+	results := make([]benchResult, *clients)
+	var clientsWG sync.WaitGroup
+	for i := range results {
+		clientsWG.Add(1)
+		go func(result *benchResult) {
+			defer clientsWG.Done()
+			payload := make([]byte, *size)
+			for time.Now().Before(deadline) {
+				handshake, err := benchTunnel(*proxyAddr, *username, *password, target, payload)
+				if err != nil {
+					result.errors++
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+				result.tunnels++
+				result.bytes += int64(2 * len(payload))
+				result.handshakes = append(result.handshakes, handshake)
+			}
+		}(&results[i])
+	}
+	clientsWG.Wait()
+	elapsed := *duration
+
+	var memAfter runtime.MemStats
+	runtime.ReadMemStats(&memAfter)
+
+	var total benchResult
+	for _, result := range results {
+		total.tunnels += result.tunnels
+		total.errors += result.errors
+		total.bytes += result.bytes
+		total.handshakes = append(total.handshakes, result.handshakes...)
+	}
+	sort.Slice(total.handshakes, func(i, j int) bool { return total.handshakes[i] < total.handshakes[j] })
+
+	fmt.Printf("Proxy:       %s, %d clients, %s, %d bytes per tunnel\n", *proxyAddr, *clients, elapsed, *size)
+	fmt.Printf("Tunnels:     %d (%.1f/s), %d errors\n", total.tunnels, float64(total.tunnels)/elapsed.Seconds(), total.errors)
+	fmt.Printf("Throughput:  %.2f MB/s\n", float64(total.bytes)/elapsed.Seconds()/(1<<20))
+	if len(total.handshakes) > 0 {
+		fmt.Printf("Handshake:   p50 %s, p90 %s, p99 %s, max %s\n",
+			benchPercentile(total.handshakes, 0.50), benchPercentile(total.handshakes, 0.90),
+			benchPercentile(total.handshakes, 0.99), total.handshakes[len(total.handshakes)-1])
+	}
+	if total.tunnels > 0 {
+		fmt.Printf("Allocations: %d allocs, %d bytes per tunnel (%s)\n",
+			(memAfter.Mallocs-memBefore.Mallocs)/uint64(total.tunnels),
+			(memAfter.TotalAlloc-memBefore.TotalAlloc)/uint64(total.tunnels), allocScope)
+	}
+	return 0
+}
+
+func benchPercentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index].Round(time.Microsecond)
+}
+
+// Echo server nội bộ làm đích cho bài đo: trả lại size byte rồi đóng kết nối
+func startEchoServer(size int64) (net.Listener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.CopyN(conn, conn, size)
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// Proxy chạy trong process với user "bench" không giới hạn, dùng cùng handler như listener thật
+func startLocalProxy() (net.Listener, error) {
+	log.SetOutput(io.Discard)
+	users = map[string]*User{
+		"bench": {
+			Username:        "bench",
+			Password:        "bench",
+			EndDate:         time.Now().AddDate(1, 0, 0),
+			ConnectionLimit: math.MaxInt32,
+			MaxData:         math.MaxInt64,
+			MaxBandwidth:    math.MaxInt64,
+			state:           new(userState),
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := listener.Addr().String()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleSocksConn(conn, addr)
+		}
+	}()
+	return listener, nil
+}
+
+// Mở một tunnel SOCKS5, gửi payload và đọc lại toàn bộ, trả về thời gian bắt tay
+func benchTunnel(proxyAddr, username, password string, target *net.TCPAddr, payload []byte) (time.Duration, error) {
+	started := time.Now()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 10*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(started.Add(30 * time.Second))
+
+	if err := socks5.Connect(conn, username, password, target); err != nil {
+		return 0, err
+	}
+	handshake := time.Since(started)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(payload)
+		errs <- err
+	}()
+	if _, err := io.CopyN(io.Discard, conn, int64(len(payload))); err != nil {
+		return 0, err
+	}
+	return handshake, <-errs
+}
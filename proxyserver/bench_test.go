@@ -0,0 +1,109 @@
+package proxyserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"proxy_server/proxyserver/socks5"
+)
+
+// Benchmark trên proxy chạy trong process như lệnh bench: số liệu gồm cả client và server
+
+// Đích echo không giới hạn số byte, mỗi kết nối chép lại mọi thứ nhận được
+func startBenchEcho(b *testing.B) *net.TCPAddr {
+	b.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func startBenchProxy(b *testing.B) net.Listener {
+	b.Helper()
+	proxy, err := startLocalProxy()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+// Mỗi lần: kết nối TCP tới proxy, lời chào, đăng nhập, CONNECT tới đích rồi đóng
+func BenchmarkSocks5Handshake(b *testing.B) {
+	proxy := startBenchProxy(b)
+	target := startBenchEcho(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		conn, err := net.DialTimeout("tcp", proxy.Addr().String(), 5*time.Second)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := socks5.Connect(conn, "bench", "bench", target); err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+	}
+}
+
+// Mỗi lần: gửi một khối qua tunnel đang mở và đọc lại từ đích echo, tức là hai lần chép qua transferData
+func BenchmarkTransferData(b *testing.B) {
+	for _, size := range []int{1 << 10, 32 << 10, 256 << 10} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			proxy := startBenchProxy(b)
+			target := startBenchEcho(b)
+			conn, err := net.DialTimeout("tcp", proxy.Addr().String(), 5*time.Second)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			if err := socks5.Connect(conn, "bench", "bench", target); err != nil {
+				b.Fatal(err)
+			}
+
+			payload := make([]byte, size)
+			received := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			// Ghi trong goroutine riêng để khối lớn không làm đầy bộ đệm của cả hai chiều
+			written := make(chan error, 1)
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := conn.Write(payload); err != nil {
+						written <- err
+						return
+					}
+				}
+				written <- nil
+			}()
+			for i := 0; i < b.N; i++ {
+				if _, err := io.ReadFull(conn, received); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := <-written; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package proxyserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Tạo hoặc gia hạn tài khoản tự động từ webhook thanh toán (Stripe checkout.session.completed)
+// theo bảng sản phẩm -> gói trong billing_plan
+const (
+	stripeSignatureTolerance = 5 * time.Minute // Chênh lệch tối đa giữa timestamp chữ ký và đồng hồ server
+	stripeEventTTL           = 72 * time.Hour  // Stripe gửi lại sự kiện trong tối đa 3 ngày
+	maxStripeBody            = 1 << 20
+)
+
+var (
+	errStripeSignature = errors.New("invalid webhook signature")
+	validBillingName   = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+)
+
+// Gói bán qua cổng thanh toán: thời hạn và giới hạn của tài khoản
+type BillingPlan struct {
+	Product         string // ID sản phẩm, giá hoặc payment link (so với metadata.product hoặc payment_link)
+	Days            int
+	ConnectionLimit int
+	MaxData         int64
+	MaxBandwidth    int64
+	MaxTransfer     int64
+	Group           string
+}
+
+// Thông báo gửi tới billing_notify_webhook, để gửi thông tin đăng nhập cho khách hàng
+type BillingNotification struct {
+	Event    string `json:"event"` // created hoặc extended
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"` // Chỉ có khi tạo mới
+	Email    string `json:"email,omitempty"`
+	Plan     string `json:"plan"`
+	EndDate  string `json:"end_date"`
+	Session  string `json:"session"`
+}
+
+var (
+	stripeEvents      = make(map[string]time.Time) // ID sự kiện đã xử lý -> thời điểm hết hạn ghi nhớ
+	stripeEventsMutex sync.Mutex
+	userFileMutex     sync.Mutex // Các lần viết lại users.conf từ server đang chạy
+)
+
+// Đọc billing_plan=<sản phẩm>,days=30,connection_limit=5,max_data=...,max_bandwidth=...[,max_transfer=...][,group=...]
+func parseBillingPlan(value string) (BillingPlan, error) {
+	parts := strings.Split(value, ",")
+	plan := BillingPlan{Product: strings.TrimSpace(parts[0])}
+	if plan.Product == "" {
+		return plan, errors.New("missing product")
+	}
+	for _, part := range parts[1:] {
+		key, text, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return plan, fmt.Errorf("expected key=value, got %q", part)
+		}
+		if key == "group" {
+			plan.Group = text
+			continue
+		}
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || n < 0 {
+			return plan, fmt.Errorf("invalid %s %q", key, text)
+		}
+		switch key {
+		case "days":
+			plan.Days = int(n)
+		case "connection_limit":
+			plan.ConnectionLimit = int(n)
+		case "max_data":
+			plan.MaxData = n
+		case "max_bandwidth":
+			plan.MaxBandwidth = n
+		case "max_transfer":
+			plan.MaxTransfer = n
+		default:
+			return plan, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	if plan.Days <= 0 {
+		return plan, errors.New("days must be positive")
+	}
+	return plan, nil
+}
+
+// Kiểm tra header Stripe-Signature ("t=<unix>,v1=<hex HMAC-SHA256 của "t.payload">")
+func verifyStripeSignature(header string, payload []byte, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errStripeSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return errStripeSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if decoded, err := hex.DecodeString(signature); err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return errStripeSignature
+}
+
+// Ghi nhận ID sự kiện; false nếu sự kiện đã được xử lý (Stripe gửi lại)
+func markStripeEvent(id string) bool {
+	now := time.Now()
+	stripeEventsMutex.Lock()
+	defer stripeEventsMutex.Unlock()
+
+	if expires, seen := stripeEvents[id]; seen && now.Before(expires) {
+		return false
+	}
+	for eventID, expires := range stripeEvents {
+		if now.After(expires) {
+			delete(stripeEvents, eventID)
+		}
+	}
+	stripeEvents[id] = now.Add(stripeEventTTL)
+	return true
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Phần cần dùng của sự kiện Stripe checkout.session.completed
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID                string            `json:"id"`
+			PaymentStatus     string            `json:"payment_status"`
+			PaymentLink       string            `json:"payment_link"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			CustomerEmail     string            `json:"customer_email"`
+			Metadata          map[string]string `json:"metadata"`
+			CustomerDetails   struct {
+				Email string `json:"email"`
+			} `json:"customer_details"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// POST /api/webhooks/stripe: xác thực bằng chữ ký stripe_webhook_secret thay cho token admin
+func handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
+	if systemConfig().StripeWebhookSecret == "" {
+		admin.WriteError(w, http.StatusNotFound, "stripe webhook is not configured")
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStripeBody))
+	if err != nil {
+		admin.WriteError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+	secret, err := resolveSecret(systemConfig().StripeWebhookSecret)
+	if err != nil {
+		log.Printf("Stripe webhook: %v", err)
+		admin.WriteError(w, http.StatusInternalServerError, "webhook secret unavailable")
+		return
+	}
+	if err := verifyStripeSignature(r.Header.Get("Stripe-Signature"), payload, secret, time.Now()); err != nil {
+		logAuthFailure(r.RemoteAddr, "stripe", "", AuthBadSignature)
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	session := event.Data.Object
+	// Sự kiện khác và phiên chưa thanh toán xong (thanh toán chậm) được xác nhận nhưng bỏ qua
+	if event.Type != "checkout.session.completed" || session.PaymentStatus == "unpaid" {
+		admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	if !markStripeEvent(event.ID) {
+		admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "duplicate"})
+		return
+	}
+
+	plan, found := billingPlanFor(session.Metadata["product"], session.PaymentLink)
+	if !found {
+		log.Printf("Stripe webhook: no billing_plan for session %s (product %q, payment link %q)",
+			session.ID, session.Metadata["product"], session.PaymentLink)
+		admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	email := session.CustomerDetails.Email
+	if email == "" {
+		email = session.CustomerEmail
+	}
+	username := session.Metadata["username"]
+	if username == "" {
+		username = session.ClientReferenceID
+	}
+	if username != "" && !validBillingName.MatchString(username) {
+		admin.WriteError(w, http.StatusBadRequest, "invalid username")
+		return
+	}
+
+	// Tài khoản đã được tạo hoặc gia hạn trong bộ nhớ kể cả khi không ghi được users.conf,
+	// nên vẫn trả về thành công để Stripe không gửi lại và gia hạn lần nữa
+	notification, err := provisionPlan(username, plan, session.ID, email)
+	if err != nil {
+		log.Printf("Stripe webhook: session %s: unable to save %s: %v", session.ID, userFile, err)
+	}
+	sendBillingNotification(notification)
+	admin.WriteJSON(w, http.StatusOK, map[string]string{"status": notification.Event, "username": notification.Username})
+}
+
+func billingPlanFor(keys ...string) (BillingPlan, bool) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		for _, plan := range systemConfig().BillingPlans {
+			if plan.Product == key {
+				return plan, true
+			}
+		}
+	}
+	return BillingPlan{}, false
+}
+
+// max_data sau khi gia hạn. Dữ liệu đã dùng không được đặt lại (bộ đếm trong journal, cluster và agent chỉ
+// tăng), nên kỳ mới được cộng plan.MaxData lên trên phần đã dùng; khi tài khoản còn hạn, phần chưa dùng của
+// kỳ trước được chuyển sang. Plan không giới hạn (max_data=0) cho kết quả 0
+func extendedMaxData(user *User, plan BillingPlan, active bool) int64 {
+	if plan.MaxData <= 0 {
+		return 0
+	}
+	base := user.dataUsage()
+	if active && user.MaxData > base {
+		base = user.MaxData
+	}
+	return base + plan.MaxData
+}
+
+// Tạo tài khoản mới (username rỗng thì sinh ngẫu nhiên) hoặc gia hạn tài khoản sẵn có thêm plan.Days
+// tính từ ngày hết hạn hiện tại (hoặc hôm nay nếu đã hết hạn), rồi lưu vào users.conf
+func provisionPlan(username string, plan BillingPlan, session, email string) (BillingNotification, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	usersMutex.Lock()
+	if username == "" {
+		for {
+			username = "u" + randomHex(5)
+			if _, exists := users[username]; !exists {
+				break
+			}
+		}
+	}
+	notification := BillingNotification{Username: username, Email: email, Plan: plan.Product, Session: session}
+	user, exists := users[username]
+	var old any
+	if exists {
+		old = newUserView(user)
+		updated := *user
+		start := updated.EndDate.AddDate(0, 0, 1)
+		active := !start.Before(today)
+		if !active {
+			start = today
+		}
+		updated.EndDate = start.AddDate(0, 0, plan.Days-1)
+		updated.ConnectionLimit, updated.MaxBandwidth = plan.ConnectionLimit, plan.MaxBandwidth
+		updated.MaxData = extendedMaxData(user, plan, active)
+		updated.MaxTransfer, updated.Group = plan.MaxTransfer, plan.Group
+		if updated.Email == "" && validUserEmail(email) {
+			updated.Email = email
+		}
+		user = &updated
+		notification.Event = "extended"
+	} else {
+		user = &User{
+			Username:        username,
+			Password:        randomHex(8),
+			StartDate:       today,
+			EndDate:         today.AddDate(0, 0, plan.Days-1),
+			ConnectionLimit: plan.ConnectionLimit,
+			MaxData:         plan.MaxData,
+			MaxBandwidth:    plan.MaxBandwidth,
+			MaxTransfer:     plan.MaxTransfer,
+			Group:           plan.Group,
+			state:           new(userState),
+		}
+		if validUserEmail(email) {
+			user.Email = email
+		}
+		notification.Event = "created"
+		notification.Password = user.Password
+		queueUserEmail(emailAccountCreated, user, user.Password)
+	}
+	users[username] = user
+	notification.EndDate = user.EndDate.Format("2006-01-02")
+	usersMutex.Unlock()
+
+	recordAudit("stripe", "billing."+notification.Event, username, old, newUserView(user))
+	log.Printf("Billing: account %s %s until %s (plan %s, session %s)", username, notification.Event, notification.EndDate, plan.Product, session)
+	return notification, saveUserLine(userFile, user)
+}
+
+// Thay dòng của user trong users.conf (giữ nguyên cột password, có thể đã mã hóa) hoặc thêm vào cuối
+func saveUserLine(path string, user *User) error {
+	_, err := rewriteUserFile(path, []*User{user}, nil)
+	return err
+}
+
+func readUserFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Gửi thông báo (kèm password của tài khoản mới) tới billing_notify_webhook
+func sendBillingNotification(notification BillingNotification) {
+	if systemConfig().BillingNotifyWebhook == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(notification)
+		if err != nil {
+			return
+		}
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(systemConfig().BillingNotifyWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Billing notify webhook error: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
@@ -0,0 +1,34 @@
+package proxyserver
+
+import "testing"
+
+// Gia hạn không đặt lại dữ liệu đã dùng: plan.MaxData được cộng lên trên phần đã dùng, phần chưa dùng
+// chỉ được chuyển sang khi tài khoản còn hạn
+func TestExtendedMaxData(t *testing.T) {
+	const gb = 1 << 30
+	tests := []struct {
+		name           string
+		maxData, usage int64
+		planData       int64
+		active         bool
+		want           int64
+	}{
+		{"active, unused data carried over", 10 * gb, 4 * gb, 10 * gb, true, 20 * gb},
+		{"active, quota used up", 10 * gb, 10 * gb, 10 * gb, true, 20 * gb},
+		{"active, usage past the quota", 10 * gb, 12 * gb, 10 * gb, true, 22 * gb},
+		{"expired, unused data dropped", 10 * gb, 4 * gb, 10 * gb, false, 14 * gb},
+		{"unlimited before", 0, 30 * gb, 10 * gb, true, 40 * gb},
+		{"unlimited plan", 10 * gb, 4 * gb, 0, true, 0},
+	}
+	for _, test := range tests {
+		user := &User{MaxData: test.maxData, state: new(userState)}
+		user.setDataUsage(test.usage)
+		got := extendedMaxData(user, BillingPlan{MaxData: test.planData}, test.active)
+		if got != test.want {
+			t.Errorf("%s: max_data %d, want %d", test.name, got, test.want)
+		}
+		if user.dataUsage() != test.usage {
+			t.Errorf("%s: data usage changed to %d", test.name, user.dataUsage())
+		}
+	}
+}
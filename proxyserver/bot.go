@@ -0,0 +1,249 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bot cho nhà vận hành: đẩy cảnh báo tới chat Telegram và/hoặc Discord webhook, nhận lệnh đơn giản
+// (status, usage, kick) từ chat Telegram đã cấu hình
+const (
+	defaultTelegramAPI  = "https://api.telegram.org"
+	telegramPollTimeout = 50 // Giây, long polling getUpdates
+	maxNotifyQueue      = 100
+	maxNotifyLength     = 4000 // Telegram giới hạn 4096 ký tự, Discord 2000 (được cắt riêng)
+)
+
+var (
+	notifyQueue = make(chan string, maxNotifyQueue)
+	botStarted  sync.Once
+)
+
+// Khởi động bot một lần cho cả process (Server có thể được Start lại sau Stop)
+func startBot() {
+	botStarted.Do(func() {
+		go runBotNotifier()
+		go runTelegramCommands()
+	})
+}
+
+func botNotifyEnabled() bool {
+	return (systemConfig().TelegramBotToken != "" && systemConfig().TelegramChatID != 0) || systemConfig().DiscordWebhook != ""
+}
+
+// Gửi cảnh báo tới các chat đã cấu hình, không chặn bên gọi; hàng đợi đầy thì bỏ tin nhắn
+func notifyOperators(format string, args ...any) {
+	if !botNotifyEnabled() {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	select {
+	case notifyQueue <- message:
+	default:
+		log.Printf("Bot notification dropped (queue full): %s", message)
+	}
+}
+
+// Gửi lần lượt các cảnh báo trong hàng đợi
+func runBotNotifier() {
+	for message := range notifyQueue {
+		if len(message) > maxNotifyLength {
+			message = message[:maxNotifyLength]
+		}
+		if systemConfig().TelegramBotToken != "" && systemConfig().TelegramChatID != 0 {
+			if err := sendTelegram(systemConfig().TelegramChatID, message); err != nil {
+				log.Printf("Telegram notification error: %v", err)
+			}
+		}
+		if systemConfig().DiscordWebhook != "" {
+			if err := sendDiscord(message); err != nil {
+				log.Printf("Discord notification error: %v", err)
+			}
+		}
+	}
+}
+
+func telegramURL(method string) (string, error) {
+	token, err := resolveSecret(systemConfig().TelegramBotToken)
+	if err != nil {
+		return "", err
+	}
+	base := systemConfig().TelegramAPIURL
+	if base == "" {
+		base = defaultTelegramAPI
+	}
+	return strings.TrimRight(base, "/") + "/bot" + token + "/" + method, nil
+}
+
+// Gọi một method của Telegram Bot API và giải mã trường result vào out
+func callTelegram(method string, params url.Values, timeout time.Duration, out any) error {
+	endpoint, err := telegramURL(method)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: timeout}
+	resp, err := client.PostForm(endpoint, params)
+	if err != nil {
+		// Lỗi của net/http chứa URL, tức là cả token của bot
+		return fmt.Errorf("%s request failed", method)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, out)
+}
+
+func sendTelegram(chatID int64, text string) error {
+	params := url.Values{"chat_id": {strconv.FormatInt(chatID, 10)}, "text": {text}}
+	return callTelegram("sendMessage", params, 10*time.Second, nil)
+}
+
+func sendDiscord(text string) error {
+	if len(text) > 2000 {
+		text = text[:2000]
+	}
+	body, err := json.Marshal(map[string]string{"content": text})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(systemConfig().DiscordWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// Nhận lệnh từ Telegram bằng long polling; chỉ trả lời chat telegram_chat_id
+func runTelegramCommands() {
+	var offset int64
+	for {
+		if systemConfig().TelegramBotToken == "" || systemConfig().TelegramChatID == 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		params := url.Values{"timeout": {strconv.Itoa(telegramPollTimeout)}, "allowed_updates": {`["message"]`}}
+		if offset != 0 {
+			params.Set("offset", strconv.FormatInt(offset, 10))
+		}
+		var updates []telegramUpdate
+		if err := callTelegram("getUpdates", params, (telegramPollTimeout+10)*time.Second, &updates); err != nil {
+			log.Printf("Telegram getUpdates error: %v", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message == nil || update.Message.Chat.ID != systemConfig().TelegramChatID {
+				continue
+			}
+			reply := runBotCommand(update.Message.Text)
+			if reply == "" {
+				continue
+			}
+			if err := sendTelegram(update.Message.Chat.ID, reply); err != nil {
+				log.Printf("Telegram reply error: %v", err)
+			}
+		}
+	}
+}
+
+// Thực hiện một lệnh chat và trả về nội dung trả lời; tin nhắn không phải lệnh được bỏ qua
+func runBotCommand(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+	// Telegram thêm @tên_bot vào lệnh trong nhóm chat
+	command, _, _ := strings.Cut(strings.TrimPrefix(fields[0], "/"), "@")
+	switch command {
+	case "status":
+		status := currentServerStatus()
+		reply := fmt.Sprintf("Up %s, %d users, %d open connections, %d tunnels, %d connections served, %s up / %s down, %d listeners",
+			time.Duration(status.UptimeSeconds)*time.Second, status.Users, status.ConnectionsActive, status.TunnelsActive,
+			status.ConnectionsTotal, formatBytes(status.BytesUp), formatBytes(status.BytesDown), len(status.Listeners))
+		if status.Maintenance != nil {
+			reply += ", in maintenance since " + status.Maintenance.Since.Format("15:04")
+		}
+		return reply
+	case "usage":
+		if len(fields) != 2 {
+			return "Usage: /usage <username>"
+		}
+		usersMutex.RLock()
+		user, exists := users[fields[1]]
+		var usage AccountUsage
+		if exists {
+			usage = accountUsages([]*User{user})[0]
+		}
+		usersMutex.RUnlock()
+		if !exists {
+			return "User not found: " + fields[1]
+		}
+		limit := "unlimited"
+		if usage.MaxData > 0 {
+			limit = formatBytes(usage.MaxData)
+		}
+		return fmt.Sprintf("%s: %s, %s of %s used, %d open tunnels, valid until %s",
+			usage.Username, usage.Status, formatBytes(usage.DataUsage), limit, usage.OpenTunnels, usage.EndDate)
+	case "kick":
+		if len(fields) != 2 {
+			return "Usage: /kick <username>"
+		}
+		closed := 0
+		for _, t := range idleTunnels(0) {
+			if t.user != nil && t.user.Username == fields[1] {
+				t.close(CloseAdminKick)
+				closed++
+			}
+		}
+		recordAudit("telegram", "user.kick", fields[1], nil, closed)
+		return fmt.Sprintf("Closed %d tunnels of %s", closed, fields[1])
+	case "maintenance":
+		if len(fields) < 2 || (fields[1] != "on" && fields[1] != "off") {
+			return "Usage: /maintenance on [message] | off"
+		}
+		wasEnabled := maintenanceActive()
+		setMaintenance(fields[1] == "on", strings.Join(fields[2:], " "), "telegram")
+		recordAudit("telegram", "maintenance.set", "", wasEnabled, fields[1] == "on")
+		return fmt.Sprintf("Maintenance %s, %d tunnels still open", fields[1], currentServerStatus().TunnelsActive)
+	case "help", "start":
+		return "Commands: /status, /usage <username>, /kick <username>, /maintenance on [message] | off"
+	}
+	return "Unknown command, try /help"
+}
@@ -0,0 +1,259 @@
+package proxyserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quy tắc capture: ghi lại N kết nối tiếp theo của một user
+type CaptureRule struct {
+	ID         int       `json:"id"`
+	Username   string    `json:"username"`
+	Remaining  int       `json:"remaining"`           // Số kết nối còn lại sẽ được capture
+	MaxBytes   int64     `json:"max_bytes,omitempty"` // Giới hạn byte mỗi chiều (0 = toàn bộ), nhỏ để chỉ lấy header
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedBy  string    `json:"created_by"`
+	CapturedTo []string  `json:"captured_to"` // Các file pcap đã ghi
+}
+
+var (
+	captureRules      []*CaptureRule
+	captureRulesMutex sync.Mutex
+	nextCaptureID     = 1
+)
+
+// Thêm quy tắc capture mới
+func addCaptureRule(username string, connections int, maxBytes int64, ttl time.Duration, createdBy string) *CaptureRule {
+	captureRulesMutex.Lock()
+	defer captureRulesMutex.Unlock()
+
+	rule := &CaptureRule{
+		ID:         nextCaptureID,
+		Username:   username,
+		Remaining:  connections,
+		MaxBytes:   maxBytes,
+		ExpiresAt:  time.Now().Add(ttl),
+		CreatedBy:  createdBy,
+		CapturedTo: []string{},
+	}
+	nextCaptureID++
+	captureRules = append(captureRules, rule)
+	return rule
+}
+
+// Xóa quy tắc capture theo ID
+func removeCaptureRule(id int) bool {
+	captureRulesMutex.Lock()
+	defer captureRulesMutex.Unlock()
+
+	for i, rule := range captureRules {
+		if rule.ID == id {
+			captureRules = append(captureRules[:i], captureRules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Danh sách quy tắc capture chưa hết hạn, kể cả quy tắc đã dùng hết số kết nối (tự động bỏ các quy tắc hết hạn)
+func listCaptureRules() []CaptureRule {
+	captureRulesMutex.Lock()
+	defer captureRulesMutex.Unlock()
+
+	pruneCaptureRules(time.Now())
+	list := make([]CaptureRule, 0, len(captureRules))
+	for _, rule := range captureRules {
+		copied := *rule
+		copied.CapturedTo = append([]string(nil), rule.CapturedTo...)
+		list = append(list, copied)
+	}
+	return list
+}
+
+func pruneCaptureRules(now time.Time) {
+	active := captureRules[:0]
+	for _, rule := range captureRules {
+		if now.Before(rule.ExpiresAt) {
+			active = append(active, rule)
+		}
+	}
+	captureRules = active
+}
+
+// Phiên capture của một kết nối, ghi file pcap với header IP/TCP giả lập để Wireshark giải mã được
+type captureSession struct {
+	mu       sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	client   *net.TCPAddr
+	target   *net.TCPAddr
+	seq      [2]uint32 // Số thứ tự TCP của chiều gửi lên và nhận về
+	written  [2]int64
+	maxBytes int64
+}
+
+// Bắt đầu capture nếu có quy tắc khớp với user, trả về nil nếu không cần capture
+func startCapture(user *User, clientConn, targetConn net.Conn) *captureSession {
+	if user == nil {
+		return nil
+	}
+
+	captureRulesMutex.Lock()
+	pruneCaptureRules(time.Now())
+	var rule *CaptureRule
+	for _, candidate := range captureRules {
+		if candidate.Username == user.Username && candidate.Remaining > 0 {
+			rule = candidate
+			break
+		}
+	}
+	if rule == nil {
+		captureRulesMutex.Unlock()
+		return nil
+	}
+	rule.Remaining--
+
+	dir := systemConfig().CaptureDir
+	if dir == "" {
+		dir = "captures"
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d-%s.pcap", user.Username, rule.ID, time.Now().Format("20060102-150405.000000")))
+	rule.CapturedTo = append(rule.CapturedTo, path)
+	maxBytes := rule.MaxBytes
+	captureRulesMutex.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Capture error: %v", err)
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Capture error: %v", err)
+		return nil
+	}
+
+	session := &captureSession{
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		client:   tcpAddrOf(clientConn.RemoteAddr(), "10.0.0.1"),
+		target:   tcpAddrOf(targetConn.RemoteAddr(), "10.0.0.2"),
+		seq:      [2]uint32{1, 1},
+		maxBytes: maxBytes,
+	}
+
+	// Header file pcap: LINKTYPE_RAW (101), gói tin bắt đầu bằng header IPv4
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], 101)
+	session.writer.Write(header)
+
+	log.Printf("Capturing connection of user %s to %s", user.Username, path)
+	return session
+}
+
+// Địa chỉ IPv4 dùng trong gói tin giả lập; địa chỉ IPv6 được thay bằng địa chỉ mặc định
+func tcpAddrOf(addr net.Addr, fallback string) *net.TCPAddr {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr.IP.To4() == nil {
+		return &net.TCPAddr{IP: net.ParseIP(fallback).To4(), Port: 1}
+	}
+	return &net.TCPAddr{IP: tcpAddr.IP.To4(), Port: tcpAddr.Port}
+}
+
+// Writer ghi dữ liệu của một chiều vào phiên capture, không bao giờ trả lỗi để không ảnh hưởng tunnel
+type captureWriter struct {
+	session    *captureSession
+	fromClient bool
+}
+
+func (s *captureSession) direction(fromClient bool) *captureWriter {
+	return &captureWriter{session: s, fromClient: fromClient}
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	c.session.record(c.fromClient, p)
+	return len(p), nil
+}
+
+func (s *captureSession) record(fromClient bool, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := 1
+	src, dst := s.target, s.client
+	if fromClient {
+		dir = 0
+		src, dst = s.client, s.target
+	}
+
+	if s.maxBytes > 0 {
+		remaining := s.maxBytes - s.written[dir]
+		if remaining <= 0 {
+			return
+		}
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+	}
+	s.written[dir] += int64(len(data))
+
+	const maxSegment = 65535 - 40
+	for len(data) > 0 {
+		segment := data
+		if len(segment) > maxSegment {
+			segment = segment[:maxSegment]
+		}
+		data = data[len(segment):]
+		s.writePacket(src, dst, s.seq[dir], s.seq[1-dir], segment)
+		s.seq[dir] += uint32(len(segment))
+	}
+}
+
+func (s *captureSession) writePacket(src, dst *net.TCPAddr, seq, ack uint32, payload []byte) {
+	packet := make([]byte, 40+len(payload))
+
+	// Header IPv4
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	packet[8] = 64
+	packet[9] = 6 // TCP
+	copy(packet[12:16], src.IP)
+	copy(packet[16:20], dst.IP)
+
+	// Header TCP (checksum để trống)
+	binary.BigEndian.PutUint16(packet[20:], uint16(src.Port))
+	binary.BigEndian.PutUint16(packet[22:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(packet[24:], seq)
+	binary.BigEndian.PutUint32(packet[28:], ack)
+	packet[32] = 5 << 4
+	packet[33] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(packet[34:], 65535)
+	copy(packet[40:], payload)
+
+	now := time.Now()
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	s.writer.Write(record)
+	s.writer.Write(packet)
+}
+
+func (s *captureSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writer.Flush()
+	s.file.Close()
+}
@@ -0,0 +1,112 @@
+package proxyserver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+
+	"proxy_server/proxyserver/limits"
+)
+
+// Lý do kết thúc một tunnel
+const (
+	CloseClientEOF       = "client_eof"
+	CloseTargetEOF       = "target_eof"
+	CloseClientError     = "client_error"
+	CloseTargetError     = "target_error"
+	CloseQuotaExceeded   = "quota_exceeded"
+	CloseTransferLimit   = "transfer_limit"
+	CloseIdleTimeout     = "idle_timeout"
+	CloseAdminKick       = "admin_kick"
+	CloseMemoryShed      = "memory_shed"
+	CloseServerStop      = "server_stop"
+	CloseMaxLifetime     = "max_lifetime"
+	CloseAccountSharing  = "account_sharing"
+	CloseAccountExpired  = "account_expired"
+	ClosePolicyDenied    = "policy_denied"
+	CloseMaintenance     = "maintenance"
+	CloseDialRefused     = "dial_refused"
+	CloseDialTimeout     = "dial_timeout"
+	CloseDialUnreachable = "dial_unreachable"
+	CloseDialDNS         = "dial_dns"
+	CloseDialError       = "dial_error"
+)
+
+var (
+	closeReasonCounts = make(map[string]int64)
+	closeReasonMutex  sync.Mutex
+)
+
+// Tăng bộ đếm số tunnel kết thúc theo lý do
+func countCloseReason(reason string) {
+	closeReasonMutex.Lock()
+	closeReasonCounts[reason]++
+	closeReasonMutex.Unlock()
+}
+
+type reasonCount struct {
+	Reason string
+	Count  int64
+}
+
+// Danh sách bộ đếm theo lý do, sắp xếp theo tên
+func closeReasonSnapshot() []reasonCount {
+	closeReasonMutex.Lock()
+	defer closeReasonMutex.Unlock()
+
+	snapshot := make([]reasonCount, 0, len(closeReasonCounts))
+	for reason, count := range closeReasonCounts {
+		snapshot = append(snapshot, reasonCount{reason, count})
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Reason < snapshot[j].Reason })
+	return snapshot
+}
+
+// Phân loại lỗi khi kết nối tới đích
+func classifyDialError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return CloseDialDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return CloseDialRefused
+	}
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return CloseDialUnreachable
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return CloseDialTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return CloseDialTimeout
+	}
+	return CloseDialError
+}
+
+// Phân loại lý do kết thúc theo chiều truyền dữ liệu kết thúc trước
+func classifyCopyEnd(fromClient bool, n, limit int64, err error) string {
+	if (limit >= 0 && n >= limit) || errors.Is(err, ErrQuotaExceeded) {
+		return CloseQuotaExceeded
+	}
+	if errors.Is(err, limits.ErrTransferLimit) {
+		return CloseTransferLimit
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return CloseIdleTimeout
+		}
+		if fromClient {
+			return CloseClientError
+		}
+		return CloseTargetError
+	}
+	if fromClient {
+		return CloseClientEOF
+	}
+	return CloseTargetEOF
+}
@@ -0,0 +1,753 @@
+package proxyserver
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Chế độ cluster: các node tìm nhau qua danh sách cluster_peers (static) hoặc gossip (biết thêm node
+// qua các node khác), trao đổi dữ liệu đã dùng và trạng thái tạm khóa của user để giới hạn được tính
+// chung cho cả cluster. Dữ liệu đã dùng là bộ đếm riêng của từng node, gộp bằng giá trị lớn nhất nên
+// trao đổi lặp lại hoặc sai thứ tự không làm đếm trùng
+const (
+	clusterDiscoveryStatic = "static"
+	clusterDiscoveryGossip = "gossip"
+
+	defaultClusterInterval = 2              // Giây
+	clusterFanout          = 3              // Số node nhận trạng thái mỗi vòng gossip
+	clusterDeadRounds      = 5              // Số chu kỳ không có heartbeat trước khi node bị coi là mất
+	clusterSeedEvery       = 10             // Mỗi chừng này vòng, thử thêm một seed và một node đã mất để nối lại cluster
+	clusterForgetAfter     = 24 * time.Hour // Node mất lâu hơn bị xóa khỏi danh sách thành viên
+	maxClusterState        = 32 << 20       // Kích thước tối đa của trạng thái nhận được
+	clusterGossipPath      = "/cluster/v1/gossip"
+)
+
+// Thống kê một node gửi kèm heartbeat
+type ClusterNodeStats struct {
+	ConnectionsActive int   `json:"connections_active"`
+	TunnelsActive     int   `json:"tunnels_active"`
+	BytesUp           int64 `json:"bytes_up"`
+	BytesDown         int64 `json:"bytes_down"`
+	Users             int   `json:"users"`
+	Maintenance       bool  `json:"maintenance"`
+}
+
+// Một node trong cluster; Updated do chính node đó đặt ở mỗi heartbeat
+type ClusterMember struct {
+	Name        string           `json:"name"`
+	Addr        string           `json:"addr"`
+	Incarnation int64            `json:"incarnation"` // Thời điểm process khởi động (unix nano)
+	Heartbeat   uint64           `json:"heartbeat"`
+	Updated     time.Time        `json:"updated"`
+	Stats       ClusterNodeStats `json:"stats"`
+}
+
+func (m ClusterMember) newerThan(other ClusterMember) bool {
+	if m.Incarnation != other.Incarnation {
+		return m.Incarnation > other.Incarnation
+	}
+	return m.Heartbeat > other.Heartbeat
+}
+
+func (m ClusterMember) alive(now time.Time) bool {
+	return now.Sub(m.Updated) < clusterDeadRounds*clusterInterval()
+}
+
+// Dữ liệu một user đã dùng qua một node. Base là tổng của các lần chạy trước của node,
+// Local là phần của lần chạy Inc
+type clusterUsage struct {
+	Inc   int64 `json:"inc"`
+	Base  int64 `json:"base"`
+	Local int64 `json:"local"`
+}
+
+func (u clusterUsage) total() int64 {
+	return u.Base + u.Local
+}
+
+// Gộp hai bản của cùng một bộ đếm: lần chạy mới hơn thắng và giữ tổng của lần chạy cũ làm Base
+func mergeClusterUsage(current, received clusterUsage) clusterUsage {
+	switch {
+	case received.Inc > current.Inc:
+		received.Base = max(received.Base, current.total())
+		return received
+	case received.Inc < current.Inc:
+		current.Base = max(current.Base, received.total())
+		return current
+	}
+	current.Base = max(current.Base, received.Base)
+	current.Local = max(current.Local, received.Local)
+	return current
+}
+
+// Trạng thái tạm khóa của user, thay đổi sau cùng thắng
+type clusterSuspension struct {
+	Suspended bool   `json:"suspended"`
+	Reason    string `json:"reason,omitempty"`
+	Time      int64  `json:"time"` // Unix nano
+	Node      string `json:"node"`
+}
+
+func (s clusterSuspension) newerThan(other clusterSuspension) bool {
+	if s.Time != other.Time {
+		return s.Time > other.Time
+	}
+	return s.Node > other.Node
+}
+
+// Trạng thái trao đổi giữa các node
+type clusterState struct {
+	From        string                             `json:"from"`
+	Members     []ClusterMember                    `json:"members"`
+	Usage       map[string]map[string]clusterUsage `json:"usage"` // User -> node -> bộ đếm
+	Suspensions map[string]clusterSuspension       `json:"suspensions"`
+}
+
+var (
+	clusterRunning     atomic.Bool
+	clusterSelf        ClusterMember
+	clusterMembers     = make(map[string]ClusterMember)           // Các node khác, theo tên
+	clusterUsageTable  = make(map[string]map[string]clusterUsage) // User -> node -> bộ đếm
+	clusterOwnBase     = make(map[string]int64)                   // Base của node này theo user
+	clusterOwnLocal    = make(map[string]int64)                   // Dữ liệu qua node này trong lần chạy hiện tại
+	clusterSuspensions = make(map[string]clusterSuspension)
+	clusterRound       int
+	clusterMutex       sync.Mutex
+
+	clusterClient *http.Client
+	clusterScheme = "http"
+)
+
+func clusterInterval() time.Duration {
+	if systemConfig().ClusterInterval > 0 {
+		return time.Duration(systemConfig().ClusterInterval) * time.Second
+	}
+	return defaultClusterInterval * time.Second
+}
+
+func clusterDiscovery() string {
+	if systemConfig().ClusterDiscovery != "" {
+		return systemConfig().ClusterDiscovery
+	}
+	return clusterDiscoveryGossip
+}
+
+// Địa chỉ công bố cho các node khác: cluster_advertise, hoặc cluster_listen với hostname thay cho địa chỉ 0.0.0.0/[::]
+func clusterAdvertiseAddr() (string, error) {
+	if systemConfig().ClusterAdvertise != "" {
+		return systemConfig().ClusterAdvertise, nil
+	}
+	host, port, err := net.SplitHostPort(systemConfig().ClusterListen)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster_listen: %v", err)
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return systemConfig().ClusterListen, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine cluster_advertise: %v", err)
+	}
+	return net.JoinHostPort(hostname, port), nil
+}
+
+// Secret chung, đọc mỗi lần dùng để đổi được bằng config apply hoặc xoay vòng secret
+func clusterSecret() (string, error) {
+	secret, err := resolveSecret(systemConfig().ClusterSecret)
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", errors.New("cluster_secret is not set")
+	}
+	return secret, nil
+}
+
+// Mở listener cluster và bắt đầu trao đổi trạng thái với các node khác
+func startCluster() error {
+	if systemConfig().ClusterListen == "" {
+		return nil
+	}
+	if _, err := clusterSecret(); err != nil {
+		return fmt.Errorf("cluster_secret: %v", err)
+	}
+	name := systemConfig().ClusterNode
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("cannot determine cluster_node: %v", err)
+		}
+		name = hostname
+	}
+	advertise, err := clusterAdvertiseAddr()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(handleClusterGossip), ReadHeaderTimeout: 10 * time.Second, MaxHeaderBytes: maxAdminHeader}
+	clientTLS, err := clusterTLSConfigs(server)
+	if err != nil {
+		return err
+	}
+	clusterClient = &http.Client{Timeout: clusterInterval(), Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+	listener, err := listenTCP(listenerCluster, systemConfig().ClusterListen)
+	if err != nil {
+		return err
+	}
+	clusterMutex.Lock()
+	clusterSelf = ClusterMember{Name: name, Addr: advertise, Incarnation: time.Now().UnixNano(), Updated: time.Now()}
+	clusterMutex.Unlock()
+	clusterRunning.Store(true)
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Cluster listener error: %v", err)
+		}
+	}()
+	go runClusterGossip()
+	log.Printf("Cluster node %s started on %s (advertised as %s, %s discovery)", name, systemConfig().ClusterListen, advertise, clusterDiscovery())
+	return nil
+}
+
+// Bật TLS cho listener cluster khi có cluster_tls_cert; với cluster_ca, hai chiều đều phải có chứng chỉ
+// do CA đó cấp. Trả về cấu hình TLS của client, nil khi dùng HTTP
+func clusterTLSConfigs(server *http.Server) (*tls.Config, error) {
+	if systemConfig().ClusterTLSCert == "" {
+		if systemConfig().ClusterCA != "" {
+			return nil, errors.New("cluster_ca requires cluster_tls_cert and cluster_tls_key")
+		}
+		return nil, nil
+	}
+	serverTLS, err := listenerTLSConfig(systemConfig().ClusterTLSCert, systemConfig().ClusterTLSKey, false)
+	if err != nil {
+		return nil, fmt.Errorf("cluster TLS: %v", err)
+	}
+	pair, err := loadKeyPair(systemConfig().ClusterTLSCert, systemConfig().ClusterTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("cluster TLS: %v", err)
+	}
+	clientTLS := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return pair.current.Load(), nil
+		},
+	}
+	if systemConfig().ClusterCA != "" {
+		caPEM, err := readSecretFile(systemConfig().ClusterCA)
+		if err != nil {
+			return nil, fmt.Errorf("cluster CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", systemConfig().ClusterCA)
+		}
+		serverTLS.ClientCAs = pool
+		serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+		clientTLS.RootCAs = pool
+	}
+	server.TLSConfig = serverTLS
+	clusterScheme = "https"
+	return clientTLS, nil
+}
+
+func runClusterGossip() {
+	lastErrors := make(map[string]string) // Địa chỉ node -> lỗi gần nhất, để chỉ log khi lỗi thay đổi
+	for {
+		time.Sleep(clusterInterval())
+		clusterHeartbeat()
+
+		targets := clusterTargets()
+		errs := make([]error, len(targets))
+		var wait sync.WaitGroup
+		for i, addr := range targets {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				errs[i] = exchangeClusterState(addr)
+			}()
+		}
+		wait.Wait()
+		applyClusterState()
+
+		for i, addr := range targets {
+			message := ""
+			if errs[i] != nil {
+				message = errs[i].Error()
+			}
+			if message != "" && message != lastErrors[addr] {
+				log.Printf("Cluster: cannot exchange state with %s: %s", addr, message)
+			} else if message == "" && lastErrors[addr] != "" {
+				log.Printf("Cluster: %s reachable again", addr)
+			}
+			lastErrors[addr] = message
+		}
+	}
+}
+
+// Tăng heartbeat, cập nhật thống kê và bộ đếm của node này
+func clusterHeartbeat() {
+	status := currentServerStatus()
+	stats := ClusterNodeStats{
+		ConnectionsActive: status.ConnectionsActive,
+		TunnelsActive:     status.TunnelsActive,
+		BytesUp:           status.BytesUp,
+		BytesDown:         status.BytesDown,
+		Users:             status.Users,
+		Maintenance:       status.Maintenance != nil,
+	}
+
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	clusterSelf.Heartbeat++
+	clusterSelf.Updated = time.Now()
+	clusterSelf.Stats = stats
+	refreshOwnUsages()
+}
+
+// Gọi khi giữ clusterMutex
+func refreshOwnUsages() {
+	for username := range clusterOwnLocal {
+		refreshOwnUsage(username)
+	}
+}
+
+// Đưa bộ đếm của node này vào bảng; nhận Base lớn hơn mà các node khác đã gộp từ lần chạy trước.
+// Gọi khi giữ clusterMutex
+func refreshOwnUsage(username string) {
+	nodes := clusterUsageTable[username]
+	if nodes == nil {
+		nodes = make(map[string]clusterUsage)
+		clusterUsageTable[username] = nodes
+	}
+	own := clusterUsage{Inc: clusterSelf.Incarnation, Base: clusterOwnBase[username], Local: clusterOwnLocal[username]}
+	merged := mergeClusterUsage(nodes[clusterSelf.Name], own)
+	if merged.Inc == clusterSelf.Incarnation {
+		clusterOwnBase[username] = merged.Base
+	}
+	nodes[clusterSelf.Name] = merged
+}
+
+// Các node nhận trạng thái trong vòng này: mọi cluster_peers với static, với gossip là vài node
+// còn sống chọn ngẫu nhiên, hoặc các seed khi chưa biết node nào
+func clusterTargets() []string {
+	seeds := make([]string, 0, len(systemConfig().ClusterPeers))
+	for _, peer := range systemConfig().ClusterPeers {
+		if peer != clusterSelf.Addr {
+			seeds = append(seeds, peer)
+		}
+	}
+	if clusterDiscovery() == clusterDiscoveryStatic {
+		return seeds
+	}
+
+	clusterMutex.Lock()
+	clusterRound++
+	round := clusterRound
+	now := time.Now()
+	var alive, dead []string
+	for _, member := range clusterMembers {
+		if member.alive(now) {
+			alive = append(alive, member.Addr)
+		} else {
+			dead = append(dead, member.Addr)
+		}
+	}
+	clusterMutex.Unlock()
+
+	if len(alive) == 0 {
+		return seeds
+	}
+	mathrand.Shuffle(len(alive), func(i, j int) { alive[i], alive[j] = alive[j], alive[i] })
+	targets := alive[:min(clusterFanout, len(alive))]
+	if round%clusterSeedEvery == 0 {
+		for _, extra := range [][]string{seeds, dead} {
+			if len(extra) > 0 {
+				if addr := extra[mathrand.IntN(len(extra))]; !containsString(targets, addr) {
+					targets = append(targets, addr)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Bản sao trạng thái hiện tại để gửi đi
+func clusterSnapshot() clusterState {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+
+	state := clusterState{
+		From:        clusterSelf.Name,
+		Members:     make([]ClusterMember, 0, len(clusterMembers)+1),
+		Usage:       make(map[string]map[string]clusterUsage, len(clusterUsageTable)),
+		Suspensions: make(map[string]clusterSuspension, len(clusterSuspensions)),
+	}
+	state.Members = append(state.Members, clusterSelf)
+	for _, member := range clusterMembers {
+		state.Members = append(state.Members, member)
+	}
+	for username, nodes := range clusterUsageTable {
+		copied := make(map[string]clusterUsage, len(nodes))
+		for node, usage := range nodes {
+			copied[node] = usage
+		}
+		state.Usage[username] = copied
+	}
+	for username, suspension := range clusterSuspensions {
+		state.Suspensions[username] = suspension
+	}
+	return state
+}
+
+// Gộp trạng thái nhận được. Với static chỉ nhận thông tin thành viên của chính node gửi
+func mergeClusterState(state clusterState) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+
+	now := time.Now()
+	for _, member := range state.Members {
+		if member.Name == "" || member.Name == clusterSelf.Name {
+			continue
+		}
+		if clusterDiscovery() == clusterDiscoveryStatic && member.Name != state.From {
+			continue
+		}
+		if now.Sub(member.Updated) > clusterForgetAfter {
+			continue
+		}
+		if current, known := clusterMembers[member.Name]; !known || member.newerThan(current) {
+			if !known {
+				log.Printf("Cluster: node %s joined (%s)", member.Name, member.Addr)
+			}
+			clusterMembers[member.Name] = member
+		}
+	}
+	for username, nodes := range state.Usage {
+		table := clusterUsageTable[username]
+		if table == nil {
+			table = make(map[string]clusterUsage, len(nodes))
+			clusterUsageTable[username] = table
+		}
+		for node, usage := range nodes {
+			table[node] = mergeClusterUsage(table[node], usage)
+		}
+	}
+	for username, suspension := range state.Suspensions {
+		if current, known := clusterSuspensions[username]; !known || suspension.newerThan(current) {
+			clusterSuspensions[username] = suspension
+		}
+	}
+}
+
+// Áp dụng trạng thái đã gộp vào user: dữ liệu đã dùng là tổng của mọi node, trạng thái tạm khóa
+// theo thay đổi sau cùng. Tunnel của user vừa bị node khác tạm khóa được đóng
+func applyClusterState() {
+	clusterMutex.Lock()
+	now := time.Now()
+	for name, member := range clusterMembers {
+		if now.Sub(member.Updated) > clusterForgetAfter {
+			delete(clusterMembers, name)
+			log.Printf("Cluster: node %s forgotten after %v without heartbeat", name, clusterForgetAfter)
+		}
+	}
+	refreshOwnUsages()
+	totals := make(map[string]int64, len(clusterUsageTable))
+	for username, nodes := range clusterUsageTable {
+		var total int64
+		for _, usage := range nodes {
+			total += usage.total()
+		}
+		totals[username] = total
+	}
+	suspensions := make(map[string]clusterSuspension, len(clusterSuspensions))
+	for username, suspension := range clusterSuspensions {
+		suspensions[username] = suspension
+	}
+	clusterMutex.Unlock()
+
+	var kicked []string
+	usersMutex.Lock()
+	for username, total := range totals {
+		if user, exists := users[username]; exists {
+			user.setDataUsage(total)
+		}
+	}
+	for username, suspension := range suspensions {
+		user, exists := users[username]
+		if !exists || user.isSuspended() == suspension.Suspended {
+			continue
+		}
+		user.setSuspended(suspension.Suspended, suspension.Reason)
+		if suspension.Suspended {
+			kicked = append(kicked, username)
+			log.Printf("Cluster: user %s suspended by node %s", username, suspension.Node)
+		} else {
+			log.Printf("Cluster: user %s unsuspended by node %s", username, suspension.Node)
+		}
+	}
+	usersMutex.Unlock()
+
+	for _, username := range kicked {
+		closeUserTunnels(username, CloseAdminKick)
+	}
+}
+
+// Gửi trạng thái tới một node và gộp trạng thái node đó trả về
+func exchangeClusterState(addr string) error {
+	secret, err := clusterSecret()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(clusterSnapshot())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, clusterScheme+"://"+addr+clusterGossipPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var state clusterState
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxClusterState)).Decode(&state); err != nil {
+		return err
+	}
+	mergeClusterState(state)
+	return nil
+}
+
+// POST /cluster/v1/gossip trên cluster_listen: nhận trạng thái của node gửi, trả lại trạng thái của node này
+func handleClusterGossip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != clusterGossipPath {
+		admin.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	secret, err := clusterSecret()
+	value, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err != nil || !found || subtle.ConstantTimeCompare([]byte(value), []byte(secret)) != 1 {
+		logAuthFailure(r.RemoteAddr, "cluster", "", AuthInvalidToken)
+		admin.WriteError(w, http.StatusUnauthorized, "invalid cluster secret")
+		return
+	}
+
+	var state clusterState
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxClusterState)).Decode(&state); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid cluster state")
+		return
+	}
+	mergeClusterState(state)
+	applyClusterState()
+	admin.WriteJSON(w, http.StatusOK, clusterSnapshot())
+}
+
+// Cộng dữ liệu user vừa dùng qua node này
+func clusterCountUsage(username string, n int64) {
+	if n <= 0 || systemConfig().ClusterListen == "" {
+		return
+	}
+	clusterMutex.Lock()
+	clusterOwnLocal[username] += n
+	clusterMutex.Unlock()
+}
+
+// Ghi lại thay đổi tạm khóa user trên node này để lan ra cả cluster
+func clusterRecordSuspension(username string, suspended bool, reason string) {
+	if !clusterRunning.Load() {
+		return
+	}
+	clusterMutex.Lock()
+	clusterSuspensions[username] = clusterSuspension{Suspended: suspended, Reason: reason, Time: time.Now().UnixNano(), Node: clusterSelf.Name}
+	clusterMutex.Unlock()
+}
+
+// Nhận tổng dữ liệu qua node này từ journal làm Base của bộ đếm node, trừ phần đã tính trong lần chạy này
+func seedClusterUsage(totals map[string]int64) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	for username, total := range totals {
+		clusterOwnBase[username] = max(clusterOwnBase[username], total-clusterOwnLocal[username])
+		clusterOwnLocal[username] += 0 // Để refreshOwnUsages đưa user vào bảng
+	}
+}
+
+// Bảng dữ liệu đã dùng, chuyển sang process mới khi nâng cấp nóng
+func clusterUsageSnapshot() map[string]map[string]clusterUsage {
+	if systemConfig().ClusterListen == "" {
+		return nil
+	}
+	return clusterSnapshot().Usage
+}
+
+// Nhận bộ đếm từ process cũ: gộp bảng của cluster, hoặc coi dữ liệu đã dùng là dữ liệu qua node này
+// khi process cũ chưa chạy cluster
+func importClusterUsage(state upgradeState) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	if state.Cluster == nil {
+		for username, usage := range state.DataUsage {
+			clusterOwnLocal[username] += usage
+		}
+		return
+	}
+	for username, nodes := range state.Cluster {
+		table := clusterUsageTable[username]
+		if table == nil {
+			table = make(map[string]clusterUsage, len(nodes))
+			clusterUsageTable[username] = table
+		}
+		for node, usage := range nodes {
+			table[node] = mergeClusterUsage(table[node], usage)
+		}
+	}
+}
+
+// Một node trong GET /api/cluster
+type clusterMemberView struct {
+	ClusterMember
+	Status string `json:"status"` // self, alive hoặc dead
+}
+
+type clusterView struct {
+	Enabled   bool                `json:"enabled"`
+	Node      string              `json:"node,omitempty"`
+	Discovery string              `json:"discovery,omitempty"`
+	Members   []clusterMemberView `json:"members"`
+	Alive     int                 `json:"alive"`
+	Totals    ClusterNodeStats    `json:"totals"` // Cộng của các node còn sống
+}
+
+func currentClusterView() clusterView {
+	view := clusterView{Members: []clusterMemberView{}}
+	if !clusterRunning.Load() {
+		return view
+	}
+	clusterMutex.Lock()
+	members := []clusterMemberView{{clusterSelf, "self"}}
+	now := time.Now()
+	for _, member := range clusterMembers {
+		status := "dead"
+		if member.alive(now) {
+			status = "alive"
+		}
+		members = append(members, clusterMemberView{member, status})
+	}
+	view.Node = clusterSelf.Name
+	clusterMutex.Unlock()
+
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	view.Enabled, view.Discovery, view.Members = true, clusterDiscovery(), members
+	for _, member := range members {
+		if member.Status == "dead" {
+			continue
+		}
+		view.Alive++
+		view.Totals.ConnectionsActive += member.Stats.ConnectionsActive
+		view.Totals.TunnelsActive += member.Stats.TunnelsActive
+		view.Totals.BytesUp += member.Stats.BytesUp
+		view.Totals.BytesDown += member.Stats.BytesDown
+	}
+	view.Totals.Users = members[0].Stats.Users
+	return view
+}
+
+// GET /api/cluster
+func handleAdminCluster(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, currentClusterView())
+}
+
+// Dữ liệu đã dùng của một user trên cả cluster
+type clusterUserView struct {
+	Username  string           `json:"username"`
+	DataUsage int64            `json:"data_usage"`
+	MaxData   int64            `json:"max_data"`
+	Suspended bool             `json:"suspended"`
+	Nodes     map[string]int64 `json:"nodes"` // Dữ liệu đã dùng qua từng node
+}
+
+// GET /api/cluster/users?user=
+func handleAdminClusterUsers(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	if !clusterRunning.Load() {
+		admin.WriteError(w, http.StatusNotFound, "cluster mode is not enabled")
+		return
+	}
+	only := r.URL.Query().Get("user")
+
+	clusterMutex.Lock()
+	byNode := make(map[string]map[string]int64, len(clusterUsageTable))
+	for username, nodes := range clusterUsageTable {
+		if only != "" && username != only {
+			continue
+		}
+		totals := make(map[string]int64, len(nodes))
+		for node, usage := range nodes {
+			totals[node] = usage.total()
+		}
+		byNode[username] = totals
+	}
+	clusterMutex.Unlock()
+
+	usersMutex.RLock()
+	list := make([]clusterUserView, 0, len(byNode))
+	for username, user := range users {
+		if (only != "" && username != only) || !canAccessUser(token, user) {
+			continue
+		}
+		nodes := byNode[username]
+		if nodes == nil {
+			nodes = map[string]int64{}
+		}
+		list = append(list, clusterUserView{username, user.dataUsage(), user.MaxData, user.isSuspended(), nodes})
+	}
+	usersMutex.RUnlock()
+
+	if only != "" && len(list) == 0 {
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	admin.WriteJSON(w, http.StatusOK, list)
+}
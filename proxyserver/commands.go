@@ -0,0 +1,226 @@
+package proxyserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Xử lý các lệnh con trên dòng lệnh, trả về mã thoát
+func runCommand(args []string) int {
+	switch args[0] {
+	case "encrypt":
+		return runEncryptCommand()
+	case "config":
+		if len(args) < 2 || args[1] != "apply" {
+			fmt.Fprintln(os.Stderr, "Usage: proxy-server config apply [--dry-run]")
+			return 2
+		}
+		return runConfigApplyCommand(args[2:])
+	case "bench":
+		return runBenchCommand(args[1:])
+	case "client":
+		return runClientCommand(args[1:])
+	case "user":
+		return runUserCommand(args[1:])
+	case "test":
+		return runTestCommand(args[1:])
+	case "grafana-dashboard":
+		return runGrafanaDashboardCommand(args[1:])
+	case "version":
+		return runVersionCommand(args[1:])
+	case "backup":
+		return runBackupCommand(args[1:])
+	case "restore":
+		return runRestoreCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		return 2
+	}
+}
+
+// Đọc một giá trị từ stdin và in ra dạng đã mã hóa để đưa vào file cấu hình
+func runEncryptCommand() int {
+	if err := loadSystemConfig(systemFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load system configuration: %v\n", err)
+		return 1
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	value, err := reader.ReadString('\n')
+	if err != nil && value == "" {
+		fmt.Fprintf(os.Stderr, "Unable to read value: %v\n", err)
+		return 1
+	}
+
+	adoptSecretKeySalt(userFile, adminTokensPath())
+	encrypted, err := encryptSecret(strings.TrimRight(value, "\r\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encrypt value: %v\n", err)
+		return 1
+	}
+	fmt.Println(encrypted)
+	return 0
+}
+
+// Các tùy chọn kết nối tới admin API của server đang chạy
+type apiClientFlags struct {
+	api      string
+	token    string
+	caCert   string
+	cert     string
+	key      string
+	insecure bool
+}
+
+func (f *apiClientFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.api, "api", "", "admin API base URL (default: from admin_listen in system.conf)")
+	flags.StringVar(&f.token, "token", os.Getenv("PROXY_API_TOKEN"), "admin API token (default: $PROXY_API_TOKEN)")
+	flags.StringVar(&f.caCert, "cacert", "", "CA bundle used to verify the admin API certificate")
+	flags.StringVar(&f.cert, "cert", "", "client certificate for mTLS")
+	flags.StringVar(&f.key, "key", "", "client private key for mTLS")
+	flags.BoolVar(&f.insecure, "insecure", false, "skip admin API certificate verification")
+}
+
+// Gửi request tới admin API và giải mã kết quả JSON vào out
+func (f *apiClientFlags) call(method, path string, out any) error {
+	baseURL := f.api
+	if baseURL == "" {
+		file, err := os.Open(systemFile)
+		if err != nil {
+			return err
+		}
+		config, err := parseSystemConfig(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if config.AdminListen == "" {
+			return fmt.Errorf("admin_listen is not set in %s, use --api", systemFile)
+		}
+		scheme := "http"
+		if config.AdminTLSCert != "" || config.AdminTLSACME {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + config.AdminListen
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: f.insecure}
+	if f.caCert != "" {
+		caPEM, err := os.ReadFile(f.caCert)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(caPEM)
+	}
+	if f.cert != "" {
+		cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(baseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiError) == nil && apiError.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiError.Error)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// Kiểm tra cấu hình trên đĩa, hiển thị khác biệt và áp dụng lên server đang chạy
+func runConfigApplyCommand(args []string) int {
+	flags := flag.NewFlagSet("config apply", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only validate and show the diff")
+	var client apiClientFlags
+	client.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	path := "/api/config/apply"
+	if *dryRun {
+		path += "?dry_run=true"
+	}
+
+	var diff ConfigDiff
+	if err := client.call(http.MethodPost, path, &diff); err != nil {
+		fmt.Fprintf(os.Stderr, "Config apply failed: %v\n", err)
+		return 1
+	}
+
+	printConfigDiff(diff)
+	if diff.Applied {
+		fmt.Println("Configuration applied.")
+	} else {
+		fmt.Println("Dry run: nothing applied.")
+	}
+	return 0
+}
+
+func printConfigDiff(diff ConfigDiff) {
+	empty := true
+	for _, username := range diff.UsersAdded {
+		fmt.Printf("+ user %s\n", username)
+		empty = false
+	}
+	for _, username := range diff.UsersRemoved {
+		fmt.Printf("- user %s\n", username)
+		empty = false
+	}
+	for _, change := range diff.UsersChanged {
+		for _, field := range change.Changes {
+			fmt.Printf("~ user %s %s: %s -> %s\n", change.Username, field.Field, field.Old, field.New)
+		}
+		empty = false
+	}
+	for _, field := range diff.SystemChanged {
+		fmt.Printf("~ system %s: %s -> %s\n", field.Field, field.Old, field.New)
+		empty = false
+	}
+	for _, listener := range diff.Listeners {
+		fmt.Printf("* listener %s\n", listener)
+		empty = false
+	}
+	for _, field := range diff.RestartRequired {
+		fmt.Printf("! %s changes only take effect after a restart\n", field)
+	}
+	if empty {
+		fmt.Println("No changes.")
+	}
+}
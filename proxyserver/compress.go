@@ -0,0 +1,232 @@
+package proxyserver
+
+import (
+	"compress/flate"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mức nén mặc định: BestSpeed tốn ít CPU và bộ nhớ cho mỗi kết nối nhất
+const defaultCompressLevel = flate.BestSpeed
+
+var (
+	compressRawBytes  atomic.Int64 // Số byte trước khi nén / sau khi giải nén
+	compressWireBytes atomic.Int64 // Số byte thực sự truyền trên kết nối nén
+)
+
+// Kết nối nén deflate hai chiều. Mỗi lần Write được flush ngay để không giữ dữ liệu tương tác
+type compressedConn struct {
+	net.Conn
+	reader     io.ReadCloser
+	writer     *flate.Writer
+	writeMutex sync.Mutex
+}
+
+func newCompressedConn(conn net.Conn, level int) *compressedConn {
+	wire := &wireCountingConn{Conn: conn}
+	writer, err := flate.NewWriter(wire, level)
+	if err != nil {
+		writer, _ = flate.NewWriter(wire, defaultCompressLevel)
+	}
+	return &compressedConn{Conn: conn, reader: flate.NewReader(wire), writer: writer}
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	compressRawBytes.Add(int64(n))
+	return n, err
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	n, err := c.writer.Write(p)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	compressRawBytes.Add(int64(n))
+	return n, err
+}
+
+// Kết thúc luồng nén để bên kia đọc được EOF, kết nối TCP vẫn mở để nhận dữ liệu
+func (c *compressedConn) CloseWrite() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writer.Close()
+}
+
+// Ghi khối cuối nếu không có Write nào đang chờ (Close có thể được gọi để gỡ một Write bị kẹt)
+func (c *compressedConn) Close() error {
+	if c.writeMutex.TryLock() {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writer.Close()
+		c.writeMutex.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+func (c *compressedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Đếm số byte đã nén đi qua kết nối
+type wireCountingConn struct {
+	net.Conn
+}
+
+func (c *wireCountingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	compressWireBytes.Add(int64(n))
+	return n, err
+}
+
+func (c *wireCountingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	compressWireBytes.Add(int64(n))
+	return n, err
+}
+
+func compressLevel() int {
+	if systemConfig().CompressLevel <= 0 {
+		return defaultCompressLevel
+	}
+	return systemConfig().CompressLevel
+}
+
+// Mở listener SOCKS mà mọi dữ liệu (kể cả bắt tay SOCKS) được nén deflate, dùng với lệnh client
+func listenCompressed(addr string) (net.Listener, error) {
+	tcpListener, err := listenTCP(listenerCompress, addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Compressed SOCKS listener started on %s", addr)
+	return tuneListener(tcpListener, addr), nil
+}
+
+func acceptCompressed(listener net.Listener, addr string) {
+	acceptWrappedSocks(listener, addr, "Compressed listener", func(conn net.Conn) (net.Conn, error) {
+		return newCompressedConn(conn, compressLevel()), nil
+	})
+}
+
+// Nhận kết nối, bọc bằng wrap (nén, che giấu) trong goroutine xử lý rồi xử lý như kết nối SOCKS.
+// Lỗi khi bọc (ví dụ bắt tay TLS) chỉ đóng kết nối, không log để thăm dò từ bên ngoài không làm đầy log
+func acceptWrappedSocks(listener net.Listener, addr, name string, wrap func(net.Conn) (net.Conn, error)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("%s %s accept error: %v", name, addr, err)
+			backoffOnFDExhaustion(err)
+			continue
+		}
+		if shedIfFDExhausted(conn) || shedIfMemoryHigh(conn) || !acquireConnCredit() {
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseConnCredit()
+			defer recoverPanic("compressed connection", conn)
+			wrapped, err := wrap(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			handleSocksConn(wrapped, addr)
+		}()
+	}
+}
+
+// Lệnh client: nhận kết nối SOCKS ở máy local và chuyển tới server qua kết nối nén (compress_listen)
+// hoặc qua lớp che giấu (obfs_listen) khi có --obfs
+func runClientCommand(args []string) int {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	serverAddr := flags.String("server", "", "compress_listen or obfs_listen address of the proxy server")
+	listenAddr := flags.String("listen", "127.0.0.1:1080", "local address for SOCKS clients")
+	level := flags.Int("level", defaultCompressLevel, "deflate level from 1 (fastest) to 9 (smallest)")
+	obfs := flags.String("obfs", "", "obfuscation method and options matching obfs_listen, e.g. padding,key=secret")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *serverAddr == "" {
+		fmt.Fprintln(os.Stderr, "--server is required")
+		return 2
+	}
+	if *level < flate.BestSpeed || *level > flate.BestCompression {
+		fmt.Fprintln(os.Stderr, "--level must be between 1 and 9")
+		return 2
+	}
+
+	wrap := func(conn net.Conn) (net.Conn, error) {
+		return newCompressedConn(conn, *level), nil
+	}
+	transport := "compression"
+	if *obfs != "" {
+		method, options, err := parseObfsSpec(*obfs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --obfs value: %v\n", err)
+			return 2
+		}
+		obfuscator, err := newObfuscator(method, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --obfs value: %v\n", err)
+			return 2
+		}
+		wrap, transport = obfuscator.Client, method+" obfuscation"
+	}
+
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to listen on %s: %v\n", *listenAddr, err)
+		return 1
+	}
+	log.Printf("Forwarding %s to %s with %s", *listenAddr, *serverAddr, transport)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Accept error: %v\n", err)
+			return 1
+		}
+		go relayToServer(conn, *serverAddr, wrap)
+	}
+}
+
+func relayToServer(local net.Conn, serverAddr string, wrap func(net.Conn) (net.Conn, error)) {
+	defer local.Close()
+	conn, err := net.DialTimeout("tcp", serverAddr, 10*time.Second)
+	if err != nil {
+		log.Printf("Unable to connect to %s: %v", serverAddr, err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	remote, err := wrap(conn)
+	if err != nil {
+		conn.Close()
+		log.Printf("Unable to set up connection to %s: %v", serverAddr, err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	defer remote.Close()
+
+	// Client local đóng chiều gửi: đóng chiều gửi tới server (nếu lớp bọc hỗ trợ) rồi tiếp tục nhận dữ liệu trả về
+	go func() {
+		io.Copy(remote, local)
+		if closer, ok := remote.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite()
+		}
+	}()
+	io.Copy(local, remote)
+}
@@ -0,0 +1,38 @@
+// Package config đọc định dạng của system.conf: các dòng key=value, chú thích #, và các section [tên] của
+// profile ghi đè cấu hình chung. Ý nghĩa của từng khóa do server quyết định
+package config
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Một dòng key=value, key và value đã bỏ khoảng trắng hai đầu
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// Đọc các dòng key=value theo thứ tự trong file sau khi áp dụng section của profile (rỗng = chỉ cấu hình
+// chung). Dòng trống, chú thích và dòng không có dấu = bị bỏ qua
+func Read(r io.Reader, profile string) ([]Entry, error) {
+	r, err := selectProfile(r, profile)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		entries = append(entries, Entry{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const profileFile = `# chung
+max_connections=100
+egress_ip=10.0.0.1
+egress_ip = 10.0.0.2
+admin_listen=127.0.0.1:9090
+not a key value line
+
+[staging]
+egress_ip=10.1.0.1
+log_level=debug
+
+[prod]
+max_connections=5000
+`
+
+func TestReadProfile(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    []Entry
+	}{
+		{"", []Entry{
+			{"max_connections", "100"},
+			{"egress_ip", "10.0.0.1"},
+			{"egress_ip", "10.0.0.2"},
+			{"admin_listen", "127.0.0.1:9090"},
+		}},
+		// Khóa của section thay mọi dòng cùng khóa tại vị trí dòng đầu tiên; khóa mới đứng cuối
+		{"staging", []Entry{
+			{"max_connections", "100"},
+			{"egress_ip", "10.1.0.1"},
+			{"admin_listen", "127.0.0.1:9090"},
+			{"log_level", "debug"},
+		}},
+		{"prod", []Entry{
+			{"max_connections", "5000"},
+			{"egress_ip", "10.0.0.1"},
+			{"egress_ip", "10.0.0.2"},
+			{"admin_listen", "127.0.0.1:9090"},
+		}},
+	}
+	for _, test := range tests {
+		entries, err := Read(strings.NewReader(profileFile), test.profile)
+		if err != nil {
+			t.Fatalf("profile %q: %v", test.profile, err)
+		}
+		if !reflect.DeepEqual(entries, test.want) {
+			t.Errorf("profile %q: got %v, want %v", test.profile, entries, test.want)
+		}
+	}
+}
+
+func TestReadProfileErrors(t *testing.T) {
+	for _, test := range []struct {
+		file, profile string
+	}{
+		{profileFile, "dev"},
+		{"[a b]\n", ""},
+		{"[prod]\n[prod]\n", "prod"},
+	} {
+		if _, err := Read(strings.NewReader(test.file), test.profile); err == nil {
+			t.Errorf("%q with profile %q: no error", test.file, test.profile)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Profile cấu hình (dev, staging, prod...) trong cùng một file: các dòng trước section đầu tiên là cấu hình
+// chung, mỗi section [tên] ghi đè cho một profile. Khóa có trong section thay thế mọi dòng cùng khóa của cấu
+// hình chung, kể cả khóa lặp lại như egress_ip hoặc tls_offload
+const maxProfileNameLen = 64
+
+// Tên profile hợp lệ: chữ, số, gạch dưới, gạch ngang, dấu chấm
+func validProfileName(name string) bool {
+	if name == "" || len(name) > maxProfileNameLen {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Tên section nếu line là dòng [tên]
+func profileSection(line string) (string, bool) {
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+	return strings.TrimSpace(line[1 : len(line)-1]), true
+}
+
+// Gộp cấu hình chung với section của profile thành nội dung key=value không còn section.
+// Dòng của profile được đặt tại vị trí dòng đầu tiên cùng khóa trong cấu hình chung để giữ thứ tự đọc
+func selectProfile(r io.Reader, profile string) (io.Reader, error) {
+	var base []string
+	overrides := make(map[string][]string)
+	var overrideKeys []string // Thứ tự xuất hiện trong section
+	sections := make(map[string]bool)
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := profileSection(line); ok {
+			if !validProfileName(name) {
+				return nil, fmt.Errorf("invalid profile section %s", line)
+			}
+			if sections[name] {
+				return nil, fmt.Errorf("duplicate profile section %s", line)
+			}
+			sections[name] = true
+			section = name
+			continue
+		}
+		if section == "" {
+			base = append(base, line)
+			continue
+		}
+		if section != profile || line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if _, exists := overrides[key]; !exists {
+			overrideKeys = append(overrideKeys, key)
+		}
+		overrides[key] = append(overrides[key], line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if profile != "" && !sections[profile] {
+		return nil, fmt.Errorf("profile %q is not defined, expected a [%s] section", profile, profile)
+	}
+
+	var merged strings.Builder
+	placed := make(map[string]bool)
+	for _, line := range base {
+		key, _, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if lines, exists := overrides[key]; found && exists && !strings.HasPrefix(line, "#") {
+			if !placed[key] {
+				for _, override := range lines {
+					merged.WriteString(override + "\n")
+				}
+				placed[key] = true
+			}
+			continue
+		}
+		merged.WriteString(line + "\n")
+	}
+	for _, key := range overrideKeys {
+		if !placed[key] {
+			for _, override := range overrides[key] {
+				merged.WriteString(override + "\n")
+			}
+		}
+	}
+	return strings.NewReader(merged.String()), nil
+}
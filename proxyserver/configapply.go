@@ -0,0 +1,388 @@
+package proxyserver
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Một giá trị cấu hình thay đổi
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Thay đổi của một user
+type UserChange struct {
+	Username string        `json:"username"`
+	Changes  []FieldChange `json:"changes"`
+}
+
+// Khác biệt giữa cấu hình đang chạy và cấu hình mới
+type ConfigDiff struct {
+	UsersAdded      []string      `json:"users_added"`
+	UsersRemoved    []string      `json:"users_removed"`
+	UsersChanged    []UserChange  `json:"users_changed"`
+	SystemChanged   []FieldChange `json:"system_changed"`
+	Listeners       []string      `json:"listeners"`        // Listener sẽ được mở/đóng khi áp dụng
+	RestartRequired []string      `json:"restart_required"` // Thay đổi chỉ có hiệu lực sau khi khởi động lại
+	Applied         bool          `json:"applied"`
+}
+
+// Các khóa cấu hình chứa secret, không đưa vào diff, audit log và fingerprint. URL ở các khóa khác
+// được ẩn userinfo và query bằng redactURL
+var secretConfigFields = map[string]bool{
+	"StripeWebhookSecret": true,
+	"TelegramBotToken":    true,
+	"SMTPPassword":        true,
+	"ClusterSecret":       true,
+	"ControllerToken":     true,
+	"AnalyticsDSN":        true,
+	"EventBus":            true,
+	"DiscordWebhook":      true, // Token nằm trong đường dẫn của webhook
+}
+
+// URL với userinfo và giá trị query (thường chứa token) được thay bằng ***; giá trị khác giữ nguyên
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.User == nil && parsed.RawQuery == "") {
+		return value
+	}
+	hasUser := parsed.User != nil
+	parsed.User = nil
+	if parsed.RawQuery != "" {
+		params := strings.Split(parsed.RawQuery, "&")
+		for i, param := range params {
+			// Tham số không có = (?<token>) bị ẩn cả tên
+			if name, _, found := strings.Cut(param, "="); found {
+				params[i] = name + "=***"
+			} else {
+				params[i] = "***"
+			}
+		}
+		parsed.RawQuery = strings.Join(params, "&")
+	}
+	redacted := parsed.String()
+	if hasUser {
+		redacted = strings.Replace(redacted, "://", "://***@", 1)
+	}
+	return redacted
+}
+
+// Giá trị cấu hình để hiển thị: URL trong chuỗi hoặc danh sách chuỗi được ẩn bằng redactURL
+func redactConfigValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return redactURL(v)
+	case []string:
+		redacted := make([]string, len(v))
+		for i, item := range v {
+			redacted[i] = redactURL(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// Bản sao cấu hình với các secret được thay bằng *** và URL đã được ẩn
+func redactSystemConfig(config SystemConfig) SystemConfig {
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if secretConfigFields[value.Type().Field(i).Name] {
+			if field.Kind() == reflect.String && field.String() != "" {
+				field.SetString("***")
+			}
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(redactURL(field.String()))
+		case reflect.Slice:
+			if items, ok := field.Interface().([]string); ok {
+				field.Set(reflect.ValueOf(redactConfigValue(items)))
+			}
+		}
+	}
+	return config
+}
+
+// Các khóa cấu hình chỉ được đọc khi khởi động
+var restartOnlyFields = map[string]bool{
+	"AdminListen":         true,
+	"AdminTokensFile":     true,
+	"AdminTLSCert":        true,
+	"AdminTLSKey":         true,
+	"AdminClientCA":       true,
+	"AdminTLSACME":        true,
+	"ACMEDomains":         true,
+	"ACMEEmail":           true,
+	"ACMECacheDir":        true,
+	"ACMEHTTPListen":      true,
+	"AuditLogFile":        true,
+	"CrashDir":            true,
+	"AuthLogFile":         true,
+	"ClusterAdvertise":    true,
+	"ClusterCA":           true,
+	"ClusterListen":       true,
+	"ClusterNode":         true,
+	"ClusterTLSCert":      true,
+	"ClusterTLSKey":       true,
+	"ConfigWatchInterval": true,
+	"ControllerCA":        true,
+	"ControllerURL":       true,
+	"HealthListen":        true,
+	"UsageListen":         true,
+	"UsageJournal":        true,
+	"LastSeenFile":        true,
+	"AnalyticsSink":       true,
+	"AnalyticsBuffer":     true,
+	"EventBusBuffer":      true,
+	"CompressListen":      true,
+	"ObfsListeners":       true,
+	"SocksIPv4":           true,
+	"SocksIPv4Port":       true,
+	"SocksIPv6":           true,
+	"SocksIPv6Port":       true,
+	"SocksDualStack":      true,
+	"SocksDualStackPort":  true,
+}
+
+// Đọc và kiểm tra system.conf và users.conf trên đĩa mà chưa áp dụng
+func readConfigFiles() (SystemConfig, map[string]*User, error) {
+	systemReader, err := os.Open(systemFile)
+	if err != nil {
+		return SystemConfig{}, nil, err
+	}
+	defer systemReader.Close()
+
+	config, err := parseSystemConfig(systemReader)
+	if err != nil {
+		return SystemConfig{}, nil, fmt.Errorf("%s: %v", systemFile, err)
+	}
+
+	usersReader, err := os.Open(userFile)
+	if err != nil {
+		return SystemConfig{}, nil, err
+	}
+	defer usersReader.Close()
+
+	newUsers, err := parseUsers(usersReader, config.StrictUsers)
+	if err != nil {
+		return SystemConfig{}, nil, fmt.Errorf("%s: %v", userFile, err)
+	}
+	return config, newUsers, nil
+}
+
+func diffSystemConfig(oldConfig, newConfig SystemConfig) (changes []FieldChange, restart []string) {
+	oldValue := reflect.ValueOf(oldConfig)
+	newValue := reflect.ValueOf(newConfig)
+	configType := oldValue.Type()
+
+	for i := 0; i < configType.NumField(); i++ {
+		name := configType.Field(i).Name
+		if name == "TLSOffloads" {
+			continue // So sánh riêng theo listener
+		}
+		before := oldValue.Field(i).Interface()
+		after := newValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if secretConfigFields[name] {
+			before, after = "***", "***" // Không đưa secret vào diff và audit log
+		} else {
+			before, after = redactConfigValue(before), redactConfigValue(after)
+		}
+		changes = append(changes, FieldChange{name, fmt.Sprint(before), fmt.Sprint(after)})
+		if restartOnlyFields[name] {
+			restart = append(restart, name)
+		}
+	}
+	return changes, restart
+}
+
+func diffUser(oldUser, newUser *User) []FieldChange {
+	var changes []FieldChange
+	if oldUser.Password != newUser.Password {
+		changes = append(changes, FieldChange{"password", "***", "***"})
+	}
+	compare := func(field string, before, after any) {
+		if before != after {
+			changes = append(changes, FieldChange{field, fmt.Sprint(before), fmt.Sprint(after)})
+		}
+	}
+	compare("start_date", oldUser.StartDate.Format("2006-01-02"), newUser.StartDate.Format("2006-01-02"))
+	compare("end_date", oldUser.EndDate.Format("2006-01-02"), newUser.EndDate.Format("2006-01-02"))
+	compare("connection_limit", oldUser.ConnectionLimit, newUser.ConnectionLimit)
+	compare("max_data", oldUser.MaxData, newUser.MaxData)
+	compare("max_bandwidth", oldUser.MaxBandwidth, newUser.MaxBandwidth)
+	compare("owner", oldUser.Owner, newUser.Owner)
+	compare("group", oldUser.Group, newUser.Group)
+	compare("max_transfer", oldUser.MaxTransfer, newUser.MaxTransfer)
+	compare("email", oldUser.Email, newUser.Email)
+	compare("tags", formatTags(oldUser.Tags), formatTags(newUser.Tags))
+	return changes
+}
+
+// Các listener TLS offload cần đóng và cần mở khi chuyển sang cấu hình mới
+func diffTLSOffloads(oldOffloads, newOffloads []TLSOffloadConfig) (removed, added []TLSOffloadConfig) {
+	oldByAddr := make(map[string]TLSOffloadConfig)
+	for _, offload := range oldOffloads {
+		oldByAddr[offload.Listen] = offload
+	}
+	newByAddr := make(map[string]TLSOffloadConfig)
+	for _, offload := range newOffloads {
+		newByAddr[offload.Listen] = offload
+	}
+
+	for addr, offload := range oldByAddr {
+		if newOffload, exists := newByAddr[addr]; !exists || newOffload != offload {
+			removed = append(removed, offload)
+		}
+	}
+	for addr, offload := range newByAddr {
+		if oldOffload, exists := oldByAddr[addr]; !exists || oldOffload != offload {
+			added = append(added, offload)
+		}
+	}
+	return removed, added
+}
+
+// Nội dung các file mà cấu hình mới tham chiếu tới, đã đọc và kiểm tra trước khi áp dụng
+type preparedConfig struct {
+	policyScript  *policyScript
+	rewriteRules  *[]rewriteRule
+	dnsOverrides  *dnsOverrides
+	acl           *aclRules
+	upstreams     []upstreamSpec
+	reputation    map[string]*reputationList
+	reputationErr error
+}
+
+// Đọc và kiểm tra mọi file mà config tham chiếu tới; lỗi ở file nào thì cả cấu hình bị từ chối.
+// reputation_list chỉ được tải khi áp dụng và nguồn lỗi giữ nội dung cũ như khi refresh định kỳ
+func prepareConfig(config *SystemConfig, dryRun bool) (*preparedConfig, error) {
+	var prepared preparedConfig
+	var err error
+	if prepared.policyScript, err = loadPolicyScript(config.PolicyScript); err != nil {
+		return nil, fmt.Errorf("policy_script: %v", err)
+	}
+	if prepared.rewriteRules, err = loadRewriteRules(config.RewriteFile); err != nil {
+		return nil, fmt.Errorf("rewrite_file: %v", err)
+	}
+	if prepared.dnsOverrides, err = loadDNSOverrides(config.DNSOverridesFile); err != nil {
+		return nil, fmt.Errorf("dns_overrides_file: %v", err)
+	}
+	if prepared.acl, err = loadACL(config.ACLFile); err != nil {
+		return nil, fmt.Errorf("acl_file: %v", err)
+	}
+	if config.UpstreamFile != "" || config.UpstreamURL != "" {
+		if prepared.upstreams, err = fetchUpstreams(config); err != nil {
+			return nil, fmt.Errorf("upstreams: %v", err)
+		}
+	}
+	if !dryRun {
+		prepared.reputation, prepared.reputationErr = fetchReputationLists(config.ReputationLists)
+	}
+	return &prepared, nil
+}
+
+// Thay các quy tắc đang chạy bằng nội dung đã chuẩn bị; pool upstream chỉ được thay khi có cấu hình upstream
+func (prepared *preparedConfig) install(config *SystemConfig) {
+	installPolicyScript(prepared.policyScript)
+	rewriteRules.Store(prepared.rewriteRules)
+	dnsOverrideRules.Store(prepared.dnsOverrides)
+	aclCurrent.Store(prepared.acl)
+	if config.UpstreamFile != "" || config.UpstreamURL != "" {
+		installUpstreams(prepared.upstreams)
+	}
+	installReputationLists(prepared.reputation)
+	if prepared.reputationErr != nil {
+		log.Printf("Reputation list reload error: %v", prepared.reputationErr)
+	}
+}
+
+// Tính khác biệt so với cấu hình đang chạy và áp dụng nếu không phải dry-run. Mọi file được tham chiếu
+// được kiểm tra trước; lỗi thì không áp dụng gì và trả về lỗi
+func applyConfig(newConfig SystemConfig, newUsers map[string]*User, dryRun bool) (ConfigDiff, error) {
+	// Đọc file và tải từ URL (có thể mất tới 30 giây) trước khi khóa usersMutex để đăng nhập SOCKS
+	// không phải chờ
+	if !dryRun {
+		reputationReloadMutex.Lock()
+		defer reputationReloadMutex.Unlock()
+	}
+	prepared, err := prepareConfig(&newConfig, dryRun)
+	if err != nil {
+		return ConfigDiff{}, err
+	}
+
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+
+	var diff ConfigDiff
+	for username, newUser := range newUsers {
+		oldUser, exists := users[username]
+		if !exists {
+			diff.UsersAdded = append(diff.UsersAdded, username)
+			continue
+		}
+		if changes := diffUser(oldUser, newUser); len(changes) > 0 {
+			diff.UsersChanged = append(diff.UsersChanged, UserChange{username, changes})
+		}
+	}
+	for username := range users {
+		if _, exists := newUsers[username]; !exists {
+			diff.UsersRemoved = append(diff.UsersRemoved, username)
+		}
+	}
+	sort.Strings(diff.UsersAdded)
+	sort.Strings(diff.UsersRemoved)
+	sort.Slice(diff.UsersChanged, func(i, j int) bool {
+		return diff.UsersChanged[i].Username < diff.UsersChanged[j].Username
+	})
+
+	oldConfig := systemConfig()
+	diff.SystemChanged, diff.RestartRequired = diffSystemConfig(*oldConfig, newConfig)
+
+	removed, added := diffTLSOffloads(oldConfig.TLSOffloads, newConfig.TLSOffloads)
+	for _, offload := range removed {
+		diff.Listeners = append(diff.Listeners, "stop tls_offload "+offload.Listen)
+	}
+	for _, offload := range added {
+		diff.Listeners = append(diff.Listeners, "start tls_offload "+offload.Listen+" -> "+offload.Backend)
+	}
+
+	if dryRun {
+		return diff, nil
+	}
+
+	// Giữ lại bộ đếm đang chạy và trạng thái khóa của các user còn tồn tại
+	replaceUsers(newUsers)
+	setSystemConfig(newConfig)
+	prepared.install(&newConfig)
+
+	syncMaintenanceConfig(*oldConfig, newConfig)
+	syncEgressPool(newConfig.EgressIPs)
+	resetPolicyCache()
+
+	for _, offload := range removed {
+		closeListener(offload.Listen)
+	}
+	for _, offload := range added {
+		go startTLSOffload(offload)
+	}
+
+	diff.Applied = true
+	log.Printf("Configuration applied: %d users added, %d removed, %d changed, %d system settings changed.",
+		len(diff.UsersAdded), len(diff.UsersRemoved), len(diff.UsersChanged), len(diff.SystemChanged))
+	return diff, nil
+}
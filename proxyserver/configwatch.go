@@ -0,0 +1,116 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tự áp dụng thay đổi của file cấu hình trên đĩa, ví dụ ConfigMap/Secret gắn vào pod Kubernetes.
+// Kubelet cập nhật các file này bằng cách đổi symlink, nên chỉ cần so thời điểm sửa và kích thước
+// của file đích sau mỗi config_watch_interval giây.
+// fileStamp là thời điểm sửa và kích thước của một file, rỗng khi không đọc được
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info.ModTime().UnixNano(), info.Size()}
+}
+
+// Một nhóm file được nạp lại cùng nhau khi một file trong nhóm thay đổi
+type watchedFiles struct {
+	name   string
+	files  []string
+	reload func() error
+}
+
+func watchedFileGroups() []watchedFiles {
+	groups := []watchedFiles{{"configuration", []string{systemFile, userFile}, applyWatchedConfig}}
+	if systemConfig().AdminListen != "" {
+		groups = append(groups, watchedFiles{"admin API tokens", []string{adminTokensPath()}, func() error {
+			return loadAPITokens(adminTokensPath())
+		}})
+	}
+	if files := keyPairFiles(); len(files) > 0 {
+		groups = append(groups, watchedFiles{"TLS key pairs", files, func() error {
+			return errors.Join(reloadKeyPairs()...)
+		}})
+	}
+	return groups
+}
+
+func runConfigWatcher() {
+	interval := time.Duration(systemConfig().ConfigWatchInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+	log.Printf("Watching configuration files for changes every %v", interval)
+
+	stamps := make(map[string]fileStamp)
+	var lastError string
+	for {
+		var errs []error
+		for _, group := range watchedFileGroups() {
+			changed := false
+			current := make(map[string]fileStamp, len(group.files))
+			for _, path := range group.files {
+				stamp := statFile(path)
+				current[path] = stamp
+				if previous, known := stamps[path]; !known {
+					stamps[path] = stamp // File mới được theo dõi, chưa coi là thay đổi
+				} else if stamp != previous {
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			// Khi lỗi, giữ dấu cũ để thử lại ở lần kiểm tra sau
+			if err := group.reload(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", group.name, err))
+				continue
+			}
+			for path, stamp := range current {
+				stamps[path] = stamp
+			}
+			log.Printf("Configuration watch: %s reloaded", group.name)
+		}
+
+		// Chỉ log khi lỗi thay đổi, như policy script
+		message := ""
+		if err := errors.Join(errs...); err != nil {
+			message = err.Error()
+		}
+		if message != "" && message != lastError {
+			log.Printf("Configuration watch error: %s", message)
+		}
+		lastError = message
+		time.Sleep(interval)
+	}
+}
+
+// Áp dụng system.conf và users.conf như config apply; file lỗi không thay đổi gì
+func applyWatchedConfig() error {
+	newConfig, newUsers, err := readConfigFiles()
+	if err != nil {
+		return err
+	}
+	diff, err := applyConfig(newConfig, newUsers, false)
+	if err != nil {
+		return err
+	}
+	recordAudit("watcher", "config.apply", systemFile+","+userFile, nil, diff)
+	if len(diff.RestartRequired) > 0 {
+		log.Printf("Configuration watch: restart required for %s", strings.Join(diff.RestartRequired, ", "))
+	}
+	return nil
+}
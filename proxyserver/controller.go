@@ -0,0 +1,212 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Một listener SOCKS (hoặc SOCKS nén) với vòng accept chạy trong goroutine riêng. Mỗi listener
+// được mở và dừng độc lập từ menu hoặc admin API; menu không bị chặn khi server đang chạy
+type listenerInstance struct {
+	kind string
+	done chan struct{} // Đóng khi vòng accept kết thúc
+}
+
+var (
+	instances      = make(map[string]*listenerInstance) // Theo địa chỉ
+	instancesMutex sync.Mutex
+
+	errInstanceNotRunning = errors.New("no listener is running on this address")
+)
+
+// Họ địa chỉ của listener SOCKS: chỉ IPv4, chỉ IPv6, hoặc một listener nhận cả hai
+const (
+	familyIPv4      = "ipv4"
+	familyIPv6      = "ipv6"
+	familyDualStack = "dual"
+)
+
+// Cổng mặc định theo họ địa chỉ; listener chỉ IPv6 dùng cổng riêng để không trùng listener dual-stack
+const (
+	defaultSocksIPv4Port      = 1080
+	defaultSocksIPv6Port      = 1081
+	defaultSocksDualStackPort = 1080
+)
+
+// Mở listener loại kind tại addr và bắt đầu nhận kết nối, không chặn bên gọi
+func startInstance(kind, addr string) error {
+	return startNetworkInstance(kind, "tcp", addr)
+}
+
+// Như startInstance với network của listener SOCKS: tcp4 (chỉ IPv4), tcp6 (chỉ IPv6)
+// hoặc tcp (dual-stack khi addr là [::]); listener SOCKS nén luôn dùng tcp
+func startNetworkInstance(kind, network, addr string) error {
+	addr = canonicalListenAddr(addr)
+	instancesMutex.Lock()
+	defer instancesMutex.Unlock()
+
+	if _, exists := instances[addr]; exists {
+		return fmt.Errorf("listener already running on %s", addr)
+	}
+	var (
+		listener net.Listener
+		accept   func(net.Listener, string)
+		err      error
+	)
+	switch kind {
+	case listenerSocks:
+		listener, err = listenSocks(network, addr)
+		accept = acceptSocks
+	case listenerCompress:
+		listener, err = listenCompressed(addr)
+		accept = acceptCompressed
+	default:
+		return fmt.Errorf("unsupported listener protocol %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	instance := &listenerInstance{kind: kind, done: make(chan struct{})}
+	instances[addr] = instance
+
+	go func() {
+		defer close(instance.done)
+		accept(listener, addr)
+
+		// Vòng accept cũng kết thúc khi listener bị đóng từ nơi khác (nâng cấp nóng)
+		instancesMutex.Lock()
+		if instances[addr] == instance {
+			delete(instances, addr)
+		}
+		instancesMutex.Unlock()
+	}()
+	return nil
+}
+
+// Ngừng nhận kết nối mới trên addr và chờ vòng accept thoát; các kết nối đang xử lý không bị đóng
+func stopInstance(addr string) error {
+	addr = canonicalListenAddr(addr)
+	instancesMutex.Lock()
+	instance, exists := instances[addr]
+	instancesMutex.Unlock()
+
+	if !exists {
+		if cancelListenerRetry(addr) {
+			log.Printf("Listener %s: retry cancelled.", addr)
+			return nil
+		}
+		return errInstanceNotRunning
+	}
+	closeListener(addr)
+	<-instance.done
+	log.Printf("Listener %s (%s) stopped.", addr, instance.kind)
+	notifyOperators("Listener %s (%s) stopped", addr, instance.kind)
+	return nil
+}
+
+// Dừng mọi listener đang chạy, trả về các địa chỉ đã dừng
+func stopInstances() []string {
+	addrs := runningInstances("")
+	for _, addr := range addrs {
+		stopInstance(addr)
+	}
+	return addrs
+}
+
+// Địa chỉ các listener đang chạy thuộc loại kind (rỗng = mọi loại), sắp theo địa chỉ
+func runningInstances(kind string) []string {
+	instancesMutex.Lock()
+	var addrs []string
+	for addr, instance := range instances {
+		if kind == "" || instance.kind == kind {
+			addrs = append(addrs, addr)
+		}
+	}
+	instancesMutex.Unlock()
+
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Cổng của listener SOCKS theo họ địa chỉ: socks_ipv4_port, socks_ipv6_port, socks_dual_stack_port hoặc mặc định
+func socksFamilyPort(family string) int {
+	switch family {
+	case familyIPv4:
+		if systemConfig().SocksIPv4Port > 0 {
+			return systemConfig().SocksIPv4Port
+		}
+		return defaultSocksIPv4Port
+	case familyIPv6:
+		if systemConfig().SocksIPv6Port > 0 {
+			return systemConfig().SocksIPv6Port
+		}
+		return defaultSocksIPv6Port
+	}
+	if systemConfig().SocksDualStackPort > 0 {
+		return systemConfig().SocksDualStackPort
+	}
+	return defaultSocksDualStackPort
+}
+
+// Network và địa chỉ của listener SOCKS theo họ địa chỉ. host rỗng = mọi địa chỉ của họ đó
+func socksFamilyAddr(family, host string) (string, string) {
+	port := strconv.Itoa(socksFamilyPort(family))
+	switch family {
+	case familyIPv4:
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		return "tcp4", net.JoinHostPort(host, port)
+	case familyIPv6:
+		if host == "" {
+			host = "::"
+		}
+		return "tcp6", net.JoinHostPort(host, port)
+	}
+	return "tcp", net.JoinHostPort("::", port)
+}
+
+// Mở các listener SOCKS được bật trong system.conf (socks_ipv4, socks_ipv6, socks_dual_stack)
+func startFamilyInstances() {
+	enabled := map[string]bool{
+		familyIPv4:      systemConfig().SocksIPv4,
+		familyIPv6:      systemConfig().SocksIPv6,
+		familyDualStack: systemConfig().SocksDualStack,
+	}
+	for _, family := range []string{familyIPv4, familyIPv6, familyDualStack} {
+		if !enabled[family] {
+			continue
+		}
+		network, addr := socksFamilyAddr(family, "")
+		if err := startInstanceRetrying(listenerSocks, network, addr); err != nil {
+			log.Printf("SOCKS %s listener %s: %v", family, addr, err)
+		}
+	}
+}
+
+// Kiểm tra các listener SOCKS theo họ địa chỉ không dùng trùng cổng. Listener chỉ IPv4 và chỉ IPv6
+// dùng chung cổng được, listener dual-stack thì không, vì nó đã nhận cả hai họ địa chỉ
+func validateSocksFamilies(config SystemConfig) error {
+	if !config.SocksDualStack {
+		return nil
+	}
+	port := func(value, fallback int) int {
+		if value > 0 {
+			return value
+		}
+		return fallback
+	}
+	dualPort := port(config.SocksDualStackPort, defaultSocksDualStackPort)
+	if config.SocksIPv4 && port(config.SocksIPv4Port, defaultSocksIPv4Port) == dualPort {
+		return fmt.Errorf("socks_dual_stack and socks_ipv4 cannot both use port %d", dualPort)
+	}
+	if config.SocksIPv6 && port(config.SocksIPv6Port, defaultSocksIPv6Port) == dualPort {
+		return fmt.Errorf("socks_dual_stack and socks_ipv6 cannot both use port %d", dualPort)
+	}
+	return nil
+}
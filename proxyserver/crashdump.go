@@ -0,0 +1,276 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Crash dump: panic trong goroutine xử lý kết nối được recover, ghi bản dump (stack mọi goroutine, fingerprint
+// cấu hình, bộ đếm) vào crash_dir và gửi tới crash_report_url; process tiếp tục phục vụ. Panic ở chỗ khác làm
+// process chết: runtime ghi output vào file fatal-<pid>.log trong crash_dir, lần khởi động sau thu lại và gửi đi
+const (
+	crashDumpInterval = time.Minute      // Khoảng cách tối thiểu giữa hai bản dump khi panic liên tục
+	maxCrashFiles     = 50               // Số bản dump giữ lại trong crash_dir
+	maxCrashStack     = 64 << 20         // Kích thước tối đa của stack mọi goroutine
+	crashReportTime   = 10 * time.Second // Thời gian chờ crash_report_url
+	crashOutputMarker = "--- runtime output ---"
+)
+
+// Bản dump ghi vào crash_dir và gửi tới crash_report_url (POST JSON)
+type CrashReport struct {
+	Kind              string        `json:"kind"` // panic (đã recover) hoặc fatal (process đã chết)
+	Time              time.Time     `json:"time"`
+	Host              string        `json:"host"`
+	PID               int           `json:"pid"`
+	Build             *BuildInfo    `json:"build,omitempty"` // Với fatal: xem phần đầu của Goroutines
+	ConfigFingerprint string        `json:"config_fingerprint,omitempty"`
+	Where             string        `json:"where,omitempty"`
+	Panic             string        `json:"panic,omitempty"`
+	Goroutines        string        `json:"goroutines"` // Với fatal: toàn bộ output của runtime
+	Status            *ServerStatus `json:"status,omitempty"`
+	CloseReasons      []reasonCount `json:"close_reasons,omitempty"`
+}
+
+var (
+	panicsTotal    atomic.Int64 // Số panic đã recover
+	lastCrashDump  time.Time
+	crashDumpMutex sync.Mutex
+)
+
+func crashReportingEnabled() bool {
+	return systemConfig().CrashDir != "" || systemConfig().CrashReportURL != ""
+}
+
+// Hash cấu hình đang chạy (không gồm secret) để so bản dump với cấu hình đã triển khai
+func configFingerprint() string {
+	config := *systemConfig()
+	value := reflect.ValueOf(&config).Elem()
+	for name := range secretConfigFields {
+		value.FieldByName(name).SetString("")
+	}
+	return sha256Hex([]byte(fmt.Sprintf("%+v", config)))[:16]
+}
+
+// Dùng bằng defer ở đầu goroutine xử lý kết nối; kết nối bị đóng khi panic. Khi crash reporting tắt,
+// không gọi recover nên panic làm process chết như trước
+func recoverPanic(where string, conn net.Conn) {
+	if !crashReportingEnabled() {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	conn.Close()
+	panicsTotal.Add(1)
+	log.Printf("Recovered panic in %s: %v", where, r)
+
+	crashDumpMutex.Lock()
+	if time.Since(lastCrashDump) < crashDumpInterval {
+		crashDumpMutex.Unlock()
+		return
+	}
+	lastCrashDump = time.Now()
+	crashDumpMutex.Unlock()
+
+	report := newCrashReport("panic")
+	report.Where, report.Panic = where, fmt.Sprint(r)
+	report.Goroutines = allGoroutineStacks()
+	collectCrashCounters(&report)
+	saveCrashReport(report)
+}
+
+func newCrashReport(kind string) CrashReport {
+	host, _ := os.Hostname()
+	build := currentBuildInfo()
+	return CrashReport{
+		Kind:              kind,
+		Time:              time.Now().UTC(),
+		Host:              host,
+		PID:               os.Getpid(),
+		Build:             &build,
+		ConfigFingerprint: configFingerprint(),
+	}
+}
+
+// Stack của mọi goroutine, tăng buffer tới khi đủ chỗ
+func allGoroutineStacks() string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxCrashStack {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// Bộ đếm lúc panic; trạng thái có thể đang hỏng nên panic khi đọc chỉ làm thiếu phần này
+func collectCrashCounters(report *CrashReport) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Crash dump: unable to collect counters: %v", r)
+		}
+	}()
+	status := currentServerStatus()
+	report.Status = &status
+	report.CloseReasons = closeReasonSnapshot()
+}
+
+// Ghi bản dump vào crash_dir và gửi tới crash_report_url trong nền
+func saveCrashReport(report CrashReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Crash dump error: %v", err)
+		return
+	}
+	if dir := systemConfig().CrashDir; dir != "" {
+		path := filepath.Join(dir, fmt.Sprintf("crash-%s-%d.json", report.Time.Format("20060102T150405Z"), report.PID))
+		if err := writeFileAtomic(path, data); err != nil {
+			log.Printf("Crash dump error: %v", err)
+		} else {
+			log.Printf("Crash dump written to %s", path)
+			pruneCrashFiles(dir)
+		}
+	}
+	if url := systemConfig().CrashReportURL; url != "" {
+		go sendCrashReport(url, data)
+	}
+}
+
+func sendCrashReport(url string, data []byte) {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Crash report error: %v", err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "proxy-server/"+Version)
+	client := &http.Client{Timeout: crashReportTime}
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("Crash report error: %v", err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		log.Printf("Crash report error: %s answered %s", url, response.Status)
+		return
+	}
+	log.Printf("Crash report sent to %s", url)
+}
+
+// Chỉ giữ maxCrashFiles bản dump mới nhất
+func pruneCrashFiles(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "crash-*"))
+	if err != nil || len(files) <= maxCrashFiles {
+		return
+	}
+	sort.Strings(files) // Tên bắt đầu bằng thời điểm nên thứ tự tên là thứ tự thời gian
+	for _, file := range files[:len(files)-maxCrashFiles] {
+		os.Remove(file)
+	}
+}
+
+// Thu output của các process đã chết từ lần chạy trước rồi chuyển output của runtime vào file của process này
+func startCrashOutput() error {
+	dir := systemConfig().CrashDir
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	collectFatalCrashes(dir)
+
+	file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("fatal-%d.log", os.Getpid())), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	report := newCrashReport("fatal")
+	fmt.Fprintf(file, "build: %s\npid: %d\nhost: %s\nstarted: %s\nconfig_fingerprint: %s\n%s\n",
+		*report.Build, report.PID, report.Host, report.Time.Format(time.RFC3339), report.ConfigFingerprint, crashOutputMarker)
+	// Runtime giữ bản sao của file descriptor nên có thể đóng file
+	defer file.Close()
+	if err := debug.SetCrashOutput(file, debug.CrashOptions{}); err != nil {
+		return err
+	}
+	debug.SetTraceback("all")
+	return nil
+}
+
+// File fatal-<pid>.log có nội dung sau dòng đánh dấu là process đó đã chết vì panic hoặc lỗi runtime.
+// File chỉ có phần đầu của process đã thoát bình thường bị xóa; file của process còn chạy (ví dụ process
+// cũ khi nâng cấp nóng) được giữ nguyên
+func collectFatalCrashes(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "fatal-*.log"))
+	for _, path := range files {
+		pid, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "fatal-"), ".log"))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		_, output, _ := strings.Cut(string(data), crashOutputMarker+"\n")
+		if strings.TrimSpace(output) == "" {
+			if !processAlive(pid) {
+				os.Remove(path)
+			}
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		header, _, _ := strings.Cut(string(data), crashOutputMarker)
+		report := newCrashReport("fatal")
+		report.Time, report.PID, report.Build, report.ConfigFingerprint = info.ModTime().UTC(), pid, nil, ""
+		for _, line := range strings.Split(header, "\n") {
+			if value, found := strings.CutPrefix(line, "config_fingerprint: "); found {
+				report.ConfigFingerprint = value
+			}
+		}
+		report.Goroutines = string(data)
+		target := filepath.Join(dir, fmt.Sprintf("crash-%s-%d.log", report.Time.Format("20060102T150405Z"), pid))
+		if err := os.Rename(path, target); err != nil {
+			log.Printf("Crash dump error: %v", err)
+			continue
+		}
+		log.Printf("Process %d crashed at %s, runtime output saved to %s", pid, report.Time.Format(time.RFC3339), target)
+		notifyOperators("Proxy process %d crashed at %s, see %s", pid, report.Time.Format(time.RFC3339), target)
+		if url := systemConfig().CrashReportURL; url != "" {
+			if data, err := json.Marshal(report); err == nil {
+				go sendCrashReport(url, data)
+			}
+		}
+	}
+	pruneCrashFiles(dir)
+}
+
+// Process còn chạy hay không (gửi signal 0)
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
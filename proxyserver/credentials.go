@@ -0,0 +1,54 @@
+package proxyserver
+
+import (
+	"log"
+	"time"
+
+	"proxy_server/proxyserver/auth"
+)
+
+// Chống đoán tài khoản bằng thời gian phản hồi: password được so sánh với thời gian không đổi (cả khi user
+// không tồn tại), và mọi lần xác thực thất bại được trả lời sau cùng một khoảng auth_failure_delay_ms tính từ
+// lúc nhận xong thông tin đăng nhập, bất kể lý do
+const defaultAuthFailureDelay = 100 // ms
+
+// Mỗi IP chỉ được sai token admin API admin_auth_max_failures lần trong một phút, sau đó bị từ chối tới hết
+// phút đó kể cả khi gửi đúng token, để việc dò token không tăng tốc được bằng nhiều request song song
+const defaultAdminAuthFailures = 10
+
+var adminFailures = auth.NewFailureLimiter(time.Minute)
+
+func authFailureDelay() time.Duration {
+	switch {
+	case systemConfig().AuthFailureDelay < 0:
+		return 0
+	case systemConfig().AuthFailureDelay == 0:
+		return defaultAuthFailureDelay * time.Millisecond
+	}
+	return time.Duration(systemConfig().AuthFailureDelay) * time.Millisecond
+}
+
+func adminAuthFailureLimit() int {
+	if systemConfig().AdminAuthFailures == 0 {
+		return defaultAdminAuthFailures
+	}
+	return systemConfig().AdminAuthFailures
+}
+
+// Thời gian IP còn bị từ chối trên admin API, 0 nếu được thử tiếp
+func adminAuthBlocked(ip string) time.Duration {
+	return adminFailures.Blocked(ip, adminAuthFailureLimit())
+}
+
+// Ghi nhận một lần sai token của IP
+func recordAdminAuthFailure(ip string) {
+	limit := adminAuthFailureLimit()
+	if adminFailures.Record(ip, limit) {
+		log.Printf("Admin API: %s blocked for %s after %d failed token checks", ip, adminFailures.Window(), limit)
+	}
+}
+
+// Chờ tới started + auth_failure_delay_ms trước khi trả lời xác thực thất bại; nếu đã quá thì trả lời ngay
+func waitAuthFailure(started time.Time) {
+	auth.WaitFailure(started, authFailureDelay())
+}
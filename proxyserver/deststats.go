@@ -0,0 +1,142 @@
+package proxyserver
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Số mẫu độ trễ gần nhất giữ lại cho mỗi đích
+const destLatencySamples = 256
+
+// Số đích tối đa được theo dõi, đích lâu không dùng nhất bị loại khi vượt quá
+const maxTrackedDestinations = 10000
+
+// Thống kê kết nối tới một đích
+type destinationStats struct {
+	attempts int64
+	failures int64
+	errors   map[string]int64 // Số lỗi theo loại (dial_refused, dial_timeout...)
+	samples  []time.Duration  // Vòng đệm độ trễ của các lần kết nối thành công
+	next     int
+	lastDial time.Time
+}
+
+// Thống kê trả về qua API
+type DestinationReport struct {
+	Host        string           `json:"host"`
+	Attempts    int64            `json:"attempts"`
+	Failures    int64            `json:"failures"`
+	FailureRate float64          `json:"failure_rate"`
+	Errors      map[string]int64 `json:"errors,omitempty"`
+	LatencyP50  float64          `json:"latency_p50_ms"`
+	LatencyP90  float64          `json:"latency_p90_ms"`
+	LatencyP99  float64          `json:"latency_p99_ms"`
+	LastDial    time.Time        `json:"last_dial"`
+}
+
+var (
+	destStats      = make(map[string]*destinationStats)
+	destStatsMutex sync.Mutex
+)
+
+// Ghi nhận kết quả một lần kết nối tới đích
+func recordDialStats(destAddr string, latency time.Duration, err error) {
+	host, _, splitErr := net.SplitHostPort(destAddr)
+	if splitErr != nil {
+		host = destAddr
+	}
+
+	destStatsMutex.Lock()
+	defer destStatsMutex.Unlock()
+
+	stats, exists := destStats[host]
+	if !exists {
+		if len(destStats) >= maxTrackedDestinations {
+			evictOldestDestination()
+		}
+		stats = &destinationStats{errors: make(map[string]int64)}
+		destStats[host] = stats
+	}
+
+	stats.attempts++
+	stats.lastDial = time.Now()
+	if err != nil {
+		stats.failures++
+		stats.errors[classifyDialError(err)]++
+		return
+	}
+	recordStatsdTiming("connect.latency", latency)
+
+	if len(stats.samples) < destLatencySamples {
+		stats.samples = append(stats.samples, latency)
+	} else {
+		stats.samples[stats.next] = latency
+		stats.next = (stats.next + 1) % destLatencySamples
+	}
+}
+
+func evictOldestDestination() {
+	var oldestHost string
+	var oldest time.Time
+	for host, stats := range destStats {
+		if oldestHost == "" || stats.lastDial.Before(oldest) {
+			oldestHost, oldest = host, stats.lastDial
+		}
+	}
+	delete(destStats, oldestHost)
+}
+
+func percentileMillis(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(p * float64(len(sorted)-1))
+	return float64(sorted[index].Microseconds()) / 1000
+}
+
+// Báo cáo thống kê theo đích, sắp xếp theo "failures", "failure_rate", "latency" hoặc "attempts"
+func destinationReports(sortBy string, limit int) []DestinationReport {
+	destStatsMutex.Lock()
+	reports := make([]DestinationReport, 0, len(destStats))
+	for host, stats := range destStats {
+		sorted := append([]time.Duration(nil), stats.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		errors := make(map[string]int64, len(stats.errors))
+		for kind, count := range stats.errors {
+			errors[kind] = count
+		}
+
+		reports = append(reports, DestinationReport{
+			Host:        host,
+			Attempts:    stats.attempts,
+			Failures:    stats.failures,
+			FailureRate: float64(stats.failures) / float64(stats.attempts),
+			Errors:      errors,
+			LatencyP50:  percentileMillis(sorted, 0.50),
+			LatencyP90:  percentileMillis(sorted, 0.90),
+			LatencyP99:  percentileMillis(sorted, 0.99),
+			LastDial:    stats.lastDial,
+		})
+	}
+	destStatsMutex.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		switch sortBy {
+		case "failure_rate":
+			return reports[i].FailureRate > reports[j].FailureRate
+		case "latency":
+			return reports[i].LatencyP90 > reports[j].LatencyP90
+		case "attempts":
+			return reports[i].Attempts > reports[j].Attempts
+		default:
+			return reports[i].Failures > reports[j].Failures
+		}
+	})
+	if limit > 0 && len(reports) > limit {
+		reports = reports[:limit]
+	}
+	return reports
+}
@@ -0,0 +1,189 @@
+package proxyserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Số lần thử kết nối mặc định (lần đầu và các lần thử lại qua egress/upstream khác)
+const defaultDialAttempts = 3
+
+// Không còn IP egress hoặc upstream nào chưa thử
+var errNoAlternative = errors.New("no untried egress left")
+
+// Dialer do chương trình nhúng cung cấp, ví dụ *net.Dialer gắn với interface VPN hoặc dialer giả khi test
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Dialer/resolver do Server cung cấp, nil = dùng đường kết nối và resolver mặc định
+var (
+	serverDialer     ContextDialer
+	serverResolver   *net.Resolver
+	userDialerFunc   func(username string) ContextDialer
+	userResolverFunc func(username string) *net.Resolver
+)
+
+// Dialer riêng cho user, nếu không có thì dialer chung của Server
+func dialerFor(user *User) ContextDialer {
+	if userDialerFunc != nil && user != nil {
+		if dialer := userDialerFunc(user.Username); dialer != nil {
+			return dialer
+		}
+	}
+	return serverDialer
+}
+
+// Resolver riêng cho user (của chương trình nhúng, rồi DNS server trong dns_overrides_file),
+// nếu không có thì resolver chung của Server
+func resolverFor(user *User) *net.Resolver {
+	if userResolverFunc != nil && user != nil {
+		if resolver := userResolverFunc(user.Username); resolver != nil {
+			return resolver
+		}
+	}
+	if resolver := overrideResolver(user); resolver != nil {
+		return resolver
+	}
+	return serverResolver
+}
+
+// Các lựa chọn đã thử trong một lần kết nối tới đích
+type dialTried struct {
+	egress    map[*egressAddr]bool
+	upstreams map[*upstreamProxy]bool
+	direct    map[string]bool // Network đã kết nối không qua IP egress nào
+}
+
+func newDialTried() *dialTried {
+	return &dialTried{
+		egress:    make(map[*egressAddr]bool),
+		upstreams: make(map[*upstreamProxy]bool),
+		direct:    make(map[string]bool),
+	}
+}
+
+// Kết nối tới địa chỉ đích và ghi nhận thống kê độ trễ/lỗi theo đích.
+// prefer quyết định họ địa chỉ thử trước khi đích là domain (rỗng = mặc định hệ thống),
+// user quyết định chiến lược chọn IP egress, egress (có thể rỗng) là IP egress ưu tiên,
+// country (có thể rỗng) giới hạn các IP egress được chọn theo quốc gia, egress_match theo tag của user.
+// Khi lỗi, thử lại qua IP egress/upstream khác trong giới hạn dial_attempts và ConnectionTimeout
+func dialTarget(destAddr, prefer string, user *User, egress, country string) (net.Conn, error) {
+	// Trang kiểm tra IP và DNS do proxy tự trả lời
+	if isIPCheckDest(destAddr) {
+		return dialIPCheck(prefer, user, egress, country), nil
+	}
+
+	// Bản ghi host tĩnh của user trong dns_overrides_file
+	destAddr, err := applyHostOverride(destAddr, user)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	policy := egressPolicyFor(user)
+	if egress != "" {
+		policy += "@" + egress
+	}
+	policy = joinEgressFilters(policy, egressFilters(user, country))
+	var dialer net.Dialer
+	timeout := systemConfig().ConnectionTimeout
+	if systemConfig().DialTCP.Timeout > 0 {
+		timeout = systemConfig().DialTCP.Timeout
+	}
+	if timeout > 0 {
+		dialer.Deadline = started.Add(time.Duration(timeout) * time.Second)
+	}
+
+	// Dialer của chương trình nhúng thay cho upstream, NAT64 và pool egress
+	if custom := dialerFor(user); custom != nil {
+		conn, err := dialCustom(custom, destAddr, dialer.Deadline)
+		recordDialStats(destAddr, time.Since(started), err)
+		return conn, err
+	}
+	dialer.Resolver = resolverFor(user)
+
+	attempts := systemConfig().DialAttempts
+	if attempts <= 0 {
+		attempts = defaultDialAttempts
+	}
+
+	tried := newDialTried()
+	var conn net.Conn
+	for attempt := 0; attempt < attempts; attempt++ {
+		// Chia đều thời gian còn lại cho các lần thử còn lại
+		attemptDialer := dialer
+		if !dialer.Deadline.IsZero() {
+			remaining := time.Until(dialer.Deadline)
+			if remaining <= 0 {
+				break
+			}
+			attemptDialer.Deadline = time.Now().Add(remaining / time.Duration(attempts-attempt))
+		}
+
+		attemptConn, attemptErr := dialOnce(attemptDialer, destAddr, prefer, policy, tried)
+		if errors.Is(attemptErr, errNoAlternative) {
+			break
+		}
+		conn, err = attemptConn, attemptErr
+		if err == nil || classifyDialError(err) == CloseDialDNS {
+			break
+		}
+	}
+	recordDialStats(destAddr, time.Since(started), err)
+	return conn, err
+}
+
+// Một lần kết nối qua upstream, NAT64 hoặc trực tiếp qua IP egress
+func dialOnce(dialer net.Dialer, destAddr, prefer, policy string, tried *dialTried) (net.Conn, error) {
+	// Chuyển tiếp qua pool proxy upstream
+	if upstreamsEnabled() {
+		return dialViaUpstream(dialer, destAddr, policy, tried)
+	}
+
+	// Máy chủ chỉ có IPv6: đích IPv4 được chuyển qua NAT64
+	if systemConfig().NAT64Prefix != "" {
+		return dialNAT64(&dialer, destAddr, policy, tried)
+	}
+
+	networks := []string{"tcp"}
+	if host, _, err := net.SplitHostPort(destAddr); err == nil && hostIP(host) == nil {
+		switch prefer {
+		case DNSPreferIPv4:
+			networks = []string{"tcp4", "tcp6"}
+		case DNSPreferIPv6:
+			networks = []string{"tcp6", "tcp4"}
+		}
+	}
+
+	err := errNoAlternative
+	for _, network := range networks {
+		conn, networkErr := dialFromEgress(dialer, network, destAddr, policy, tried)
+		if networkErr == nil {
+			return conn, nil
+		}
+		// Họ địa chỉ không còn lựa chọn nào thì giữ lỗi của họ đã thử
+		if !errors.Is(networkErr, errNoAlternative) {
+			err = networkErr
+		}
+	}
+	return nil, err
+}
+
+// Kết nối qua dialer của chương trình nhúng, không thử lại vì không có lựa chọn thay thế
+func dialCustom(dialer ContextDialer, destAddr string, deadline time.Time) (net.Conn, error) {
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", destAddr)
+	if err != nil {
+		return nil, err
+	}
+	tuneTCPConn(conn, systemConfig().DialTCP)
+	return conn, nil
+}
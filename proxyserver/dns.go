@@ -0,0 +1,83 @@
+package proxyserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Chế độ xử lý đích dạng domain của SOCKS5
+const (
+	DNSModeRemote = "remote" // Proxy tự phân giải domain
+	DNSModeReject = "reject" // Từ chối domain, client phải tự phân giải
+)
+
+// Họ địa chỉ ưu tiên khi phân giải domain
+const (
+	DNSPreferBoth = "both" // Theo mặc định của hệ thống (A và AAAA)
+	DNSPreferIPv4 = "ipv4" // Thử bản ghi A trước, sau đó AAAA
+	DNSPreferIPv6 = "ipv6" // Thử bản ghi AAAA trước, sau đó A
+)
+
+// Cách phân giải domain của một listener
+type DNSOptions struct {
+	Mode   string
+	Prefer string
+}
+
+// Cấu hình DNS riêng cho một listener: socks_dns=listen,mode,prefer
+type ListenerDNSConfig struct {
+	Listen string
+	DNSOptions
+}
+
+func validateDNSOptions(options DNSOptions) error {
+	switch options.Mode {
+	case DNSModeRemote, DNSModeReject:
+	default:
+		return fmt.Errorf("unknown DNS mode %q", options.Mode)
+	}
+	switch options.Prefer {
+	case DNSPreferBoth, DNSPreferIPv4, DNSPreferIPv6:
+	default:
+		return fmt.Errorf("unknown DNS preference %q", options.Prefer)
+	}
+	return nil
+}
+
+func parseListenerDNS(value string) (ListenerDNSConfig, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return ListenerDNSConfig{}, fmt.Errorf("expected listen,mode,prefer")
+	}
+
+	listenerDNS := ListenerDNSConfig{
+		Listen: canonicalListenAddr(strings.TrimSpace(parts[0])),
+		DNSOptions: DNSOptions{
+			Mode:   strings.TrimSpace(parts[1]),
+			Prefer: strings.TrimSpace(parts[2]),
+		},
+	}
+	return listenerDNS, validateDNSOptions(listenerDNS.DNSOptions)
+}
+
+// Cách phân giải domain cho listener tại addr: cấu hình riêng nếu có, ngược lại dùng dns_mode/dns_prefer
+func dnsOptionsFor(addr string) DNSOptions {
+	for _, listenerDNS := range systemConfig().ListenerDNS {
+		if listenerDNS.Listen == addr {
+			return listenerDNS.DNSOptions
+		}
+	}
+
+	return DNSOptions{Mode: systemConfig().DNSMode, Prefer: systemConfig().DNSPrefer}.withDefaults()
+}
+
+// Điền giá trị mặc định (remote, both) cho các trường chưa cấu hình
+func (o DNSOptions) withDefaults() DNSOptions {
+	if o.Mode == "" {
+		o.Mode = DNSModeRemote
+	}
+	if o.Prefer == "" {
+		o.Prefer = DNSPreferBoth
+	}
+	return o
+}
@@ -0,0 +1,158 @@
+package proxyserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DNS riêng theo user hoặc nhóm (gói): DNS server và bản ghi host tĩnh. Phạm vi là
+// "user:<tên>", "group:<nhóm>" hoặc "*" (mọi user); phạm vi user ưu tiên hơn nhóm, nhóm hơn "*"
+type dnsOverrides struct {
+	servers map[string]string            // Phạm vi -> địa chỉ DNS server host:port
+	hosts   map[string]map[string]net.IP // Phạm vi -> host -> IP
+}
+
+var (
+	dnsOverrideRules atomic.Pointer[dnsOverrides]
+	dnsResolvers     sync.Map // Địa chỉ DNS server -> *net.Resolver
+)
+
+// Đọc file dns_overrides_file, mỗi dòng "<phạm vi> server <ip>[:port]" hoặc "<phạm vi> host <domain> <ip>";
+// dòng trống và dòng bắt đầu bằng # được bỏ qua
+func parseDNSOverrides(r io.Reader) (*dnsOverrides, error) {
+	overrides := &dnsOverrides{servers: make(map[string]string), hosts: make(map[string]map[string]net.IP)}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		scope := fields[0]
+		if scope != "*" && !strings.HasPrefix(scope, "user:") && !strings.HasPrefix(scope, "group:") {
+			return nil, fmt.Errorf("line %d: scope must be user:<name>, group:<name> or *", lineNumber)
+		}
+		switch {
+		case len(fields) == 3 && fields[1] == "server":
+			server := fields[2]
+			if net.ParseIP(server) != nil {
+				server = net.JoinHostPort(server, "53")
+			} else if host, port, err := net.SplitHostPort(server); err != nil || net.ParseIP(host) == nil || !validPort(port) {
+				return nil, fmt.Errorf("line %d: invalid DNS server %q", lineNumber, fields[2])
+			}
+			overrides.servers[scope] = server
+		case len(fields) == 4 && fields[1] == "host":
+			ip := net.ParseIP(fields[3])
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid IP %q", lineNumber, fields[3])
+			}
+			if overrides.hosts[scope] == nil {
+				overrides.hosts[scope] = make(map[string]net.IP)
+			}
+			overrides.hosts[scope][strings.TrimSuffix(strings.ToLower(fields[2]), ".")] = ip
+		default:
+			return nil, fmt.Errorf("line %d: expected \"<scope> server <ip>[:port]\" or \"<scope> host <domain> <ip>\"", lineNumber)
+		}
+	}
+	return overrides, scanner.Err()
+}
+
+// Nạp lại dns_overrides_file; file lỗi thì giữ cấu hình đang dùng
+func reloadDNSOverrides() error {
+	overrides, err := loadDNSOverrides(systemConfig().DNSOverridesFile)
+	if err != nil {
+		return err
+	}
+	dnsOverrideRules.Store(overrides)
+	return nil
+}
+
+// Đọc dns_overrides_file, nil khi path rỗng
+func loadDNSOverrides(path string) (*dnsOverrides, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	overrides, err := parseDNSOverrides(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return overrides, nil
+}
+
+// Các phạm vi áp dụng cho user, ưu tiên cao nhất trước
+func dnsScopes(user *User) []string {
+	if user == nil {
+		return []string{"*"}
+	}
+	scopes := []string{"user:" + user.Username}
+	if user.Group != "" {
+		scopes = append(scopes, "group:"+user.Group)
+	}
+	return append(scopes, "*")
+}
+
+// Resolver dùng DNS server riêng của user, nil nếu không có cấu hình
+func overrideResolver(user *User) *net.Resolver {
+	overrides := dnsOverrideRules.Load()
+	if overrides == nil {
+		return nil
+	}
+	for _, scope := range dnsScopes(user) {
+		server, exists := overrides.servers[scope]
+		if !exists {
+			continue
+		}
+		if resolver, cached := dnsResolvers.Load(server); cached {
+			return resolver.(*net.Resolver)
+		}
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+		dnsResolvers.Store(server, resolver)
+		return resolver
+	}
+	return nil
+}
+
+// Thay domain đích bằng IP tĩnh của user nếu có. IP 0.0.0.0 hoặc :: chặn domain (ví dụ chặn quảng cáo)
+// và trả về lỗi DNS, không kết nối tới chính máy chủ
+func applyHostOverride(destAddr string, user *User) (string, error) {
+	overrides := dnsOverrideRules.Load()
+	if overrides == nil {
+		return destAddr, nil
+	}
+	host, port, err := net.SplitHostPort(destAddr)
+	if err != nil || hostIP(host) != nil {
+		return destAddr, nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, scope := range dnsScopes(user) {
+		ip, exists := overrides.hosts[scope][name]
+		if !exists {
+			continue
+		}
+		if ip.IsUnspecified() {
+			return "", &net.DNSError{Err: "blocked by DNS override", Name: host, IsNotFound: true}
+		}
+		return net.JoinHostPort(ip.String(), port), nil
+	}
+	return destAddr, nil
+}
@@ -0,0 +1,427 @@
+package proxyserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"proxy_server/proxyserver/socks4"
+	"proxy_server/proxyserver/socks5"
+)
+
+// Kiểm tra end-to-end: proxy chạy trong process với handler thật, client là socksclient.go
+
+// Kích thước dữ liệu echo trong mỗi kịch bản
+const e2ePayloadSize = 4096
+
+// Proxy riêng cho mỗi test (kịch bản đóng listener không ảnh hưởng test khác) và echo server
+func startE2E(t *testing.T) (net.Listener, *net.TCPAddr) {
+	t.Helper()
+	echo, err := startEchoServer(e2ePayloadSize)
+	if err != nil {
+		t.Fatalf("start echo server: %v", err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	proxy, err := startLocalProxy()
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy, echo.Addr().(*net.TCPAddr)
+}
+
+// Thêm user vào proxy đang chạy, mặc định không giới hạn như user bench
+func addE2EUser(user *User) *User {
+	if user.ConnectionLimit == 0 {
+		user.ConnectionLimit = math.MaxInt32
+	}
+	if user.MaxBandwidth == 0 {
+		user.MaxBandwidth = math.MaxInt64
+	}
+	user.Password = user.Username
+	user.EndDate = time.Now().AddDate(1, 0, 0)
+	user.state = new(userState)
+	usersMutex.Lock()
+	users[user.Username] = user
+	usersMutex.Unlock()
+	return user
+}
+
+// Kết quả của các tunnel theo username, nhận qua một hook OnClose đăng ký một lần: handler của test trước
+// có thể còn đang chạy hook khi test sau bắt đầu
+var (
+	closeWatchers      = make(map[string]chan CloseInfo)
+	closeWatchersMutex sync.Mutex
+)
+
+func init() {
+	connHooks = []Hooks{{OnClose: func(info *ConnInfo, result CloseInfo) {
+		closeWatchersMutex.Lock()
+		defer closeWatchersMutex.Unlock()
+		if closes, exists := closeWatchers[info.Username]; exists {
+			closes <- result
+		}
+	}}}
+}
+
+func watchCloses(t *testing.T, username string) <-chan CloseInfo {
+	closes := make(chan CloseInfo, 16)
+	closeWatchersMutex.Lock()
+	closeWatchers[username] = closes
+	closeWatchersMutex.Unlock()
+	t.Cleanup(func() {
+		closeWatchersMutex.Lock()
+		delete(closeWatchers, username)
+		closeWatchersMutex.Unlock()
+	})
+	return closes
+}
+
+func waitClose(t *testing.T, closes <-chan CloseInfo) CloseInfo {
+	t.Helper()
+	select {
+	case result := <-closes:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel did not finish")
+		return CloseInfo{}
+	}
+}
+
+func dialE2E(t *testing.T, proxy net.Listener) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxy.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Mở tunnel SOCKS5 tới target, dừng test nếu không thành công
+func connectE2E(t *testing.T, proxy net.Listener, username string, target *net.TCPAddr) net.Conn {
+	t.Helper()
+	conn := dialE2E(t, proxy)
+	if err := socks5.Connect(conn, username, username, target); err != nil {
+		t.Fatalf("connect as %s: %v", username, err)
+	}
+	return conn
+}
+
+// Gửi payload qua tunnel và kiểm tra nhận lại đúng dữ liệu
+func checkEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	payload := make([]byte, e2ePayloadSize)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(payload, received) {
+		t.Fatal("relayed data does not match")
+	}
+}
+
+// Đích riêng cho một test: mỗi kết nối nhận vào được xử lý bằng handle rồi đóng
+func startE2ETarget(t *testing.T, handle func(conn net.Conn)) *net.TCPAddr {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestSocks5Relay(t *testing.T) {
+	proxy, echo := startE2E(t)
+	checkEcho(t, connectE2E(t, proxy, "bench", echo))
+}
+
+func TestSocks5WrongPassword(t *testing.T) {
+	proxy, echo := startE2E(t)
+	err := socks5.Connect(dialE2E(t, proxy), "bench", "wrong", echo)
+	if !errors.Is(err, socks5.ErrAuthRejected) {
+		t.Fatalf("expected authentication to be rejected, got %v", err)
+	}
+}
+
+func TestSocks5UnsupportedCommand(t *testing.T) {
+	proxy, _ := startE2E(t)
+	conn := dialE2E(t, proxy)
+
+	// BIND (0x02) thay vì CONNECT
+	request := []byte{0x05, 0x01, 0x02, 0x01, 0x05, 'b', 'e', 'n', 'c', 'h', 0x05, 'b', 'e', 'n', 'c', 'h'}
+	request = append(request, 0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, 0, 80)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[5] != socks5.CommandNotSupported {
+		t.Fatalf("expected reply 0x%02x, got 0x%02x", socks5.CommandNotSupported, reply[5])
+	}
+}
+
+func TestSocks5ConnectionRefused(t *testing.T) {
+	proxy, _ := startE2E(t)
+	// Lấy một cổng vừa được giải phóng để chắc chắn không có ai lắng nghe
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := closed.Addr().(*net.TCPAddr)
+	closed.Close()
+
+	err = socks5.Connect(dialE2E(t, proxy), "bench", "bench", target)
+	var replyErr *socks5.ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != socks5.ConnectionRefused {
+		t.Fatalf("expected reply 0x%02x, got %v", socks5.ConnectionRefused, err)
+	}
+}
+
+func TestSocks4Relay(t *testing.T) {
+	proxy, echo := startE2E(t)
+	conn := dialE2E(t, proxy)
+	if err := socks4.Connect(conn, "bench", echo); err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+}
+
+// Đóng listener (như khi dừng server) không được cắt các tunnel đang mở
+func TestListenerCloseKeepsTunnels(t *testing.T) {
+	proxy, echo := startE2E(t)
+	conn := connectE2E(t, proxy, "bench", echo)
+
+	proxy.Close()
+	if late, err := net.DialTimeout("tcp", proxy.Addr().String(), time.Second); err == nil {
+		late.Close()
+		t.Fatal("listener still accepts connections after close")
+	}
+	checkEcho(t, conn)
+}
+
+// Client gửi yêu cầu rồi đóng chiều gửi; đích chỉ trả lời sau khi nhận EOF
+func TestClientHalfCloseGetsResponse(t *testing.T) {
+	proxy, _ := startE2E(t)
+	target := startE2ETarget(t, func(conn net.Conn) {
+		request, err := io.ReadAll(conn)
+		if err == nil {
+			conn.Write(request)
+		}
+	})
+
+	conn := connectE2E(t, proxy, "bench", target)
+	request := []byte("request until EOF")
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("no response after half-close: %v", err)
+	}
+	if !bytes.Equal(response, request) {
+		t.Fatalf("expected %q, got %q", request, response)
+	}
+}
+
+// Đích gửi dữ liệu rồi đóng: client nhận đủ dữ liệu và EOF
+func TestTargetCloseEndsTunnel(t *testing.T) {
+	proxy, _ := startE2E(t)
+	target := startE2ETarget(t, func(conn net.Conn) {
+		conn.Write([]byte("bye"))
+	})
+
+	conn := connectE2E(t, proxy, "bench", target)
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("tunnel not closed after target close: %v", err)
+	}
+	if string(data) != "bye" {
+		t.Fatalf("expected %q, got %q", "bye", data)
+	}
+}
+
+// Client đóng kết nối: đích nhận EOF thay vì chờ mãi
+func TestClientCloseReachesTarget(t *testing.T) {
+	proxy, _ := startE2E(t)
+	closed := make(chan error, 1)
+	target := startE2ETarget(t, func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err := io.ReadAll(conn)
+		closed <- err
+	})
+
+	connectE2E(t, proxy, "bench", target).Close()
+	if err := <-closed; err != nil {
+		t.Fatalf("target did not see the client close: %v", err)
+	}
+}
+
+// Tải về nhiều hơn max_data trong một tunnel: tunnel bị cắt đúng tại max_data với lý do quota_exceeded,
+// dữ liệu đã dùng được ghi nhận và lần đăng nhập sau bị từ chối
+func TestQuotaExhaustedMidTransfer(t *testing.T) {
+	const maxData = 64 << 10
+	proxy, _ := startE2E(t)
+	closes := watchCloses(t, "quota")
+	user := addE2EUser(&User{Username: "quota", MaxData: maxData})
+	target := startE2ETarget(t, func(conn net.Conn) {
+		conn.Write(make([]byte, 1<<20))
+	})
+
+	conn := connectE2E(t, proxy, "quota", target)
+	received, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if received != maxData {
+		t.Fatalf("received %d bytes, want exactly max_data %d", received, maxData)
+	}
+	result := waitClose(t, closes)
+	if result.Reason != CloseQuotaExceeded || !errors.Is(result.Err, ErrQuotaExceeded) {
+		t.Fatalf("close reason %q (%v), want %q", result.Reason, result.Err, CloseQuotaExceeded)
+	}
+	if usage := user.dataUsage(); usage != maxData {
+		t.Fatalf("data usage %d, want %d", usage, maxData)
+	}
+	if remaining, _ := user.quotaRemaining(); remaining != 0 {
+		t.Fatalf("%d bytes still counted as in flight", maxData-remaining-user.dataUsage())
+	}
+
+	err = socks5.Connect(dialE2E(t, proxy), "quota", "quota", target)
+	if !errors.Is(err, socks5.ErrAuthRejected) {
+		t.Fatalf("expected login over quota to be rejected, got %v", err)
+	}
+}
+
+// Quota được chia giữa các tunnel đang mở của cùng user: tunnel thứ hai chỉ nhận phần còn lại
+func TestQuotaSharedBetweenTunnels(t *testing.T) {
+	const maxData = 64 << 10
+	proxy, _ := startE2E(t)
+	closes := watchCloses(t, "shared")
+	user := addE2EUser(&User{Username: "shared", MaxData: maxData})
+
+	// Tunnel đầu dùng một phần quota và vẫn mở
+	echo := startE2ETarget(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	first := connectE2E(t, proxy, "shared", echo)
+	checkEcho(t, first)
+
+	target := startE2ETarget(t, func(conn net.Conn) {
+		conn.Write(make([]byte, 1<<20))
+	})
+	second := connectE2E(t, proxy, "shared", target)
+	received, err := io.Copy(io.Discard, second)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := int64(maxData - 2*e2ePayloadSize); received != want {
+		t.Fatalf("second tunnel received %d bytes, want %d", received, want)
+	}
+	if result := waitClose(t, closes); result.Reason != CloseQuotaExceeded {
+		t.Fatalf("close reason %q, want %q", result.Reason, CloseQuotaExceeded)
+	}
+
+	// Tunnel đầu hết quota ở lần ghi tiếp theo
+	first.Write([]byte("more"))
+	if _, err := io.Copy(io.Discard, first); err != nil {
+		t.Fatalf("first tunnel: %v", err)
+	}
+	waitClose(t, closes)
+	if usage := user.dataUsage(); usage != maxData {
+		t.Fatalf("data usage %d, want %d", usage, maxData)
+	}
+}
+
+// connection_limit: tunnel vượt giới hạn bị từ chối khi xác thực, kể cả khi nhiều client đăng nhập cùng lúc;
+// tunnel kết thúc trả lại chỗ
+func TestConnectionLimitEnforced(t *testing.T) {
+	const limit = 2
+	proxy, _ := startE2E(t)
+	closes := watchCloses(t, "limited")
+	user := addE2EUser(&User{Username: "limited", ConnectionLimit: limit})
+	target := startE2ETarget(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		opened   []net.Conn
+		rejected int
+	)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", proxy.Addr().String(), 5*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			err = socks5.Connect(conn, "limited", "limited", target)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				opened = append(opened, conn)
+			case errors.Is(err, socks5.ErrAuthRejected):
+				rejected++
+				conn.Close()
+			default:
+				t.Errorf("connect: %v", err)
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(opened) != limit || rejected != 8-limit {
+		t.Fatalf("%d tunnels opened and %d rejected, want %d and %d", len(opened), rejected, limit, 8-limit)
+	}
+	if conns := user.activeConns(); conns != limit {
+		t.Fatalf("%d active connections, want %d", conns, limit)
+	}
+
+	// Đóng một tunnel thì đăng nhập được lại
+	opened[0].Close()
+	waitClose(t, closes)
+	deadline := time.Now().Add(5 * time.Second)
+	for user.activeConns() >= limit && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	connectE2E(t, proxy, "limited", target)
+	opened[1].Close()
+}
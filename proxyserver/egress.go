@@ -0,0 +1,585 @@
+package proxyserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// URL mặc định trả về IP public của người gọi dưới dạng text
+const defaultEgressCheckURL = "https://api.ipify.org"
+
+// Chu kỳ kiểm tra mặc định (giây)
+const defaultEgressCheckInterval = 60
+
+// Các chiến lược chọn IP egress
+const (
+	EgressPolicyRoundRobin = "round_robin"
+	EgressPolicyLeastConn  = "least_conn"
+	EgressPolicyWeighted   = "weighted"
+	EgressPolicyLatency    = "latency"
+	EgressPolicyHash       = "hash"
+)
+
+// Trạng thái của một IP egress
+type egressAddr struct {
+	ip        net.IP
+	weight    int
+	country   string // Mã quốc gia viết thường, chọn được qua tham số country trong username
+	tags      map[string]string
+	healthy   bool
+	publicIP  string
+	lastCheck time.Time
+	lastError string
+
+	active        int           // Số kết nối đang mở qua IP này
+	currentWeight int           // Trọng số hiện tại cho smooth weighted round-robin
+	latency       time.Duration // Độ trễ kết nối trung bình (EWMA)
+}
+
+// Trạng thái trả về qua API
+type EgressStatus struct {
+	IP           string            `json:"ip"`
+	Weight       int               `json:"weight"`
+	Country      string            `json:"country,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Healthy      bool              `json:"healthy"`
+	PublicIP     string            `json:"public_ip,omitempty"`
+	LastCheck    time.Time         `json:"last_check,omitempty"`
+	LastError    string            `json:"last_error,omitempty"`
+	Active       int               `json:"active_connections"`
+	LatencyAvgMs float64           `json:"latency_avg_ms"`
+}
+
+// Cảnh báo khi một IP egress đổi trạng thái
+type EgressAlert struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"egress_ip"`
+	Event    string    `json:"event"` // down hoặc up
+	PublicIP string    `json:"public_ip,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var (
+	egressPool      []*egressAddr
+	egressNext      int
+	egressPoolMutex sync.Mutex
+)
+
+// Một giá trị egress_ip
+type egressSpec struct {
+	ip      net.IP
+	weight  int
+	country string
+	tags    map[string]string
+}
+
+// Khóa bộ lọc egress chọn theo quốc gia của IP thay vì theo tag
+const egressCountryKey = "country"
+
+// Đọc giá trị egress_ip: ip[,weight[,country[,key=value...]]]
+func parseEgressIP(value string) (egressSpec, error) {
+	parts := strings.Split(value, ",")
+	spec := egressSpec{ip: net.ParseIP(strings.TrimSpace(parts[0])), weight: 1}
+	if spec.ip == nil {
+		return spec, fmt.Errorf("invalid IP address %q", parts[0])
+	}
+	if len(parts) >= 2 && strings.TrimSpace(parts[1]) != "" {
+		parsed, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || parsed < 1 {
+			return spec, fmt.Errorf("invalid weight %q", parts[1])
+		}
+		spec.weight = parsed
+	}
+	if len(parts) >= 3 && strings.TrimSpace(parts[2]) != "" {
+		spec.country = strings.ToLower(strings.TrimSpace(parts[2]))
+		if !validCountryCode(spec.country) {
+			return spec, fmt.Errorf("invalid country code %q", parts[2])
+		}
+	}
+	if len(parts) > 3 {
+		var pairs []string
+		for _, part := range parts[3:] {
+			pairs = append(pairs, strings.TrimSpace(part))
+		}
+		tags, err := parseTags(strings.Join(pairs, ";"))
+		if err != nil {
+			return spec, fmt.Errorf("invalid tags: %v", err)
+		}
+		if _, exists := tags[egressCountryKey]; exists {
+			return spec, errors.New("use the country field instead of a country tag")
+		}
+		spec.tags = tags
+	}
+	return spec, nil
+}
+
+// IP có khớp mọi bộ lọc không; khóa country so với quốc gia của IP, các khóa khác so với tag
+func (egress *egressAddr) matches(filters map[string]string) bool {
+	for key, value := range filters {
+		if key == egressCountryKey {
+			if egress.country != value {
+				return false
+			}
+		} else if egress.tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Tách bộ lọc khỏi policy dạng chiến_lược[@ip][#key=value;...]
+func splitEgressFilters(policy string) (string, map[string]string) {
+	policy, filters, found := strings.Cut(policy, "#")
+	if !found {
+		return policy, nil
+	}
+	parsed, _ := parseTags(filters)
+	return policy, parsed
+}
+
+// Thêm bộ lọc vào policy; không có bộ lọc thì giữ nguyên
+func joinEgressFilters(policy string, filters map[string]string) string {
+	if len(filters) == 0 {
+		return policy
+	}
+	return policy + "#" + formatTags(filters)
+}
+
+// Mã quốc gia hai chữ cái (ISO 3166-1 alpha-2), đã viết thường
+func validCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'a' && code[0] <= 'z' && code[1] >= 'a' && code[1] <= 'z'
+}
+
+// Đọc giá trị socks_country: listen,country
+func parseListenerCountry(value string) (string, string, error) {
+	listen, country, found := strings.Cut(value, ",")
+	if !found {
+		return "", "", fmt.Errorf("expected listen,country, got %q", value)
+	}
+	listen, country = strings.TrimSpace(listen), strings.ToLower(strings.TrimSpace(country))
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q", listen)
+	}
+	if !validCountryCode(country) {
+		return "", "", fmt.Errorf("invalid country code %q", country)
+	}
+	return canonicalListenAddr(listen), country, nil
+}
+
+// Có lựa chọn nào cho quốc gia này không: upstream khi dùng pool upstream, ngược lại IP egress
+func countryAvailable(country string) bool {
+	if upstreamsEnabled() {
+		return upstreamCountryExists(country)
+	}
+	return egressCountryExists(country)
+}
+
+// Có IP egress nào trong pool được gắn quốc gia này không
+func egressCountryExists(country string) bool {
+	egressPoolMutex.Lock()
+	defer egressPoolMutex.Unlock()
+	for _, egress := range egressPool {
+		if egress.country == country {
+			return true
+		}
+	}
+	return false
+}
+
+// Kiểm tra tên chiến lược chọn IP egress
+func validEgressPolicy(policy string) bool {
+	switch policy {
+	case EgressPolicyRoundRobin, EgressPolicyLeastConn, EgressPolicyWeighted, EgressPolicyLatency, EgressPolicyHash:
+		return true
+	}
+	return false
+}
+
+// Đọc giá trị egress_group_policy: group,policy
+func parseEgressGroupPolicy(value string) (string, string, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected group,policy, got %q", value)
+	}
+	group, policy := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if !validEgressPolicy(policy) {
+		return "", "", fmt.Errorf("unknown policy %q", policy)
+	}
+	return group, policy, nil
+}
+
+// Chiến lược áp dụng cho user: theo nhóm nếu có cấu hình, nếu không thì dùng egress_policy
+func egressPolicyFor(user *User) string {
+	if user != nil && user.Group != "" {
+		if policy, exists := systemConfig().EgressGroupPolicies[user.Group]; exists {
+			return policy
+		}
+	}
+	if systemConfig().EgressPolicy != "" {
+		return systemConfig().EgressPolicy
+	}
+	return EgressPolicyRoundRobin
+}
+
+// Cập nhật pool theo cấu hình, giữ lại trạng thái của các IP vẫn còn trong danh sách.
+// IP mới được coi là khỏe cho tới lần kiểm tra đầu tiên
+func syncEgressPool(ips []string) {
+	egressPoolMutex.Lock()
+	defer egressPoolMutex.Unlock()
+
+	existing := make(map[string]*egressAddr, len(egressPool))
+	for _, egress := range egressPool {
+		existing[egress.ip.String()] = egress
+	}
+
+	pool := make([]*egressAddr, 0, len(ips))
+	for _, value := range ips {
+		spec, err := parseEgressIP(value)
+		if err != nil {
+			continue
+		}
+		if egress, exists := existing[spec.ip.String()]; exists {
+			egress.weight, egress.country, egress.tags = spec.weight, spec.country, spec.tags
+			pool = append(pool, egress)
+			continue
+		}
+		pool = append(pool, &egressAddr{ip: spec.ip, weight: spec.weight, country: spec.country, tags: spec.tags, healthy: true})
+	}
+	egressPool = pool
+	egressNext = 0
+}
+
+// Chọn IP egress khỏe phù hợp với network và đích theo chiến lược policy, bỏ qua các IP trong exclude.
+// Trả về network cụ thể (tcp4/tcp6) theo họ địa chỉ của IP được chọn
+func pickEgress(network, destAddr, policy string, exclude map[*egressAddr]bool) (*egressAddr, string) {
+	// policy dạng chiến_lược[@ip][#key=value;...]: chỉ chọn trong các IP khớp bộ lọc
+	policy, filters := splitEgressFilters(policy)
+	host, _, err := net.SplitHostPort(destAddr)
+	if err != nil {
+		host = destAddr
+	}
+	wantV4, wantV6 := network == "tcp4", network == "tcp6"
+	if ip, ok := parseHostIP(host); ok && network == "tcp" {
+		wantV4, wantV6 = ip.Is4(), ip.Is6()
+	}
+
+	egressPoolMutex.Lock()
+	defer egressPoolMutex.Unlock()
+
+	// Các IP khỏe theo thứ tự round-robin, bắt đầu từ vị trí kế tiếp
+	var candidates []*egressAddr
+	var positions []int
+	for i := 0; i < len(egressPool); i++ {
+		position := (egressNext + i) % len(egressPool)
+		egress := egressPool[position]
+		isV4 := egress.ip.To4() != nil
+		if !egress.healthy || exclude[egress] || (wantV4 && !isV4) || (wantV6 && isV4) || !egress.matches(filters) {
+			continue
+		}
+		candidates = append(candidates, egress)
+		positions = append(positions, position)
+	}
+	if len(candidates) == 0 {
+		return nil, network
+	}
+
+	// policy dạng chiến_lược@ip: dùng IP chỉ định nếu nó còn khỏe, ngược lại chọn theo chiến lược
+	policy, pinned, _ := strings.Cut(policy, "@")
+	chosen := -1
+	for i, egress := range candidates {
+		if pinned != "" && egress.ip.String() == pinned {
+			chosen = i
+		}
+	}
+	if chosen < 0 {
+		chosen = pickEgressCandidate(candidates, policy, host)
+	}
+	egressNext = (positions[chosen] + 1) % len(egressPool)
+
+	egress := candidates[chosen]
+	egress.active++
+	if egress.ip.To4() != nil {
+		return egress, "tcp4"
+	}
+	return egress, "tcp6"
+}
+
+// Chọn một IP trong các IP khỏe theo chiến lược, trả về vị trí trong candidates
+func pickEgressCandidate(candidates []*egressAddr, policy, host string) int {
+	chosen := 0
+	switch policy {
+	case EgressPolicyLeastConn:
+		for i, egress := range candidates {
+			if egress.active < candidates[chosen].active {
+				chosen = i
+			}
+		}
+	case EgressPolicyWeighted:
+		// Smooth weighted round-robin: phân bố đều theo trọng số, không dồn cục
+		total := 0
+		for i, egress := range candidates {
+			egress.currentWeight += egress.weight
+			total += egress.weight
+			if egress.currentWeight > candidates[chosen].currentWeight {
+				chosen = i
+			}
+		}
+		candidates[chosen].currentWeight -= total
+	case EgressPolicyLatency:
+		// IP chưa có số đo (latency 0) được thử trước
+		for i, egress := range candidates {
+			if egress.latency < candidates[chosen].latency {
+				chosen = i
+			}
+		}
+	case EgressPolicyHash:
+		// Rendezvous hashing: cùng đích luôn ra cùng IP, chỉ đổi khi IP đó bị loại
+		var best uint64
+		for i, egress := range candidates {
+			hash := fnv.New64a()
+			hash.Write([]byte(egress.ip.String() + "|" + host))
+			if score := hash.Sum64(); i == 0 || score > best {
+				chosen, best = i, score
+			}
+		}
+	}
+	return chosen
+}
+
+// Giải phóng một kết nối đã chọn IP egress
+func (egress *egressAddr) release() {
+	egressPoolMutex.Lock()
+	egress.active--
+	egressPoolMutex.Unlock()
+}
+
+// Cập nhật độ trễ kết nối trung bình của IP egress
+func (egress *egressAddr) recordLatency(latency time.Duration) {
+	egressPoolMutex.Lock()
+	if egress.latency == 0 {
+		egress.latency = latency
+	} else {
+		egress.latency = (egress.latency*4 + latency) / 5
+	}
+	egressPoolMutex.Unlock()
+}
+
+// Kết nối ra ngoài qua một IP egress, giải phóng IP khi kết nối đóng
+type egressConn struct {
+	net.Conn
+	egress *egressAddr
+	once   sync.Once
+}
+
+func (c *egressConn) Close() error {
+	c.once.Do(c.egress.release)
+	return c.Conn.Close()
+}
+
+func (c *egressConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Kết nối qua IP egress được chọn; không có IP phù hợp thì để hệ thống tự chọn địa chỉ nguồn.
+// tried (có thể nil) ghi lại các lựa chọn đã thử để lần thử lại dùng IP khác
+func dialFromEgress(dialer net.Dialer, network, destAddr, policy string, tried *dialTried) (net.Conn, error) {
+	dialer.KeepAlive = time.Duration(systemConfig().DialTCP.KeepAlive) * time.Second
+	if systemConfig().DialTCP.FastOpen {
+		dialer.Control = fastOpenDialControl
+	}
+	var exclude map[*egressAddr]bool
+	if tried != nil {
+		exclude = tried.egress
+	}
+	egress, network := pickEgress(network, destAddr, policy, exclude)
+	if egress == nil {
+		// Đã yêu cầu quốc gia hoặc tag thì không được để hệ thống tự chọn địa chỉ nguồn
+		if _, filters := splitEgressFilters(policy); len(filters) > 0 {
+			if tried != nil && len(tried.egress) > 0 {
+				return nil, errNoAlternative
+			}
+			return nil, fmt.Errorf("no healthy egress IP matching %s: %w", formatTags(filters), syscall.ENETUNREACH)
+		}
+		if tried != nil {
+			if tried.direct[network] || len(tried.egress) > 0 {
+				return nil, errNoAlternative
+			}
+			tried.direct[network] = true
+		}
+		conn, err := dialer.Dial(network, destAddr)
+		if err != nil {
+			return nil, err
+		}
+		tuneTCPConn(conn, systemConfig().DialTCP)
+		return conn, nil
+	}
+	if tried != nil {
+		tried.egress[egress] = true
+	}
+
+	dialer.LocalAddr = &net.TCPAddr{IP: egress.ip}
+	started := time.Now()
+	conn, err := dialer.Dial(network, destAddr)
+	if err != nil {
+		egress.release()
+		return nil, err
+	}
+	egress.recordLatency(time.Since(started))
+	tuneTCPConn(conn, systemConfig().DialTCP)
+	return &egressConn{Conn: conn, egress: egress}, nil
+}
+
+// Danh sách trạng thái các IP egress
+func egressStatuses() []EgressStatus {
+	egressPoolMutex.Lock()
+	defer egressPoolMutex.Unlock()
+
+	statuses := make([]EgressStatus, 0, len(egressPool))
+	for _, egress := range egressPool {
+		statuses = append(statuses, EgressStatus{
+			IP:           egress.ip.String(),
+			Weight:       egress.weight,
+			Country:      egress.country,
+			Tags:         egress.tags,
+			Healthy:      egress.healthy,
+			PublicIP:     egress.publicIP,
+			LastCheck:    egress.lastCheck,
+			LastError:    egress.lastError,
+			Active:       egress.active,
+			LatencyAvgMs: float64(egress.latency) / float64(time.Millisecond),
+		})
+	}
+	return statuses
+}
+
+// Kiểm tra định kỳ các IP egress trong suốt thời gian chạy
+func runEgressHealthChecks() {
+	for {
+		egressPoolMutex.Lock()
+		pool := append([]*egressAddr(nil), egressPool...)
+		egressPoolMutex.Unlock()
+
+		var checks sync.WaitGroup
+		for _, egress := range pool {
+			checks.Add(1)
+			go func(egress *egressAddr) {
+				defer checks.Done()
+				publicIP, err := checkEgress(egress.ip)
+				updateEgressHealth(egress, publicIP, err)
+			}(egress)
+		}
+		checks.Wait()
+
+		interval := systemConfig().EgressCheckInterval
+		if interval <= 0 {
+			interval = defaultEgressCheckInterval
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
+
+// Gửi request kiểm tra từ IP egress, trả về IP public mà request đi ra
+func checkEgress(ip net.IP) (string, error) {
+	checkURL := systemConfig().EgressCheckURL
+	if checkURL == "" {
+		checkURL = defaultEgressCheckURL
+	}
+
+	network := "tcp6"
+	if ip.To4() != nil {
+		network = "tcp4"
+	}
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}, Timeout: 10 * time.Second}
+	client := http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := client.Get(checkURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	publicIP := net.ParseIP(strings.TrimSpace(string(body)))
+	if publicIP == nil {
+		return "", fmt.Errorf("unexpected response %q", strings.TrimSpace(string(body)))
+	}
+	return publicIP.String(), nil
+}
+
+// Cập nhật trạng thái sau một lần kiểm tra và cảnh báo khi trạng thái thay đổi
+func updateEgressHealth(egress *egressAddr, publicIP string, err error) {
+	egressPoolMutex.Lock()
+	wasHealthy := egress.healthy
+	egress.lastCheck = time.Now()
+	if err != nil {
+		egress.healthy = false
+		egress.lastError = err.Error()
+	} else {
+		egress.healthy = true
+		egress.lastError = ""
+		egress.publicIP = publicIP
+	}
+	alert := EgressAlert{Time: egress.lastCheck, IP: egress.ip.String(), PublicIP: egress.publicIP, Error: egress.lastError}
+	healthy := egress.healthy
+	egressPoolMutex.Unlock()
+
+	if healthy == wasHealthy {
+		return
+	}
+	if healthy {
+		alert.Event = "up"
+		log.Printf("Egress %s is back in rotation (public IP %s)", alert.IP, alert.PublicIP)
+		notifyOperators("Egress %s is back in rotation (public IP %s)", alert.IP, alert.PublicIP)
+	} else {
+		alert.Event = "down"
+		log.Printf("Egress %s removed from rotation: %s", alert.IP, alert.Error)
+		notifyOperators("Egress %s removed from rotation: %s", alert.IP, alert.Error)
+	}
+	sendEgressAlert(alert)
+}
+
+func sendEgressAlert(alert EgressAlert) {
+	if systemConfig().EgressWebhook == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			return
+		}
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(systemConfig().EgressWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Egress webhook error: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
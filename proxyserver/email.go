@@ -0,0 +1,390 @@
+package proxyserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Sự kiện gửi email cho user, bật riêng từng sự kiện bằng email_event
+const (
+	emailAccountCreated  = "account_created"  // Tài khoản vừa được tạo (kèm password)
+	emailQuotaWarning    = "quota_warning"    // Dữ liệu đã dùng vượt email_quota_warning % của max_data
+	emailAccountExpiring = "account_expiring" // Còn email_expiry_warning ngày tới end_date
+	emailAccountExpired  = "account_expired"  // Tài khoản vừa qua end_date
+	emailLoginNewIP      = "login_new_ip"     // Đăng nhập từ mạng client chưa từng dùng
+)
+
+// Giá trị mặc định của thông báo email
+const (
+	defaultEmailQuotaWarning  = 80 // Phần trăm max_data
+	defaultEmailExpiryWarning = 3  // Ngày
+	emailCheckInterval        = time.Hour
+	maxEmailQueue             = 100
+)
+
+// Template mặc định: dòng đầu là Subject, sau một dòng trống là nội dung
+var defaultEmailTemplates = map[string]string{
+	emailAccountCreated: `Subject: Your proxy account {{.Username}}
+
+Your proxy account has been created.
+
+Username: {{.Username}}
+Password: {{.Password}}
+Valid until: {{.EndDate}}
+Data limit: {{.MaxData}}
+`,
+	emailQuotaWarning: `Subject: Proxy account {{.Username}} has used {{.Percent}}% of its data
+
+Your proxy account {{.Username}} has used {{.DataUsage}} of its {{.MaxData}} data limit.
+`,
+	emailAccountExpiring: `Subject: Proxy account {{.Username}} expires in {{.DaysLeft}} days
+
+Your proxy account {{.Username}} is valid until {{.EndDate}}. Renew it to keep using the proxy.
+`,
+	emailAccountExpired: `Subject: Proxy account {{.Username}} has expired
+
+Your proxy account {{.Username}} expired on {{.EndDate}}.
+`,
+	emailLoginNewIP: `Subject: New login to proxy account {{.Username}}
+
+Your proxy account {{.Username}} was used from a new address.
+
+Time: {{.LoginTime}}
+IP address: {{.LoginIP}}
+Protocol: {{.LoginProtocol}}
+
+If this was not you, change your password.
+`,
+}
+
+// Dữ liệu truyền vào template email
+type EmailData struct {
+	Event     string
+	Username  string
+	Password  string // Chỉ có với account_created
+	Email     string
+	StartDate string
+	EndDate   string
+	MaxData   string // Đã định dạng, ví dụ "10.0 GiB"; "unlimited" khi max_data = 0
+	DataUsage string
+	Percent   int // Phần trăm max_data đã dùng
+	DaysLeft  int // Số ngày còn lại tới hết end_date
+
+	LoginTime     string // Chỉ có với login_new_ip
+	LoginIP       string
+	LoginProtocol string
+}
+
+type emailMessage struct {
+	to   string
+	data EmailData
+}
+
+var (
+	emailQueue   = make(chan emailMessage, maxEmailQueue)
+	emailStarted sync.Once
+
+	emailsSent      = make(map[string]bool) // Thông báo hết hạn đã gửi, theo sự kiện, user và end_date
+	emailsSentMutex sync.Mutex
+)
+
+func validEmailEvent(event string) bool {
+	_, exists := defaultEmailTemplates[event]
+	return exists
+}
+
+// email_event=<sự kiện>[,<file template>]
+func parseEmailEvent(value string) (string, string, error) {
+	event, path, _ := strings.Cut(value, ",")
+	event, path = strings.TrimSpace(event), strings.TrimSpace(path)
+	if !validEmailEvent(event) {
+		return "", "", fmt.Errorf("unknown event %q", event)
+	}
+	return event, path, nil
+}
+
+// Địa chỉ email của user: rỗng hoặc một địa chỉ hợp lệ không chứa dấu phẩy (phân cách cột users.conf)
+func validUserEmail(email string) bool {
+	if email == "" {
+		return true
+	}
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && !strings.Contains(email, ",")
+}
+
+func emailEnabled(event string) bool {
+	if systemConfig().SMTPServer == "" {
+		return false
+	}
+	_, enabled := systemConfig().EmailEvents[event]
+	return enabled
+}
+
+func emailQuotaPercent() int {
+	if systemConfig().EmailQuotaWarning <= 0 {
+		return defaultEmailQuotaWarning
+	}
+	return systemConfig().EmailQuotaWarning
+}
+
+func emailExpiryDays() int {
+	if systemConfig().EmailExpiryWarning <= 0 {
+		return defaultEmailExpiryWarning
+	}
+	return systemConfig().EmailExpiryWarning
+}
+
+// Khởi động việc gửi email một lần cho cả process
+func startEmailNotifier() {
+	emailStarted.Do(func() {
+		go runEmailSender()
+		go runEmailExpiryNotices()
+	})
+}
+
+// Đưa email của sự kiện vào hàng đợi nếu sự kiện được bật và user có địa chỉ email; gọi khi giữ usersMutex
+// hoặc khi user chưa được chia sẻ. Hàng đợi đầy thì bỏ email
+func queueUserEmail(event string, user *User, password string) {
+	if user.Email == "" || !emailEnabled(event) {
+		return
+	}
+	enqueueEmail(user, userEmailData(event, user, password))
+}
+
+// Email login_new_ip kèm thông tin lần đăng nhập; gọi khi giữ usersMutex
+func queueLoginEmail(user *User, login LastLogin) {
+	if user.Email == "" || !emailEnabled(emailLoginNewIP) {
+		return
+	}
+	data := userEmailData(emailLoginNewIP, user, "")
+	data.LoginTime = login.Time.Format(time.RFC1123)
+	data.LoginIP, data.LoginProtocol = login.IP, login.Protocol
+	enqueueEmail(user, data)
+}
+
+func userEmailData(event string, user *User, password string) EmailData {
+	data := EmailData{
+		Event:     event,
+		Username:  user.Username,
+		Password:  password,
+		Email:     user.Email,
+		StartDate: user.StartDate.Format("2006-01-02"),
+		EndDate:   user.EndDate.Format("2006-01-02"),
+		MaxData:   "unlimited",
+		DataUsage: formatBytes(user.dataUsage()),
+	}
+	if user.MaxData > 0 {
+		data.MaxData = formatBytes(user.MaxData)
+		data.Percent = int(user.dataUsage() * 100 / user.MaxData)
+	}
+	if !user.EndDate.IsZero() {
+		data.DaysLeft = int(math.Ceil(time.Until(user.EndDate.AddDate(0, 0, 1)).Hours() / 24))
+	}
+	return data
+}
+
+func enqueueEmail(user *User, data EmailData) {
+	select {
+	case emailQueue <- emailMessage{user.Email, data}:
+	default:
+		log.Printf("Email %s to %s dropped (queue full)", data.Event, user.Username)
+	}
+}
+
+// Gửi email quota_warning khi dữ liệu đã dùng vừa tăng từ before lên after và vượt ngưỡng
+func checkQuotaWarning(user *User, before, after int64) {
+	if user.MaxData <= 0 {
+		return
+	}
+	threshold := user.MaxData * int64(emailQuotaPercent()) / 100
+	if before < threshold && after >= threshold {
+		queueUserEmail(emailQuotaWarning, user, "")
+	}
+}
+
+func runEmailSender() {
+	for message := range emailQueue {
+		if err := sendUserEmail(message.to, message.data); err != nil {
+			log.Printf("Email %s to %s error: %v", message.data.Event, message.data.Username, err)
+		}
+	}
+}
+
+// Kiểm tra theo chu kỳ các user sắp hết hạn hoặc vừa hết hạn. Mỗi thông báo chỉ gửi một lần cho mỗi
+// end_date; trạng thái chỉ giữ trong bộ nhớ nên khởi động lại có thể gửi lại thông báo trong ngày
+func runEmailExpiryNotices() {
+	for {
+		queueExpiryEmails(time.Now())
+		time.Sleep(emailCheckInterval)
+	}
+}
+
+func queueExpiryEmails(now time.Time) {
+	if !emailEnabled(emailAccountExpiring) && !emailEnabled(emailAccountExpired) {
+		return
+	}
+	warning := time.Duration(emailExpiryDays()) * 24 * time.Hour
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	emailsSentMutex.Lock()
+	defer emailsSentMutex.Unlock()
+
+	for _, user := range users {
+		if user.Email == "" || user.EndDate.IsZero() {
+			continue
+		}
+		// User dùng được hết ngày end_date
+		expires := user.EndDate.AddDate(0, 0, 1)
+		var event string
+		switch {
+		case now.Before(expires) && expires.Sub(now) <= warning:
+			event = emailAccountExpiring
+		case !now.Before(expires) && now.Before(expires.AddDate(0, 0, 1)):
+			event = emailAccountExpired
+		default:
+			continue
+		}
+		key := event + "\x00" + user.Username + "\x00" + user.EndDate.Format("2006-01-02")
+		if emailsSent[key] || !emailEnabled(event) {
+			continue
+		}
+		emailsSent[key] = true
+		queueUserEmail(event, user, "")
+	}
+	// Bỏ các khóa của end_date đã qua lâu để map không lớn mãi
+	for key := range emailsSent {
+		date, err := time.Parse("2006-01-02", key[strings.LastIndexByte(key, 0)+1:])
+		if err == nil && now.Sub(date) > 7*24*time.Hour {
+			delete(emailsSent, key)
+		}
+	}
+}
+
+// Dựng email từ template của sự kiện: file trong email_event hoặc template mặc định
+func renderEmail(data EmailData) (subject, body string, err error) {
+	text := defaultEmailTemplates[data.Event]
+	if path := systemConfig().EmailEvents[data.Event]; path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", err
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New(data.Event).Parse(text)
+	if err != nil {
+		return "", "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", "", err
+	}
+	rendered := strings.ReplaceAll(out.String(), "\r\n", "\n")
+	first, rest, _ := strings.Cut(rendered, "\n")
+	if !strings.HasPrefix(first, "Subject:") {
+		return "", "", errors.New("template must start with a Subject: line")
+	}
+	return strings.TrimSpace(strings.TrimPrefix(first, "Subject:")), strings.TrimLeft(rest, "\n"), nil
+}
+
+func sendUserEmail(to string, data EmailData) error {
+	subject, body, err := renderEmail(data)
+	if err != nil {
+		return err
+	}
+	from := systemConfig().SMTPFrom
+	if from == "" {
+		from = systemConfig().SMTPUsername
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid smtp_from: %v", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", fromAddr.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(&msg)
+	writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	writer.Close()
+
+	return sendSMTP(fromAddr.Address, to, msg.Bytes())
+}
+
+// Gửi qua smtp_server: cổng 465 dùng TLS ngay khi kết nối, các cổng khác dùng STARTTLS nếu server hỗ trợ
+func sendSMTP(from, to string, msg []byte) error {
+	addr := systemConfig().SMTPServer
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid smtp_server: %v", err)
+	}
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if port == "465" {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if systemConfig().SMTPUsername != "" {
+		password, err := resolveSecret(systemConfig().SMTPPassword)
+		if err != nil {
+			return err
+		}
+		// PlainAuth từ chối gửi password khi chưa có TLS, trừ khi server là localhost
+		if err := client.Auth(smtp.PlainAuth("", systemConfig().SMTPUsername, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(msg); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,96 @@
+package proxyserver
+
+import "errors"
+
+// Lỗi theo nguyên nhân, dùng chung cho handler, chương trình nhúng, log và nhãn metrics.
+// Chương trình nhúng phân biệt nguyên nhân bằng errors.Is, ví dụ trên CloseInfo.Err trong OnClose
+var (
+	ErrAuthFailed    = errors.New("authentication failed")
+	ErrQuotaExceeded = errors.New("data quota exceeded")
+	ErrDialRefused   = errors.New("destination refused the connection")
+	ErrDialFailed    = errors.New("cannot connect to destination")
+	ErrRuleDenied    = errors.New("denied by rule")
+)
+
+// Lý do xác thực thất bại, ghi trong auth log (reason=) và nhãn của proxy_auth_failures_total
+const (
+	AuthInvalidCredentials = "invalid_credentials"
+	AuthInvalidParams      = "invalid_username_params"
+	AuthAccountSuspended   = "account_suspended"
+	AuthAccountExpired     = "account_expired"
+	AuthConnectionLimit    = "connection_limit"
+	AuthQuotaExceeded      = CloseQuotaExceeded // Đã dùng hết max_data, cùng mã với lý do đóng tunnel
+	AuthMissingToken       = "missing_token"
+	AuthInvalidToken       = "invalid_token"
+	AuthBadSignature       = "bad_signature"
+	AuthMalformedRequest   = "malformed_request"
+)
+
+// Nguyên nhân chung của mỗi lý do; lý do không có ở đây (client_eof, admin_kick...) không gắn với lỗi chung nào
+var reasonCauses = map[string]error{
+	AuthInvalidCredentials: ErrAuthFailed,
+	AuthInvalidParams:      ErrAuthFailed,
+	AuthAccountSuspended:   ErrAuthFailed,
+	AuthAccountExpired:     ErrAuthFailed,
+	AuthConnectionLimit:    ErrAuthFailed,
+	AuthMissingToken:       ErrAuthFailed,
+	AuthInvalidToken:       ErrAuthFailed,
+	AuthBadSignature:       ErrAuthFailed,
+	AuthMalformedRequest:   ErrAuthFailed,
+	CloseQuotaExceeded:     ErrQuotaExceeded,
+	CloseDialRefused:       ErrDialRefused,
+	CloseDialTimeout:       ErrDialFailed,
+	CloseDialUnreachable:   ErrDialFailed,
+	CloseDialDNS:           ErrDialFailed,
+	CloseDialError:         ErrDialFailed,
+	ClosePolicyDenied:      ErrRuleDenied,
+}
+
+// Lỗi của một lần xác thực hoặc một kết nối: Reason là mã trong log và nhãn metrics,
+// Err là lỗi cụ thể (có thể nil). errors.Is khớp cả nguyên nhân chung của Reason và Err
+type ConnError struct {
+	Reason string
+	Err    error
+}
+
+func newConnError(reason string, err error) *ConnError {
+	return &ConnError{Reason: reason, Err: err}
+}
+
+func (e *ConnError) Error() string {
+	switch {
+	case e.Err != nil:
+		return e.Err.Error()
+	case reasonCauses[e.Reason] != nil:
+		return reasonCauses[e.Reason].Error() + " (" + e.Reason + ")"
+	}
+	return e.Reason
+}
+
+func (e *ConnError) Unwrap() []error {
+	var causes []error
+	if cause := reasonCauses[e.Reason]; cause != nil {
+		causes = append(causes, cause)
+	}
+	if e.Err != nil {
+		causes = append(causes, e.Err)
+	}
+	return causes
+}
+
+// Mã lý do của lỗi như trong log và nhãn metrics, rỗng khi lỗi không mang lý do
+func ErrorReason(err error) string {
+	var connErr *ConnError
+	if errors.As(err, &connErr) {
+		return connErr.Reason
+	}
+	return ""
+}
+
+// Lỗi cho kết nối kết thúc với lý do reason; nil khi kết thúc bình thường
+func closeError(reason string) error {
+	if reason == "" || reason == CloseClientEOF || reason == CloseTargetEOF {
+		return nil
+	}
+	return newConnError(reason, nil)
+}
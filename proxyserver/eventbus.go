@@ -0,0 +1,324 @@
+package proxyserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Phát sự kiện vòng đời kết nối và xác thực lên Kafka hoặc NATS cho các hệ thống chống gian lận và tính
+// cước. Sự kiện vào hàng đợi có giới hạn và được gửi theo lô từ một goroutine riêng; khi gửi lỗi, lô được
+// thử lại sau khi kết nối lại (có thể gửi trùng), hàng đợi đầy thì sự kiện mới bị bỏ và được đếm
+const (
+	eventConnectionOpen  = "connection.open"
+	eventConnectionClose = "connection.close"
+	eventAuthSuccess     = "auth.success"
+	eventAuthFailure     = "auth.failure"
+
+	eventEncodingJSON     = "json"
+	eventEncodingProtobuf = "protobuf"
+
+	eventBusClientName   = "coffee-proxy"
+	defaultEventTopic    = "proxy.{type}"
+	defaultEventBuffer   = 10000
+	maxEventBatch        = 500
+	eventBusMaxBackoff   = 30 * time.Second
+	eventBusIdleTimeout  = 2 * time.Minute // Kết nối không dùng lâu hơn thì mở lại, tránh bị broker đóng
+	eventBusTimeout      = 10 * time.Second
+	eventBusDrainTimeout = 10 * time.Second // Thời gian chờ gửi nốt sự kiện trước khi process thoát
+)
+
+var eventTypes = []string{eventConnectionOpen, eventConnectionClose, eventAuthSuccess, eventAuthFailure}
+
+// Một sự kiện; các trường không áp dụng cho loại sự kiện thì để trống
+type BusEvent struct {
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	Node       string            `json:"node"`
+	Username   string            `json:"user,omitempty"`
+	Client     string            `json:"client"`
+	Listener   string            `json:"listener,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	Dest       string            `json:"dest,omitempty"`
+	Session    string            `json:"session,omitempty"`
+	Country    string            `json:"country,omitempty"`
+	Up         int64             `json:"up,omitempty"`
+	Down       int64             `json:"down,omitempty"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+	Reason     string            `json:"reason,omitempty"` // Lý do kết thúc (connection.close) hoặc lý do thất bại (auth.failure)
+	Unmetered  bool              `json:"unmetered,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// Sự kiện đã mã hóa, sẵn sàng gửi
+type busMessage struct {
+	topic string
+	key   []byte // Kafka: sự kiện cùng key vào cùng partition, giữ thứ tự theo user
+	value []byte
+	time  time.Time
+}
+
+// Kafka hoặc NATS
+type eventPublisher interface {
+	publish(messages []busMessage) error
+	close()
+}
+
+var (
+	eventQueue     chan BusEvent
+	eventBusNode   string
+	eventBusOnce   sync.Once
+	eventsSent     atomic.Int64
+	eventsDropped  atomic.Int64
+	eventBusErrors atomic.Int64
+	eventsDropping atomic.Bool  // Đang bỏ sự kiện vì hàng đợi đầy, chỉ ghi log một lần
+	eventsPending  atomic.Int64 // Sự kiện trong hàng đợi hoặc trong lô đang gửi
+)
+
+func validEventType(event string) bool {
+	return containsString(eventTypes, event)
+}
+
+func validEventEncoding(encoding string) bool {
+	return encoding == eventEncodingJSON || encoding == eventEncodingProtobuf
+}
+
+// event_bus: kafka://broker1:9092,broker2:9092, kafka+tls://..., nats://host:4222 hoặc nats+tls://...;
+// user:password trong URL dùng cho SASL PLAIN (Kafka) hoặc xác thực NATS
+func parseEventBusURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "kafka", "kafka+tls", "nats", "nats+tls":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, expected kafka, kafka+tls, nats or nats+tls", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// Topic hoặc subject của loại sự kiện theo event_bus_topic, {type} được thay bằng loại sự kiện
+func eventTopic(eventType string) string {
+	topic := systemConfig().EventBusTopic
+	if topic == "" {
+		topic = defaultEventTopic
+	}
+	return strings.ReplaceAll(topic, "{type}", eventType)
+}
+
+func eventEnabled(eventType string) bool {
+	if systemConfig().EventBus == "" || eventQueue == nil {
+		return false
+	}
+	return len(systemConfig().EventBusEvents) == 0 || containsString(systemConfig().EventBusEvents, eventType)
+}
+
+// Tạo hàng đợi và bắt đầu gửi một lần cho cả process; sự kiện chỉ được đưa vào khi event_bus được đặt
+func startEventBus() {
+	eventBusOnce.Do(func() {
+		size := systemConfig().EventBusBuffer
+		if size <= 0 {
+			size = defaultEventBuffer
+		}
+		eventBusNode = systemConfig().ClusterNode
+		if eventBusNode == "" {
+			eventBusNode = alertInstance
+		}
+		eventQueue = make(chan BusEvent, size)
+		go runEventPublisher()
+	})
+}
+
+// Đưa sự kiện vào hàng đợi; không bao giờ chặn
+func publishEvent(event BusEvent) {
+	if !eventEnabled(event.Type) {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.Node = eventBusNode
+	eventsPending.Add(1)
+	select {
+	case eventQueue <- event:
+	default:
+		eventsPending.Add(-1)
+		eventsDropped.Add(1)
+		if !eventsDropping.Swap(true) {
+			log.Printf("Event bus queue full (%d events), dropping new events", cap(eventQueue))
+		}
+	}
+}
+
+// Sự kiện của kết nối từ thông tin cho hook
+func connEvent(eventType string, info *ConnInfo) BusEvent {
+	return BusEvent{
+		Type: eventType, Username: info.Username, Client: info.Client.String(), Listener: info.Listener,
+		Protocol: info.Protocol, Dest: info.Dest, Session: info.Session, Country: info.Country,
+	}
+}
+
+func runEventPublisher() {
+	var publisher eventPublisher
+	var publisherURL string
+	var lastUsed time.Time
+	backoff := time.Second
+
+	for {
+		event := <-eventQueue
+		batch := []BusEvent{event}
+		for len(batch) < maxEventBatch && len(eventQueue) > 0 {
+			batch = append(batch, <-eventQueue)
+		}
+		messages := make([]busMessage, 0, len(batch))
+		for _, event := range batch {
+			message, err := encodeEvent(event)
+			if err != nil {
+				log.Printf("Event bus encoding error: %v", err)
+				continue
+			}
+			messages = append(messages, message)
+		}
+
+		// Thử lại tới khi gửi được; trong lúc đó sự kiện mới nằm trong hàng đợi
+		for {
+			address, err := resolveSecret(systemConfig().EventBus)
+			if publisher != nil && (address != publisherURL || time.Since(lastUsed) > eventBusIdleTimeout) {
+				publisher.close()
+				publisher = nil
+			}
+			if err == nil && publisher == nil {
+				publisher, err = openEventPublisher(address)
+				publisherURL = address
+			}
+			if err == nil {
+				err = publisher.publish(messages)
+			}
+			if err == nil {
+				lastUsed = time.Now()
+				break
+			}
+			eventBusErrors.Add(1)
+			log.Printf("Event bus error (%d events pending): %v", len(messages)+len(eventQueue), err)
+			if publisher != nil {
+				publisher.close()
+				publisher = nil
+			}
+			time.Sleep(backoff)
+			backoff = min(backoff*2, eventBusMaxBackoff)
+		}
+		backoff = time.Second
+		eventsSent.Add(int64(len(messages)))
+		eventsPending.Add(-int64(len(batch)))
+		if eventsDropping.Swap(false) {
+			log.Printf("Event bus caught up, %d events dropped so far", eventsDropped.Load())
+		}
+	}
+}
+
+// Chờ gửi nốt các sự kiện đang chờ trước khi process thoát, tối đa eventBusDrainTimeout
+func drainEventBus() {
+	if eventQueue == nil {
+		return
+	}
+	deadline := time.Now().Add(eventBusDrainTimeout)
+	for eventsPending.Load() > 0 {
+		if time.Now().After(deadline) {
+			log.Printf("Event bus drain timed out, %d events not sent", eventsPending.Load())
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func openEventPublisher(address string) (eventPublisher, error) {
+	u, err := parseEventBusURL(address)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(u.Scheme, "kafka") {
+		return newKafkaProducer(u), nil
+	}
+	return natsConnect(u)
+}
+
+// Mã hóa sự kiện theo event_bus_encoding; key là username, hoặc IP client khi không có user
+func encodeEvent(event BusEvent) (busMessage, error) {
+	message := busMessage{topic: eventTopic(event.Type), key: []byte(event.Username), time: event.Time}
+	if event.Username == "" {
+		host, _, err := net.SplitHostPort(event.Client)
+		if err != nil {
+			host = event.Client
+		}
+		message.key = []byte(host)
+	}
+	var err error
+	if systemConfig().EventBusEncoding == eventEncodingProtobuf {
+		message.value = encodeEventProtobuf(event)
+	} else {
+		message.value, err = json.Marshal(event)
+	}
+	return message, err
+}
+
+// Mã hóa protobuf theo message ProxyEvent trong README; trường rỗng không được ghi như proto3
+func encodeEventProtobuf(event BusEvent) []byte {
+	var b []byte
+	appendString := func(b []byte, field int, value string) []byte {
+		if value == "" {
+			return b
+		}
+		b = binary.AppendUvarint(b, uint64(field<<3|2))
+		b = binary.AppendUvarint(b, uint64(len(value)))
+		return append(b, value...)
+	}
+	appendInt := func(b []byte, field int, value int64) []byte {
+		if value == 0 {
+			return b
+		}
+		b = binary.AppendUvarint(b, uint64(field<<3))
+		return binary.AppendUvarint(b, uint64(value))
+	}
+
+	b = appendString(b, 1, event.Type)
+	b = appendInt(b, 2, event.Time.UnixMilli())
+	b = appendString(b, 3, event.Node)
+	b = appendString(b, 4, event.Username)
+	b = appendString(b, 5, event.Client)
+	b = appendString(b, 6, event.Listener)
+	b = appendString(b, 7, event.Protocol)
+	b = appendString(b, 8, event.Dest)
+	b = appendString(b, 9, event.Session)
+	b = appendString(b, 10, event.Country)
+	b = appendInt(b, 11, event.Up)
+	b = appendInt(b, 12, event.Down)
+	b = appendInt(b, 13, event.DurationMs)
+	b = appendString(b, 14, event.Reason)
+	if event.Unmetered {
+		b = appendInt(b, 15, 1)
+	}
+	// map<string, string> tags = 16: mỗi cặp là một message lồng {1: key, 2: value}
+	keys := make([]string, 0, len(event.Tags))
+	for key := range event.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := appendString(appendString(nil, 1, key), 2, event.Tags[key])
+		b = binary.AppendUvarint(b, 16<<3|2)
+		b = binary.AppendUvarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+	return b
+}
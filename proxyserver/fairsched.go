@@ -0,0 +1,13 @@
+package proxyserver
+
+import "proxy_server/proxyserver/limits"
+
+// Khi fair_scheduling bật, dữ liệu của mọi tunnel chia chung tốc độ max_bandwidth theo user (xem limits.Scheduler)
+var fairScheduler = limits.NewScheduler(fairSchedulingRate)
+
+func fairSchedulingRate() int64 {
+	if !systemConfig().FairScheduling {
+		return 0
+	}
+	return systemConfig().MaxBandwidth
+}
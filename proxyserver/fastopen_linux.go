@@ -0,0 +1,52 @@
+//go:build linux
+
+package proxyserver
+
+import (
+	"log"
+	"sync"
+	"syscall"
+)
+
+// Tùy chọn socket TCP Fast Open (linux/tcp.h)
+const (
+	tcpFastOpen        = 0x17
+	tcpFastOpenConnect = 0x1e
+)
+
+// Độ dài hàng đợi kết nối TFO chưa hoàn tất bắt tay trên listener
+const fastOpenQueueLength = 256
+
+const fastOpenSupported = true
+
+var fastOpenDialWarning sync.Once
+
+// Bật TFO cho socket listener. Lỗi chỉ được ghi log, listener vẫn hoạt động bình thường
+func fastOpenListenControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, fastOpenQueueLength)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		log.Printf("TCP Fast Open unavailable on %s: %v", address, sockErr)
+	}
+	return nil
+}
+
+// Bật TFO cho kết nối ra ngoài: dữ liệu đầu tiên được gửi cùng gói SYN
+func fastOpenDialControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		fastOpenDialWarning.Do(func() {
+			log.Printf("TCP Fast Open unavailable for outbound connections: %v", sockErr)
+		})
+	}
+	return nil
+}
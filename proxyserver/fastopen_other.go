@@ -0,0 +1,20 @@
+//go:build !linux
+
+package proxyserver
+
+import (
+	"errors"
+	"syscall"
+)
+
+const fastOpenSupported = false
+
+var errFastOpenUnsupported = errors.New("TCP Fast Open is not supported on this platform")
+
+func fastOpenListenControl(network, address string, c syscall.RawConn) error {
+	return errFastOpenUnsupported
+}
+
+func fastOpenDialControl(network, address string, c syscall.RawConn) error {
+	return errFastOpenUnsupported
+}
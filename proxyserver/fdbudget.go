@@ -0,0 +1,93 @@
+package proxyserver
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Số fd mỗi tunnel dùng (kết nối client và kết nối tới đích)
+const fdsPerTunnel = 2
+
+var (
+	fdLimitValue    atomic.Int64 // Soft limit RLIMIT_NOFILE, 0 = không xác định
+	fdSampled       atomic.Int64 // Số fd đang mở ở lần đếm gần nhất
+	fdCreditsSample atomic.Int64 // Số credit đang dùng ở lần đếm gần nhất
+	fdShedTotal     atomic.Int64 // Số kết nối bị từ chối vì sắp hết fd
+	fdLastShedLog   atomic.Int64
+)
+
+// Nâng soft limit lên hard limit và bắt đầu theo dõi số fd đang mở
+func startFDBudget() {
+	limit, err := raiseFDLimit()
+	if err != nil {
+		log.Printf("Unable to raise open file limit: %v", err)
+	}
+	if limit > 0 {
+		log.Printf("Open file limit: %d", limit)
+	}
+	fdLimitValue.Store(int64(limit))
+
+	go func() {
+		for ; ; time.Sleep(time.Second) {
+			credits := int64(activeConnCredits())
+			if count := countOpenFDs(); count >= 0 {
+				fdSampled.Store(int64(count))
+			} else {
+				fdSampled.Store(credits * fdsPerTunnel)
+			}
+			fdCreditsSample.Store(credits)
+		}
+	}()
+}
+
+// Ước lượng số fd đang mở: lần đếm gần nhất cộng các tunnel mở thêm từ đó
+func estimatedOpenFDs() int64 {
+	delta := int64(activeConnCredits()) - fdCreditsSample.Load()
+	return fdSampled.Load() + delta*fdsPerTunnel
+}
+
+// Số fd để dành: fd_headroom hoặc mặc định 10% limit
+func fdHeadroom(limit int64) int64 {
+	if systemConfig().FDHeadroom > 0 {
+		return int64(systemConfig().FDHeadroom)
+	}
+	return limit / 10
+}
+
+// Có nên từ chối kết nối mới để tránh hết fd (EMFILE) hay không
+func fdBudgetExhausted() bool {
+	limit := fdLimitValue.Load()
+	if limit <= 0 {
+		return false
+	}
+	if estimatedOpenFDs()+fdsPerTunnel <= limit-fdHeadroom(limit) {
+		return false
+	}
+
+	fdShedTotal.Add(1)
+	now := time.Now().Unix()
+	if last := fdLastShedLog.Load(); now-last >= 10 && fdLastShedLog.CompareAndSwap(last, now) {
+		log.Printf("Shedding new connections: about %d of %d file descriptors in use", estimatedOpenFDs(), limit)
+	}
+	return true
+}
+
+// Lỗi accept do hết fd: chờ một chút thay vì lặp liên tục
+func backoffOnFDExhaustion(err error) {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Đóng kết nối khi sắp hết fd, trả về true nếu kết nối đã bị từ chối
+func shedIfFDExhausted(conn net.Conn) bool {
+	if !fdBudgetExhausted() {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package proxyserver
+
+// Không có RLIMIT_NOFILE: không giới hạn theo fd
+func raiseFDLimit() (uint64, error) {
+	return 0, nil
+}
+
+func countOpenFDs() int {
+	return -1
+}
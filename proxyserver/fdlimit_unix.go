@@ -0,0 +1,35 @@
+//go:build unix
+
+package proxyserver
+
+import (
+	"os"
+	"syscall"
+)
+
+// Nâng soft limit số file mở lên bằng hard limit, trả về soft limit hiện tại
+func raiseFDLimit() (uint64, error) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, err
+	}
+	if rlimit.Cur < rlimit.Max {
+		raised := rlimit
+		raised.Cur = raised.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err != nil {
+			return uint64(rlimit.Cur), err
+		}
+		rlimit = raised
+	}
+	return uint64(rlimit.Cur), nil
+}
+
+// Đếm số fd đang mở của process, -1 nếu không đếm được
+func countOpenFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			return len(entries)
+		}
+	}
+	return -1
+}
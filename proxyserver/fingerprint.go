@@ -0,0 +1,173 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Độ dài một cửa sổ thống kê hành vi
+const fingerprintWindow = time.Minute
+
+// Số cửa sổ tối thiểu trước khi bắt đầu so sánh với baseline
+const fingerprintWarmupWindows = 5
+
+// Hệ số lệch mặc định so với baseline để phát cảnh báo
+const defaultAnomalyFactor = 5
+
+// Hệ số làm mượt baseline (EWMA)
+const fingerprintSmoothing = 0.2
+
+// Thống kê hành vi của một user trong cửa sổ hiện tại và baseline các cửa sổ trước
+type userFingerprint struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	connects    int
+	hosts       map[string]struct{}
+	bytesUp     int64
+	bytesDown   int64
+
+	windows     int
+	avgConnects float64
+	avgHosts    float64
+	avgRatio    float64
+}
+
+// Cảnh báo hành vi bất thường
+type AnomalyAlert struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Metric   string    `json:"metric"` // destinations_per_minute, unique_hosts, bytes_ratio
+	Value    float64   `json:"value"`
+	Baseline float64   `json:"baseline"`
+}
+
+var (
+	fingerprints      = make(map[string]*userFingerprint)
+	fingerprintsMutex sync.Mutex
+)
+
+func getFingerprint(username string) *userFingerprint {
+	fingerprintsMutex.Lock()
+	defer fingerprintsMutex.Unlock()
+
+	fp, exists := fingerprints[username]
+	if !exists {
+		fp = &userFingerprint{windowStart: time.Now(), hosts: make(map[string]struct{})}
+		fingerprints[username] = fp
+	}
+	return fp
+}
+
+// Ghi nhận một kết nối tới đích của user
+func recordDestination(user *User, destAddr string) {
+	if user == nil || !systemConfig().AnomalyDetection {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(destAddr)
+	if err != nil {
+		host = destAddr
+	}
+
+	fp := getFingerprint(user.Username)
+	fp.mu.Lock()
+	alerts := fp.rotate(user.Username, time.Now())
+	fp.connects++
+	fp.hosts[host] = struct{}{}
+	fp.mu.Unlock()
+
+	sendAnomalyAlerts(alerts)
+}
+
+// Ghi nhận lượng dữ liệu gửi lên (up) và nhận về (down) của user
+func recordTrafficBytes(user *User, up, down int64) {
+	if user == nil || !systemConfig().AnomalyDetection {
+		return
+	}
+
+	fp := getFingerprint(user.Username)
+	fp.mu.Lock()
+	alerts := fp.rotate(user.Username, time.Now())
+	fp.bytesUp += up
+	fp.bytesDown += down
+	fp.mu.Unlock()
+
+	sendAnomalyAlerts(alerts)
+}
+
+// Đóng cửa sổ đã hết hạn: so sánh với baseline, cập nhật baseline và bắt đầu cửa sổ mới
+func (fp *userFingerprint) rotate(username string, now time.Time) []AnomalyAlert {
+	if now.Sub(fp.windowStart) < fingerprintWindow {
+		return nil
+	}
+
+	connects := float64(fp.connects)
+	hosts := float64(len(fp.hosts))
+	ratio := 0.0
+	if fp.bytesDown > 0 {
+		ratio = float64(fp.bytesUp) / float64(fp.bytesDown)
+	}
+
+	var alerts []AnomalyAlert
+	if fp.windows >= fingerprintWarmupWindows {
+		factor := systemConfig().AnomalyFactor
+		if factor <= 0 {
+			factor = defaultAnomalyFactor
+		}
+		if connects >= float64(systemConfig().AnomalyMinDestinations) && connects > fp.avgConnects*factor {
+			alerts = append(alerts, AnomalyAlert{now, username, "destinations_per_minute", connects, fp.avgConnects})
+		}
+		if hosts >= float64(systemConfig().AnomalyMinUniqueHosts) && hosts > fp.avgHosts*factor {
+			alerts = append(alerts, AnomalyAlert{now, username, "unique_hosts", hosts, fp.avgHosts})
+		}
+		if fp.bytesUp+fp.bytesDown >= systemConfig().AnomalyMinBytes && fp.avgRatio > 0 && (ratio > fp.avgRatio*factor || ratio*factor < fp.avgRatio) {
+			alerts = append(alerts, AnomalyAlert{now, username, "bytes_ratio", ratio, fp.avgRatio})
+		}
+	}
+
+	if fp.windows == 0 {
+		fp.avgConnects, fp.avgHosts, fp.avgRatio = connects, hosts, ratio
+	} else {
+		fp.avgConnects += fingerprintSmoothing * (connects - fp.avgConnects)
+		fp.avgHosts += fingerprintSmoothing * (hosts - fp.avgHosts)
+		fp.avgRatio += fingerprintSmoothing * (ratio - fp.avgRatio)
+	}
+	fp.windows++
+
+	fp.windowStart = now
+	fp.connects = 0
+	fp.hosts = make(map[string]struct{})
+	fp.bytesUp = 0
+	fp.bytesDown = 0
+	return alerts
+}
+
+// Ghi log và gửi cảnh báo tới webhook (nếu có cấu hình)
+func sendAnomalyAlerts(alerts []AnomalyAlert) {
+	for _, alert := range alerts {
+		log.Printf("Anomaly alert: user %s %s=%.2f (baseline %.2f)", alert.Username, alert.Metric, alert.Value, alert.Baseline)
+		notifyOperators("Anomaly alert: user %s %s=%.2f (baseline %.2f)", alert.Username, alert.Metric, alert.Value, alert.Baseline)
+
+		if systemConfig().AnomalyWebhook == "" {
+			continue
+		}
+		go func(alert AnomalyAlert) {
+			body, err := json.Marshal(alert)
+			if err != nil {
+				return
+			}
+			client := http.Client{Timeout: 10 * time.Second}
+			resp, err := client.Post(systemConfig().AnomalyWebhook, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Anomaly webhook error: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}(alert)
+	}
+}
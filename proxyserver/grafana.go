@@ -0,0 +1,197 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sinh dashboard Grafana dùng đúng tên các metric ở /metrics, để import thẳng vào Grafana
+const grafanaSchemaVersion = 39
+
+// Một panel của dashboard: các biểu thức PromQL với $sel là bộ chọn instance
+type grafanaPanelSpec struct {
+	title   string
+	kind    string // timeseries hoặc stat
+	unit    string
+	width   int
+	targets [][2]string // Biểu thức và legend
+}
+
+// Các hàng panel, mỗi hàng rộng 24 cột
+var grafanaRows = []struct {
+	title  string
+	panels []grafanaPanelSpec
+}{
+	{"Overview", []grafanaPanelSpec{
+		{"Uptime", "stat", "s", 4, [][2]string{{`max(proxy_uptime_seconds{$sel})`, ""}}},
+		{"Open connections", "stat", "short", 4, [][2]string{{`sum(proxy_connections_active{$sel})`, ""}}},
+		{"Connection limit", "stat", "short", 4, [][2]string{{`max(proxy_connections_limit{$sel})`, ""}}},
+		{"Firing alerts", "stat", "short", 4, [][2]string{{`sum(proxy_alert_firing{$sel})`, ""}}},
+		{"Throughput", "stat", "Bps", 8, [][2]string{{`sum(rate(proxy_bytes_total{$sel}[5m]))`, ""}}},
+	}},
+	{"Connections", []grafanaPanelSpec{
+		{"Connections", "timeseries", "short", 12, [][2]string{
+			{`sum(proxy_connections_active{$sel})`, "open"},
+			{`max(proxy_connections_limit{$sel}) > 0`, "limit"},
+		}},
+		{"New connections per second", "timeseries", "cps", 12, [][2]string{
+			{`sum(rate(proxy_connections_total{$sel}[5m]))`, "handled"},
+			{`sum(rate(proxy_accept_queued_total{$sel}[5m]))`, "queued"},
+			{`sum(rate(proxy_accept_refused_total{$sel}[5m]))`, "refused"},
+		}},
+		{"Connections by listener", "timeseries", "cps", 24, [][2]string{
+			{`sum by (listener) (rate(proxy_listener_accepted_total{$sel}[5m]))`, "{{listener}}"},
+		}},
+	}},
+	{"Bandwidth", []grafanaPanelSpec{
+		{"Bandwidth", "timeseries", "Bps", 12, [][2]string{
+			{`sum by (direction) (rate(proxy_bytes_total{$sel}[5m]))`, "{{direction}}"},
+			{`sum(rate(proxy_unmetered_bytes_total{$sel}[5m]))`, "unmetered"},
+		}},
+		{"Bandwidth by listener", "timeseries", "Bps", 12, [][2]string{
+			{`sum by (listener, direction) (rate(proxy_listener_bytes_total{$sel}[5m]))`, "{{listener}} {{direction}}"},
+		}},
+		{"Compressed link", "timeseries", "Bps", 24, [][2]string{
+			{`sum by (stage) (rate(proxy_compress_bytes_total{$sel}[5m]))`, "{{stage}}"},
+		}},
+	}},
+	{"Errors", []grafanaPanelSpec{
+		{"Tunnels closed by reason", "timeseries", "ops", 12, [][2]string{
+			{`sum by (reason) (rate(proxy_tunnels_closed_total{$sel}[5m]))`, "{{reason}}"},
+		}},
+		{"Destination error ratio", "timeseries", "percentunit", 12, [][2]string{
+			{`sum(rate(proxy_tunnels_closed_total{$sel,reason=~"target_error|dial_.*"}[5m])) / sum(rate(proxy_tunnels_closed_total{$sel}[5m]))`, "errors"},
+		}},
+		{"Denied requests", "timeseries", "ops", 12, [][2]string{
+			{`sum by (result) (rate(proxy_policy_decisions_total{$sel,result!="allow"}[5m]))`, "policy {{result}}"},
+			{`sum(rate(proxy_acl_denied_total{$sel}[5m]))`, "acl"},
+			{`sum(rate(proxy_reputation_denied_total{$sel}[5m]))`, "reputation"},
+		}},
+		{"Firing alerts", "timeseries", "short", 12, [][2]string{
+			{`max by (alertname) (proxy_alert_firing{$sel}) > 0`, "{{alertname}}"},
+		}},
+	}},
+	{"Resources", []grafanaPanelSpec{
+		{"Memory", "timeseries", "bytes", 8, [][2]string{
+			{`max(proxy_memory_bytes{$sel})`, "memory"},
+		}},
+		{"File descriptors", "timeseries", "short", 8, [][2]string{
+			{`max(proxy_open_fds{$sel})`, "open"},
+			{`max(proxy_fd_limit{$sel}) > 0`, "limit"},
+		}},
+		{"Shed connections", "timeseries", "ops", 8, [][2]string{
+			{`sum(rate(proxy_fd_shed_total{$sel}[5m]))`, "fd budget"},
+			{`sum(rate(proxy_memory_shed_total{$sel}[5m]))`, "memory"},
+			{`sum(rate(proxy_memory_idle_closed_total{$sel}[5m]))`, "idle closed"},
+		}},
+		{"Egress IPs up", "timeseries", "short", 12, [][2]string{
+			{`min by (ip) (proxy_egress_up{$sel})`, "{{ip}}"},
+		}},
+		{"Upstreams up", "timeseries", "short", 12, [][2]string{
+			{`min by (upstream) (proxy_upstream_up{$sel})`, "{{upstream}}"},
+		}},
+	}},
+}
+
+// Dashboard theo định dạng JSON của Grafana; datasource rỗng thì khai báo input để chọn khi import
+func grafanaDashboard(title, datasource string) map[string]any {
+	dsRef := map[string]any{"type": "prometheus", "uid": datasource}
+	if datasource == "" {
+		dsRef["uid"] = "${DS_PROMETHEUS}"
+	}
+
+	var panels []any
+	id, y := 1, 0
+	for _, row := range grafanaRows {
+		panels = append(panels, map[string]any{
+			"id": id, "type": "row", "title": row.title, "collapsed": false, "panels": []any{},
+			"gridPos": map[string]int{"h": 1, "w": 24, "x": 0, "y": y},
+		})
+		id++
+		y++
+		x, rowHeight := 0, 0
+		for _, spec := range row.panels {
+			height := 8
+			if spec.kind == "stat" {
+				height = 4
+			}
+			if x+spec.width > 24 {
+				x, y = 0, y+rowHeight
+			}
+			var targets []any
+			for i, target := range spec.targets {
+				targets = append(targets, map[string]any{
+					"datasource":   dsRef,
+					"expr":         strings.ReplaceAll(target[0], "$sel", `instance=~"$instance"`),
+					"legendFormat": target[1],
+					"refId":        string(rune('A' + i)),
+				})
+			}
+			panel := map[string]any{
+				"id": id, "type": spec.kind, "title": spec.title, "datasource": dsRef, "targets": targets,
+				"gridPos":     map[string]int{"h": height, "w": spec.width, "x": x, "y": y},
+				"fieldConfig": map[string]any{"defaults": map[string]any{"unit": spec.unit}, "overrides": []any{}},
+			}
+			if spec.kind == "timeseries" {
+				panel["options"] = map[string]any{"legend": map[string]any{"displayMode": "list", "placement": "bottom", "showLegend": true}, "tooltip": map[string]any{"mode": "multi"}}
+			}
+			panels = append(panels, panel)
+			id++
+			x += spec.width
+			rowHeight = height
+		}
+		y += rowHeight
+	}
+
+	dashboard := map[string]any{
+		"title":         title,
+		"uid":           "coffee-proxy",
+		"tags":          []string{"proxy", "socks5"},
+		"timezone":      "browser",
+		"schemaVersion": grafanaSchemaVersion,
+		"editable":      true,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"panels":        panels,
+		"templating": map[string]any{"list": []any{map[string]any{
+			"name": "instance", "label": "Instance", "type": "query", "datasource": dsRef,
+			"query":      map[string]any{"query": "label_values(proxy_uptime_seconds, instance)", "refId": "instance"},
+			"definition": "label_values(proxy_uptime_seconds, instance)",
+			"includeAll": true, "multi": true, "refresh": 2,
+			"current": map[string]any{"text": "All", "value": "$__all"},
+		}}},
+	}
+	if datasource == "" {
+		dashboard["__inputs"] = []any{map[string]string{
+			"name": "DS_PROMETHEUS", "label": "Prometheus", "description": "Prometheus scraping the proxy /metrics endpoint",
+			"type": "datasource", "pluginId": "prometheus", "pluginName": "Prometheus",
+		}}
+	}
+	return dashboard
+}
+
+// proxy-server grafana-dashboard [--title T] [--datasource UID] > dashboard.json
+func runGrafanaDashboardCommand(args []string) int {
+	flags := flag.NewFlagSet("grafana-dashboard", flag.ContinueOnError)
+	title := flags.String("title", "Coffee Proxy", "dashboard title")
+	datasource := flags.String("datasource", "", "Prometheus datasource UID (default: choose when importing)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: proxy-server grafana-dashboard [--title TITLE] [--datasource UID]")
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(grafanaDashboard(*title, *datasource)); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write dashboard: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package proxyserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/socks4"
+	"proxy_server/proxyserver/socks5"
+)
+
+// Bảo vệ khỏi client mở kết nối rồi không bao giờ gửi xong bắt tay (slowloris): mỗi kết nối nhận vào có hạn
+// đọc handshake_timeout tới khi tunnel được thiết lập, và số byte đọc trước đó bị giới hạn
+const (
+	defaultHandshakeTimeout = 10      // Giây
+	maxHandshakeBytes       = 8 << 10 // Đủ cho SOCKS5 với username, password và domain dài nhất
+)
+
+var (
+	errHandshakeTooLarge = errors.New("handshake too large")
+
+	handshakeTimeouts  atomic.Int64 // Số kết nối hết handshake_timeout khi đang bắt tay
+	handshakeOversized atomic.Int64 // Số kết nối gửi quá maxHandshakeBytes khi đang bắt tay
+)
+
+func handshakeTimeout() time.Duration {
+	switch {
+	case systemConfig().HandshakeTimeout < 0:
+		return 0
+	case systemConfig().HandshakeTimeout == 0:
+		return defaultHandshakeTimeout * time.Second
+	}
+	return time.Duration(systemConfig().HandshakeTimeout) * time.Second
+}
+
+// Đặt hạn đọc bắt tay cho kết nối vừa nhận; timeout của listener_tcp ngắn hơn thì giữ timeout đó
+func setHandshakeDeadline(conn net.Conn, tuning TCPTuning) {
+	timeout := handshakeTimeout()
+	if timeout <= 0 || (tuning.Timeout > 0 && time.Duration(tuning.Timeout)*time.Second <= timeout) {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// Reader đếm số byte đọc trong lúc bắt tay và ghi nhận kết nối hết hạn bắt tay
+type handshakeReader struct {
+	r        io.Reader
+	client   net.Addr
+	read     int
+	done     bool // Tunnel đã thiết lập, không còn giới hạn
+	timedOut bool
+}
+
+func (h *handshakeReader) Read(p []byte) (int, error) {
+	if h.done {
+		return h.r.Read(p)
+	}
+	if h.read >= maxHandshakeBytes {
+		if h.read == maxHandshakeBytes {
+			h.read++ // Chỉ đếm một lần
+			handshakeOversized.Add(1)
+			log.Printf("Handshake from %s exceeds %d bytes, closing", h.client, maxHandshakeBytes)
+		}
+		return 0, errHandshakeTooLarge
+	}
+	if len(p) > maxHandshakeBytes-h.read {
+		p = p[:maxHandshakeBytes-h.read]
+	}
+	n, err := h.r.Read(p)
+	h.read += n
+	if errors.Is(err, os.ErrDeadlineExceeded) && !h.timedOut {
+		h.timedOut = true
+		handshakeTimeouts.Add(1)
+	}
+	return n, err
+}
+
+// Kết thúc bắt tay khi tunnel đã thiết lập: bỏ hạn của listener và giới hạn byte
+func endHandshake(conn net.Conn) {
+	conn.SetDeadline(time.Time{})
+	if buffered, ok := conn.(*bufferedConn); ok && buffered.handshake != nil {
+		buffered.handshake.done = true
+	}
+}
+
+// Các trường bị từ chối khi client gửi sai hoặc quá dài, là nhãn field của proxy_malformed_requests_total
+const (
+	malformedMethods  = socks5.MalformedMethods
+	malformedUsername = "username"
+	malformedPassword = "password"
+	malformedDomain   = "domain"
+	malformedUserID   = socks4.MalformedUserID
+	malformedHTTP     = "http_request"
+
+	maxDomainLen      = 253      // Độ dài tối đa của tên miền, không tính dấu chấm cuối
+	maxDomainLabelLen = 63       // Độ dài tối đa của một nhãn trong tên miền
+	maxHTTPRequest    = 64 << 10 // Kích thước tối đa của request HTTP tới cổng SOCKS (trang bảo trì)
+)
+
+var malformedRequests = map[string]*atomic.Int64{
+	malformedMethods:  new(atomic.Int64),
+	malformedUsername: new(atomic.Int64),
+	malformedPassword: new(atomic.Int64),
+	malformedDomain:   new(atomic.Int64),
+	malformedUserID:   new(atomic.Int64),
+	malformedHTTP:     new(atomic.Int64),
+}
+
+// Ghi nhận yêu cầu sai định dạng; handler tự gửi reply phù hợp với giao thức
+func recordMalformed(field string, client net.Addr, detail string) {
+	malformedRequests[field].Add(1)
+	log.Printf("Malformed request from %s: %s %s", client, field, detail)
+}
+
+// Trả lời yêu cầu bị từ chối bằng reply của giao thức và ghi nhận trường sai định dạng; lỗi đọc và kết
+// nối không đúng giao thức chỉ được ghi log
+func rejectSocksRequest(conn net.Conn, proto string, err error, reply func(code byte) []byte) {
+	var socks4Err *socks4.RequestError
+	var socks5Err *socks5.RequestError
+	var code byte
+	var field, detail string
+	switch {
+	case errors.As(err, &socks4Err):
+		code, field, detail = socks4Err.Reply, socks4Err.Field, socks4Err.Detail
+	case errors.As(err, &socks5Err):
+		code, field, detail = socks5Err.Reply, socks5Err.Field, socks5Err.Detail
+	default:
+		log.Printf("%s handshake from %s failed: %v", proto, conn.RemoteAddr(), err)
+		return
+	}
+	if field != "" {
+		recordMalformed(field, conn.RemoteAddr(), detail)
+	}
+	conn.Write(reply(code))
+}
+
+// Tên miền hợp lệ theo độ dài (RFC 1035) và ký tự: chữ, số, '-', '_', mỗi nhãn 1-63 ký tự; cho phép dấu chấm cuối
+func validDomainName(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || len(domain) > maxDomainLen {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > maxDomainLabelLen {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
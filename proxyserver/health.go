@@ -0,0 +1,170 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Probe cho Kubernetes: /healthz (liveness) chỉ báo lỗi khi process bị treo, /readyz (readiness) báo
+// chưa sẵn sàng khi listener chưa mở, chưa nạp user, quá tải hoặc đang bảo trì để pod được bỏ khỏi Service
+// mà không bị khởi động lại
+const (
+	defaultReadinessWatermark = 90
+	livenessLockTimeout       = 2 * time.Second
+)
+
+// Kết quả một mục kiểm tra của /readyz
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type readinessReport struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// Kho user còn phản hồi: không giữ được khóa đọc trong livenessLockTimeout nghĩa là có goroutine bị kẹt
+func userStoreResponsive() bool {
+	deadline := time.Now().Add(livenessLockTimeout)
+	for !usersMutex.TryRLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	usersMutex.RUnlock()
+	return true
+}
+
+// GET /healthz
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !userStoreResponsive() {
+		admin.WriteError(w, http.StatusServiceUnavailable, "user store is not responding")
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /readyz
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := currentReadiness()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	admin.WriteJSON(w, status, report)
+}
+
+func currentReadiness() readinessReport {
+	report := readinessReport{Ready: true}
+	add := func(name string, ok bool, detail string) {
+		report.Checks = append(report.Checks, ReadinessCheck{Name: name, OK: ok, Detail: detail})
+		report.Ready = report.Ready && ok
+	}
+
+	missing, bound := proxyListenersBound()
+	switch {
+	case len(missing) > 0:
+		add("listeners", false, "not bound: "+strings.Join(missing, ", "))
+	case bound == 0:
+		add("listeners", false, "no proxy listener is open")
+	default:
+		add("listeners", true, fmt.Sprintf("%d open", bound))
+	}
+
+	usersMutex.RLock()
+	loaded, userCount := users != nil, len(users)
+	usersMutex.RUnlock()
+	if loaded {
+		add("users", true, fmt.Sprintf("%d loaded", userCount))
+	} else {
+		add("users", false, "user store not loaded")
+	}
+
+	if limit := systemConfig().MaxConnections; limit > 0 {
+		watermark := systemConfig().ReadinessWatermark
+		if watermark == 0 {
+			watermark = defaultReadinessWatermark
+		}
+		active := activeConnCredits()
+		add("connections", active*100 < limit*watermark, fmt.Sprintf("%d of %d (watermark %d%%)", active, limit, watermark))
+	}
+
+	if memoryHigh.Load() {
+		add("memory", false, fmt.Sprintf("above memory_limit_mb (%d MB)", systemConfig().MemoryLimitMB))
+	}
+	if maintenanceActive() {
+		add("maintenance", false, "maintenance mode is on")
+	}
+	return report
+}
+
+// Các listener nhận client được cấu hình nhưng chưa mở, và số listener nhận client đang mở.
+// Listener mở qua menu hoặc API cũng được tính khi system.conf không cấu hình listener nào
+func proxyListenersBound() (missing []string, bound int) {
+	var expected []string
+	enabled := map[string]bool{
+		familyIPv4:      systemConfig().SocksIPv4,
+		familyIPv6:      systemConfig().SocksIPv6,
+		familyDualStack: systemConfig().SocksDualStack,
+	}
+	for _, family := range []string{familyIPv4, familyIPv6, familyDualStack} {
+		if enabled[family] {
+			_, addr := socksFamilyAddr(family, "")
+			expected = append(expected, addr)
+		}
+	}
+	if systemConfig().CompressListen != "" {
+		expected = append(expected, systemConfig().CompressListen)
+	}
+	for _, obfs := range systemConfig().ObfsListeners {
+		expected = append(expected, obfs.Listen)
+	}
+	for _, offload := range systemConfig().TLSOffloads {
+		expected = append(expected, offload.Listen)
+	}
+
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	for _, addr := range expected {
+		_, open := activeListeners[addr]
+		if _, canonical := activeListeners[canonicalListenAddr(addr)]; !open && !canonical {
+			missing = append(missing, addr)
+		}
+	}
+	for _, registered := range activeListeners {
+		switch registered.kind {
+		case listenerSocks, listenerCompress, listenerObfs, listenerOffload:
+			bound++
+		}
+	}
+	return missing, bound
+}
+
+// Listener HTTP riêng chỉ phục vụ probe, không cần token, dùng khi admin API chỉ nghe trên localhost
+// hoặc bắt buộc chứng chỉ client
+func startHealthServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+
+	listener, err := listenTCP(listenerHealth, addr)
+	if err != nil {
+		log.Printf("Health listener error: %v", err)
+		return
+	}
+	log.Printf("Health probes served on %s", addr)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, MaxHeaderBytes: maxAdminHeader}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Health listener error: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package proxyserver
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"proxy_server/proxyserver/auth"
+)
+
+// Thông tin một kết nối được truyền cho các hook
+type ConnInfo struct {
+	Protocol string   // socks4, socks5 hoặc tls (TLS offload)
+	Listener string   // Địa chỉ listener nhận kết nối
+	Client   net.Addr // Địa chỉ client
+	Username string   // Rỗng khi kết nối không gắn với user
+	Dest     string   // host:port đích; OnConnectRequest có thể đổi trước khi kết nối
+	Egress   string   // IP egress ưu tiên (phải có trong egress_ip); OnConnectRequest có thể đặt
+	Session  string   // ID phiên của user, rỗng khi không gắn với user hoặc phiên bị tắt
+	Country  string   // Mã quốc gia của IP egress (tham số country trong username); OnConnectRequest có thể đổi
+
+	Unmetered bool // Đích khớp quy tắc unmetered của acl_file, đặt sau khi kết nối tới đích
+
+	session  *userSession
+	closeErr error // Lỗi khiến kết nối kết thúc trước khi truyền dữ liệu
+	internal bool  // Đích là chặng nội bộ (backend TLS offload): không áp dụng acl_file và rewrite_file
+}
+
+// Kết quả của kết nối khi kết thúc
+type CloseInfo struct {
+	Up       int64 // Số byte client gửi lên
+	Down     int64 // Số byte nhận về
+	Duration time.Duration
+	Reason   string // Lý do kết thúc như trong access log
+	Err      error  // nil khi client hoặc đích đóng kết nối bình thường; errors.Is khớp ErrQuotaExceeded, ErrDialRefused...
+}
+
+// Hook vòng đời kết nối. Các hook trả về lỗi sẽ từ chối kết nối (client nhận mã "not allowed").
+// Hook nào để nil thì bỏ qua
+type Hooks struct {
+	OnAuth           func(info *ConnInfo) error                  // Sau khi xác thực username/password thành công (SOCKS5)
+	OnConnectRequest func(info *ConnInfo) error                  // Trước khi kết nối tới đích
+	OnDialed         func(info *ConnInfo, target net.Conn) error // Sau khi đã kết nối tới đích, trước khi trả lời client
+	OnClose          func(info *ConnInfo, result CloseInfo)      // Khi kết nối có yêu cầu CONNECT kết thúc, kể cả khi bị từ chối hoặc kết nối lỗi
+}
+
+// Chuỗi hook đã đăng ký, chạy theo thứ tự; hook đầu tiên trả về lỗi dừng chuỗi
+var connHooks []Hooks
+
+func runAuthHooks(info *ConnInfo) error {
+	for _, hooks := range connHooks {
+		if hooks.OnAuth != nil {
+			if err := hooks.OnAuth(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Dịch vụ policy và script policy (nếu có) được hỏi theo đích client yêu cầu, sau đó mới áp dụng
+// quy tắc rewrite_file và chạy các hook của chương trình nhúng
+func runConnectRequestHooks(info *ConnInfo, user *User) error {
+	if !info.internal {
+		if err := checkACL(info.Dest); err != nil {
+			return err
+		}
+	}
+	if err := checkPolicy(info, user); err != nil {
+		return err
+	}
+	if err := checkPolicyScript(info, user); err != nil {
+		return err
+	}
+	if !info.internal {
+		info.Dest = rewriteDest(info.Dest)
+	}
+	for _, hooks := range connHooks {
+		if hooks.OnConnectRequest != nil {
+			if err := hooks.OnConnectRequest(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runDialedHooks(info *ConnInfo, target net.Conn) error {
+	if info.session != nil {
+		info.session.recordEgress(target)
+	}
+	if !info.internal {
+		if err := checkACLResolved(info.Dest, target); err != nil {
+			return err
+		}
+		info.Unmetered = unmeteredDest(info.Dest, target)
+	}
+	for _, hooks := range connHooks {
+		if hooks.OnDialed != nil {
+			if err := hooks.OnDialed(info, target); err != nil {
+				return err
+			}
+		}
+	}
+	publishEvent(connEvent(eventConnectionOpen, info))
+	return nil
+}
+
+func runCloseHooks(info *ConnInfo, up, down int64, started time.Time, reason string) {
+	result := CloseInfo{Up: up, Down: down, Duration: time.Since(started), Reason: reason, Err: info.closeErr}
+	if result.Err == nil {
+		result.Err = closeError(reason)
+	}
+	for _, hooks := range connHooks {
+		if hooks.OnClose != nil {
+			hooks.OnClose(info, result)
+		}
+	}
+}
+
+// Ghi access log và chạy hook OnClose khi kết nối kết thúc
+func finishConn(info *ConnInfo, user *User, up, down int64, started time.Time, reason string) {
+	connsServed.Add(1)
+	countListenerConn(info.Listener, up, down)
+	if info.session != nil {
+		info.session.finish(up, down)
+	}
+	// Dữ liệu tới đích unmetered không tính vào dữ liệu đã dùng của user
+	if info.Unmetered {
+		unmeteredBytes.Add(up + down)
+	} else if user != nil {
+		addDataUsage(user, up+down)
+		clusterCountUsage(user.Username, up+down)
+		agentCountUsage(user.Username, up+down)
+	}
+	logAccess(user, info.Client.String(), info.Listener, info.Dest, info.Session, info.Unmetered, up, down, started, reason)
+	recordStatsdTiming("tunnel.duration", time.Since(started))
+	recordTalker(info.Username, info.Dest, up+down)
+	recordTagBytes(user, up+down)
+	event := connEvent(eventConnectionClose, info)
+	event.Up, event.Down, event.DurationMs, event.Reason, event.Unmetered = up, down, time.Since(started).Milliseconds(), reason, info.Unmetered
+	if user != nil {
+		event.Tags = user.Tags
+	}
+	publishEvent(event)
+	runCloseHooks(info, up, down, started, reason)
+}
+
+// Cộng dữ liệu đã dùng của user, gửi cảnh báo quota khi vừa vượt ngưỡng
+func addDataUsage(user *User, n int64) {
+	usage := user.addDataUsage(n)
+	before := usage - n
+	checkQuotaWarning(user, before, usage)
+	journalUsage(user.Username, n)
+	if maxData := user.MaxData; maxData > 0 && before < maxData && usage >= maxData {
+		notifyOperators("User %s is over quota: %s of %s used", user.Username, formatBytes(usage), formatBytes(maxData))
+	}
+}
+
+// Kết nối bị hook hoặc dịch vụ policy từ chối
+func denyByHook(info *ConnInfo, user *User, started time.Time, err error) {
+	log.Printf("%s connection to %s refused: %v", info.Protocol, info.Dest, err)
+	failConn(info, user, started, newConnError(ClosePolicyDenied, err))
+}
+
+// Kết nối kết thúc trước khi truyền dữ liệu; lý do trong access log lấy từ err
+func failConn(info *ConnInfo, user *User, started time.Time, err *ConnError) {
+	info.closeErr = err
+	finishConn(info, user, 0, 0, started, err.Reason)
+}
+
+// Thông tin kết nối cho hook, username rỗng khi không có user.
+// Kết nối của user được gắn vào phiên và nhận IP egress của phiên nếu session_sticky_egress bật
+// hoặc phiên có tên; params là các tham số trong username SOCKS5.
+// Quốc gia trong username được ưu tiên hơn quốc gia của listener (socks_country)
+func newConnInfo(protocol, listener string, conn net.Conn, user *User, dest string, params auth.UsernameParams) *ConnInfo {
+	info := &ConnInfo{Protocol: protocol, Listener: listener, Client: conn.RemoteAddr(), Dest: dest, Country: params.Country}
+	if info.Country == "" {
+		info.Country = systemConfig().ListenerCountries[listener]
+	}
+	if user != nil {
+		info.Username = user.Username
+	}
+	if session := joinSession(user, info.Client, params); session != nil {
+		info.session, info.Session = session, session.id
+		info.Egress = session.stickyEgress()
+	}
+	return info
+}
@@ -0,0 +1,94 @@
+package proxyserver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Ngôn ngữ của menu và trạng thái trên console: language trong system.conf, nếu không thì theo
+// LC_ALL/LC_MESSAGES/LANG (vi_VN.UTF-8 -> vi), mặc định en. Log, audit và API luôn bằng tiếng Anh
+// để các công cụ đọc log không phụ thuộc ngôn ngữ. Mỗi thông báo có ID cố định; ID thiếu bản dịch
+// dùng bản tiếng Anh
+const (
+	langEnglish    = "en"
+	langVietnamese = "vi"
+)
+
+var consoleMessages = map[string]map[string]string{
+	"menu.title":               {langEnglish: "Menu:", langVietnamese: "Menu:"},
+	"menu.status":              {langEnglish: "1. Server status", langVietnamese: "1. Trạng thái server"},
+	"menu.start_ipv4":          {langEnglish: "2. Start Proxy/Socks4/Socks5 on IPv4", langVietnamese: "2. Tạo Proxy/Socks4/Socks5 cho IPv4"},
+	"menu.start_ipv6":          {langEnglish: "3. Start Proxy/Socks4/Socks5 on IPv6", langVietnamese: "3. Tạo Proxy/Socks4/Socks5 cho IPv6"},
+	"menu.stop":                {langEnglish: "4. Stop server", langVietnamese: "4. Dừng server"},
+	"menu.list_ipv6":           {langEnglish: "5. List IPv6 Proxy/Socks4/Socks5", langVietnamese: "5. Danh sách Proxy/Socks4/Socks5 cho IPv6"},
+	"menu.start_other":         {langEnglish: "6. Open a listener on another address", langVietnamese: "6. Mở listener tại địa chỉ khác"},
+	"menu.start_dual":          {langEnglish: "7. Start dual-stack Proxy/Socks4/Socks5 (IPv4 + IPv6 on one port)", langVietnamese: "7. Tạo Proxy/Socks4/Socks5 dual-stack (IPv4 + IPv6 trên một cổng)"},
+	"menu.maintenance":         {langEnglish: "8. Turn maintenance mode on/off", langVietnamese: "8. Bật/tắt chế độ bảo trì"},
+	"menu.connections":         {langEnglish: "9. Show open connections", langVietnamese: "9. Xem các kết nối đang mở"},
+	"menu.choose":              {langEnglish: "Choose an option: ", langVietnamese: "Chọn tùy chọn: "},
+	"menu.invalid_choice":      {langEnglish: "Invalid option. Please choose again.", langVietnamese: "Tùy chọn không hợp lệ. Vui lòng chọn lại."},
+	"menu.ipv6_prompt":         {langEnglish: "IPv6 address (empty = all): ", langVietnamese: "Địa chỉ IPv6 (bỏ trống = tất cả): "},
+	"menu.ipv6_invalid":        {langEnglish: "Invalid IPv6 address: %s", langVietnamese: "Địa chỉ IPv6 không hợp lệ: %s"},
+	"menu.ipv6_list":           {langEnglish: "IPv6 proxies: %v", langVietnamese: "Danh sách proxy IPv6: %v"},
+	"menu.address_prompt":      {langEnglish: "Address (e.g. 0.0.0.0:1081): ", langVietnamese: "Địa chỉ (ví dụ 0.0.0.0:1081): "},
+	"menu.protocol_prompt":     {langEnglish: "Protocol (socks or compress, default socks): ", langVietnamese: "Giao thức (socks hoặc compress, mặc định socks): "},
+	"menu.maintenance_off":     {langEnglish: "Maintenance mode turned off.", langVietnamese: "Đã tắt chế độ bảo trì."},
+	"menu.maintenance_prompt":  {langEnglish: "Message for clients (empty = maintenance_message): ", langVietnamese: "Thông báo cho client (bỏ trống = maintenance_message): "},
+	"menu.maintenance_on":      {langEnglish: "Maintenance mode turned on, %d open tunnels continue.", langVietnamese: "Đã bật chế độ bảo trì, %d tunnel đang mở vẫn tiếp tục."},
+	"menu.connections_prompt":  {langEnglish: "Filter, e.g. user=alice client=10.0.0.0/8 dest=example.com listener=0.0.0.0:1080 min_age=60 sort=bytes (empty = all): ", langVietnamese: "Bộ lọc, ví dụ user=alice client=10.0.0.0/8 dest=example.com listener=0.0.0.0:1080 min_age=60 sort=bytes (bỏ trống = tất cả): "},
+	"menu.connections_invalid": {langEnglish: "Invalid filter: %s", langVietnamese: "Bộ lọc không hợp lệ: %s"},
+
+	"listener.retrying":     {langEnglish: "Port %s is in use, retrying in the background (see option 1 for status).", langVietnamese: "Port %s đang bị chiếm, đang thử lại trong nền (xem trạng thái ở tùy chọn 1)."},
+	"listener.start_failed": {langEnglish: "Cannot start listener on %s: %v", langVietnamese: "Không thể khởi động listener trên %s: %v"},
+	"listener.none_running": {langEnglish: "Server is not running.", langVietnamese: "Server chưa chạy."},
+	"listener.running":      {langEnglish: "Running listeners: %s", langVietnamese: "Listener đang chạy: %s"},
+	"listener.stop_prompt":  {langEnglish: "Listener address to stop (empty = all): ", langVietnamese: "Địa chỉ listener cần dừng (bỏ trống = tất cả): "},
+	"listener.stop_failed":  {langEnglish: "Cannot stop listener %s: %v", langVietnamese: "Không thể dừng listener %s: %v"},
+
+	"status.running":        {langEnglish: "Server is running.", langVietnamese: "Server đang chạy."},
+	"status.stopped":        {langEnglish: "Server is stopped.", langVietnamese: "Server đã dừng."},
+	"status.version":        {langEnglish: "Version: %s", langVietnamese: "Phiên bản: %s"},
+	"status.profile":        {langEnglish: "Configuration profile: %s", langVietnamese: "Profile cấu hình: %s"},
+	"status.uptime":         {langEnglish: "Uptime: %s (since %s)", langVietnamese: "Thời gian chạy: %s (từ %s)"},
+	"status.connections":    {langEnglish: "Connections: %d open, %d tunnels relaying, %d handled", langVietnamese: "Kết nối: %d đang mở, %d tunnel đang truyền, %d đã xử lý"},
+	"status.data":           {langEnglish: "Data: %s up, %s down", langVietnamese: "Dữ liệu: %s gửi lên, %s nhận về"},
+	"status.users":          {langEnglish: "Users: %d", langVietnamese: "User: %d"},
+	"status.maintenance":    {langEnglish: "In maintenance since %s (%s): new tunnels are refused", langVietnamese: "Đang bảo trì từ %s (%s): tunnel mới bị từ chối"},
+	"status.no_listeners":   {langEnglish: "No listeners are open.", langVietnamese: "Không có listener nào đang mở."},
+	"status.listener_retry": {langEnglish: "  %-8s %-24s waiting for the address to be released, %d attempts, giving up at %s: %s", langVietnamese: "  %-8s %-24s đang chờ địa chỉ được giải phóng, đã thử %d lần, bỏ cuộc lúc %s: %s"},
+	"status.listener":       {langEnglish: "  %-8s %-24s %-4s %d connections, %s up, %s down, since %s", langVietnamese: "  %-8s %-24s %-4s %d kết nối, %s gửi lên, %s nhận về, từ %s"},
+
+	"connections.columns": {langEnglish: "ID|User|Client|Destination|Egress|Up|Down|Age", langVietnamese: "ID|User|Client|Đích|Egress|Gửi lên|Nhận về|Thời gian"},
+	"connections.none":    {langEnglish: "No open connections match.", langVietnamese: "Không có kết nối đang mở nào khớp."},
+	"connections.more":    {langEnglish: "... and %d more (use GET /api/connections for the full list)", langVietnamese: "... và %d kết nối khác (xem đầy đủ qua GET /api/connections)"},
+}
+
+// Ngôn ngữ console theo cấu hình hoặc locale
+func consoleLanguage() string {
+	if systemConfig().Language != "" {
+		return systemConfig().Language
+	}
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			if strings.HasPrefix(value, langVietnamese) {
+				return langVietnamese
+			}
+			return langEnglish
+		}
+	}
+	return langEnglish
+}
+
+// Thông báo console theo ID, định dạng với args như fmt.Sprintf
+func msg(id string, args ...any) string {
+	translations, exists := consoleMessages[id]
+	if !exists {
+		return id
+	}
+	format, exists := translations[consoleLanguage()]
+	if !exists {
+		format = translations[langEnglish]
+	}
+	return fmt.Sprintf(format, args...)
+}
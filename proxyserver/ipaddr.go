@@ -0,0 +1,60 @@
+package proxyserver
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Xử lý địa chỉ IP dạng chữ trong đích và địa chỉ lắng nghe: IPv6 có zone ID (fe80::1%eth0),
+// IPv4-mapped (::ffff:192.0.2.1) và IPv6 trong ngoặc vuông
+
+// IP của host nếu host là địa chỉ IP dạng chữ (có thể trong ngoặc vuông, có zone ID);
+// địa chỉ IPv4-mapped được đưa về IPv4
+func parseHostIP(host string) (netip.Addr, bool) {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Như parseHostIP nhưng trả về net.IP (bỏ zone ID), nil khi host không phải IP
+func hostIP(host string) net.IP {
+	addr, ok := parseHostIP(host)
+	if !ok {
+		return nil
+	}
+	return net.IP(addr.AsSlice())
+}
+
+// Đích host:port của một địa chỉ IP: IPv6 trong ngoặc vuông (giữ zone ID), IPv4-mapped viết dạng IPv4
+func ipDest(addr netip.Addr, port uint16) string {
+	return netip.AddrPortFrom(addr.Unmap(), port).String()
+}
+
+// Đích dạng domain nhưng thực chất là IP dạng chữ (ví dụ "[::1]" hoặc "::ffff:10.0.0.1" trong trường
+// domain của SOCKS5) được viết lại dạng chuẩn, để ACL, rewrite và chọn IP egress xử lý như đích IP
+func ipLiteralDest(host string, port uint16) (string, bool) {
+	addr, ok := parseHostIP(host)
+	if !ok {
+		return "", false
+	}
+	return ipDest(addr, port), true
+}
+
+// Dạng chuẩn của địa chỉ lắng nghe host:port, để cấu hình theo listener (socks_dns, listener_tcp,
+// socks_country...) khớp dù IPv6 được viết khác nhau. Địa chỉ không hợp lệ được trả về nguyên vẹn
+func canonicalListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, ok := parseHostIP(host); ok {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, port)
+}
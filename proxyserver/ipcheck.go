@@ -0,0 +1,211 @@
+package proxyserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Trang "IP của tôi" dựng sẵn trong proxy (ip_check_host): kết nối tới http://<ip_check_host>/ qua proxy
+// được proxy tự trả lời. Tên này không tồn tại trong DNS công cộng, nên mở được trang nghĩa là
+// tên miền đã được phân giải phía server; IP public được lấy bằng cách gọi egress_check_url
+// theo đúng đường đi (IP egress, upstream, quốc gia, tag) mà kết nối thường của user sẽ dùng
+const (
+	ipCheckPort    = "80"
+	ipCheckTimeout = 30 * time.Second
+)
+
+// Kết quả trả về cho client
+type IPCheckResult struct {
+	IP       string   `json:"ip,omitempty"`     // IP public mà egress_check_url nhìn thấy
+	Error    string   `json:"error,omitempty"`  // Lỗi khi gọi egress_check_url
+	Source   string   `json:"source,omitempty"` // Địa chỉ nguồn cục bộ (IP egress) khi kết nối trực tiếp
+	Route    string   `json:"route"`            // direct, upstream hoặc custom
+	User     string   `json:"user,omitempty"`
+	Country  string   `json:"country,omitempty"`
+	DNS      string   `json:"dns"`                   // Nơi phân giải tên miền đích: luôn là proxy khi tới được trang này
+	Resolver string   `json:"resolver"`              // system, upstream, custom hoặc địa chỉ DNS server riêng
+	Servers  []string `json:"dns_servers,omitempty"` // Nameserver trong /etc/resolv.conf khi resolver là system
+}
+
+// Đích là trang kiểm tra IP dựng sẵn
+func isIPCheckDest(destAddr string) bool {
+	if systemConfig().IPCheckHost == "" {
+		return false
+	}
+	host, port, err := net.SplitHostPort(destAddr)
+	if err != nil || port != ipCheckPort {
+		return false
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".") == systemConfig().IPCheckHost
+}
+
+// Đọc giá trị ip_check_host: tên miền, không phải IP
+func parseIPCheckHost(value string) (string, error) {
+	host := strings.TrimSuffix(strings.ToLower(value), ".")
+	if host == "" || net.ParseIP(host) != nil || strings.ContainsAny(host, ":/ ") {
+		return "", fmt.Errorf("expected a host name, got %q", value)
+	}
+	return host, nil
+}
+
+// Trả về đầu client của một kết nối trong bộ nhớ mà proxy tự phục vụ trang kiểm tra IP
+func dialIPCheck(prefer string, user *User, egress, country string) net.Conn {
+	client, server := net.Pipe()
+	go serveIPCheck(server, prefer, user, egress, country)
+	return client
+}
+
+// Đọc một request HTTP và trả kết quả: / dạng JSON, /ip chỉ IP public dạng text
+func serveIPCheck(conn net.Conn, prefer string, user *User, egress, country string) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ipCheckTimeout))
+
+	request, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	response := &http.Response{
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    request,
+		Header:     make(http.Header),
+		Close:      true,
+	}
+	response.Header.Set("Cache-Control", "no-store")
+	var body []byte
+	switch request.URL.Path {
+	case "/", "/ip":
+		result := checkIPRoute(prefer, user, egress, country)
+		response.StatusCode = http.StatusOK
+		if request.URL.Path == "/ip" {
+			if result.IP == "" {
+				response.StatusCode = http.StatusBadGateway
+				body = []byte(result.Error + "\n")
+			} else {
+				body = []byte(result.IP + "\n")
+			}
+			response.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			body, _ = json.MarshalIndent(result, "", "  ")
+			body = append(body, '\n')
+			response.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		response.StatusCode = http.StatusNotFound
+		body = []byte("not found\n")
+		response.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	response.ContentLength = int64(len(body))
+	if request.Method != http.MethodHead {
+		response.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	response.Write(conn)
+}
+
+// Gọi egress_check_url theo đường đi của user để biết IP public và cách phân giải DNS
+func checkIPRoute(prefer string, user *User, egress, country string) IPCheckResult {
+	result := IPCheckResult{Route: "direct", Country: country, DNS: "proxy"}
+	if user != nil {
+		result.User = user.Username
+	}
+	result.Resolver, result.Servers = resolverDescription(user)
+	if dialerFor(user) != nil {
+		result.Route = "custom"
+	} else if upstreamsEnabled() {
+		result.Route = "upstream"
+	}
+
+	checkURL := systemConfig().EgressCheckURL
+	if checkURL == "" {
+		checkURL = defaultEgressCheckURL
+	}
+	client := http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, addr string) (net.Conn, error) {
+				conn, err := dialTarget(addr, prefer, user, egress, country)
+				if err == nil && result.Route == "direct" {
+					if local, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+						result.Source = local.IP.String()
+					}
+				}
+				return conn, err
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Get(checkURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("unexpected status %s from %s", resp.Status, checkURL)
+		return result
+	}
+	text, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	publicIP := net.ParseIP(strings.TrimSpace(string(text)))
+	if publicIP == nil {
+		result.Error = fmt.Sprintf("unexpected response %q from %s", strings.TrimSpace(string(text)), checkURL)
+		return result
+	}
+	result.IP = publicIP.String()
+	return result
+}
+
+// Resolver dùng cho tên miền đích của user và các nameserver của nó nếu biết
+func resolverDescription(user *User) (string, []string) {
+	switch {
+	case dialerFor(user) != nil:
+		return "custom", nil
+	case upstreamsEnabled():
+		return "upstream", nil // Upstream nhận tên miền và tự phân giải
+	}
+	if userResolverFunc != nil && user != nil && userResolverFunc(user.Username) != nil {
+		return "custom", nil
+	}
+	if overrides := dnsOverrideRules.Load(); overrides != nil {
+		for _, scope := range dnsScopes(user) {
+			if server, exists := overrides.servers[scope]; exists {
+				return server, nil
+			}
+		}
+	}
+	if serverResolver != nil {
+		return "custom", nil
+	}
+	return "system", systemNameservers()
+}
+
+// Các dòng nameserver trong /etc/resolv.conf; rỗng khi không đọc được (ví dụ trên Windows)
+func systemNameservers() []string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
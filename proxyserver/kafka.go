@@ -0,0 +1,422 @@
+package proxyserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Producer Kafka tối giản: Metadata v4 để tìm leader của partition, Produce v3 với RecordBatch v2 (acks=1),
+// SASL PLAIN khi URL có user:password và TLS với kafka+tls://. Không nén, không idempotent
+const (
+	kafkaProduce          = 0
+	kafkaMetadata         = 3
+	kafkaSaslHandshake    = 17
+	kafkaSaslAuthenticate = 36
+	kafkaMaxResponse      = 64 << 20
+	kafkaProduceTimeout   = 10000 // Mili giây broker chờ ghi
+)
+
+var kafkaCRC = crc32.MakeTable(crc32.Castagnoli)
+
+type kafkaProducer struct {
+	bootstrap []string
+	useTLS    bool
+	user      string
+	password  string
+	brokers   map[int32]string      // node id -> host:port
+	leaders   map[string][]int32    // topic -> leader của từng partition
+	conns     map[string]*kafkaConn // Theo địa chỉ broker
+}
+
+func newKafkaProducer(u *url.URL) *kafkaProducer {
+	producer := &kafkaProducer{
+		bootstrap: strings.Split(u.Host, ","),
+		useTLS:    u.Scheme == "kafka+tls",
+		brokers:   make(map[int32]string),
+		leaders:   make(map[string][]int32),
+		conns:     make(map[string]*kafkaConn),
+	}
+	if u.User != nil {
+		producer.user = u.User.Username()
+		producer.password, _ = u.User.Password()
+	}
+	return producer
+}
+
+func (k *kafkaProducer) close() {
+	for address, conn := range k.conns {
+		conn.conn.Close()
+		delete(k.conns, address)
+	}
+}
+
+// Kết nối (dùng lại) tới broker, xác thực SASL PLAIN nếu có
+func (k *kafkaProducer) connect(address string) (*kafkaConn, error) {
+	if conn := k.conns[address]; conn != nil {
+		return conn, nil
+	}
+	raw, err := net.DialTimeout("tcp", address, eventBusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if k.useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConn := tls.Client(raw, &tls.Config{ServerName: host})
+		tlsConn.SetDeadline(time.Now().Add(eventBusTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		raw = tlsConn
+	}
+	conn := &kafkaConn{conn: raw, r: bufio.NewReader(raw)}
+	if k.user != "" {
+		if err := conn.saslPlain(k.user, k.password); err != nil {
+			raw.Close()
+			return nil, err
+		}
+	}
+	k.conns[address] = conn
+	return conn, nil
+}
+
+// Đọc metadata của các topic từ broker khởi đầu đầu tiên trả lời
+func (k *kafkaProducer) refreshMetadata(topics []string) error {
+	var request []byte
+	request = binary.BigEndian.AppendUint32(request, uint32(len(topics)))
+	for _, topic := range topics {
+		request = kafkaAppendString(request, topic)
+	}
+	request = append(request, 1) // allow_auto_topic_creation
+
+	var lastErr error
+	for _, address := range k.bootstrap {
+		address = strings.TrimSpace(address)
+		conn, err := k.connect(address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		response, err := conn.request(kafkaMetadata, 4, request)
+		if err != nil {
+			conn.conn.Close()
+			delete(k.conns, address)
+			lastErr = err
+			continue
+		}
+		return k.parseMetadata(response)
+	}
+	return lastErr
+}
+
+func (k *kafkaProducer) parseMetadata(data []byte) error {
+	r := &kafkaReader{data: data}
+	r.int32() // throttle_time_ms
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		node := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		k.brokers[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster_id
+	r.int32()  // controller_id
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		code := r.int16()
+		topic := r.string()
+		r.bytes(1) // is_internal
+		var leaders []int32
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			r.int16() // error_code của partition
+			partition := r.int32()
+			leader := r.int32()
+			r.bytes(4 * int(r.int32())) // replicas
+			r.bytes(4 * int(r.int32())) // isr
+			for int(partition) >= len(leaders) {
+				leaders = append(leaders, -1)
+			}
+			leaders[partition] = leader
+		}
+		if code != 0 {
+			return fmt.Errorf("kafka topic %s: %s", topic, kafkaErrorText(code))
+		}
+		k.leaders[topic] = leaders
+	}
+	return r.err
+}
+
+func (k *kafkaProducer) publish(messages []busMessage) error {
+	var missing []string
+	for _, message := range messages {
+		if _, known := k.leaders[message.topic]; !known && !containsString(missing, message.topic) {
+			missing = append(missing, message.topic)
+		}
+	}
+	if len(missing) > 0 {
+		if err := k.refreshMetadata(missing); err != nil {
+			return err
+		}
+	}
+
+	// Gom theo broker leader, rồi theo topic và partition
+	batches := make(map[int32]map[string]map[int32][]busMessage)
+	for _, message := range messages {
+		leaders := k.leaders[message.topic]
+		if len(leaders) == 0 {
+			return fmt.Errorf("kafka topic %s has no partitions", message.topic)
+		}
+		hash := fnv.New32a()
+		hash.Write(message.key)
+		partition := int32(hash.Sum32() % uint32(len(leaders)))
+		leader := leaders[partition]
+		if leader < 0 {
+			delete(k.leaders, message.topic)
+			return fmt.Errorf("kafka topic %s partition %d has no leader", message.topic, partition)
+		}
+		if batches[leader] == nil {
+			batches[leader] = make(map[string]map[int32][]busMessage)
+		}
+		if batches[leader][message.topic] == nil {
+			batches[leader][message.topic] = make(map[int32][]busMessage)
+		}
+		batches[leader][message.topic][partition] = append(batches[leader][message.topic][partition], message)
+	}
+
+	for leader, topics := range batches {
+		address, known := k.brokers[leader]
+		if !known {
+			k.leaders = make(map[string][]int32)
+			return fmt.Errorf("kafka broker %d unknown", leader)
+		}
+		if err := k.produce(address, topics); err != nil {
+			// Leader có thể đã đổi: đọc lại metadata ở lần thử sau
+			k.leaders = make(map[string][]int32)
+			return err
+		}
+	}
+	return nil
+}
+
+// Gửi một Produce request tới broker và kiểm tra lỗi của từng partition
+func (k *kafkaProducer) produce(address string, topics map[string]map[int32][]busMessage) error {
+	var request []byte
+	request = binary.BigEndian.AppendUint16(request, 0xffff) // transactional_id = null
+	request = binary.BigEndian.AppendUint16(request, 1)      // acks
+	request = binary.BigEndian.AppendUint32(request, kafkaProduceTimeout)
+	request = binary.BigEndian.AppendUint32(request, uint32(len(topics)))
+	for topic, partitions := range topics {
+		request = kafkaAppendString(request, topic)
+		request = binary.BigEndian.AppendUint32(request, uint32(len(partitions)))
+		for partition, messages := range partitions {
+			batch := kafkaRecordBatch(messages)
+			request = binary.BigEndian.AppendUint32(request, uint32(partition))
+			request = binary.BigEndian.AppendUint32(request, uint32(len(batch)))
+			request = append(request, batch...)
+		}
+	}
+
+	conn, err := k.connect(address)
+	if err != nil {
+		return err
+	}
+	response, err := conn.request(kafkaProduce, 3, request)
+	if err != nil {
+		conn.conn.Close()
+		delete(k.conns, address)
+		return err
+	}
+	r := &kafkaReader{data: response}
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		topic := r.string()
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			partition := r.int32()
+			code := r.int16()
+			r.bytes(16) // base_offset, log_append_time
+			if code != 0 {
+				return fmt.Errorf("kafka produce %s/%d: %s", topic, partition, kafkaErrorText(code))
+			}
+		}
+	}
+	return r.err
+}
+
+// RecordBatch v2 (magic 2) không nén; CRC-32C tính từ attributes tới hết batch
+func kafkaRecordBatch(messages []busMessage) []byte {
+	first := messages[0].time.UnixMilli()
+	last := first
+	var records []byte
+	for i, message := range messages {
+		timestamp := message.time.UnixMilli()
+		last = max(last, timestamp)
+		var record []byte
+		record = append(record, 0) // attributes
+		record = binary.AppendVarint(record, timestamp-first)
+		record = binary.AppendVarint(record, int64(i))
+		record = binary.AppendVarint(record, int64(len(message.key)))
+		record = append(record, message.key...)
+		record = binary.AppendVarint(record, int64(len(message.value)))
+		record = append(record, message.value...)
+		record = binary.AppendVarint(record, 0) // headers
+		records = binary.AppendVarint(records, int64(len(record)))
+		records = append(records, record...)
+	}
+
+	var body []byte
+	body = binary.BigEndian.AppendUint32(body, 0xffffffff) // partition_leader_epoch
+	body = append(body, 2)                                 // magic
+	body = binary.BigEndian.AppendUint32(body, 0)          // crc, điền sau
+	body = binary.BigEndian.AppendUint16(body, 0)          // attributes
+	body = binary.BigEndian.AppendUint32(body, uint32(len(messages)-1))
+	body = binary.BigEndian.AppendUint64(body, uint64(first))
+	body = binary.BigEndian.AppendUint64(body, uint64(last))
+	body = binary.BigEndian.AppendUint64(body, 0xffffffffffffffff) // producer_id
+	body = binary.BigEndian.AppendUint16(body, 0xffff)             // producer_epoch
+	body = binary.BigEndian.AppendUint32(body, 0xffffffff)         // base_sequence
+	body = binary.BigEndian.AppendUint32(body, uint32(len(messages)))
+	body = append(body, records...)
+	binary.BigEndian.PutUint32(body[5:9], crc32.Checksum(body[9:], kafkaCRC))
+
+	var batch []byte
+	batch = binary.BigEndian.AppendUint64(batch, 0) // base_offset
+	batch = binary.BigEndian.AppendUint32(batch, uint32(len(body)))
+	return append(batch, body...)
+}
+
+// Một kết nối tới broker
+type kafkaConn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	correlation int32
+}
+
+// Gửi request (header v1) và đọc response (header v0)
+func (c *kafkaConn) request(apiKey, version int16, body []byte) ([]byte, error) {
+	c.correlation++
+	var message []byte
+	message = binary.BigEndian.AppendUint16(message, uint16(apiKey))
+	message = binary.BigEndian.AppendUint16(message, uint16(version))
+	message = binary.BigEndian.AppendUint32(message, uint32(c.correlation))
+	message = kafkaAppendString(message, eventBusClientName)
+	message = append(message, body...)
+
+	c.conn.SetDeadline(time.Now().Add(eventBusTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write(binary.BigEndian.AppendUint32(nil, uint32(len(message)))); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[0:4]))
+	if size < 4 || size > kafkaMaxResponse {
+		return nil, fmt.Errorf("kafka response too large (%d bytes)", size)
+	}
+	if int32(binary.BigEndian.Uint32(header[4:8])) != c.correlation {
+		return nil, errors.New("kafka response out of order")
+	}
+	response := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// SaslHandshake v1 rồi SaslAuthenticate v0 với cơ chế PLAIN
+func (c *kafkaConn) saslPlain(user, password string) error {
+	response, err := c.request(kafkaSaslHandshake, 1, kafkaAppendString(nil, "PLAIN"))
+	if err != nil {
+		return err
+	}
+	r := &kafkaReader{data: response}
+	if code := r.int16(); code != 0 {
+		return fmt.Errorf("kafka SASL handshake: %s", kafkaErrorText(code))
+	}
+
+	token := "\x00" + user + "\x00" + password
+	request := binary.BigEndian.AppendUint32(nil, uint32(len(token)))
+	response, err = c.request(kafkaSaslAuthenticate, 0, append(request, token...))
+	if err != nil {
+		return err
+	}
+	r = &kafkaReader{data: response}
+	if code := r.int16(); code != 0 {
+		return fmt.Errorf("kafka SASL authentication: %s: %s", kafkaErrorText(code), r.string())
+	}
+	return r.err
+}
+
+func kafkaAppendString(b []byte, value string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// Đọc response theo thứ tự trường; lỗi đầu tiên được giữ trong err
+type kafkaReader struct {
+	data []byte
+	err  error
+}
+
+func (r *kafkaReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		if r.err == nil {
+			r.err = errors.New("kafka response truncated")
+		}
+		return nil
+	}
+	value := r.data[:n]
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.bytes(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.bytes(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// Chuỗi có độ dài int16; -1 là null
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.bytes(int(n)))
+}
+
+// Tên một số mã lỗi thường gặp của Kafka
+func kafkaErrorText(code int16) string {
+	names := map[int16]string{
+		3: "UNKNOWN_TOPIC_OR_PARTITION", 5: "LEADER_NOT_AVAILABLE", 6: "NOT_LEADER_OR_FOLLOWER",
+		7: "REQUEST_TIMED_OUT", 10: "MESSAGE_TOO_LARGE", 17: "INVALID_TOPIC_EXCEPTION",
+		19: "NOT_ENOUGH_REPLICAS", 29: "TOPIC_AUTHORIZATION_FAILED", 33: "UNSUPPORTED_SASL_MECHANISM",
+		35: "UNSUPPORTED_VERSION", 58: "SASL_AUTHENTICATION_FAILED",
+	}
+	if name, known := names[code]; known {
+		return name
+	}
+	return "error " + strconv.Itoa(int(code))
+}
@@ -0,0 +1,167 @@
+package proxyserver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Đếm byte trong kernel (kernel_accounting): tunnel TCP thuần ở cả hai phía được chép bằng splice, không đi
+// qua bộ đếm trên từng lần ghi. Bộ đếm của tunnel, user và toàn server được cập nhật định kỳ từ số byte
+// kernel đã nhận trên mỗi socket (TCP_INFO) trừ phần chưa được đọc; khi tunnel kết thúc, số byte chính
+// xác từ kết quả chép được bù vào
+const defaultKernelAccountingInterval = time.Second
+
+// Trạng thái đếm của một tunnel
+type kernelAccount struct {
+	tunnel           *activeTunnel
+	clientRelay      net.Conn // Kết nối client dùng để chép: socket TCP hoặc socket kTLS
+	client, target   *net.TCPConn
+	baseUp, baseDown uint64 // Số byte đã đọc từ mỗi socket lúc bắt đầu relay
+	countsUp         bool   // Client là socket kTLS: TCP_INFO tính cả phần mã hóa, chiều lên được đếm khi đọc
+
+	mu                   sync.Mutex
+	syncedUp, syncedDown int64 // Số byte đã cộng vào bộ đếm
+	done                 bool
+}
+
+var (
+	kernelAccounts      = make(map[*kernelAccount]struct{})
+	kernelAccountsMutex sync.Mutex
+	kernelTunnels       atomic.Int64 // Số tunnel đã được đếm trong kernel
+)
+
+// Kết nối để chép trực tiếp và socket TCP của nó, bỏ qua các lớp bọc không đổi dữ liệu; nil khi dữ liệu
+// đi qua TLS trong userspace, nén, obfs hoặc còn nằm trong bộ đệm của server
+func relayConn(conn net.Conn) (net.Conn, *net.TCPConn) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, c
+		case *ktlsConn:
+			return c, c.TCPConn
+		case *egressConn:
+			conn = c.Conn
+		case *bufferedConn:
+			if c.reader.Buffered() > 0 || (c.handshake != nil && !c.handshake.done) {
+				return nil, nil
+			}
+			conn = c.Conn
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// Bắt đầu đếm trong kernel cho tunnel; nil nếu không bật, kết nối không phải TCP thuần hoặc kernel
+// không trả về TCP_INFO, khi đó tunnel được đếm trong userspace như bình thường
+func startKernelAccount(tunnel *activeTunnel, src, dst net.Conn) *kernelAccount {
+	if !systemConfig().KernelAccounting {
+		return nil
+	}
+	clientRelay, client := relayConn(src)
+	_, target := relayConn(dst)
+	if client == nil || target == nil {
+		return nil
+	}
+	baseUp, errUp := tcpBytesRead(client)
+	baseDown, errDown := tcpBytesRead(target)
+	if errUp != nil || errDown != nil {
+		return nil
+	}
+
+	_, countsUp := clientRelay.(*ktlsConn)
+	account := &kernelAccount{tunnel: tunnel, clientRelay: clientRelay, client: client, target: target, baseUp: baseUp, baseDown: baseDown, countsUp: countsUp}
+	kernelAccountsMutex.Lock()
+	kernelAccounts[account] = struct{}{}
+	kernelAccountsMutex.Unlock()
+	kernelTunnels.Add(1)
+	return account
+}
+
+// Cộng phần đã đọc khỏi socket kể từ lần cập nhật trước
+func (a *kernelAccount) sync() {
+	up, errUp := tcpBytesRead(a.client)
+	down, errDown := tcpBytesRead(a.target)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.done {
+		return
+	}
+	var moved int64
+	if errUp == nil && !a.countsUp {
+		moved += addKernelBytes(&a.syncedUp, int64(up-a.baseUp), &a.tunnel.up, &bytesUpTotal)
+	}
+	if errDown == nil {
+		moved += addKernelBytes(&a.syncedDown, int64(down-a.baseDown), &a.tunnel.down, &bytesDownTotal)
+	}
+	if moved > 0 {
+		a.tunnel.touch()
+		a.tunnel.addUsage(moved)
+		// Dữ liệu đi qua splice không dừng được giữa chừng: hết max_data thì đóng tunnel ở lần cập nhật này
+		if remaining, limited := a.tunnel.quotaRemaining(); limited && remaining <= 0 {
+			a.tunnel.close(CloseQuotaExceeded)
+		}
+	}
+}
+
+// Cộng phần tăng thêm vào bộ đếm, trả về số byte đã cộng
+func addKernelBytes(synced *int64, count int64, n, total *atomic.Int64) int64 {
+	delta := count - *synced
+	if delta <= 0 {
+		return 0
+	}
+	*synced = count
+	n.Add(delta)
+	total.Add(delta)
+	return delta
+}
+
+// Kết thúc đếm với số byte đã chép mỗi chiều. Dữ liệu đã đọc vào pipe của splice có thể chưa kịp
+// chuyển tiếp, nên phần chênh lệch (có thể âm) được bù để bộ đếm khớp với số byte thực sự đã chuyển
+func (a *kernelAccount) finish(up, down int64) {
+	kernelAccountsMutex.Lock()
+	delete(kernelAccounts, a)
+	kernelAccountsMutex.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.done = true
+	if !a.countsUp {
+		a.tunnel.up.Add(up - a.syncedUp)
+		bytesUpTotal.Add(up - a.syncedUp)
+		a.tunnel.addUsage(up - a.syncedUp)
+	}
+	a.tunnel.down.Add(down - a.syncedDown)
+	bytesDownTotal.Add(down - a.syncedDown)
+	a.tunnel.addUsage(down - a.syncedDown)
+}
+
+func openKernelAccounts() int {
+	kernelAccountsMutex.Lock()
+	defer kernelAccountsMutex.Unlock()
+	return len(kernelAccounts)
+}
+
+// Cập nhật định kỳ bộ đếm của các tunnel được đếm trong kernel
+func runKernelAccounting() {
+	for {
+		interval := defaultKernelAccountingInterval
+		if systemConfig().KernelAccountingInterval > 0 {
+			interval = time.Duration(systemConfig().KernelAccountingInterval) * time.Second
+		}
+		time.Sleep(interval)
+
+		kernelAccountsMutex.Lock()
+		accounts := make([]*kernelAccount, 0, len(kernelAccounts))
+		for account := range kernelAccounts {
+			accounts = append(accounts, account)
+		}
+		kernelAccountsMutex.Unlock()
+		for _, account := range accounts {
+			account.sync()
+		}
+	}
+}
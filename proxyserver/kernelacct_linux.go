@@ -0,0 +1,37 @@
+//go:build linux
+
+package proxyserver
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+const kernelAccountingSupported = true
+
+// Số byte đã được đọc ra khỏi socket: tcpi_bytes_received (Linux 4.1 trở lên) trừ phần còn nằm trong
+// hàng đợi nhận. Kernel cũ hơn trả 0 nên bộ đếm chỉ được cập nhật khi tunnel kết thúc
+func tcpBytesRead(conn *net.TCPConn) (uint64, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var info *unix.TCPInfo
+	var queued int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO); sockErr == nil {
+			queued, sockErr = unix.IoctlGetInt(int(fd), unix.SIOCINQ)
+		}
+	}); err != nil {
+		return 0, err
+	}
+	if sockErr != nil {
+		return 0, sockErr
+	}
+	if uint64(queued) > info.Bytes_received {
+		return 0, nil
+	}
+	return info.Bytes_received - uint64(queued), nil
+}
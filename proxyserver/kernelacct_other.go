@@ -0,0 +1,16 @@
+//go:build !linux
+
+package proxyserver
+
+import (
+	"errors"
+	"net"
+)
+
+const kernelAccountingSupported = false
+
+var errKernelAccountingUnsupported = errors.New("kernel accounting is not supported on this platform")
+
+func tcpBytesRead(conn *net.TCPConn) (uint64, error) {
+	return 0, errKernelAccountingUnsupported
+}
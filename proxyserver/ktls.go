@@ -0,0 +1,166 @@
+package proxyserver
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Kernel TLS (ktls): bắt tay vẫn do crypto/tls thực hiện, sau đó khóa của phiên TLS 1.3 được nạp vào socket
+// để kernel mã hóa và giải mã. Socket khi đó đọc ghi dữ liệu gốc nên relay dùng được splice như kết nối
+// TCP thường. Kết nối TLS 1.2, bộ mã không hỗ trợ hoặc kernel từ chối thì tiếp tục mã hóa trong userspace
+var (
+	ktlsKernel       atomic.Int64 // Kết nối đã chuyển mã hóa sang kernel
+	ktlsUserspace    atomic.Int64 // Kết nối vẫn mã hóa trong userspace khi ktls bật
+	ktlsFallbackOnce sync.Once
+)
+
+// Bộ mã TLS 1.3 kernel hỗ trợ: độ dài khóa và hàm băm của HKDF
+type ktlsSuite struct {
+	keyLen int
+	hash   func() hash.Hash
+}
+
+var ktlsSuites = map[uint16]ktlsSuite{
+	tls.TLS_AES_128_GCM_SHA256:       {16, sha256.New},
+	tls.TLS_AES_256_GCM_SHA384:       {32, sha512.New384},
+	tls.TLS_CHACHA20_POLY1305_SHA256: {32, sha256.New},
+}
+
+// Kết nối TLS đã chuyển mã hóa sang kernel: đọc ghi trên socket là dữ liệu gốc
+type ktlsConn struct {
+	*net.TCPConn
+	raw      syscall.RawConn
+	notified atomic.Bool // Đã gửi close_notify
+}
+
+// Bắt tay TLS phía server của frontend (tls_offload, obfs_listen tls). Khi ktls bật và kết nối là TCP
+// thuần, mã hóa được chuyển sang kernel sau bắt tay nếu có thể
+func serverTLS(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	var tcpConn *net.TCPConn
+	if systemConfig().KTLS {
+		_, tcpConn = relayConn(conn)
+	}
+	if tcpConn == nil {
+		tlsConn := tls.Server(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	records := &tlsRecordReader{Conn: tcpConn}
+	keys := make(tlsKeyLog)
+	config = config.Clone()
+	// Vé phiên TLS 1.3 được gửi bằng khóa ứng dụng ngay sau bắt tay, làm lệch số thứ tự bản ghi
+	// mà kernel cần biết
+	config.SessionTicketsDisabled = true
+	if config.KeyLogWriter != nil {
+		config.KeyLogWriter = io.MultiWriter(config.KeyLogWriter, keys)
+	} else {
+		config.KeyLogWriter = keys
+	}
+	tlsConn := tls.Server(records, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	records.passthrough = true
+
+	state := tlsConn.ConnectionState()
+	suite, supported := ktlsSuites[state.CipherSuite]
+	clientSecret, serverSecret := keys["CLIENT_TRAFFIC_SECRET_0"], keys["SERVER_TRAFFIC_SECRET_0"]
+	if state.Version != tls.VersionTLS13 || !supported || clientSecret == nil || serverSecret == nil {
+		ktlsUserspace.Add(1)
+		return tlsConn, nil
+	}
+	clientKey, clientIV := tls13TrafficKeys(suite, clientSecret)
+	serverKey, serverIV := tls13TrafficKeys(suite, serverSecret)
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := installKernelTLS(raw, state.CipherSuite, clientKey, clientIV, serverKey, serverIV)
+	if err != nil {
+		// Chưa nạp khóa nhận thì socket vẫn nguyên trạng, tiếp tục với crypto/tls
+		if !installed {
+			ktlsFallbackOnce.Do(func() {
+				log.Printf("kTLS unavailable, falling back to userspace TLS: %v", err)
+			})
+			ktlsUserspace.Add(1)
+			return tlsConn, nil
+		}
+		return nil, err
+	}
+	ktlsKernel.Add(1)
+	return &ktlsConn{TCPConn: tcpConn, raw: raw}, nil
+}
+
+// Khóa và IV của một chiều từ traffic secret (RFC 8446 mục 7.3)
+func tls13TrafficKeys(suite ktlsSuite, secret []byte) (key, iv []byte) {
+	return hkdfExpandLabel(suite.hash, secret, "key", suite.keyLen), hkdfExpandLabel(suite.hash, secret, "iv", 12)
+}
+
+func hkdfExpandLabel(hash func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0) // Context rỗng
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(hash, secret, info), out)
+	return out
+}
+
+// Nhận secret của đúng một kết nối qua KeyLogWriter (định dạng NSS key log: nhãn, client random, secret)
+type tlsKeyLog map[string][]byte
+
+func (k tlsKeyLog) Write(line []byte) (int, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) == 3 {
+		if secret, err := hex.DecodeString(fields[2]); err == nil {
+			k[fields[0]] = secret
+		}
+	}
+	return len(line), nil
+}
+
+// Đọc từng bản ghi TLS một, không đọc trước dữ liệu của bản ghi sau, để khi bắt tay xong không còn dữ liệu
+// của client nằm lại trong tls.Conn; phần chưa đọc được kernel giải mã sau khi nạp khóa
+type tlsRecordReader struct {
+	net.Conn
+	header      [5]byte
+	headerRead  int
+	body        int  // Số byte còn lại của bản ghi hiện tại
+	passthrough bool // Bắt tay xong mà không chuyển sang kernel thì đọc như bình thường
+}
+
+func (r *tlsRecordReader) Read(p []byte) (int, error) {
+	if r.passthrough || len(p) == 0 {
+		return r.Conn.Read(p)
+	}
+	if r.body == 0 {
+		n, err := r.Conn.Read(p[:min(len(p), len(r.header)-r.headerRead)])
+		copy(r.header[r.headerRead:], p[:n])
+		r.headerRead += n
+		if r.headerRead == len(r.header) {
+			r.body = int(binary.BigEndian.Uint16(r.header[3:5]))
+			r.headerRead = 0
+		}
+		return n, err
+	}
+	n, err := r.Conn.Read(p[:min(len(p), r.body)])
+	r.body -= n
+	return n, err
+}
@@ -0,0 +1,174 @@
+//go:build linux
+
+package proxyserver
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Hằng số của kTLS (linux/tls.h) và loại bản ghi TLS
+const (
+	tlsTX            = 1
+	tlsRX            = 2
+	tlsSetRecordType = 1
+	tlsGetRecordType = 2
+
+	tls13Version              = 0x0304
+	tlsCipherAESGCM128        = 51
+	tlsCipherAESGCM256        = 52
+	tlsCipherChaCha20Poly1305 = 54
+
+	tlsRecordAlert           = 21
+	tlsRecordApplicationData = 23
+	tlsAlertWarning          = 1
+	tlsAlertCloseNotify      = 0
+)
+
+const ktlsSupported = true
+
+// Nạp khóa vào socket: TLS_RX (client gửi) rồi TLS_TX (server gửi), số thứ tự bản ghi bắt đầu từ 0.
+// Kernel 4.13-4.16 chỉ có TLS_TX nên TLS_RX được thử trước; lỗi ở bước này vẫn để socket nguyên trạng.
+// installed cho biết đã nạp khóa nhận, khi đó socket không còn dùng được với crypto/tls
+func installKernelTLS(raw syscall.RawConn, suite uint16, clientKey, clientIV, serverKey, serverIV []byte) (installed bool, err error) {
+	var cipher uint16
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		cipher = tlsCipherAESGCM128
+	case tls.TLS_AES_256_GCM_SHA384:
+		cipher = tlsCipherAESGCM256
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		cipher = tlsCipherChaCha20Poly1305
+	default:
+		return false, fmt.Errorf("unsupported cipher suite %s", tls.CipherSuiteName(suite))
+	}
+
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if sockErr = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_ULP, "tls"); sockErr != nil {
+			sockErr = fmt.Errorf("TCP_ULP: %v", sockErr)
+			return
+		}
+		if sockErr = unix.SetsockoptString(int(fd), unix.SOL_TLS, tlsRX, string(ktlsCryptoInfo(cipher, clientKey, clientIV))); sockErr != nil {
+			sockErr = fmt.Errorf("TLS_RX: %v", sockErr)
+			return
+		}
+		installed = true
+		if sockErr = unix.SetsockoptString(int(fd), unix.SOL_TLS, tlsTX, string(ktlsCryptoInfo(cipher, serverKey, serverIV))); sockErr != nil {
+			sockErr = fmt.Errorf("TLS_TX: %v", sockErr)
+		}
+	}); err != nil {
+		return false, err
+	}
+	return installed, sockErr
+}
+
+// struct tls12_crypto_info_*: version, cipher_type, iv, key, salt, rec_seq. Với AES-GCM, 4 byte đầu của
+// IV TLS 1.3 là salt; ChaCha20-Poly1305 dùng cả 12 byte làm iv và không có salt
+func ktlsCryptoInfo(cipher uint16, key, iv []byte) []byte {
+	info := binary.NativeEndian.AppendUint16(nil, tls13Version)
+	info = binary.NativeEndian.AppendUint16(info, cipher)
+	if cipher == tlsCipherChaCha20Poly1305 {
+		info = append(info, iv...)
+		info = append(info, key...)
+	} else {
+		info = append(info, iv[4:]...)
+		info = append(info, key...)
+		info = append(info, iv[:4]...)
+	}
+	return append(info, make([]byte, 8)...)
+}
+
+// Đọc dữ liệu đã giải mã. Bản ghi không phải dữ liệu được kernel báo qua control message:
+// close_notify là EOF, alert khác và thông điệp bắt tay sau bắt tay (KeyUpdate) kết thúc kết nối
+func (c *ktlsConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	oob := make([]byte, unix.CmsgSpace(1))
+	var n, oobn int
+	var recvErr error
+	if err := c.raw.Read(func(fd uintptr) bool {
+		n, oobn, _, _, recvErr = unix.Recvmsg(int(fd), p, oob, 0)
+		return recvErr != unix.EAGAIN
+	}); err != nil {
+		return 0, err
+	}
+	if recvErr != nil {
+		return 0, os.NewSyscallError("recvmsg", recvErr)
+	}
+
+	recordType := byte(tlsRecordApplicationData)
+	messages, _ := unix.ParseSocketControlMessage(oob[:oobn])
+	for _, message := range messages {
+		if message.Header.Level == unix.SOL_TLS && message.Header.Type == tlsGetRecordType && len(message.Data) > 0 {
+			recordType = message.Data[0]
+		}
+	}
+	switch recordType {
+	case tlsRecordApplicationData:
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	case tlsRecordAlert:
+		if n >= 2 && p[1] == tlsAlertCloseNotify {
+			return 0, io.EOF
+		}
+		if n >= 2 {
+			return 0, fmt.Errorf("peer sent TLS alert %d", p[1])
+		}
+		return 0, errors.New("peer sent a TLS alert")
+	default:
+		return 0, fmt.Errorf("unsupported TLS record type %d after handshake", recordType)
+	}
+}
+
+// Chép qua Read để nhận ra close_notify; splice thẳng từ socket sẽ coi nó là lỗi
+func (c *ktlsConn) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, struct{ io.Reader }{c})
+}
+
+// Gửi close_notify một lần, không chờ khi bộ đệm gửi đầy
+func (c *ktlsConn) closeNotify() {
+	if c.notified.Swap(true) {
+		return
+	}
+	oob := ktlsRecordType(tlsRecordAlert)
+	c.raw.Write(func(fd uintptr) bool {
+		unix.Sendmsg(int(fd), []byte{tlsAlertWarning, tlsAlertCloseNotify}, oob, nil, 0)
+		return true
+	})
+}
+
+func (c *ktlsConn) CloseWrite() error {
+	c.closeNotify()
+	return c.TCPConn.CloseWrite()
+}
+
+func (c *ktlsConn) Close() error {
+	c.closeNotify()
+	return c.TCPConn.Close()
+}
+
+// Control message TLS_SET_RECORD_TYPE cho sendmsg. Cmsghdr.Len dài 4 hoặc 8 byte tùy kiến trúc
+func ktlsRecordType(recordType byte) []byte {
+	oob := make([]byte, unix.CmsgSpace(1))
+	lenSize := unix.CmsgLen(0) - 8
+	if lenSize == 8 {
+		binary.NativeEndian.PutUint64(oob, uint64(unix.CmsgLen(1)))
+	} else {
+		binary.NativeEndian.PutUint32(oob, uint32(unix.CmsgLen(1)))
+	}
+	binary.NativeEndian.PutUint32(oob[lenSize:], unix.SOL_TLS)
+	binary.NativeEndian.PutUint32(oob[lenSize+4:], tlsSetRecordType)
+	oob[unix.CmsgLen(0)] = recordType
+	return oob
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package proxyserver
+
+import (
+	"errors"
+	"syscall"
+)
+
+const ktlsSupported = false
+
+var errKTLSUnsupported = errors.New("kTLS is not supported on this platform")
+
+func installKernelTLS(raw syscall.RawConn, suite uint16, clientKey, clientIV, serverKey, serverIV []byte) (bool, error) {
+	return false, errKTLSUnsupported
+}
@@ -0,0 +1,201 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lần đăng nhập gần nhất của mỗi user và các mạng client đã từng đăng nhập. Đăng nhập từ mạng chưa thấy
+// bao giờ gửi email login_new_ip cho user. Khi đặt last_seen_file, trạng thái được ghi mỗi phút và đọc lại
+// khi khởi động; nâng cấp nóng chuyển trạng thái sang process mới
+const (
+	lastSeenSaveInterval = time.Minute
+	maxKnownNetworks     = 20 // Số mạng client nhớ cho mỗi user, bỏ mạng lâu không dùng nhất khi vượt quá
+)
+
+// Lần đăng nhập thành công gần nhất, trả về qua user API. SOCKS không có user agent nên giao thức và
+// listener là gợi ý duy nhất về client
+type LastLogin struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Protocol string    `json:"protocol"`
+	Listener string    `json:"listener"`
+}
+
+type lastSeenState struct {
+	Last     LastLogin            `json:"last"`
+	Networks map[string]time.Time `json:"networks"` // Mạng client (như sharing_ipv4_prefix/sharing_ipv6_prefix) -> lần gần nhất
+}
+
+var (
+	lastSeen       = make(map[string]*lastSeenState) // Theo username
+	lastSeenDirty  bool
+	lastSeenClosed bool // Đã chuyển cho process mới khi nâng cấp nóng
+	lastSeenMutex  sync.Mutex
+	lastSeenOnce   sync.Once
+)
+
+// Ghi nhận đăng nhập thành công; gửi email nếu mạng client mới với user đã có lịch sử đăng nhập
+func recordLogin(user *User, info *ConnInfo) {
+	now := time.Now()
+	host, _, err := net.SplitHostPort(info.Client.String())
+	if err != nil {
+		host = info.Client.String()
+	}
+	network := clientNetwork(info.Client)
+
+	lastSeenMutex.Lock()
+	state := lastSeen[user.Username]
+	if state == nil {
+		state = &lastSeenState{Networks: make(map[string]time.Time)}
+		lastSeen[user.Username] = state
+	}
+	_, known := state.Networks[network]
+	firstLogin := len(state.Networks) == 0
+	state.Last = LastLogin{Time: now.UTC(), IP: host, Protocol: info.Protocol, Listener: info.Listener}
+	state.Networks[network] = now.UTC()
+	if len(state.Networks) > maxKnownNetworks {
+		oldest := ""
+		for candidate, seen := range state.Networks {
+			if oldest == "" || seen.Before(state.Networks[oldest]) {
+				oldest = candidate
+			}
+		}
+		delete(state.Networks, oldest)
+	}
+	lastSeenDirty = true
+	last := state.Last
+	lastSeenMutex.Unlock()
+
+	event := connEvent(eventAuthSuccess, info)
+	event.Dest, event.Tags = "", user.Tags
+	publishEvent(event)
+
+	if known || firstLogin {
+		return
+	}
+	log.Printf("User %s logged in from new network %s (%s)", user.Username, network, host)
+	usersMutex.RLock()
+	queueLoginEmail(user, last)
+	usersMutex.RUnlock()
+}
+
+// Lần đăng nhập gần nhất của user, nil khi chưa có
+func lastLoginOf(username string) *LastLogin {
+	lastSeenMutex.Lock()
+	defer lastSeenMutex.Unlock()
+	state := lastSeen[username]
+	if state == nil {
+		return nil
+	}
+	last := state.Last
+	return &last
+}
+
+// Đọc last_seen_file và bắt đầu ghi định kỳ
+func startLastSeen() error {
+	path := systemConfig().LastSeenFile
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		loaded := make(map[string]*lastSeenState)
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return err
+		}
+		mergeLastSeen(loaded)
+	}
+	lastSeenOnce.Do(func() { go runLastSeenSaver() })
+	return nil
+}
+
+// Gộp trạng thái đọc từ file hoặc nhận từ process cũ: giữ lần đăng nhập mới hơn, hợp các mạng client
+func mergeLastSeen(states map[string]*lastSeenState) {
+	lastSeenMutex.Lock()
+	defer lastSeenMutex.Unlock()
+	for username, incoming := range states {
+		if incoming == nil {
+			continue
+		}
+		state := lastSeen[username]
+		if state == nil {
+			state = &lastSeenState{Networks: make(map[string]time.Time)}
+			lastSeen[username] = state
+		}
+		if incoming.Last.Time.After(state.Last.Time) {
+			state.Last = incoming.Last
+		}
+		for network, seen := range incoming.Networks {
+			if seen.After(state.Networks[network]) {
+				state.Networks[network] = seen
+			}
+		}
+	}
+	lastSeenDirty = true
+}
+
+func runLastSeenSaver() {
+	for {
+		time.Sleep(lastSeenSaveInterval)
+		if err := saveLastSeen(); err != nil {
+			log.Printf("Last seen file error: %v", err)
+		}
+	}
+}
+
+// Ghi trạng thái vào last_seen_file nếu có thay đổi; bỏ user đã bị xóa
+func saveLastSeen() error {
+	path := systemConfig().LastSeenFile
+	if path == "" {
+		return nil
+	}
+	usersMutex.RLock()
+	lastSeenMutex.Lock()
+	for username := range lastSeen {
+		if _, exists := users[username]; !exists {
+			delete(lastSeen, username)
+		}
+	}
+	usersMutex.RUnlock()
+	if !lastSeenDirty || lastSeenClosed {
+		lastSeenMutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(lastSeen)
+	lastSeenDirty = false
+	lastSeenMutex.Unlock()
+	if err == nil {
+		err = writeFileAtomic(path, data)
+	}
+	if err != nil {
+		lastSeenMutex.Lock()
+		lastSeenDirty = true // Ghi lại ở lần sau
+		lastSeenMutex.Unlock()
+	}
+	return err
+}
+
+// Trạng thái chuyển cho process mới khi nâng cấp nóng; process này ngừng ghi last_seen_file
+func lastSeenHandoff() map[string]*lastSeenState {
+	lastSeenMutex.Lock()
+	defer lastSeenMutex.Unlock()
+	lastSeenClosed = true
+	handoff := make(map[string]*lastSeenState, len(lastSeen))
+	for username, state := range lastSeen {
+		networks := make(map[string]time.Time, len(state.Networks))
+		for network, seen := range state.Networks {
+			networks[network] = seen
+		}
+		handoff[username] = &lastSeenState{Last: state.Last, Networks: networks}
+	}
+	return handoff
+}
@@ -0,0 +1,179 @@
+package limits
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bộ lập lịch băng thông công bằng: dữ liệu của mọi tunnel đi qua một token bucket có tốc độ rate. Còn token
+// thì dữ liệu đi ngay; khi server bão hòa, các lần ghi phải chờ trong hàng đợi theo user và được phục vụ bằng
+// deficit round-robin, nên mỗi user có cùng phần băng thông bất kể số tunnel
+const (
+	fairQuantum = 32 << 10               // Số byte mỗi user được thêm mỗi lượt, cũng là kích thước tối đa một lần đọc
+	fairBurst   = 100 * time.Millisecond // Token tích lũy tối đa tính theo thời gian ở tốc độ rate
+)
+
+// Một lần ghi đang chờ token
+type fairRequest struct {
+	n     int
+	ready chan struct{}
+}
+
+// Hàng đợi của một user
+type fairQueue struct {
+	requests []*fairRequest
+	deficit  int
+}
+
+type Scheduler struct {
+	rate func() int64 // Byte/giây, <= 0 là tắt lập lịch
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	queues map[string]*fairQueue
+	active []string // User có lần ghi đang chờ, theo thứ tự lượt
+	inTurn bool     // User đầu hàng active đã được cộng quantum cho lượt hiện tại
+	wake   chan struct{}
+
+	waits     atomic.Int64 // Số lần ghi phải chờ vì server bão hòa
+	waitBytes atomic.Int64
+}
+
+// rate được đọc lại ở mỗi lần dùng nên đổi cấu hình có hiệu lực ngay. Cần chạy Run trong một goroutine
+func NewScheduler(rate func() int64) *Scheduler {
+	return &Scheduler{rate: rate, queues: make(map[string]*fairQueue), wake: make(chan struct{}, 1)}
+}
+
+// Cộng token theo thời gian đã trôi qua; gọi khi giữ s.mu
+func (s *Scheduler) refill(now time.Time) {
+	rate := float64(max(s.rate(), 0))
+	if !s.last.IsZero() {
+		s.tokens += now.Sub(s.last).Seconds() * rate
+	}
+	s.last = now
+	if burst := max(rate*fairBurst.Seconds(), fairQuantum); s.tokens > burst {
+		s.tokens = burst
+	}
+}
+
+// Chờ tới khi được ghi n byte (n <= fairQuantum) cho user
+func (s *Scheduler) wait(username string, n int) {
+	s.mu.Lock()
+	s.refill(time.Now())
+	if len(s.active) == 0 && s.tokens >= float64(n) {
+		s.tokens -= float64(n)
+		s.mu.Unlock()
+		return
+	}
+
+	request := &fairRequest{n: n, ready: make(chan struct{})}
+	queue := s.queues[username]
+	if queue == nil {
+		queue = &fairQueue{}
+		s.queues[username] = queue
+		s.active = append(s.active, username)
+	}
+	queue.requests = append(queue.requests, request)
+	s.mu.Unlock()
+
+	s.waits.Add(1)
+	s.waitBytes.Add(int64(n))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	<-request.ready
+}
+
+// Phục vụ hàng đợi bằng token hiện có, trả về số byte còn thiếu cho lần ghi kế tiếp (0 = hết hàng đợi);
+// gọi khi giữ s.mu
+func (s *Scheduler) serve() float64 {
+	for len(s.active) > 0 {
+		username := s.active[0]
+		queue := s.queues[username]
+		if !s.inTurn {
+			queue.deficit += fairQuantum
+			s.inTurn = true
+		}
+		head := queue.requests[0]
+		if queue.deficit < head.n {
+			// Hết phần của lượt này: phần còn lại để dành cho lượt sau, chuyển sang user kế tiếp
+			s.active = append(s.active[1:], username)
+			s.inTurn = false
+			continue
+		}
+		if s.rate() > 0 {
+			if s.tokens < float64(head.n) {
+				return float64(head.n) - s.tokens
+			}
+			s.tokens -= float64(head.n)
+		}
+		queue.deficit -= head.n
+		queue.requests = queue.requests[1:]
+		close(head.ready)
+		if len(queue.requests) == 0 {
+			delete(s.queues, username)
+			s.active = s.active[1:]
+			s.inTurn = false
+		}
+	}
+	return 0
+}
+
+// Chia token cho các lần ghi đang chờ; không bao giờ trả về
+func (s *Scheduler) Run() {
+	for {
+		s.mu.Lock()
+		s.refill(time.Now())
+		missing := s.serve()
+		s.mu.Unlock()
+
+		rate := float64(s.rate())
+		if missing == 0 || rate <= 0 {
+			<-s.wake
+			continue
+		}
+		delay := time.Duration(missing / rate * float64(time.Second))
+		time.Sleep(max(delay, time.Millisecond))
+	}
+}
+
+// Số user đang có lần ghi chờ token
+func (s *Scheduler) WaitingUsers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.active)
+}
+
+// Số lần ghi và số byte đã phải chờ vì server bão hòa
+func (s *Scheduler) Waits() (count, bytes int64) {
+	return s.waits.Load(), s.waitBytes.Load()
+}
+
+// Reader đọc tối đa fairQuantum byte mỗi lần và chờ bộ lập lịch trước khi trả dữ liệu cho bên ghi
+func (s *Scheduler) Reader(r io.Reader, username string) io.Reader {
+	return &fairReader{r: r, username: username, scheduler: s}
+}
+
+type fairReader struct {
+	r         io.Reader
+	username  string
+	scheduler *Scheduler
+}
+
+func (f *fairReader) Read(p []byte) (int, error) {
+	if f.scheduler.rate() <= 0 {
+		return f.r.Read(p)
+	}
+	if len(p) > fairQuantum {
+		p = p[:fairQuantum]
+	}
+	n, err := f.r.Read(p)
+	if n > 0 {
+		f.scheduler.wait(f.username, n)
+	}
+	return n, err
+}
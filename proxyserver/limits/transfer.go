@@ -0,0 +1,46 @@
+// Package limits chứa các bộ giới hạn dữ liệu của tunnel: hạn mức byte của một tunnel và bộ lập lịch băng
+// thông công bằng giữa các user. Giá trị cấu hình được truyền vào, package không đọc cấu hình của server
+package limits
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+var ErrTransferLimit = errors.New("per-connection transfer limit reached")
+
+// Hạn mức byte chung của cả hai chiều một tunnel (max_transfer của user)
+type TransferLimit struct {
+	remaining atomic.Int64
+}
+
+func NewTransferLimit(bytes int64) *TransferLimit {
+	limit := &TransferLimit{}
+	limit.remaining.Store(bytes)
+	return limit
+}
+
+// Reader trừ vào hạn mức, trả về ErrTransferLimit khi hết. Hai chiều đọc đồng thời nên tổng có thể vượt
+// hạn mức tối đa một lần đọc của chiều còn lại
+func (l *TransferLimit) Reader(r io.Reader) io.Reader {
+	return &transferLimitReader{r: r, limit: l}
+}
+
+type transferLimitReader struct {
+	r     io.Reader
+	limit *TransferLimit
+}
+
+func (l *transferLimitReader) Read(p []byte) (int, error) {
+	remaining := l.limit.remaining.Load()
+	if remaining <= 0 {
+		return 0, ErrTransferLimit
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.limit.remaining.Add(-int64(n))
+	return n, err
+}
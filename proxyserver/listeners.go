@@ -0,0 +1,298 @@
+package proxyserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Biến môi trường dùng khi nâng cấp nóng: danh sách địa chỉ listener được truyền sang
+// (theo thứ tự fd bắt đầu từ 3) và fd của socket trao đổi với process cũ
+const (
+	inheritListenersEnv = "PROXY_INHERIT_LISTENERS"
+	inheritNetworksEnv  = "PROXY_INHERIT_NETWORKS" // network@addr của các listener chỉ IPv4 hoặc chỉ IPv6
+	inheritStateFDEnv   = "PROXY_STATE_FD"
+)
+
+// Dòng process mới gửi qua socket trạng thái khi đã khởi động xong; process cũ chỉ drain sau khi nhận được
+const upgradeReadyMessage = "ready\n"
+
+// Loại listener, dùng để khởi động lại đúng dịch vụ sau khi nâng cấp
+const (
+	listenerSocks    = "socks"
+	listenerAdmin    = "admin"
+	listenerOffload  = "offload"
+	listenerCompress = "compress"
+	listenerObfs     = "obfs"
+	listenerHealth   = "health"
+	listenerCluster  = "cluster"
+	listenerUsage    = "usage"
+)
+
+type registeredListener struct {
+	kind     string
+	network  string // tcp4, tcp6 hoặc tcp (dual-stack khi địa chỉ là [::])
+	listener net.Listener
+	started  time.Time
+	stats    *listenerStats
+}
+
+// Bộ đếm riêng của một listener kể từ khi mở
+type listenerStats struct {
+	accepted  atomic.Int64 // Kết nối TCP đã nhận
+	conns     atomic.Int64 // Kết nối SOCKS đã xử lý xong
+	bytesUp   atomic.Int64
+	bytesDown atomic.Int64
+}
+
+var (
+	activeListeners    = make(map[string]registeredListener) // Các listener đang mở, theo địa chỉ
+	inheritedListeners = make(map[string]registeredListener) // Listener nhận từ process cũ, chưa dùng
+	listenersMutex     sync.Mutex
+	upgradeStateFile   *os.File // Socket trao đổi với process cũ khi được khởi động bằng nâng cấp nóng
+)
+
+// Mở listener TCP, ưu tiên dùng lại socket được truyền từ process cũ khi nâng cấp
+func listenTCP(kind, addr string) (net.Listener, error) {
+	return listenTCPNetwork(kind, "tcp", addr)
+}
+
+// Như listenTCP với network chỉ định: tcp4 hoặc tcp6 để chỉ nhận một họ địa chỉ.
+// Với tcp6 trên [::], socket đặt IPV6_V6ONLY nên listener IPv4 cùng port vẫn mở được
+func listenTCPNetwork(kind, network, addr string) (net.Listener, error) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	registered, inherited := inheritedListeners[addr]
+	if inherited {
+		delete(inheritedListeners, addr)
+		log.Printf("Reusing inherited listener on %s", addr)
+	} else {
+		var listenConfig net.ListenConfig
+		if listenerTCPTuning(addr).FastOpen {
+			listenConfig.Control = fastOpenListenControl
+		}
+		listener, err := listenConfig.Listen(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+		registered = registeredListener{kind: kind, network: network, listener: listener}
+	}
+
+	registered.started = time.Now()
+	registered.stats = new(listenerStats)
+	activeListeners[addr] = registered
+	return &countedListener{Listener: registered.listener, stats: registered.stats}, nil
+}
+
+// Họ địa chỉ mà listener nhận: ipv4, ipv6 hoặc dual (cả hai, khi lắng nghe tcp trên [::])
+func (registered registeredListener) family() string {
+	addr, ok := registered.listener.Addr().(*net.TCPAddr)
+	switch {
+	case !ok:
+		return ""
+	case registered.network == "tcp4" || addr.IP.To4() != nil:
+		return familyIPv4
+	case registered.network == "tcp" && addr.IP.IsUnspecified():
+		return familyDualStack
+	}
+	return familyIPv6
+}
+
+// Đếm số kết nối nhận được trên listener cho trạng thái server
+type countedListener struct {
+	net.Listener
+	stats *listenerStats
+}
+
+func (l *countedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.stats.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// Cộng kết quả của một kết nối đã kết thúc vào bộ đếm của listener nhận nó
+func countListenerConn(addr string, up, down int64) {
+	listenersMutex.Lock()
+	registered, exists := activeListeners[addr]
+	listenersMutex.Unlock()
+	if !exists {
+		return
+	}
+	registered.stats.conns.Add(1)
+	registered.stats.bytesUp.Add(up)
+	registered.stats.bytesDown.Add(down)
+}
+
+// Đóng listener và bỏ khỏi danh sách đang mở
+func closeListener(addr string) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	if registered, exists := activeListeners[addr]; exists {
+		registered.listener.Close()
+		delete(activeListeners, addr)
+	}
+}
+
+// Nạp các listener và bộ đếm được process cũ truyền sang (nếu có)
+func loadInheritedListeners() {
+	addrs := os.Getenv(inheritListenersEnv)
+	if addrs == "" {
+		return
+	}
+	os.Unsetenv(inheritListenersEnv)
+
+	networks := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv(inheritNetworksEnv), ";") {
+		if network, addr, found := strings.Cut(entry, "@"); found {
+			networks[addr] = network
+		}
+	}
+	os.Unsetenv(inheritNetworksEnv)
+
+	listenersMutex.Lock()
+	for i, entry := range strings.Split(addrs, ";") {
+		kind, addr, _ := strings.Cut(entry, "@")
+		file := os.NewFile(uintptr(3+i), addr)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			log.Printf("Cannot use inherited listener %s: %v", addr, err)
+			continue
+		}
+		network := networks[addr]
+		if network == "" {
+			network = "tcp"
+		}
+		inheritedListeners[addr] = registeredListener{kind: kind, network: network, listener: listener}
+	}
+	listenersMutex.Unlock()
+
+	if fd, err := strconv.Atoi(os.Getenv(inheritStateFDEnv)); err == nil {
+		journalAwaiting.Store(true)
+		upgradeStateFile = os.NewFile(uintptr(fd), "upgrade-state")
+		go receiveUpgradeState(upgradeStateFile)
+	}
+	os.Unsetenv(inheritStateFDEnv)
+}
+
+// Báo process cũ rằng các dịch vụ đã khởi động xong; process cũ chỉ ngừng nhận kết nối sau khi nhận được.
+// Process mới lỗi khi khởi động thì thoát trước khi gọi hàm này và process cũ tiếp tục phục vụ
+func signalUpgradeReady() {
+	if upgradeStateFile == nil {
+		return
+	}
+	if _, err := upgradeStateFile.WriteString(upgradeReadyMessage); err != nil {
+		log.Printf("Cannot report readiness to previous process: %v", err)
+	}
+}
+
+// Các địa chỉ listener loại kind được truyền từ process cũ, cần khởi động lại ngay
+func inheritedAddrs(kind string) []string {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	var addrs []string
+	for addr, registered := range inheritedListeners {
+		if registered.kind == kind {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Đóng các listener được truyền sang nhưng không được cấu hình lại trong process mới
+func closeUnusedInheritedListeners() {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	for addr, registered := range inheritedListeners {
+		log.Printf("Closing unused inherited listener %s", addr)
+		registered.listener.Close()
+		delete(inheritedListeners, addr)
+	}
+}
+
+// Trạng thái bộ đếm chuyển giao giữa process cũ và mới
+type upgradeState struct {
+	DataUsage map[string]int64  `json:"data_usage"`
+	Suspended map[string]string `json:"suspended,omitempty"` // User bị tạm khóa -> lý do
+
+	Cluster map[string]map[string]clusterUsage `json:"cluster,omitempty"` // Bộ đếm của cluster, khi chạy cluster
+	Agent   map[string]int64                   `json:"agent,omitempty"`   // Dữ liệu chưa gửi lên controller, khi chạy agent
+
+	Journaled bool `json:"journaled,omitempty"` // Dữ liệu đã dùng đã được ghi hết vào usage_journal
+
+	LastSeen map[string]*lastSeenState `json:"last_seen,omitempty"` // Lần đăng nhập gần nhất theo user
+}
+
+// Nhận bộ đếm từ process cũ (gửi sau khi process cũ đã drain xong) và cộng dồn vào user hiện tại
+func receiveUpgradeState(file *os.File) {
+	defer file.Close()
+	defer journalAwaiting.Store(false)
+
+	var state upgradeState
+	if err := json.NewDecoder(file).Decode(&state); err != nil {
+		log.Printf("Cannot read upgrade state: %v", err)
+		return
+	}
+
+	// Khi chạy cluster, dữ liệu đã dùng được tính lại từ bảng của cluster ở lần trao đổi tiếp theo
+	if systemConfig().ClusterListen != "" {
+		importClusterUsage(state)
+	}
+	importAgentUsage(state.Agent)
+	mergeLastSeen(state.LastSeen)
+	// Khi cả hai process cùng ghi usage_journal, dữ liệu đã dùng được đọc lại từ journal thay vì cộng dồn
+	journaled := state.Journaled && systemConfig().UsageJournal != ""
+	if journaled {
+		if err := reloadUsageJournal(); err != nil {
+			log.Printf("Usage journal error: %v", err)
+		}
+	}
+	usersMutex.Lock()
+	for username, usage := range state.DataUsage {
+		if user, exists := users[username]; exists && !journaled {
+			user.addDataUsage(usage)
+		}
+	}
+	for username, reason := range state.Suspended {
+		if user, exists := users[username]; exists {
+			user.setSuspended(true, reason)
+		}
+	}
+	usersMutex.Unlock()
+
+	log.Printf("Received counters for %d users from previous process.", len(state.DataUsage))
+}
+
+// Tạo trạng thái bộ đếm hiện tại để chuyển giao
+func currentUpgradeState() upgradeState {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	state := upgradeState{DataUsage: make(map[string]int64, len(users)), Suspended: make(map[string]string)}
+	for username, user := range users {
+		if usage := user.dataUsage(); usage > 0 {
+			state.DataUsage[username] = usage
+		}
+		if user.isSuspended() {
+			state.Suspended[username] = user.suspendReason()
+		}
+	}
+	state.Cluster = clusterUsageSnapshot()
+	state.Agent = agentUsageHandoff()
+	state.Journaled = closeUsageJournal()
+	state.LastSeen = lastSeenHandoff()
+	return state
+}
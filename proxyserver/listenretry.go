@@ -0,0 +1,158 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Thử lại listener khi địa chỉ đang bị chiếm (EADDRINUSE), thường gặp khi khởi động lại trong lúc process
+// cũ chưa thoát hẳn. Việc mở lại chạy trong goroutine riêng, chờ tăng dần từ 250ms tới 5s, và dừng sau
+// listen_retry_timeout giây. Listener đang chờ được hiện trong GET /api/listeners và menu với trạng thái retrying
+const (
+	defaultListenRetryTimeout = 30 // Giây
+	listenRetryFirstBackoff   = 250 * time.Millisecond
+	listenRetryMaxBackoff     = 5 * time.Second
+)
+
+// Listener đang chờ địa chỉ được giải phóng
+type listenerRetry struct {
+	kind        string
+	network     string
+	since       time.Time
+	deadline    time.Time
+	attempts    int
+	lastError   string
+	nextAttempt time.Time
+	cancel      chan struct{}
+}
+
+var (
+	listenerRetries      = make(map[string]*listenerRetry) // Theo địa chỉ
+	listenerRetriesMutex sync.Mutex
+
+	errListenerRetrying = errors.New("address in use, retrying in background")
+)
+
+func listenRetryTimeout() time.Duration {
+	if systemConfig().ListenRetryTimeout < 0 {
+		return 0
+	}
+	if systemConfig().ListenRetryTimeout > 0 {
+		return time.Duration(systemConfig().ListenRetryTimeout) * time.Second
+	}
+	return defaultListenRetryTimeout * time.Second
+}
+
+// Như startNetworkInstance; khi địa chỉ đang bị chiếm, tiếp tục thử lại trong nền và trả về lỗi bọc errListenerRetrying
+func startInstanceRetrying(kind, network, addr string) error {
+	err := startNetworkInstance(kind, network, addr)
+	timeout := listenRetryTimeout()
+	if err == nil || !errors.Is(err, syscall.EADDRINUSE) || timeout <= 0 {
+		return err
+	}
+
+	addr = canonicalListenAddr(addr)
+	now := time.Now()
+	listenerRetriesMutex.Lock()
+	if _, exists := listenerRetries[addr]; exists {
+		listenerRetriesMutex.Unlock()
+		return fmt.Errorf("listener on %s is already being retried", addr)
+	}
+	retry := &listenerRetry{
+		kind:        kind,
+		network:     network,
+		since:       now,
+		deadline:    now.Add(timeout),
+		attempts:    1,
+		lastError:   err.Error(),
+		nextAttempt: now.Add(listenRetryFirstBackoff),
+		cancel:      make(chan struct{}),
+	}
+	listenerRetries[addr] = retry
+	listenerRetriesMutex.Unlock()
+
+	go runListenerRetry(addr, retry)
+	return fmt.Errorf("%w for up to %v: %v", errListenerRetrying, timeout, err)
+}
+
+func runListenerRetry(addr string, retry *listenerRetry) {
+	backoff := listenRetryFirstBackoff
+	for {
+		select {
+		case <-retry.cancel:
+			return
+		case <-time.After(backoff):
+		}
+		err := startNetworkInstance(retry.kind, retry.network, addr)
+
+		listenerRetriesMutex.Lock()
+		if listenerRetries[addr] != retry {
+			// Bị hủy trong lúc đang mở: đóng listener vừa mở
+			listenerRetriesMutex.Unlock()
+			if err == nil {
+				stopInstance(addr)
+			}
+			return
+		}
+		retry.attempts++
+		if err == nil {
+			delete(listenerRetries, addr)
+			listenerRetriesMutex.Unlock()
+			log.Printf("Listener %s opened after %d attempts", addr, retry.attempts)
+			return
+		}
+		retry.lastError = err.Error()
+		if !errors.Is(err, syscall.EADDRINUSE) || time.Now().After(retry.deadline) {
+			delete(listenerRetries, addr)
+			listenerRetriesMutex.Unlock()
+			log.Printf("Listener %s: giving up after %d attempts: %v", addr, retry.attempts, err)
+			notifyOperators("Listener %s could not be opened: %v", addr, err)
+			return
+		}
+		backoff = min(backoff*2, listenRetryMaxBackoff)
+		retry.nextAttempt = time.Now().Add(backoff)
+		listenerRetriesMutex.Unlock()
+	}
+}
+
+// Hủy việc thử lại listener tại addr; false khi addr không đang chờ
+func cancelListenerRetry(addr string) bool {
+	listenerRetriesMutex.Lock()
+	defer listenerRetriesMutex.Unlock()
+	retry, exists := listenerRetries[addr]
+	if !exists {
+		return false
+	}
+	delete(listenerRetries, addr)
+	close(retry.cancel)
+	return true
+}
+
+// Trạng thái các listener đang chờ, sắp theo địa chỉ
+func retryingListenerStatuses() []ListenerStatus {
+	listenerRetriesMutex.Lock()
+	list := make([]ListenerStatus, 0, len(listenerRetries))
+	for addr, retry := range listenerRetries {
+		list = append(list, ListenerStatus{
+			Address: addr,
+			Kind:    retry.kind,
+			State:   listenerStateRetrying,
+			Retry: &ListenerRetryStatus{
+				Since:       retry.since,
+				Attempts:    retry.attempts,
+				LastError:   retry.lastError,
+				NextAttempt: retry.nextAttempt,
+				GiveUpAt:    retry.deadline,
+			},
+		})
+	}
+	listenerRetriesMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
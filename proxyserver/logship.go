@@ -0,0 +1,186 @@
+package proxyserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Xoay access log theo chu kỳ hoặc kích thước: file hiện tại được đổi tên thành <access_log_file>.<thời gian>
+// và mở file mới. Khi đặt access_log_ship, file đã xoay được nén gzip và tải lên bucket S3 rồi xóa khỏi đĩa;
+// file tải lên lỗi được giữ lại và thử lại sau, kể cả sau khi khởi động lại
+const (
+	defaultAccessLogRotate = 60 // Phút, chỉ dùng khi có access_log_ship
+	accessLogCheckInterval = 10 * time.Second
+	accessLogShipDelay     = 30 * time.Second // Chờ process khác (nâng cấp nóng) mở lại file trước khi tải lên
+	accessLogShipRetry     = time.Minute
+	accessLogTimeFormat    = "20060102T150405Z"
+)
+
+var (
+	accessLogsShipped   atomic.Int64
+	accessLogShipErrors atomic.Int64
+	accessLogsPending   atomic.Int64 // Số file đã xoay chưa tải lên
+)
+
+func accessLogRotateInterval() time.Duration {
+	switch {
+	case systemConfig().AccessLogRotateInterval < 0:
+		return 0
+	case systemConfig().AccessLogRotateInterval == 0:
+		if systemConfig().AccessLogShip == "" {
+			return 0
+		}
+		return defaultAccessLogRotate * time.Minute
+	}
+	return time.Duration(systemConfig().AccessLogRotateInterval) * time.Minute
+}
+
+func runAccessLogRotator() {
+	var retryAt time.Time
+	for {
+		time.Sleep(accessLogCheckInterval)
+		accessLogMutex.Lock()
+		path := accessLogPath
+		accessLogMutex.Unlock()
+		if path == "" {
+			continue
+		}
+		if err := checkAccessLogRotation(); err != nil {
+			log.Printf("Access log rotation error: %v", err)
+		}
+		if systemConfig().AccessLogShip == "" || time.Now().Before(retryAt) {
+			continue
+		}
+		if err := shipAccessLogs(path); err != nil {
+			accessLogShipErrors.Add(1)
+			log.Printf("Access log shipping error: %v", err)
+			retryAt = time.Now().Add(accessLogShipRetry)
+		}
+	}
+}
+
+// Xoay file khi tới hạn; nếu process khác đã xoay file (nâng cấp nóng) thì mở lại đường dẫn
+func checkAccessLogRotation() error {
+	accessLogMutex.Lock()
+	defer accessLogMutex.Unlock()
+	if accessLogFile == nil {
+		return nil
+	}
+	info, err := accessLogFile.Stat()
+	if err != nil {
+		return err
+	}
+	if current, err := os.Stat(accessLogPath); err != nil || !os.SameFile(info, current) {
+		log.Printf("Access log %s was rotated by another process, reopening", accessLogPath)
+		return reopenAccessLog()
+	}
+	if info.Size() == 0 {
+		// Không xoay file rỗng
+		accessLogOpened = time.Now()
+		return nil
+	}
+	interval := accessLogRotateInterval()
+	due := interval > 0 && time.Since(accessLogOpened) >= interval
+	if systemConfig().AccessLogRotateMB > 0 && info.Size() >= int64(systemConfig().AccessLogRotateMB)<<20 {
+		due = true
+	}
+	if !due {
+		return nil
+	}
+
+	rotated := accessLogPath + "." + time.Now().UTC().Format(accessLogTimeFormat)
+	if _, err := os.Stat(rotated); err == nil {
+		return nil // Đã xoay trong giây này, chờ lần sau
+	}
+	if err := os.Rename(accessLogPath, rotated); err != nil {
+		return err
+	}
+	log.Printf("Access log rotated to %s", rotated)
+	return reopenAccessLog()
+}
+
+// Mở lại access_log_file; gọi khi giữ accessLogMutex. Lỗi thì tiếp tục ghi vào file cũ
+func reopenAccessLog() error {
+	file, err := os.OpenFile(accessLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	accessLogOpened = time.Now()
+	if err != nil {
+		return err
+	}
+	accessLogFile.Close()
+	accessLogFile = file
+	return nil
+}
+
+// Nén và tải lên các file đã xoay của access log; file tải lên thành công bị xóa
+func shipAccessLogs(path string) error {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(systemConfig().AccessLogShip, "s3://"), "/")
+	store := newS3Store(bucket, prefix, systemConfig().AccessLogShipEndpoint)
+	host, _ := os.Hostname()
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var pending int64
+	var shipErr error
+	for _, file := range files {
+		stamp, found := strings.CutPrefix(file.Name(), base+".")
+		if !found {
+			continue
+		}
+		rotatedAt, err := time.Parse(accessLogTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		pending++
+		if shipErr != nil || time.Since(rotatedAt) < accessLogShipDelay || time.Since(info.ModTime()) < accessLogShipDelay {
+			continue
+		}
+		rotatedPath := filepath.Join(dir, file.Name())
+		key := fmt.Sprintf("%s/%s/%s.gz", host, rotatedAt.Format("2006/01/02"), file.Name())
+		if shipErr = shipAccessLog(store, rotatedPath, key); shipErr != nil {
+			continue // Dừng ở file lỗi đầu tiên, các file còn lại thử lại sau
+		}
+		pending--
+		accessLogsShipped.Add(1)
+		log.Printf("Access log %s shipped to %s%s", rotatedPath, strings.TrimSuffix(systemConfig().AccessLogShip, "/")+"/", key)
+	}
+	accessLogsPending.Store(pending)
+	return shipErr
+}
+
+func shipAccessLog(store *s3BackupStore, path, key string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := store.put(key, compressed.Bytes()); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,2138 @@
+package proxyserver
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/auth"
+	"proxy_server/proxyserver/config"
+	"proxy_server/proxyserver/limits"
+	"proxy_server/proxyserver/socks4"
+	"proxy_server/proxyserver/socks5"
+)
+
+// Cấu hình của người dùng (từ users.conf hoặc API) và con trỏ tới trạng thái runtime dùng chung
+type User struct {
+	Username        string
+	Password        string
+	StartDate       time.Time
+	EndDate         time.Time
+	ConnectionLimit int
+	MaxData         int64             // Giới hạn dữ liệu (tính bằng byte)
+	MaxBandwidth    int64             // Băng thông tối đa (tính bằng byte/giây)
+	Owner           string            // Reseller sở hữu user (cột thứ 8, tùy chọn)
+	Group           string            // Nhóm của user, dùng để chọn chiến lược egress (cột thứ 9, tùy chọn)
+	MaxTransfer     int64             // Số byte tối đa của một tunnel, cả hai chiều (cột thứ 10, tùy chọn), 0 = không giới hạn
+	Email           string            // Địa chỉ nhận thông báo email (cột thứ 11, tùy chọn)
+	Tags            map[string]string // Tag tự do key=value;key=value (cột thứ 12, tùy chọn)
+
+	state *userState // Trạng thái runtime: dữ liệu đã dùng, số tunnel đang mở, tạm khóa
+}
+
+type SystemConfig struct {
+	MaxConnections    int   // Tổng số kết nối tối đa
+	MaxBandwidth      int64 // Băng thông tối đa (byte/giây)
+	FairScheduling    bool  // Áp dụng max_bandwidth và chia đều cho các user khi server bão hòa
+	ConnectionTimeout int   // Thời gian timeout kết nối (giây)
+	HandshakeTimeout  int   // Thời gian tối đa client hoàn tất bắt tay (giây), 0 = mặc định, âm = tắt
+	AuthFailureDelay  int   // Thời gian trả lời xác thực thất bại (ms), 0 = mặc định, âm = tắt
+	AdminAuthFailures int   // Số lần sai token tối đa mỗi phút của một IP trên admin API, 0 = mặc định, âm = tắt
+	GCPercent         int   // Tỉ lệ thu gom rác
+
+	Language string // Ngôn ngữ của menu console: en hoặc vi (rỗng = theo LANG)
+
+	AdminListen     string // Địa chỉ lắng nghe của admin API (rỗng = tắt)
+	AdminTokensFile string // Đường dẫn đến file token của admin API
+	AdminTLSCert    string // Chứng chỉ TLS của admin API
+	AdminTLSKey     string // Khóa riêng TLS của admin API
+	AdminClientCA   string // CA bundle dùng để xác thực chứng chỉ client (mTLS)
+	AdminTLSACME    bool   // Dùng chứng chỉ ACME cho admin API
+
+	ACMEDomains    string // Danh sách domain cấp chứng chỉ ACME, phân cách bằng dấu phẩy
+	ACMEEmail      string // Email đăng ký tài khoản ACME
+	ACMECacheDir   string // Thư mục lưu chứng chỉ ACME
+	ACMEHTTPListen string // Địa chỉ lắng nghe challenge HTTP-01 (ví dụ :80)
+
+	TLSOffloads []TLSOffloadConfig // Các listener TLS offload
+
+	CompressListen string // Địa chỉ listener SOCKS nén deflate cho lệnh client
+	CompressLevel  int    // Mức nén deflate (1-9) của listener nén
+
+	ObfsListeners []ObfsListenerConfig // Các listener SOCKS có lớp che giấu lưu lượng
+
+	SocksIPv4          bool // Mở listener SOCKS chỉ IPv4 khi khởi động
+	SocksIPv4Port      int  // Cổng của listener chỉ IPv4 (mặc định 1080)
+	SocksIPv6          bool // Mở listener SOCKS chỉ IPv6 khi khởi động
+	SocksIPv6Port      int  // Cổng của listener chỉ IPv6 (mặc định 1081)
+	SocksDualStack     bool // Mở một listener SOCKS nhận cả IPv4 và IPv6 khi khởi động
+	SocksDualStackPort int  // Cổng của listener dual-stack (mặc định 1080)
+	ListenRetryTimeout int  // Số giây thử lại listener khi địa chỉ đang bị chiếm (mặc định 30, âm = tắt)
+
+	LatencyPorts  []portRange // Port đích dùng chế độ relay độ trễ thấp (SSH, game...)
+	LatencyGroups []string    // Nhóm user dùng chế độ relay độ trễ thấp
+
+	SessionTimeout      int  // Số giây không có kết nối mới trước khi phiên kết thúc, âm = tắt phiên
+	SessionStickyEgress bool // Các kết nối trong cùng phiên dùng lại IP egress của kết nối trước
+	UsernameParams      bool // Đọc tham số session, ttl, country gắn trong username SOCKS5
+
+	MaxTunnelLifetime int // Thời gian sống tối đa của một tunnel (giây), 0 = không giới hạn
+
+	DNSMode     string              // Xử lý đích dạng domain của SOCKS5: remote hoặc reject
+	DNSPrefer   string              // Họ địa chỉ ưu tiên khi phân giải: both, ipv4, ipv6
+	ListenerDNS []ListenerDNSConfig // Cấu hình DNS riêng theo listener
+	NAT64Prefix string              // Prefix NAT64 (ví dụ 64:ff9b::/96) cho máy chủ chỉ có IPv6
+
+	ListenerCountries map[string]string // Quốc gia egress theo listener, để mỗi quốc gia có cổng riêng
+
+	DNSOverridesFile string // File DNS server và bản ghi host tĩnh theo user hoặc nhóm
+	IPCheckHost      string // Tên miền của trang kiểm tra IP và DNS dựng sẵn trong proxy
+
+	ACLFile    string // File quy tắc allow/deny theo host, hậu tố domain, CIDR và port
+	ACLDefault string // Hành động khi đích không khớp quy tắc nào: allow hoặc deny
+
+	EgressIPs           []string          // Các IP nguồn dùng để kết nối ra ngoài (ip[,weight])
+	EgressCheckURL      string            // URL kiểm tra trả về IP public dạng text
+	EgressCheckInterval int               // Chu kỳ kiểm tra IP egress (giây)
+	EgressWebhook       string            // URL nhận cảnh báo khi IP egress đổi trạng thái (POST JSON)
+	EgressPolicy        string            // Chiến lược chọn IP egress mặc định
+	EgressGroupPolicies map[string]string // Chiến lược chọn IP egress theo nhóm user
+	EgressMatch         []string          // Key tag của user mà IP egress phải có cùng giá trị
+	MetricTagLabels     []string          // Key tag dùng làm label của metric
+
+	UpstreamFile            string // File danh sách proxy upstream, mỗi dòng một proxy
+	UpstreamURL             string // URL trả về danh sách proxy upstream
+	UpstreamCheckTarget     string // Đích host:port dùng để kiểm tra upstream
+	UpstreamCheckInterval   int    // Chu kỳ kiểm tra upstream (giây)
+	UpstreamRefreshInterval int    // Chu kỳ tải lại danh sách upstream (giây)
+	UpstreamMaxFailures     int    // Số lần lỗi liên tiếp trước khi loại upstream
+
+	DialAttempts  int             // Số lần thử kết nối tới đích qua các egress/upstream khác nhau
+	SOCKS5Replies map[string]byte // Mã reply SOCKS5 tùy chỉnh theo loại lỗi kết nối
+
+	Maintenance           bool   // Chế độ bảo trì: từ chối tunnel mới, giữ tunnel đang mở
+	MaintenanceMessage    string // Thông báo bảo trì cho client
+	MaintenanceSOCKSReply byte   // Mã reply SOCKS5 khi từ chối vì bảo trì
+	MaintenancePage       string // File HTML trả cho request HTTP tới cổng SOCKS khi bảo trì
+
+	AcceptQueueTimeout int // Thời gian chờ credit khi đạt max_connections trước khi từ chối (ms)
+	FDHeadroom         int // Số file descriptor để dành, kết nối mới bị từ chối khi vượt quá
+
+	MemoryLimitMB  int  // Ngưỡng bộ nhớ (MB), vượt quá thì từ chối tunnel mới
+	MemoryShedIdle bool // Đóng bớt tunnel idle khi vượt ngưỡng bộ nhớ
+
+	HealthListen        string // Địa chỉ HTTP riêng cho /healthz và /readyz (rỗng = chỉ trên admin API)
+	UsageListen         string // Địa chỉ HTTP cho client xem lượng dùng bằng username/password proxy (rỗng = tắt)
+	ReadinessWatermark  int    // Phần trăm max_connections mà từ đó /readyz báo chưa sẵn sàng (0 = mặc định)
+	ConfigWatchInterval int    // Chu kỳ kiểm tra thay đổi file cấu hình (giây), 0 = tắt
+
+	ListenerTCP []ListenerTCPConfig // Tùy chỉnh TCP cho kết nối nhận vào, theo listener
+	DialTCP     TCPTuning           // Tùy chỉnh TCP cho kết nối ra ngoài
+
+	PolicyURL      string // URL dịch vụ policy (OPA Data API hoặc webhook) quyết định từng yêu cầu CONNECT
+	PolicyTimeout  int    // Thời gian chờ dịch vụ policy (ms)
+	PolicyCacheTTL int    // Thời gian giữ quyết định trong cache (giây), âm = không cache
+	PolicyFailOpen bool   // Cho phép kết nối khi không hỏi được dịch vụ policy hoặc script lỗi
+	PolicyScript   string // File script Lua quyết định từng yêu cầu CONNECT, tự nạp lại khi thay đổi
+
+	RewriteFile string // File quy tắc đổi host/port đích trước khi kết nối
+
+	AnomalyDetection       bool    // Bật phát hiện hành vi bất thường theo user
+	AnomalyFactor          float64 // Hệ số lệch so với baseline để cảnh báo
+	AnomalyMinDestinations int     // Số kết nối/phút tối thiểu để xét cảnh báo
+	AnomalyMinUniqueHosts  int     // Số host khác nhau/phút tối thiểu để xét cảnh báo
+	AnomalyMinBytes        int64   // Lượng dữ liệu/phút tối thiểu để xét tỉ lệ up/down
+	AnomalyWebhook         string  // URL nhận cảnh báo (POST JSON)
+
+	SharingMaxNetworks      int            // Số mạng client tối đa của một user trong sharing_window, 0 = tắt
+	SharingGroupMaxNetworks map[string]int // Số mạng client tối đa theo nhóm (gói) user
+	SharingWindow           int            // Cửa sổ đếm mạng client (giây)
+	SharingAction           string         // alert, throttle hoặc suspend khi vượt giới hạn
+	SharingSuspendTime      int            // Thời gian khóa user khi sharing_action=suspend (giây)
+	SharingIPv4Prefix       int            // Độ dài prefix gộp các IPv4 client thành một mạng
+	SharingIPv6Prefix       int            // Độ dài prefix gộp các IPv6 client thành một mạng
+
+	ReputationLists    []string // File hoặc URL danh sách IP/CIDR client xấu (exit node Tor, IP lạm dụng...)
+	ReputationDNSBL    []string // Zone DNSBL dùng để tra IP client
+	ReputationAction   string   // log hoặc deny khi IP client bị liệt kê
+	ReputationCacheTTL int      // Thời gian giữ kết quả tra một IP (giây)
+	ReputationRefresh  int      // Chu kỳ tải lại reputation_list (giây)
+
+	ExpiredUserAction    string // none, disable hoặc delete với user đã qua end_date
+	ExpiredUserRetention int    // Số ngày sau end_date trước khi xóa user khi expired_user_action=delete
+	ExpiredUserArchive   string // File JSON lines lưu bản ghi của user bị xóa
+	StrictUsers          bool   // Từ chối users.conf có dòng lỗi hoặc username trùng
+	UsersWriteBack       bool   // Ghi thay đổi user qua admin API vào users.conf
+
+	StripeWebhookSecret  string        // Secret ký webhook Stripe (whsec_...), rỗng = tắt
+	BillingPlans         []BillingPlan // Sản phẩm -> gói tạo hoặc gia hạn tài khoản khi thanh toán
+	BillingNotifyWebhook string        // URL nhận thông tin tài khoản vừa tạo hoặc gia hạn (POST JSON)
+
+	TelegramBotToken string // Token bot Telegram nhận cảnh báo và lệnh, rỗng = tắt
+	TelegramChatID   int64  // Chat nhận cảnh báo; chỉ lệnh từ chat này được trả lời
+	TelegramAPIURL   string // Địa chỉ Bot API (mặc định https://api.telegram.org)
+	DiscordWebhook   string // Discord webhook nhận cảnh báo
+
+	SMTPServer         string            // host:port gửi email thông báo cho user, rỗng = tắt
+	SMTPUsername       string            // Tài khoản đăng nhập SMTP (rỗng = không đăng nhập)
+	SMTPPassword       string            // Password SMTP, có thể mã hóa
+	SMTPFrom           string            // Địa chỉ người gửi (mặc định smtp_username)
+	EmailEvents        map[string]string // Sự kiện được bật -> file template (rỗng = template mặc định)
+	EmailQuotaWarning  int               // Phần trăm max_data gửi quota_warning
+	EmailExpiryWarning int               // Số ngày trước end_date gửi account_expiring
+
+	AlertWebhook    string // URL nhận thay đổi trạng thái cảnh báo (định dạng webhook của Alertmanager)
+	AlertmanagerURL string // Địa chỉ Alertmanager nhận cảnh báo qua API v2
+	AlertErrorRate  int    // Phần trăm tunnel lỗi trong 5 phút để kích hoạt ProxyErrorRateHigh
+	AlertFDPercent  int    // Phần trăm fd đang dùng để kích hoạt ProxyFDExhaustion
+
+	StatsdAddress  string   // host:port UDP nhận metric statsd, rỗng = tắt
+	StatsdFlavor   string   // statsd hoặc dogstatsd (gửi label dưới dạng tag)
+	StatsdPrefix   string   // Tiền tố tên metric (mặc định "proxy.")
+	StatsdTags     []string // Tag gắn vào mọi metric (chỉ với dogstatsd)
+	StatsdInterval int      // Chu kỳ gửi (giây)
+
+	TopTalkersWindow int // Số phút giữ thống kê top đích
+
+	UpgradeDrainTimeout int // Thời gian chờ kết nối cũ kết thúc khi nâng cấp nóng (giây)
+
+	AuditLogFile     string // File lưu audit log các thao tác quản trị
+	AuthLogFile      string // File log riêng cho xác thực thất bại (dùng cho fail2ban)
+	AccessLogFile    string // File access log, mỗi tunnel một dòng (rỗng = ghi vào log chính)
+	CaptureDir       string // Thư mục lưu file pcap khi capture kết nối
+	CrashDir         string // Thư mục lưu crash dump khi panic (rỗng = tắt)
+	CrashReportURL   string // URL nhận crash dump (POST JSON)
+	MasterKeyCommand string // Lệnh in ra master key (ví dụ gọi KMS) khi không có PROXY_MASTER_KEY
+
+	VaultAddr      string // Địa chỉ HashiCorp Vault cho secret vault:, mặc định VAULT_ADDR
+	VaultTokenFile string // File chứa token Vault, mặc định dùng VAULT_TOKEN
+	VaultNamespace string // Namespace Vault Enterprise, mặc định VAULT_NAMESPACE
+	AWSRegion      string // Region của SSM Parameter Store cho secret ssm:, mặc định AWS_REGION
+	SSMEndpoint    string // Endpoint SSM riêng (ví dụ VPC endpoint)
+
+	ClusterListen    string   // Địa chỉ nhận trạng thái từ các node khác (rỗng = không chạy cluster)
+	ClusterNode      string   // Tên node, mặc định hostname
+	ClusterAdvertise string   // Địa chỉ các node khác dùng để kết nối tới node này
+	ClusterPeers     []string // Các node seed (host:port)
+	ClusterDiscovery string   // static hoặc gossip
+	ClusterSecret    string   // Secret chung của cluster
+	ClusterInterval  int      // Chu kỳ trao đổi trạng thái (giây)
+	ClusterTLSCert   string   // Chứng chỉ TLS của node, bật HTTPS giữa các node
+	ClusterTLSKey    string
+	ClusterCA        string // CA xác thực chứng chỉ của các node (mTLS)
+
+	ControllerURL      string // Admin API của controller trung tâm; khi đặt, server chạy ở chế độ agent
+	ControllerToken    string // Token agent trên controller
+	ControllerInterval int    // Chu kỳ đồng bộ với controller (giây)
+	ControllerCA       string // CA xác thực chứng chỉ TLS của controller
+	AgentName          string // Tên agent trên controller, mặc định hostname
+
+	UsageJournal                string // File journal dữ liệu đã dùng (rỗng = không lưu qua lần khởi động)
+	UsageJournalFlush           int    // Chu kỳ ghi journal xuống đĩa (ms)
+	UsageJournalCompactInterval int    // Chu kỳ nén journal (giây)
+
+	LastSeenFile string // File lưu lần đăng nhập gần nhất và các mạng client đã biết của user
+
+	BackupTarget     string // Nơi lưu bản sao lưu: thư mục hoặc s3://bucket/prefix (rỗng = tắt)
+	BackupInterval   int    // Chu kỳ sao lưu (giờ), 0 = mặc định, âm = chỉ sao lưu khi được yêu cầu
+	BackupKeep       int    // Số bản sao lưu giữ lại, 0 = mặc định
+	BackupS3Endpoint string // Endpoint của dịch vụ tương thích S3 (rỗng = AWS S3 theo aws_region)
+
+	AccessLogRotateInterval int    // Chu kỳ xoay access log (phút), 0 = mặc định (chỉ xoay khi có access_log_ship), âm = tắt
+	AccessLogRotateMB       int    // Xoay access log khi file vượt quá số MB này, 0 = không giới hạn
+	AccessLogShip           string // Nơi tải lên access log đã xoay: s3://bucket/prefix (rỗng = giữ trên đĩa)
+	AccessLogShipEndpoint   string // Endpoint của dịch vụ tương thích S3 cho access_log_ship (rỗng = AWS S3)
+
+	AnalyticsSink          string // clickhouse hoặc timescaledb (rỗng = tắt)
+	AnalyticsDSN           string // URL HTTP của ClickHouse hoặc postgres://... của TimescaleDB, có thể là secret
+	AnalyticsTable         string // Bảng nhận bản ghi kết nối, mặc định proxy_connections
+	AnalyticsBatchSize     int    // Số bản ghi tối đa mỗi lần ghi, 0 = mặc định
+	AnalyticsFlushInterval int    // Thời gian tối đa giữ một lô chưa đầy (giây), 0 = mặc định
+	AnalyticsBuffer        int    // Số bản ghi tối đa chờ ghi, vượt quá thì bỏ bản ghi mới; 0 = mặc định
+
+	EventBus         string   // kafka://broker:9092,... hoặc nats://host:4222 (rỗng = tắt), có thể là secret
+	EventBusTopic    string   // Topic Kafka hoặc subject NATS, {type} được thay bằng loại sự kiện
+	EventBusEncoding string   // json hoặc protobuf
+	EventBusEvents   []string // Loại sự kiện được gửi (rỗng = tất cả)
+	EventBusBuffer   int      // Số sự kiện tối đa chờ gửi, vượt quá thì bỏ sự kiện mới; 0 = mặc định
+
+	KernelAccounting         bool // Tunnel TCP thuần được chép bằng splice và đếm byte từ TCP_INFO (chỉ Linux)
+	KernelAccountingInterval int  // Chu kỳ cập nhật bộ đếm từ kernel (giây), 0 = mặc định
+	KTLS                     bool // Chuyển mã hóa TLS 1.3 của tls_offload và obfs_listen tls sang kernel (chỉ Linux)
+}
+
+var (
+	users         map[string]*User
+	usersMutex    sync.RWMutex                 // Bảo vệ truy cập đến map `users`
+	runningConfig atomic.Pointer[SystemConfig] // Cấu hình đang chạy, chỉ được thay cả khối qua setSystemConfig
+	wg            sync.WaitGroup
+	userFile      = "users.conf"  // Đường dẫn đến file `users.conf`
+	systemFile    = "system.conf" // Đường dẫn đến file `system.conf`
+	tokenFile     = "tokens.conf" // Đường dẫn mặc định đến file token của admin API
+	ipv6ProxyList []string        // Lưu danh sách proxy IPv6
+	ipv4ProxyList []string        // Lưu danh sách proxy IPv4
+)
+
+// Cấu hình đang chạy. Relay và các goroutine nền đọc không cần khóa nên giá trị trả về không được sửa
+func systemConfig() *SystemConfig {
+	if config := runningConfig.Load(); config != nil {
+		return config
+	}
+	return &SystemConfig{}
+}
+
+// Thay cấu hình đang chạy; các lần gọi systemConfig() sau đó thấy cấu hình mới
+func setSystemConfig(config SystemConfig) {
+	runningConfig.Store(&config)
+}
+
+// Load cấu hình hệ thống từ file
+func loadSystemConfig(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	config, err := parseSystemConfig(file)
+	if err != nil {
+		return err
+	}
+	setSystemConfig(config)
+
+	if configProfile != "" {
+		log.Printf("System configuration loaded successfully (profile %s).", configProfile)
+		return nil
+	}
+	log.Println("System configuration loaded successfully.")
+	return nil
+}
+
+// Đọc cấu hình hệ thống dạng key=value
+func parseSystemConfig(r io.Reader) (SystemConfig, error) {
+	entries, err := config.Read(r, configProfile)
+	var config SystemConfig
+	if err != nil {
+		return config, err
+	}
+	for _, entry := range entries {
+		key, value := entry.Key, entry.Value
+		switch key {
+		case "max_connections":
+			maxConns, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid max_connections value: %v", err)
+			}
+			config.MaxConnections = maxConns
+
+		case "max_bandwidth":
+			maxBW, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return config, fmt.Errorf("invalid max_bandwidth value: %v", err)
+			}
+			config.MaxBandwidth = maxBW
+
+		case "fair_scheduling":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid fair_scheduling value: %v", err)
+			}
+			config.FairScheduling = enabled
+
+		case "connection_timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid connection_timeout value: %v", err)
+			}
+			config.ConnectionTimeout = timeout
+
+		case "handshake_timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid handshake_timeout value: %v", err)
+			}
+			config.HandshakeTimeout = timeout
+
+		case "auth_failure_delay_ms":
+			delay, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid auth_failure_delay_ms value: %v", err)
+			}
+			config.AuthFailureDelay = delay
+
+		case "admin_auth_max_failures":
+			failures, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid admin_auth_max_failures value: %v", err)
+			}
+			config.AdminAuthFailures = failures
+
+		case "gc_percent":
+			gcPercent, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid gc_percent value: %v", err)
+			}
+			config.GCPercent = gcPercent
+
+		case "language":
+			if value != langEnglish && value != langVietnamese {
+				return config, fmt.Errorf("invalid language value: %s", value)
+			}
+			config.Language = value
+
+		case "admin_listen":
+			config.AdminListen = value
+
+		case "admin_tokens_file":
+			config.AdminTokensFile = value
+
+		case "admin_tls_cert":
+			config.AdminTLSCert = value
+
+		case "admin_tls_key":
+			config.AdminTLSKey = value
+
+		case "admin_client_ca":
+			config.AdminClientCA = value
+
+		case "admin_tls_acme":
+			acme, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid admin_tls_acme value: %v", err)
+			}
+			config.AdminTLSACME = acme
+
+		case "acme_domains":
+			config.ACMEDomains = value
+
+		case "acme_email":
+			config.ACMEEmail = value
+
+		case "acme_cache_dir":
+			config.ACMECacheDir = value
+
+		case "acme_http_listen":
+			config.ACMEHTTPListen = value
+
+		case "tls_offload":
+			offload, err := parseTLSOffload(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid tls_offload value: %v", err)
+			}
+			config.TLSOffloads = append(config.TLSOffloads, offload)
+
+		case "compress_listen":
+			config.CompressListen = value
+
+		case "compress_level":
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 1 || level > 9 {
+				return config, fmt.Errorf("invalid compress_level value: %s", value)
+			}
+			config.CompressLevel = level
+
+		case "socks_ipv4":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid socks_ipv4 value: %v", err)
+			}
+			config.SocksIPv4 = enabled
+
+		case "socks_ipv4_port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return config, fmt.Errorf("invalid socks_ipv4_port value: %s", value)
+			}
+			config.SocksIPv4Port = port
+
+		case "socks_ipv6":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid socks_ipv6 value: %v", err)
+			}
+			config.SocksIPv6 = enabled
+
+		case "socks_ipv6_port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return config, fmt.Errorf("invalid socks_ipv6_port value: %s", value)
+			}
+			config.SocksIPv6Port = port
+
+		case "socks_dual_stack":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid socks_dual_stack value: %v", err)
+			}
+			config.SocksDualStack = enabled
+
+		case "socks_dual_stack_port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return config, fmt.Errorf("invalid socks_dual_stack_port value: %s", value)
+			}
+			config.SocksDualStackPort = port
+
+		case "listen_retry_timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid listen_retry_timeout value: %s", value)
+			}
+			config.ListenRetryTimeout = timeout
+
+		case "obfs_listen":
+			obfs, err := parseObfsListener(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid obfs_listen value: %v", err)
+			}
+			config.ObfsListeners = append(config.ObfsListeners, obfs)
+
+		case "latency_ports":
+			ports, err := parsePortRanges(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid latency_ports value: %v", err)
+			}
+			config.LatencyPorts = ports
+
+		case "latency_groups":
+			config.LatencyGroups = parseNameList(value)
+
+		case "session_timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid session_timeout value: %v", err)
+			}
+			config.SessionTimeout = timeout
+
+		case "session_sticky_egress":
+			sticky, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid session_sticky_egress value: %v", err)
+			}
+			config.SessionStickyEgress = sticky
+
+		case "username_params":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid username_params value: %v", err)
+			}
+			config.UsernameParams = enabled
+
+		case "max_tunnel_lifetime":
+			lifetime, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid max_tunnel_lifetime value: %v", err)
+			}
+			config.MaxTunnelLifetime = lifetime
+
+		case "dns_mode":
+			config.DNSMode = value
+
+		case "dns_prefer":
+			config.DNSPrefer = value
+
+		case "socks_dns":
+			listenerDNS, err := parseListenerDNS(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid socks_dns value: %v", err)
+			}
+			config.ListenerDNS = append(config.ListenerDNS, listenerDNS)
+
+		case "socks_country":
+			listen, country, err := parseListenerCountry(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid socks_country value: %v", err)
+			}
+			if config.ListenerCountries == nil {
+				config.ListenerCountries = make(map[string]string)
+			}
+			config.ListenerCountries[listen] = country
+
+		case "nat64_prefix":
+			if _, err := parseNAT64Prefix(value); err != nil {
+				return config, fmt.Errorf("invalid nat64_prefix value: %v", err)
+			}
+			config.NAT64Prefix = value
+
+		case "dns_overrides_file":
+			config.DNSOverridesFile = value
+
+		case "ip_check_host":
+			host, err := parseIPCheckHost(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid ip_check_host value: %v", err)
+			}
+			config.IPCheckHost = host
+
+		case "acl_file":
+			config.ACLFile = value
+
+		case "acl_default":
+			if value != aclAllow && value != aclDeny {
+				return config, fmt.Errorf("invalid acl_default value: %s", value)
+			}
+			config.ACLDefault = value
+
+		case "egress_ip":
+			if _, err := parseEgressIP(value); err != nil {
+				return config, fmt.Errorf("invalid egress_ip value: %v", err)
+			}
+			config.EgressIPs = append(config.EgressIPs, value)
+
+		case "egress_check_url":
+			config.EgressCheckURL = value
+
+		case "egress_check_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid egress_check_interval value: %v", err)
+			}
+			config.EgressCheckInterval = interval
+
+		case "egress_webhook":
+			config.EgressWebhook = value
+
+		case "egress_policy":
+			if !validEgressPolicy(value) {
+				return config, fmt.Errorf("invalid egress_policy value: %s", value)
+			}
+			config.EgressPolicy = value
+
+		case "egress_group_policy":
+			group, policy, err := parseEgressGroupPolicy(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid egress_group_policy value: %v", err)
+			}
+			if config.EgressGroupPolicies == nil {
+				config.EgressGroupPolicies = make(map[string]string)
+			}
+			config.EgressGroupPolicies[group] = policy
+
+		case "egress_match":
+			keys, err := parseTagKeys(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid egress_match value: %v", err)
+			}
+			config.EgressMatch = keys
+
+		case "metric_tag_labels":
+			keys, err := parseTagKeys(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid metric_tag_labels value: %v", err)
+			}
+			config.MetricTagLabels = keys
+
+		case "upstream_file":
+			config.UpstreamFile = value
+
+		case "upstream_url":
+			config.UpstreamURL = value
+
+		case "upstream_check_target":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return config, fmt.Errorf("invalid upstream_check_target value: %v", err)
+			}
+			config.UpstreamCheckTarget = value
+
+		case "upstream_check_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid upstream_check_interval value: %v", err)
+			}
+			config.UpstreamCheckInterval = interval
+
+		case "upstream_refresh_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid upstream_refresh_interval value: %v", err)
+			}
+			config.UpstreamRefreshInterval = interval
+
+		case "upstream_max_failures":
+			maxFailures, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid upstream_max_failures value: %v", err)
+			}
+			config.UpstreamMaxFailures = maxFailures
+
+		case "dial_attempts":
+			dialAttempts, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid dial_attempts value: %v", err)
+			}
+			config.DialAttempts = dialAttempts
+
+		case "socks5_reply":
+			reason, code, err := parseSOCKS5Reply(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid socks5_reply value: %v", err)
+			}
+			if config.SOCKS5Replies == nil {
+				config.SOCKS5Replies = make(map[string]byte)
+			}
+			config.SOCKS5Replies[reason] = code
+
+		case "maintenance":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid maintenance value: %v", err)
+			}
+			config.Maintenance = enabled
+
+		case "maintenance_message":
+			config.MaintenanceMessage = value
+
+		case "maintenance_socks_reply":
+			code, err := socks5.ParseReplyCode(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid maintenance_socks_reply value: %v", err)
+			}
+			config.MaintenanceSOCKSReply = code
+
+		case "maintenance_page":
+			config.MaintenancePage = value
+
+		case "accept_queue_timeout_ms":
+			queueTimeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid accept_queue_timeout_ms value: %v", err)
+			}
+			config.AcceptQueueTimeout = queueTimeout
+
+		case "fd_headroom":
+			headroom, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid fd_headroom value: %v", err)
+			}
+			config.FDHeadroom = headroom
+
+		case "memory_limit_mb":
+			limitMB, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid memory_limit_mb value: %v", err)
+			}
+			config.MemoryLimitMB = limitMB
+
+		case "memory_shed_idle":
+			shedIdle, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid memory_shed_idle value: %v", err)
+			}
+			config.MemoryShedIdle = shedIdle
+
+		case "cluster_listen":
+			config.ClusterListen = value
+
+		case "cluster_node":
+			if !validTagKey(value) {
+				return config, fmt.Errorf("invalid cluster_node value: %s", value)
+			}
+			config.ClusterNode = value
+
+		case "cluster_advertise":
+			config.ClusterAdvertise = value
+
+		case "cluster_peers":
+			for _, peer := range strings.Split(value, ",") {
+				peer = strings.TrimSpace(peer)
+				if peer == "" {
+					continue
+				}
+				if _, _, err := net.SplitHostPort(peer); err != nil {
+					return config, fmt.Errorf("invalid cluster_peers value: %v", err)
+				}
+				config.ClusterPeers = append(config.ClusterPeers, peer)
+			}
+
+		case "cluster_discovery":
+			if value != clusterDiscoveryStatic && value != clusterDiscoveryGossip {
+				return config, fmt.Errorf("invalid cluster_discovery value: %s", value)
+			}
+			config.ClusterDiscovery = value
+
+		case "cluster_secret":
+			config.ClusterSecret = value
+
+		case "cluster_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return config, fmt.Errorf("invalid cluster_interval value: %s", value)
+			}
+			config.ClusterInterval = interval
+
+		case "cluster_tls_cert":
+			config.ClusterTLSCert = value
+
+		case "cluster_tls_key":
+			config.ClusterTLSKey = value
+
+		case "cluster_ca":
+			config.ClusterCA = value
+
+		case "controller_url":
+			config.ControllerURL = strings.TrimRight(value, "/")
+
+		case "controller_token":
+			config.ControllerToken = value
+
+		case "controller_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return config, fmt.Errorf("invalid controller_interval value: %s", value)
+			}
+			config.ControllerInterval = interval
+
+		case "controller_ca":
+			config.ControllerCA = value
+
+		case "agent_name":
+			if !validTagKey(value) {
+				return config, fmt.Errorf("invalid agent_name value: %s", value)
+			}
+			config.AgentName = value
+
+		case "usage_journal":
+			config.UsageJournal = value
+
+		case "last_seen_file":
+			config.LastSeenFile = value
+
+		case "backup_target":
+			config.BackupTarget = value
+
+		case "backup_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid backup_interval value: %v", err)
+			}
+			config.BackupInterval = interval
+
+		case "backup_keep":
+			keep, err := strconv.Atoi(value)
+			if err != nil || keep < 0 {
+				return config, fmt.Errorf("invalid backup_keep value: %s", value)
+			}
+			config.BackupKeep = keep
+
+		case "backup_s3_endpoint":
+			config.BackupS3Endpoint = value
+
+		case "usage_journal_flush_ms":
+			flush, err := strconv.Atoi(value)
+			if err != nil || flush < 1 {
+				return config, fmt.Errorf("invalid usage_journal_flush_ms value: %s", value)
+			}
+			config.UsageJournalFlush = flush
+
+		case "usage_journal_compact_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return config, fmt.Errorf("invalid usage_journal_compact_interval value: %s", value)
+			}
+			config.UsageJournalCompactInterval = interval
+
+		case "health_listen":
+			config.HealthListen = value
+
+		case "usage_listen":
+			config.UsageListen = value
+
+		case "readiness_watermark":
+			watermark, err := strconv.Atoi(value)
+			if err != nil || watermark < 1 || watermark > 100 {
+				return config, fmt.Errorf("invalid readiness_watermark value: %s", value)
+			}
+			config.ReadinessWatermark = watermark
+
+		case "config_watch_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 0 {
+				return config, fmt.Errorf("invalid config_watch_interval value: %s", value)
+			}
+			config.ConfigWatchInterval = interval
+
+		case "listener_tcp":
+			listenerTCP, err := parseListenerTCP(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid listener_tcp value: %v", err)
+			}
+			config.ListenerTCP = append(config.ListenerTCP, listenerTCP)
+
+		case "dial_tcp":
+			dialTCP, err := parseTCPTuning(strings.Split(value, ","))
+			if err != nil {
+				return config, fmt.Errorf("invalid dial_tcp value: %v", err)
+			}
+			config.DialTCP = dialTCP
+
+		case "policy_url":
+			config.PolicyURL = value
+
+		case "policy_timeout_ms":
+			timeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid policy_timeout_ms value: %v", err)
+			}
+			config.PolicyTimeout = timeout
+
+		case "policy_cache_ttl":
+			ttl, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid policy_cache_ttl value: %v", err)
+			}
+			config.PolicyCacheTTL = ttl
+
+		case "policy_fail_open":
+			failOpen, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid policy_fail_open value: %v", err)
+			}
+			config.PolicyFailOpen = failOpen
+
+		case "policy_script":
+			config.PolicyScript = value
+
+		case "rewrite_file":
+			config.RewriteFile = value
+
+		case "anomaly_detection":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid anomaly_detection value: %v", err)
+			}
+			config.AnomalyDetection = enabled
+
+		case "anomaly_factor":
+			factor, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return config, fmt.Errorf("invalid anomaly_factor value: %v", err)
+			}
+			config.AnomalyFactor = factor
+
+		case "anomaly_min_destinations":
+			minDest, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid anomaly_min_destinations value: %v", err)
+			}
+			config.AnomalyMinDestinations = minDest
+
+		case "anomaly_min_unique_hosts":
+			minHosts, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid anomaly_min_unique_hosts value: %v", err)
+			}
+			config.AnomalyMinUniqueHosts = minHosts
+
+		case "anomaly_min_bytes":
+			minBytes, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return config, fmt.Errorf("invalid anomaly_min_bytes value: %v", err)
+			}
+			config.AnomalyMinBytes = minBytes
+
+		case "anomaly_webhook":
+			config.AnomalyWebhook = value
+
+		case "sharing_max_networks":
+			maxNetworks, err := strconv.Atoi(value)
+			if err != nil || maxNetworks < 0 {
+				return config, fmt.Errorf("invalid sharing_max_networks value: %s", value)
+			}
+			config.SharingMaxNetworks = maxNetworks
+
+		case "sharing_group_max_networks":
+			group, maxNetworks, err := parseSharingGroupLimit(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid sharing_group_max_networks value: %v", err)
+			}
+			if config.SharingGroupMaxNetworks == nil {
+				config.SharingGroupMaxNetworks = make(map[string]int)
+			}
+			config.SharingGroupMaxNetworks[group] = maxNetworks
+
+		case "sharing_window":
+			window, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid sharing_window value: %v", err)
+			}
+			config.SharingWindow = window
+
+		case "sharing_action":
+			if !validSharingAction(value) {
+				return config, fmt.Errorf("invalid sharing_action value: %s", value)
+			}
+			config.SharingAction = value
+
+		case "sharing_suspend_time":
+			suspendTime, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid sharing_suspend_time value: %v", err)
+			}
+			config.SharingSuspendTime = suspendTime
+
+		case "sharing_ipv4_prefix":
+			prefix, err := strconv.Atoi(value)
+			if err != nil || prefix < 1 || prefix > 32 {
+				return config, fmt.Errorf("invalid sharing_ipv4_prefix value: %s", value)
+			}
+			config.SharingIPv4Prefix = prefix
+
+		case "sharing_ipv6_prefix":
+			prefix, err := strconv.Atoi(value)
+			if err != nil || prefix < 1 || prefix > 128 {
+				return config, fmt.Errorf("invalid sharing_ipv6_prefix value: %s", value)
+			}
+			config.SharingIPv6Prefix = prefix
+
+		case "expired_user_action":
+			if !validExpiredUserAction(value) {
+				return config, fmt.Errorf("invalid expired_user_action value: %s", value)
+			}
+			config.ExpiredUserAction = value
+
+		case "expired_user_retention":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 1 {
+				return config, fmt.Errorf("invalid expired_user_retention value: %s", value)
+			}
+			config.ExpiredUserRetention = days
+
+		case "expired_user_archive":
+			config.ExpiredUserArchive = value
+
+		case "strict_users":
+			strict, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid strict_users value: %v", err)
+			}
+			config.StrictUsers = strict
+
+		case "users_write_back":
+			writeBack, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid users_write_back value: %v", err)
+			}
+			config.UsersWriteBack = writeBack
+
+		case "stripe_webhook_secret":
+			config.StripeWebhookSecret = value
+
+		case "billing_plan":
+			plan, err := parseBillingPlan(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid billing_plan value: %v", err)
+			}
+			config.BillingPlans = append(config.BillingPlans, plan)
+
+		case "billing_notify_webhook":
+			config.BillingNotifyWebhook = value
+
+		case "telegram_bot_token":
+			config.TelegramBotToken = value
+
+		case "telegram_chat_id":
+			chatID, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || chatID == 0 {
+				return config, fmt.Errorf("invalid telegram_chat_id value: %s", value)
+			}
+			config.TelegramChatID = chatID
+
+		case "telegram_api_url":
+			config.TelegramAPIURL = value
+
+		case "discord_webhook":
+			config.DiscordWebhook = value
+
+		case "smtp_server":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return config, fmt.Errorf("invalid smtp_server value: %v", err)
+			}
+			config.SMTPServer = value
+
+		case "smtp_username":
+			config.SMTPUsername = value
+
+		case "smtp_password":
+			config.SMTPPassword = value
+
+		case "smtp_from":
+			if _, err := mail.ParseAddress(value); err != nil {
+				return config, fmt.Errorf("invalid smtp_from value: %v", err)
+			}
+			config.SMTPFrom = value
+
+		case "email_event":
+			event, path, err := parseEmailEvent(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid email_event value: %v", err)
+			}
+			if config.EmailEvents == nil {
+				config.EmailEvents = make(map[string]string)
+			}
+			config.EmailEvents[event] = path
+
+		case "email_quota_warning":
+			percent, err := strconv.Atoi(value)
+			if err != nil || percent < 1 || percent > 100 {
+				return config, fmt.Errorf("invalid email_quota_warning value: %s", value)
+			}
+			config.EmailQuotaWarning = percent
+
+		case "email_expiry_warning":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 1 {
+				return config, fmt.Errorf("invalid email_expiry_warning value: %s", value)
+			}
+			config.EmailExpiryWarning = days
+
+		case "alert_webhook":
+			config.AlertWebhook = value
+
+		case "alertmanager_url":
+			config.AlertmanagerURL = value
+
+		case "alert_error_rate":
+			percent, err := strconv.Atoi(value)
+			if err != nil || percent < 1 || percent > 100 {
+				return config, fmt.Errorf("invalid alert_error_rate value: %s", value)
+			}
+			config.AlertErrorRate = percent
+
+		case "alert_fd_percent":
+			percent, err := strconv.Atoi(value)
+			if err != nil || percent < 1 || percent > 100 {
+				return config, fmt.Errorf("invalid alert_fd_percent value: %s", value)
+			}
+			config.AlertFDPercent = percent
+
+		case "statsd_address":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return config, fmt.Errorf("invalid statsd_address value: %v", err)
+			}
+			config.StatsdAddress = value
+
+		case "statsd_flavor":
+			if !validStatsdFlavor(value) {
+				return config, fmt.Errorf("invalid statsd_flavor value: %s", value)
+			}
+			config.StatsdFlavor = value
+
+		case "statsd_prefix":
+			config.StatsdPrefix = value
+
+		case "statsd_tags":
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					config.StatsdTags = append(config.StatsdTags, statsdSanitize(tag, true))
+				}
+			}
+
+		case "statsd_interval":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 1 {
+				return config, fmt.Errorf("invalid statsd_interval value: %s", value)
+			}
+			config.StatsdInterval = seconds
+
+		case "top_talkers_window":
+			minutes, err := strconv.Atoi(value)
+			if err != nil || minutes < 1 || minutes > 24*60 {
+				return config, fmt.Errorf("invalid top_talkers_window value: %s", value)
+			}
+			config.TopTalkersWindow = minutes
+
+		case "reputation_list":
+			config.ReputationLists = append(config.ReputationLists, value)
+
+		case "reputation_dnsbl":
+			config.ReputationDNSBL = append(config.ReputationDNSBL, value)
+
+		case "reputation_action":
+			if !validReputationAction(value) {
+				return config, fmt.Errorf("invalid reputation_action value: %s", value)
+			}
+			config.ReputationAction = value
+
+		case "reputation_cache_ttl":
+			ttl, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid reputation_cache_ttl value: %v", err)
+			}
+			config.ReputationCacheTTL = ttl
+
+		case "reputation_refresh":
+			refresh, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid reputation_refresh value: %v", err)
+			}
+			config.ReputationRefresh = refresh
+
+		case "audit_log_file":
+			config.AuditLogFile = value
+
+		case "upgrade_drain_timeout":
+			drainTimeout, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid upgrade_drain_timeout value: %v", err)
+			}
+			config.UpgradeDrainTimeout = drainTimeout
+
+		case "capture_dir":
+			config.CaptureDir = value
+
+		case "crash_dir":
+			config.CrashDir = value
+
+		case "crash_report_url":
+			config.CrashReportURL = value
+
+		case "access_log_file":
+			config.AccessLogFile = value
+
+		case "access_log_rotate_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid access_log_rotate_interval value: %v", err)
+			}
+			config.AccessLogRotateInterval = interval
+
+		case "access_log_rotate_mb":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				return config, fmt.Errorf("invalid access_log_rotate_mb value: %s", value)
+			}
+			config.AccessLogRotateMB = size
+
+		case "access_log_ship":
+			if rest, found := strings.CutPrefix(value, "s3://"); !found || strings.HasPrefix(rest, "/") || rest == "" {
+				return config, fmt.Errorf("invalid access_log_ship value: %s", value)
+			}
+			config.AccessLogShip = value
+
+		case "access_log_ship_endpoint":
+			config.AccessLogShipEndpoint = value
+
+		case "analytics_sink":
+			if !validAnalyticsSink(value) {
+				return config, fmt.Errorf("invalid analytics_sink value: %s (expected clickhouse or timescaledb)", value)
+			}
+			config.AnalyticsSink = value
+
+		case "analytics_dsn":
+			config.AnalyticsDSN = value
+
+		case "analytics_table":
+			if !validAnalyticsTable(value) {
+				return config, fmt.Errorf("invalid analytics_table value: %s", value)
+			}
+			config.AnalyticsTable = value
+
+		case "analytics_batch_size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				return config, fmt.Errorf("invalid analytics_batch_size value: %s", value)
+			}
+			config.AnalyticsBatchSize = size
+
+		case "analytics_flush_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 0 {
+				return config, fmt.Errorf("invalid analytics_flush_interval value: %s", value)
+			}
+			config.AnalyticsFlushInterval = interval
+
+		case "analytics_buffer":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				return config, fmt.Errorf("invalid analytics_buffer value: %s", value)
+			}
+			config.AnalyticsBuffer = size
+
+		case "event_bus":
+			if !isSecretReference(value) && !strings.HasPrefix(value, encryptedPrefix) {
+				if _, err := parseEventBusURL(value); err != nil {
+					return config, fmt.Errorf("invalid event_bus value: %v", err)
+				}
+			}
+			config.EventBus = value
+
+		case "event_bus_topic":
+			if strings.ContainsAny(value, " \t") {
+				return config, fmt.Errorf("invalid event_bus_topic value: %s", value)
+			}
+			config.EventBusTopic = value
+
+		case "event_bus_encoding":
+			if !validEventEncoding(value) {
+				return config, fmt.Errorf("invalid event_bus_encoding value: %s (expected json or protobuf)", value)
+			}
+			config.EventBusEncoding = value
+
+		case "event_bus_events":
+			for _, event := range strings.Split(value, ",") {
+				if event = strings.TrimSpace(event); event == "" {
+					continue
+				}
+				if !validEventType(event) {
+					return config, fmt.Errorf("invalid event_bus_events value: unknown event %s", event)
+				}
+				config.EventBusEvents = append(config.EventBusEvents, event)
+			}
+
+		case "event_bus_buffer":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				return config, fmt.Errorf("invalid event_bus_buffer value: %s", value)
+			}
+			config.EventBusBuffer = size
+
+		case "kernel_accounting":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid kernel_accounting value: %v", err)
+			}
+			if enabled && !kernelAccountingSupported {
+				return config, errors.New("invalid kernel_accounting value: only supported on Linux")
+			}
+			config.KernelAccounting = enabled
+
+		case "kernel_accounting_interval":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 0 {
+				return config, fmt.Errorf("invalid kernel_accounting_interval value: %s", value)
+			}
+			config.KernelAccountingInterval = interval
+
+		case "ktls":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid ktls value: %v", err)
+			}
+			if enabled && !ktlsSupported {
+				return config, errors.New("invalid ktls value: only supported on Linux")
+			}
+			config.KTLS = enabled
+
+		case "auth_log_file":
+			config.AuthLogFile = value
+
+		case "master_key_command":
+			config.MasterKeyCommand = value
+
+		case "vault_addr":
+			config.VaultAddr = value
+
+		case "vault_token_file":
+			config.VaultTokenFile = value
+
+		case "vault_namespace":
+			config.VaultNamespace = value
+
+		case "aws_region":
+			config.AWSRegion = value
+
+		case "ssm_endpoint":
+			config.SSMEndpoint = value
+
+		default:
+			log.Printf("Unknown configuration key: %s", key)
+		}
+	}
+
+	if err := validateDNSOptions(DNSOptions{Mode: config.DNSMode, Prefer: config.DNSPrefer}.withDefaults()); err != nil {
+		return config, err
+	}
+	if err := validateSocksFamilies(config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// Load user từ file
+func loadUsers(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	newUsers, err := parseUsers(file, systemConfig().StrictUsers)
+	if err != nil {
+		return err
+	}
+
+	// Lock the users map and update it with the new data
+	usersMutex.Lock()
+	replaceUsers(newUsers)
+	usersMutex.Unlock()
+
+	log.Println("User list reloaded successfully.")
+	return nil
+}
+
+// Đọc danh sách user, mỗi dòng: username,password,start,end,conn_limit,max_data,max_bandwidth[,owner[,group[,max_transfer[,email[,tags]]]]].
+// Mặc định dòng sai số cột bị bỏ qua và username trùng thì dòng sau thắng; với strict, dòng lỗi và
+// username trùng được báo theo số dòng và cả file bị từ chối
+func parseUsers(r io.Reader, strict bool) (map[string]*User, error) {
+	if strict {
+		return parseUsersStrict(r)
+	}
+	scanner := bufio.NewScanner(r)
+	newUsers := make(map[string]*User) // Temporary user map
+
+	for scanner.Scan() {
+		parts, err := splitUserLine(scanner.Text())
+		if err != nil || len(parts) < 7 || len(parts) > 12 {
+			continue
+		}
+
+		startDate, _ := time.Parse("2006-01-02", parts[2])
+		endDate, _ := time.Parse("2006-01-02", parts[3])
+		connectionLimit, _ := strconv.Atoi(parts[4])
+		maxData, _ := strconv.ParseInt(parts[5], 10, 64)
+		maxBandwidth, _ := strconv.ParseInt(parts[6], 10, 64)
+
+		password, err := resolveSecret(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %v", parts[0], err)
+		}
+
+		user := &User{
+			Username:        parts[0],
+			Password:        password,
+			StartDate:       startDate,
+			EndDate:         endDate,
+			ConnectionLimit: connectionLimit,
+			MaxData:         maxData,
+			MaxBandwidth:    maxBandwidth,
+			state:           new(userState),
+		}
+		if len(parts) >= 8 {
+			user.Owner = parts[7]
+		}
+		if len(parts) >= 9 {
+			user.Group = parts[8]
+		}
+		if len(parts) >= 10 {
+			user.MaxTransfer, _ = strconv.ParseInt(parts[9], 10, 64)
+		}
+		if len(parts) >= 11 {
+			user.Email = parts[10]
+		}
+		if len(parts) == 12 {
+			user.Tags, _ = parseTags(parts[11])
+		}
+		newUsers[parts[0]] = user
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newUsers, nil
+}
+
+// Xác thực người dùng dựa trên username và password; lỗi là *ConnError mang lý do cho auth log
+func authenticateUser(username, password string) (*User, error) {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	// User không tồn tại vẫn so sánh password như thường để thời gian xử lý giống sai password
+	user, exists := users[username]
+	stored := ""
+	if exists {
+		stored = user.Password
+	}
+	if !auth.PasswordEqual(stored, password) || !exists {
+		return nil, newConnError(AuthInvalidCredentials, nil) // Không tồn tại user hoặc sai password
+	}
+
+	// User bị tạm khóa, hoặc đã hết hạn khi bật expired_user_action
+	if user.isSuspended() {
+		return nil, newConnError(AuthAccountSuspended, nil)
+	}
+	if userExpired(user, time.Now()) {
+		return nil, newConnError(AuthAccountExpired, nil)
+	}
+
+	// Đã dùng hết max_data (kể cả phần của các tunnel đang mở)
+	if remaining, limited := user.quotaRemaining(); limited && remaining <= 0 {
+		return nil, newConnError(AuthQuotaExceeded, nil)
+	}
+
+	// Giữ chỗ trong giới hạn số lượng kết nối, bên gọi trả lại bằng releaseConn
+	if !user.reserveConn() {
+		return nil, newConnError(AuthConnectionLimit, nil)
+	}
+
+	return user, nil
+}
+
+// Xử lý kết nối SOCKS4
+func handleSocks4(conn net.Conn, user *User, listener string) {
+	defer conn.Close()
+
+	// Đọc yêu cầu SOCKS4, chỉ hỗ trợ lệnh CONNECT
+	request, err := socks4.ReadRequest(conn)
+	if err != nil {
+		rejectSocksRequest(conn, "SOCKS4", err, socks4.Reply)
+		return
+	}
+
+	// Kết nối tới địa chỉ đích
+	started := time.Now()
+	info := newConnInfo("socks4", listener, conn, user, request.Dest, auth.UsernameParams{})
+	if maintenanceActive() {
+		conn.Write(socks4.Reply(socks4.Rejected))
+		finishConn(info, user, 0, 0, started, CloseMaintenance)
+		return
+	}
+	if err := runConnectRequestHooks(info, user); err != nil {
+		conn.Write(socks4.Reply(socks4.Rejected))
+		denyByHook(info, user, started, err)
+		return
+	}
+	targetConn, err := dialTarget(info.Dest, "", user, info.Egress, info.Country)
+	if err != nil {
+		conn.Write(socks4.Reply(socks4.Rejected)) // Không thể kết nối
+		failConn(info, user, started, newConnError(classifyDialError(err), err))
+		return
+	}
+	defer targetConn.Close()
+	if err := runDialedHooks(info, targetConn); err != nil {
+		conn.Write(socks4.Reply(socks4.Rejected))
+		denyByHook(info, user, started, err)
+		return
+	}
+
+	conn.Write(socks4.Reply(socks4.Granted)) // Xác nhận kết nối thành công
+	endHandshake(conn)                       // Bỏ hạn và giới hạn byte của bắt tay
+	recordDestination(user, info.Dest)
+
+	// Truyền dữ liệu giữa client và đích
+	up, down, reason := transferData(conn, targetConn, user, info)
+	finishConn(info, user, up, down, started, reason)
+}
+
+// Xử lý kết nối SOCKS5 với xác thực username/password
+func handleSocks5(conn net.Conn, user *User, listener string) {
+	defer conn.Close()
+
+	// Bước 1: Handshake, không có phương thức nào được chấp nhận thì trả về 0xFF
+	if err := socks5.ReadGreeting(conn); err != nil {
+		rejectSocksRequest(conn, "SOCKS5", err, func(code byte) []byte { return []byte{0x05, code} })
+		return
+	}
+
+	// Trả về rằng yêu cầu xác thực username/password (mã 0x02)
+	conn.Write([]byte{0x05, 0x02})
+
+	// Bước 2: Xác thực username/password
+	username, password, err := socks5.ReadAuth(conn)
+	if err != nil {
+		rejectSocksRequest(conn, "SOCKS5", err, func(code byte) []byte { return []byte{0x01, code} })
+		return
+	}
+
+	// Mọi lần từ chối sau đây được trả lời cùng một lúc tính từ thời điểm này
+	authStarted := time.Now()
+
+	// Username hoặc password rỗng, có ký tự điều khiển: từ chối trước khi tra user hay ghi log với username
+	if !auth.ValidUsername(username) {
+		recordMalformed(malformedUsername, conn.RemoteAddr(), "empty or with control characters")
+		logAuthFailure(conn.RemoteAddr().String(), "socks5", string(username), AuthMalformedRequest)
+		waitAuthFailure(authStarted)
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+	if !auth.ValidPassword(password) {
+		recordMalformed(malformedPassword, conn.RemoteAddr(), "empty or with NUL, CR or LF")
+		logAuthFailure(conn.RemoteAddr().String(), "socks5", string(username), AuthMalformedRequest)
+		waitAuthFailure(authStarted)
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+
+	// Tham số phiên và quốc gia gắn trong username (username_params)
+	name, params, err := resolveUsernameParams(string(username))
+	if err != nil {
+		log.Printf("SOCKS5 authentication of %s from %s refused: %v", username, conn.RemoteAddr(), err)
+		logAuthFailure(conn.RemoteAddr().String(), "socks5", string(username), AuthInvalidParams)
+		waitAuthFailure(authStarted)
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+
+	// Xác thực người dùng
+	user, err = authenticateUser(name, string(password))
+	if err != nil {
+		logAuthFailure(conn.RemoteAddr().String(), "socks5", string(username), ErrorReason(err))
+		waitAuthFailure(authStarted)
+		conn.Write([]byte{0x01, 0x01}) // Trả về mã lỗi xác thực
+		return
+	}
+	defer user.releaseConn()
+
+	// Tài khoản dùng từ quá nhiều mạng client (sharing_max_networks)
+	if err := checkSharing(user, conn.RemoteAddr()); err != nil {
+		log.Printf("SOCKS5 authentication of %s from %s refused: %v", username, conn.RemoteAddr(), err)
+		waitAuthFailure(authStarted)
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+
+	info := newConnInfo("socks5", listener, conn, user, "", params)
+	if err := runAuthHooks(info); err != nil {
+		log.Printf("SOCKS5 authentication of %s denied by hook: %v", username, err)
+		waitAuthFailure(authStarted)
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+
+	recordLogin(user, info)
+	conn.Write([]byte{0x01, 0x00}) // Xác thực thành công
+
+	// Bước 3: Xử lý yêu cầu kết nối (chỉ hỗ trợ CONNECT) tới IPv4, IPv6 hoặc domain name. Listener bắt
+	// client tự phân giải thì không nhận domain
+	dnsOptions := dnsOptionsFor(listener)
+	destAddr, err := readSocks5Request(conn, dnsOptions.Mode != DNSModeReject)
+	if err != nil {
+		rejectSocksRequest(conn, "SOCKS5", err, func(code byte) []byte { return socks5.Reply(code, nil) })
+		return
+	}
+
+	// Kết nối tới địa chỉ đích
+	started := time.Now()
+	info.Dest = destAddr
+	if maintenanceActive() {
+		conn.Write(socks5.Reply(maintenanceSOCKS5Reply(), nil)) // Tunnel mới bị từ chối, tunnel đang mở không bị ảnh hưởng
+		finishConn(info, user, 0, 0, started, CloseMaintenance)
+		return
+	}
+	if err := runConnectRequestHooks(info, user); err != nil {
+		conn.Write(socks5.Reply(socks5.NotAllowed, nil))
+		denyByHook(info, user, started, err)
+		return
+	}
+	targetConn, err := dialTarget(info.Dest, dnsOptions.Prefer, user, info.Egress, info.Country)
+	if err != nil {
+		conn.Write(socks5.Reply(socks5ReplyCode(err), nil)) // Mã lỗi theo nguyên nhân kết nối thất bại
+		failConn(info, user, started, newConnError(classifyDialError(err), err))
+		return
+	}
+	defer targetConn.Close()
+	if err := runDialedHooks(info, targetConn); err != nil {
+		conn.Write(socks5.Reply(socks5.NotAllowed, nil))
+		denyByHook(info, user, started, err)
+		return
+	}
+
+	// Trả về thành công kết nối
+	conn.Write(socks5.Reply(socks5.Succeeded, targetConn.LocalAddr()))
+	endHandshake(conn) // Bỏ hạn và giới hạn byte của bắt tay
+	recordDestination(user, info.Dest)
+
+	// Truyền dữ liệu giữa client và đích
+	up, down, reason := transferData(conn, targetConn, user, info)
+	finishConn(info, user, up, down, started, reason)
+}
+
+// Truyền dữ liệu giữa client và server đích với giới hạn băng thông (trừ đích unmetered),
+// trả về số byte gửi lên, nhận về và lý do kết thúc
+func transferData(src, dst net.Conn, user *User, info *ConnInfo) (int64, int64, string) {
+	copyData := io.Copy
+	lowLatency := lowLatencyRelay(user, info.Dest)
+	if lowLatency {
+		latencyTunnels.Add(1)
+		setNoDelay(src)
+		setNoDelay(dst)
+		copyData = copyLowLatency
+	}
+
+	limit := int64(-1)
+	var upReader, downReader io.Reader = src, dst
+	if user != nil && !info.Unmetered {
+		// Giới hạn băng thông và theo dõi dữ liệu
+		limit = user.MaxBandwidth
+		upReader = io.LimitReader(src, limit)
+		downReader = io.LimitReader(dst, limit)
+	}
+
+	type copyEnd struct {
+		fromClient bool
+		n          int64
+		reason     string
+	}
+	ends := make(chan copyEnd, 2)
+
+	// Ghi lại dữ liệu nếu có quy tắc capture cho user
+	var upWriter, downWriter io.Writer = dst, src
+	capture := startCapture(user, src, dst)
+	if capture != nil {
+		defer capture.close()
+		upWriter = io.MultiWriter(dst, capture.direction(true))
+		downWriter = io.MultiWriter(src, capture.direction(false))
+	}
+
+	tunnel := registerTunnel(user, info, src, dst)
+	defer unregisterTunnel(tunnel)
+
+	// Đếm byte trong kernel khi không có lớp nào cần đọc dữ liệu trong userspace
+	var account *kernelAccount
+	if capture == nil && !lowLatency && !systemConfig().FairScheduling && (user == nil || user.MaxTransfer <= 0) {
+		account = startKernelAccount(tunnel, src, dst)
+	}
+	if account != nil {
+		// Chép thẳng giữa hai socket để io.Copy dùng splice
+		upReader, downReader = account.clientRelay, account.target
+		if limit >= 0 {
+			upReader = io.LimitReader(account.clientRelay, limit)
+			downReader = io.LimitReader(account.target, limit)
+		}
+		upWriter, downWriter = account.target, account.clientRelay
+	}
+
+	// Chia băng thông theo user khi server bão hòa
+	if systemConfig().FairScheduling {
+		username := ""
+		if user != nil {
+			username = user.Username
+		}
+		upReader = fairScheduler.Reader(upReader, username)
+		downReader = fairScheduler.Reader(downReader, username)
+	}
+
+	// Hạn mức byte của riêng tunnel này, dùng chung cho hai chiều
+	if user != nil && user.MaxTransfer > 0 {
+		limit := limits.NewTransferLimit(user.MaxTransfer)
+		upReader = limit.Reader(upReader)
+		downReader = limit.Reader(downReader)
+	}
+
+	var up, down io.Writer = upWriter, downWriter
+	if account == nil || account.countsUp {
+		up = &countingWriter{w: upWriter, n: &tunnel.up, tunnel: tunnel, total: &bytesUpTotal}
+	}
+	if account == nil {
+		down = &countingWriter{w: downWriter, n: &tunnel.down, tunnel: tunnel, total: &bytesDownTotal}
+	}
+	go func() {
+		n, err := copyData(up, upReader)
+		recordTrafficBytes(user, n, 0)
+		ends <- copyEnd{true, n, classifyCopyEnd(true, n, limit, err)}
+	}()
+	go func() {
+		n, err := copyData(down, downReader)
+		recordTrafficBytes(user, 0, n)
+		ends <- copyEnd{false, n, classifyCopyEnd(false, n, limit, err)}
+	}()
+
+	// Số byte đã chép mỗi chiều, dùng cho kernel accounting
+	var copiedUp, copiedDown int64
+	received := func(end copyEnd) {
+		if end.fromClient {
+			copiedUp = end.n
+		} else {
+			copiedDown = end.n
+		}
+	}
+
+	// Chiều kết thúc trước quyết định lý do. Client đóng chiều gửi: báo EOF cho đích (half-close)
+	// và chờ chiều nhận về. Các trường hợp khác (lỗi, hết quota, đích kết thúc) kết thúc tunnel ngay
+	first := <-ends
+	received(first)
+	remaining := 1
+	if first.fromClient && first.reason == CloseClientEOF {
+		closeWrite(dst)
+		received(<-ends)
+		remaining = 0
+	}
+	// Đóng cả hai kết nối để goroutine còn lại thoát, không phụ thuộc bên gọi
+	src.Close()
+	dst.Close()
+	for ; remaining > 0; remaining-- {
+		received(<-ends)
+	}
+	if account != nil {
+		account.finish(copiedUp, copiedDown)
+	}
+	if reason := tunnel.closedReason(); reason != "" {
+		first.reason = reason
+	}
+	return tunnel.up.Load(), tunnel.down.Load(), first.reason
+}
+
+// Writer đếm số byte đã ghi, an toàn khi đọc từ goroutine khác. Chỉ ghi phần còn lại trong max_data
+// của user rồi trả về ErrQuotaExceeded; các tunnel của cùng user ghi đồng thời nên tổng có thể vượt
+// max_data tối đa một lần ghi của mỗi tunnel
+type countingWriter struct {
+	w      io.Writer
+	n      *atomic.Int64 // Bộ đếm của chiều này trong tunnel
+	tunnel *activeTunnel
+	total  *atomic.Int64 // Bộ đếm toàn server của chiều này
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	var quotaErr error
+	if remaining, limited := c.tunnel.quotaRemaining(); limited && int64(len(p)) > remaining {
+		p, quotaErr = p[:max(remaining, 0)], ErrQuotaExceeded
+	}
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	c.total.Add(int64(n))
+	c.tunnel.addUsage(int64(n))
+	c.tunnel.touch()
+	if err == nil {
+		err = quotaErr
+	}
+	return n, err
+}
+
+// Mở listener từ menu; lỗi chỉ được in ra, menu vẫn tiếp tục. Port đang bị chiếm được thử lại trong nền
+func startServer(kind, network, addr string) {
+	err := startInstanceRetrying(kind, network, addr)
+	if errors.Is(err, errListenerRetrying) {
+		fmt.Println(msg("listener.retrying", addr))
+	} else if err != nil {
+		fmt.Println(msg("listener.start_failed", addr, err))
+		return
+	}
+	recordAudit("console", "listener.start", addr, nil, kind)
+}
+
+// Dừng listener từ menu: hỏi địa chỉ khi có nhiều listener, bỏ trống để dừng tất cả
+func stopServer(input *bufio.Scanner) {
+	addrs := runningInstances("")
+	if len(addrs) == 0 {
+		fmt.Println(msg("listener.none_running"))
+		return
+	}
+	if len(addrs) > 1 {
+		fmt.Println(msg("listener.running", strings.Join(addrs, ", ")))
+		addr, ok := readMenuLine(input, msg("listener.stop_prompt"))
+		if !ok {
+			return
+		}
+		if addr != "" {
+			addrs = []string{addr}
+		}
+	}
+	for _, addr := range addrs {
+		if err := stopInstance(addr); err != nil {
+			fmt.Println(msg("listener.stop_failed", addr, err))
+			continue
+		}
+		recordAudit("console", "listener.stop", addr, nil, nil)
+	}
+}
+
+// In lời nhắc và đọc một dòng từ console; false khi không còn đầu vào
+func readMenuLine(input *bufio.Scanner, prompt string) (string, bool) {
+	fmt.Print(prompt)
+	if !input.Scan() {
+		return "", false
+	}
+	return strings.TrimSpace(input.Text()), true
+}
+
+// Mở listener SOCKS tại addr với network tcp, tcp4 hoặc tcp6
+func listenSocks(network, addr string) (net.Listener, error) {
+	listener, err := listenTCPNetwork(listenerSocks, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	listener = tuneListener(listener, addr)
+	log.Printf("Server started on %s", addr)
+	return listener, nil
+}
+
+// Nhận kết nối trên listener SOCKS tại addr cho tới khi listener bị đóng
+func acceptSocks(listener net.Listener, addr string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Accept error on %s: %v", addr, err)
+			backoffOnFDExhaustion(err)
+			continue
+		}
+
+		// Sắp hết file descriptor hoặc bộ nhớ: đóng ngay, không giữ thêm tài nguyên để trả lời
+		if shedIfFDExhausted(conn) || shedIfMemoryHigh(conn) {
+			continue
+		}
+
+		// Hết credit: chờ trong giới hạn accept_queue_timeout_ms rồi từ chối
+		if !acquireConnCredit() {
+			refuseSocksConn(conn)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseConnCredit()
+			defer recoverPanic("socks connection", conn)
+			handleSocksConn(conn, addr)
+		}()
+	}
+}
+
+// Menu điều khiển trên console. Server chạy trong goroutine riêng nên menu luôn nhận lệnh tiếp theo
+func showMenu() {
+	input := bufio.NewScanner(os.Stdin)
+	for {
+		for _, id := range []string{"menu.title", "menu.status", "menu.start_ipv4", "menu.start_ipv6", "menu.stop",
+			"menu.list_ipv6", "menu.start_other", "menu.start_dual", "menu.maintenance", "menu.connections"} {
+			fmt.Println(msg(id))
+		}
+
+		line, ok := readMenuLine(input, msg("menu.choose"))
+		if !ok {
+			// Không còn đầu vào (ví dụ chạy dưới systemd): server tiếp tục chạy, không có menu
+			log.Println("Console input closed, menu disabled.")
+			select {}
+		}
+		choice, _ := strconv.Atoi(line)
+
+		switch choice {
+		case 1:
+			// Trạng thái server
+			printServerStatus()
+		case 2:
+			// Tạo Proxy chỉ IPv4 trên socks_ipv4_port
+			network, addr := socksFamilyAddr(familyIPv4, "")
+			startServer(listenerSocks, network, addr)
+		case 3:
+			// Tạo Proxy chỉ IPv6 trên socks_ipv6_port, trên mọi địa chỉ IPv6 hoặc một địa chỉ cụ thể (có thể kèm zone ID)
+			host, _ := readMenuLine(input, msg("menu.ipv6_prompt"))
+			if host != "" {
+				if ip, ok := parseHostIP(host); !ok || !ip.Is6() {
+					fmt.Println(msg("menu.ipv6_invalid", host))
+					continue
+				}
+			}
+			network, addr := socksFamilyAddr(familyIPv6, strings.Trim(host, "[]"))
+			startServer(listenerSocks, network, addr)
+		case 4:
+			// Dừng server
+			stopServer(input)
+		case 5:
+			// Hiển thị danh sách proxy IPv6
+			fmt.Println(msg("menu.ipv6_list", ipv6ProxyList))
+		case 6:
+			// Mở thêm listener SOCKS hoặc SOCKS nén
+			addr, _ := readMenuLine(input, msg("menu.address_prompt"))
+			kind, _ := readMenuLine(input, msg("menu.protocol_prompt"))
+			if kind == "" {
+				kind = listenerSocks
+			}
+			if addr != "" {
+				startServer(kind, "tcp", addr)
+			}
+		case 7:
+			// Tạo Proxy dual-stack trên socks_dual_stack_port: một listener [::] nhận cả IPv4 và IPv6
+			network, addr := socksFamilyAddr(familyDualStack, "")
+			startServer(listenerSocks, network, addr)
+		case 8:
+			// Bảo trì: từ chối tunnel mới, tunnel đang mở chạy tiếp tới khi kết thúc
+			if maintenanceActive() {
+				setMaintenance(false, "", "menu")
+				recordAudit("console", "maintenance.set", "", true, false)
+				fmt.Println(msg("menu.maintenance_off"))
+				continue
+			}
+			message, _ := readMenuLine(input, msg("menu.maintenance_prompt"))
+			setMaintenance(true, message, "menu")
+			recordAudit("console", "maintenance.set", "", false, true)
+			fmt.Println(msg("menu.maintenance_on", currentServerStatus().TunnelsActive))
+		case 9:
+			// Xem tunnel đang mở, lọc theo user, client, đích, listener hoặc thời gian
+			filter, _ := readMenuLine(input, msg("menu.connections_prompt"))
+			printConnections(filter)
+		default:
+			fmt.Println(msg("menu.invalid_choice"))
+		}
+	}
+}
+
+// Chạy chương trình proxy-server: lệnh con nếu có tham số, ngược lại khởi động server và menu điều khiển
+func Main() {
+	// Tùy chọn chung đứng trước lệnh con, ví dụ proxy-server --profile staging config apply
+	flags := flag.NewFlagSet("proxy-server", flag.ContinueOnError)
+	flags.StringVar(&configProfile, "profile", configProfile, "configuration profile, a [name] section of "+systemFile)
+	showVersion := flags.Bool("version", false, "print version and build information, then exit")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if *showVersion {
+		fmt.Println(currentBuildInfo())
+		os.Exit(0)
+	}
+	if flags.NArg() > 0 {
+		os.Exit(runCommand(flags.Args()))
+	}
+
+	log.Printf("Starting %s", currentBuildInfo())
+
+	// Nhận listener từ process cũ nếu đang nâng cấp nóng
+	loadInheritedListeners()
+
+	if err := startServices(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Tiếp tục phục vụ các listener SOCKS (kể cả SOCKS nén mở từ API) được chuyển từ process cũ
+	for _, kind := range []string{listenerSocks, listenerCompress} {
+		for _, addr := range inheritedAddrs(kind) {
+			if err := startInstance(kind, addr); err != nil {
+				log.Printf("Cannot resume listener on %s: %v", addr, err)
+			}
+		}
+	}
+	// Listener cũ không còn trong cấu hình mới được đóng sau khi các dịch vụ đã khởi động
+	time.AfterFunc(30*time.Second, closeUnusedInheritedListeners)
+	watchUpgradeSignal()
+	watchRotationSignal()
+	watchShutdownSignal()
+	signalUpgradeReady()
+
+	// Bắt đầu menu điều khiển server
+	showMenu()
+}
+
+// Load cấu hình, user và khởi động các dịch vụ nền (log, admin API, TLS offload, health check)
+func startServices() error {
+	if err := loadSystemConfig(systemFile); err != nil {
+		return fmt.Errorf("unable to load system configuration: %v", err)
+	}
+	if err := loadUsers(userFile); err != nil {
+		return fmt.Errorf("unable to load user list: %v", err)
+	}
+	syncMaintenanceConfig(SystemConfig{}, *systemConfig())
+	if err := startCrashOutput(); err != nil {
+		return fmt.Errorf("unable to open crash directory: %v", err)
+	}
+	if err := startUsageJournal(); err != nil {
+		return fmt.Errorf("unable to open usage journal: %v", err)
+	}
+	if err := startLastSeen(); err != nil {
+		return fmt.Errorf("unable to load last seen file: %v", err)
+	}
+
+	if systemConfig().AuditLogFile != "" {
+		if err := openAuditLog(systemConfig().AuditLogFile); err != nil {
+			return fmt.Errorf("unable to open audit log: %v", err)
+		}
+	}
+
+	if systemConfig().AccessLogFile != "" {
+		if err := openAccessLog(systemConfig().AccessLogFile); err != nil {
+			return fmt.Errorf("unable to open access log: %v", err)
+		}
+	}
+
+	if systemConfig().AuthLogFile != "" {
+		if err := openAuthLog(systemConfig().AuthLogFile); err != nil {
+			return fmt.Errorf("unable to open auth log: %v", err)
+		}
+	}
+
+	// Khởi động admin API nếu được cấu hình
+	if systemConfig().AdminListen != "" {
+		if err := loadAPITokens(adminTokensPath()); err != nil {
+			return fmt.Errorf("unable to load admin API tokens: %v", err)
+		}
+		go startAdminServer(systemConfig().AdminListen)
+	}
+	if systemConfig().HealthListen != "" {
+		go startHealthServer(systemConfig().HealthListen)
+	}
+	if systemConfig().UsageListen != "" {
+		go startUsageServer(systemConfig().UsageListen)
+	}
+	if err := startCluster(); err != nil {
+		return fmt.Errorf("unable to start cluster: %v", err)
+	}
+	if err := startAgent(); err != nil {
+		return fmt.Errorf("unable to start agent: %v", err)
+	}
+
+	for _, offload := range systemConfig().TLSOffloads {
+		go startTLSOffload(offload)
+	}
+	if systemConfig().CompressListen != "" {
+		if err := startInstanceRetrying(listenerCompress, "tcp", systemConfig().CompressListen); err != nil {
+			log.Printf("Compressed listener %s: %v", systemConfig().CompressListen, err)
+		}
+	}
+	for _, obfs := range systemConfig().ObfsListeners {
+		go startObfsListener(obfs)
+	}
+	startFamilyInstances()
+
+	startFDBudget()
+	go runMemoryGuard()
+
+	syncEgressPool(systemConfig().EgressIPs)
+	go runEgressHealthChecks()
+
+	if upstreamsEnabled() {
+		if err := reloadUpstreams(); err != nil {
+			return fmt.Errorf("unable to load upstream proxies: %v", err)
+		}
+	}
+	go runUpstreamHealthChecks()
+
+	if err := reloadPolicyScript(); err != nil {
+		return fmt.Errorf("unable to load policy script: %v", err)
+	}
+	go runPolicyScriptWatcher()
+	go runConfigWatcher()
+	go runSessionJanitor()
+	go runSharingJanitor()
+	go runUserExpiry()
+	go runTunnelLifetimeSweeper()
+	go fairScheduler.Run()
+	go runAlerts()
+	startStatsd()
+
+	if err := reloadReputationLists(); err != nil {
+		log.Printf("Reputation list error: %v", err)
+	}
+	go runReputationRefresher()
+
+	if err := reloadRewriteRules(); err != nil {
+		return fmt.Errorf("unable to load rewrite rules: %v", err)
+	}
+	if err := reloadDNSOverrides(); err != nil {
+		return fmt.Errorf("unable to load DNS overrides: %v", err)
+	}
+	if err := reloadACL(); err != nil {
+		return fmt.Errorf("unable to load ACL: %v", err)
+	}
+
+	startBot()
+	startEmailNotifier()
+	go runBackupScheduler()
+	go runAccessLogRotator()
+	if err := startAnalytics(); err != nil {
+		return fmt.Errorf("unable to start analytics sink: %v", err)
+	}
+	startEventBus()
+	go runKernelAccounting()
+	notifyOperators("Proxy server started")
+	return nil
+}
@@ -0,0 +1,193 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+	"proxy_server/proxyserver/socks5"
+)
+
+// Chế độ bảo trì: tunnel mới bị từ chối bằng mã lỗi SOCKS cấu hình được (client HTTP gõ nhầm vào cổng
+// SOCKS nhận trang thông báo 503), tunnel đang mở vẫn chạy tới khi tự kết thúc.
+// Bật bằng maintenance=true trong system.conf hoặc qua admin API, menu, lệnh /maintenance của bot
+const (
+	defaultMaintenanceMessage = "The proxy is down for scheduled maintenance. Please try again later."
+	maintenanceReadTimeout    = 10 * time.Second
+	maxMaintenancePage        = 1 << 20
+)
+
+// Trạng thái bảo trì trả về qua API và trong trạng thái server
+type MaintenanceStatus struct {
+	Since   time.Time `json:"since"`
+	Source  string    `json:"source"`            // config, api, menu hoặc telegram
+	Message string    `json:"message,omitempty"` // Thông báo riêng, rỗng = maintenance_message
+}
+
+// nil khi không bảo trì
+var maintenance atomic.Pointer[MaintenanceStatus]
+
+func maintenanceActive() bool {
+	return maintenance.Load() != nil
+}
+
+// Bật hoặc tắt bảo trì; message rỗng dùng maintenance_message
+func setMaintenance(enabled bool, message, source string) {
+	if !enabled {
+		if maintenance.Swap(nil) != nil {
+			log.Printf("Maintenance mode disabled (%s)", source)
+		}
+		return
+	}
+	status := &MaintenanceStatus{Since: time.Now(), Source: source, Message: message}
+	if previous := maintenance.Load(); previous != nil {
+		status.Since = previous.Since // Chỉ đổi thông báo, giữ thời điểm bắt đầu
+	}
+	maintenance.Store(status)
+	log.Printf("Maintenance mode enabled (%s): new tunnels are refused", source)
+}
+
+// Thông báo hiển thị cho client
+func maintenanceMessage() string {
+	if status := maintenance.Load(); status != nil && status.Message != "" {
+		return status.Message
+	}
+	if systemConfig().MaintenanceMessage != "" {
+		return systemConfig().MaintenanceMessage
+	}
+	return defaultMaintenanceMessage
+}
+
+// Mã reply SOCKS5 cho kết nối bị từ chối vì bảo trì (maintenance_socks_reply, mặc định general failure)
+func maintenanceSOCKS5Reply() byte {
+	if systemConfig().MaintenanceSOCKSReply != 0 {
+		return systemConfig().MaintenanceSOCKSReply
+	}
+	return socks5.GeneralFailure
+}
+
+// Đồng bộ với maintenance trong cấu hình khi khởi động hoặc khi giá trị trong file thay đổi,
+// để trạng thái bật/tắt qua API không bị ghi đè bởi mỗi lần apply cấu hình
+func syncMaintenanceConfig(oldConfig, newConfig SystemConfig) {
+	if oldConfig.Maintenance != newConfig.Maintenance {
+		setMaintenance(newConfig.Maintenance, "", "config")
+	}
+}
+
+// Byte đầu của một request HTTP (GET, CONNECT, POST...)
+func looksLikeHTTP(first byte) bool {
+	return first >= 'A' && first <= 'Z'
+}
+
+// Trả lời 503 kèm trang thông báo cho một request HTTP tới cổng SOCKS trong lúc bảo trì
+func serveMaintenancePage(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(maintenanceReadTimeout))
+
+	request, err := readHTTPRequest(conn)
+	if err != nil {
+		if errors.Is(err, errHTTPRequestSize) {
+			recordMalformed(malformedHTTP, conn.RemoteAddr(), "larger than 64 KiB")
+			conn.Write([]byte("HTTP/1.1 431 Request Header Fields Too Large\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+		}
+		return
+	}
+	body := maintenancePage()
+	header := make(http.Header)
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+	response := &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       request,
+		Header:        header,
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+	if request.Method != http.MethodHead {
+		response.Body = io.NopCloser(strings.NewReader(body))
+	}
+	response.Write(conn)
+}
+
+// Nội dung maintenance_page, hoặc trang mặc định hiển thị thông báo bảo trì
+func maintenancePage() string {
+	if path := systemConfig().MaintenancePage; path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil && len(data) <= maxMaintenancePage {
+			return string(data)
+		}
+		if err == nil {
+			err = fmt.Errorf("larger than %d bytes", maxMaintenancePage)
+		}
+		log.Printf("Cannot use maintenance page %s: %v", path, err)
+	}
+	return "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Maintenance</title></head>\n" +
+		"<body><h1>Scheduled maintenance</h1>\n<p>" + html.EscapeString(maintenanceMessage()) + "</p></body></html>\n"
+}
+
+// Trạng thái trả về qua API: bảo trì và số kết nối, tunnel còn mở để theo dõi việc drain
+type maintenanceView struct {
+	Enabled           bool       `json:"enabled"`
+	Since             *time.Time `json:"since,omitempty"`
+	Source            string     `json:"source,omitempty"`
+	Message           string     `json:"message"`
+	ConnectionsActive int        `json:"connections_active"`
+	TunnelsActive     int        `json:"tunnels_active"`
+}
+
+func currentMaintenanceView() maintenanceView {
+	status := currentServerStatus()
+	view := maintenanceView{
+		Message:           maintenanceMessage(),
+		ConnectionsActive: status.ConnectionsActive,
+		TunnelsActive:     status.TunnelsActive,
+	}
+	if status.Maintenance != nil {
+		view.Enabled = true
+		view.Since = &status.Maintenance.Since
+		view.Source = status.Maintenance.Source
+	}
+	return view
+}
+
+// GET /api/maintenance
+func handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, currentMaintenanceView())
+}
+
+// PUT /api/maintenance với {"enabled":true,"message":"..."}; tắt bảo trì không đóng tunnel nào
+func handleAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&req); err != nil || req.Enabled == nil {
+		admin.WriteError(w, http.StatusBadRequest, "expected {\"enabled\": true|false}")
+		return
+	}
+	if strings.ContainsAny(req.Message, "\r\n") {
+		admin.WriteError(w, http.StatusBadRequest, "message must be a single line")
+		return
+	}
+
+	wasEnabled := maintenanceActive()
+	setMaintenance(*req.Enabled, req.Message, "api")
+	recordAudit(token.Name, "maintenance.set", "", wasEnabled, *req.Enabled)
+	log.Printf("Admin API: maintenance mode set to %t by token %s", *req.Enabled, token.Name)
+	admin.WriteJSON(w, http.StatusOK, currentMaintenanceView())
+}
@@ -0,0 +1,94 @@
+package proxyserver
+
+import (
+	"log"
+	"math"
+	"net"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// Tunnel phải im lặng ít nhất chừng này mới bị đóng khi thiếu bộ nhớ
+const memoryShedMinIdle = 10 * time.Second
+
+var (
+	memoryUsage       atomic.Uint64 // Bộ nhớ process đang giữ từ hệ điều hành (byte)
+	memoryHigh        atomic.Bool   // Đang vượt ngưỡng memory_limit_mb
+	memoryShedTotal   atomic.Int64  // Số kết nối mới bị từ chối vì thiếu bộ nhớ
+	memoryClosedTotal atomic.Int64  // Số tunnel idle bị đóng vì thiếu bộ nhớ
+)
+
+// Bộ nhớ runtime đang giữ, trừ phần đã trả lại cho hệ điều hành
+func readMemoryUsage() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 || samples[1].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// Theo dõi bộ nhớ mỗi giây, bật/tắt trạng thái quá tải và đóng bớt tunnel idle nếu được cấu hình
+func runMemoryGuard() {
+	limitMB := -1
+	for ; ; time.Sleep(time.Second) {
+		// Giới hạn mềm cho GC theo ngưỡng để thu gom mạnh hơn trước khi phải từ chối kết nối
+		if systemConfig().MemoryLimitMB != limitMB {
+			limitMB = systemConfig().MemoryLimitMB
+			if limitMB > 0 {
+				debug.SetMemoryLimit(int64(limitMB) << 20)
+			} else {
+				debug.SetMemoryLimit(math.MaxInt64)
+			}
+		}
+
+		usage := readMemoryUsage()
+		memoryUsage.Store(usage)
+		if limitMB <= 0 {
+			memoryHigh.Store(false)
+			continue
+		}
+
+		high := usage >= uint64(limitMB)<<20
+		if high != memoryHigh.Swap(high) {
+			if high {
+				log.Printf("Memory usage %d MB above limit %d MB: refusing new tunnels", usage>>20, limitMB)
+				notifyOperators("Memory usage %d MB above limit %d MB: refusing new tunnels", usage>>20, limitMB)
+			} else {
+				log.Printf("Memory usage back to %d MB: accepting new tunnels", usage>>20)
+				notifyOperators("Memory usage back to %d MB: accepting new tunnels", usage>>20)
+			}
+		}
+		if high && systemConfig().MemoryShedIdle {
+			shedIdleTunnels()
+		}
+	}
+}
+
+// Đóng 5% số tunnel idle lâu nhất (ít nhất 1) mỗi lần kiểm tra
+func shedIdleTunnels() {
+	idle := idleTunnels(memoryShedMinIdle)
+	count := (len(idle) + 19) / 20
+	for _, t := range idle[:count] {
+		t.close(CloseMemoryShed)
+	}
+	if count > 0 {
+		memoryClosedTotal.Add(int64(count))
+		log.Printf("Closed %d idle tunnels to free memory", count)
+	}
+}
+
+// Đóng kết nối mới khi đang vượt ngưỡng bộ nhớ, trả về true nếu kết nối đã bị từ chối
+func shedIfMemoryHigh(conn net.Conn) bool {
+	if !memoryHigh.Load() {
+		return false
+	}
+	memoryShedTotal.Add(1)
+	conn.Close()
+	return true
+}
@@ -0,0 +1,257 @@
+package proxyserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Xuất các bộ đếm theo định dạng text của Prometheus
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+// Ghi các bộ đếm theo định dạng text của Prometheus; statsd dùng lại cùng nội dung này
+func writeMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP proxy_tunnels_closed_total Tunnels closed, by close reason.")
+	fmt.Fprintln(w, "# TYPE proxy_tunnels_closed_total counter")
+	for _, entry := range closeReasonSnapshot() {
+		fmt.Fprintf(w, "proxy_tunnels_closed_total{reason=%q} %d\n", entry.Reason, entry.Count)
+	}
+	fmt.Fprintln(w, "# HELP proxy_auth_failures_total Failed logins, by protocol and reason.")
+	fmt.Fprintln(w, "# TYPE proxy_auth_failures_total counter")
+	for _, entry := range authFailureSnapshot() {
+		fmt.Fprintf(w, "proxy_auth_failures_total{proto=%q,reason=%q} %d\n", entry.Proto, entry.Reason, entry.Count)
+	}
+
+	fmt.Fprintln(w, "# HELP proxy_egress_up Whether an egress IP passed its last health check.")
+	fmt.Fprintln(w, "# TYPE proxy_egress_up gauge")
+	for _, egress := range egressStatuses() {
+		up := 0
+		if egress.Healthy {
+			up = 1
+		}
+		tags := egress.Tags
+		if len(systemConfig().MetricTagLabels) > 0 && egress.Country != "" {
+			tags = cloneLabels(egress.Tags)
+			tags[egressCountryKey] = egress.Country
+		}
+		fmt.Fprintf(w, "proxy_egress_up{ip=%q%s} %d\n", egress.IP, tagLabels(tags), up)
+	}
+
+	fmt.Fprintln(w, "# HELP proxy_upstream_up Whether an upstream proxy is in rotation.")
+	fmt.Fprintln(w, "# TYPE proxy_upstream_up gauge")
+	for _, upstream := range upstreamStatuses() {
+		up := 0
+		if upstream.Healthy {
+			up = 1
+		}
+		fmt.Fprintf(w, "proxy_upstream_up{upstream=%q} %d\n", upstream.Upstream, up)
+	}
+
+	fmt.Fprintln(w, "# HELP proxy_connections_active Connections holding a connection credit.")
+	fmt.Fprintln(w, "# TYPE proxy_connections_active gauge")
+	fmt.Fprintf(w, "proxy_connections_active %d\n", activeConnCredits())
+	fmt.Fprintln(w, "# HELP proxy_connections_limit Configured max_connections (0 means unlimited).")
+	fmt.Fprintln(w, "# TYPE proxy_connections_limit gauge")
+	fmt.Fprintf(w, "proxy_connections_limit %d\n", systemConfig().MaxConnections)
+	fmt.Fprintln(w, "# HELP proxy_accept_queued_total Connections that waited for a free connection credit.")
+	fmt.Fprintln(w, "# TYPE proxy_accept_queued_total counter")
+	fmt.Fprintf(w, "proxy_accept_queued_total %d\n", acceptQueuedTotal.Load())
+	fmt.Fprintln(w, "# HELP proxy_accept_refused_total Connections refused because max_connections was reached.")
+	fmt.Fprintln(w, "# TYPE proxy_accept_refused_total counter")
+	fmt.Fprintf(w, "proxy_accept_refused_total %d\n", acceptRefusedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_open_fds Estimated open file descriptors.")
+	fmt.Fprintln(w, "# TYPE proxy_open_fds gauge")
+	fmt.Fprintf(w, "proxy_open_fds %d\n", estimatedOpenFDs())
+	fmt.Fprintln(w, "# HELP proxy_fd_limit Open file descriptor soft limit (0 if unknown).")
+	fmt.Fprintln(w, "# TYPE proxy_fd_limit gauge")
+	fmt.Fprintf(w, "proxy_fd_limit %d\n", fdLimitValue.Load())
+	fmt.Fprintln(w, "# HELP proxy_fd_shed_total Connections closed on accept because the file descriptor budget was exhausted.")
+	fmt.Fprintln(w, "# TYPE proxy_fd_shed_total counter")
+	fmt.Fprintf(w, "proxy_fd_shed_total %d\n", fdShedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_memory_bytes Memory held by the Go runtime, excluding memory returned to the OS.")
+	fmt.Fprintln(w, "# TYPE proxy_memory_bytes gauge")
+	fmt.Fprintf(w, "proxy_memory_bytes %d\n", memoryUsage.Load())
+	fmt.Fprintln(w, "# HELP proxy_memory_shed_total Connections closed on accept because memory_limit_mb was exceeded.")
+	fmt.Fprintln(w, "# TYPE proxy_memory_shed_total counter")
+	fmt.Fprintf(w, "proxy_memory_shed_total %d\n", memoryShedTotal.Load())
+	fmt.Fprintln(w, "# HELP proxy_memory_idle_closed_total Idle tunnels closed to free memory.")
+	fmt.Fprintln(w, "# TYPE proxy_memory_idle_closed_total counter")
+	fmt.Fprintf(w, "proxy_memory_idle_closed_total %d\n", memoryClosedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_policy_decisions_total CONNECT requests checked against policy_url or policy_script, by outcome.")
+	fmt.Fprintln(w, "# TYPE proxy_policy_decisions_total counter")
+	fmt.Fprintf(w, "proxy_policy_decisions_total{result=\"allow\"} %d\n", policyAllowed.Load())
+	fmt.Fprintf(w, "proxy_policy_decisions_total{result=\"deny\"} %d\n", policyDenied.Load())
+	fmt.Fprintf(w, "proxy_policy_decisions_total{result=\"error\"} %d\n", policyErrors.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_rewrites_total CONNECT requests whose destination was changed by rewrite_file.")
+	fmt.Fprintln(w, "# TYPE proxy_rewrites_total counter")
+	fmt.Fprintf(w, "proxy_rewrites_total %d\n", rewriteTotal.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_compress_bytes_total Bytes through compress_listen connections, before compression (raw) and on the network (wire).")
+	fmt.Fprintln(w, "# TYPE proxy_compress_bytes_total counter")
+	fmt.Fprintf(w, "proxy_compress_bytes_total{stage=\"raw\"} %d\n", compressRawBytes.Load())
+	fmt.Fprintf(w, "proxy_compress_bytes_total{stage=\"wire\"} %d\n", compressWireBytes.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_latency_tunnels_total Tunnels relayed in low-latency mode (latency_ports or latency_groups).")
+	fmt.Fprintln(w, "# TYPE proxy_latency_tunnels_total counter")
+	fmt.Fprintf(w, "proxy_latency_tunnels_total %d\n", latencyTunnels.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_uptime_seconds Seconds since the process started.")
+	fmt.Fprintln(w, "# TYPE proxy_uptime_seconds gauge")
+	fmt.Fprintf(w, "proxy_uptime_seconds %d\n", int64(time.Since(processStarted).Seconds()))
+	inMaintenance := 0
+	if maintenanceActive() {
+		inMaintenance = 1
+	}
+	build := currentBuildInfo()
+	fmt.Fprintln(w, "# HELP proxy_build_info Version of the running binary, always 1.")
+	fmt.Fprintln(w, "# TYPE proxy_build_info gauge")
+	fmt.Fprintf(w, "proxy_build_info{version=%q,commit=%q,go_version=%q} 1\n", build.Version, build.Commit, build.GoVersion)
+	fmt.Fprintln(w, "# HELP proxy_maintenance Whether maintenance mode is refusing new tunnels.")
+	fmt.Fprintln(w, "# TYPE proxy_maintenance gauge")
+	fmt.Fprintf(w, "proxy_maintenance %d\n", inMaintenance)
+	fmt.Fprintln(w, "# HELP proxy_connections_total Connections handled, including refused ones.")
+	fmt.Fprintln(w, "# TYPE proxy_connections_total counter")
+	fmt.Fprintf(w, "proxy_connections_total %d\n", connsServed.Load())
+	fmt.Fprintln(w, "# HELP proxy_bytes_total Bytes relayed through tunnels, by direction.")
+	fmt.Fprintln(w, "# TYPE proxy_bytes_total counter")
+	fmt.Fprintf(w, "proxy_bytes_total{direction=\"up\"} %d\n", bytesUpTotal.Load())
+	fmt.Fprintf(w, "proxy_bytes_total{direction=\"down\"} %d\n", bytesDownTotal.Load())
+	fmt.Fprintln(w, "# HELP proxy_listener_accepted_total Connections accepted, by listener.")
+	fmt.Fprintln(w, "# TYPE proxy_listener_accepted_total counter")
+	var listeners []ListenerStatus
+	retrying := 0
+	for _, listener := range listenerStatuses() {
+		if listener.Retry != nil {
+			retrying++ // Chưa mở, chưa có bộ đếm
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+	for _, listener := range listeners {
+		fmt.Fprintf(w, "proxy_listener_accepted_total{listener=%q,kind=%q,family=%q} %d\n", listener.Address, listener.Kind, listener.Family, listener.Accepted)
+	}
+	fmt.Fprintln(w, "# HELP proxy_listener_connections_total SOCKS connections handled, by listener.")
+	fmt.Fprintln(w, "# TYPE proxy_listener_connections_total counter")
+	for _, listener := range listeners {
+		fmt.Fprintf(w, "proxy_listener_connections_total{listener=%q,kind=%q,family=%q} %d\n", listener.Address, listener.Kind, listener.Family, listener.Connections)
+	}
+	fmt.Fprintln(w, "# HELP proxy_listener_bytes_total Bytes relayed through tunnels, by listener and direction.")
+	fmt.Fprintln(w, "# TYPE proxy_listener_bytes_total counter")
+	for _, listener := range listeners {
+		fmt.Fprintf(w, "proxy_listener_bytes_total{listener=%q,kind=%q,family=%q,direction=\"up\"} %d\n", listener.Address, listener.Kind, listener.Family, listener.BytesUp)
+		fmt.Fprintf(w, "proxy_listener_bytes_total{listener=%q,kind=%q,family=%q,direction=\"down\"} %d\n", listener.Address, listener.Kind, listener.Family, listener.BytesDown)
+	}
+	fmt.Fprintln(w, "# HELP proxy_listener_retrying Listeners waiting for their address to be released.")
+	fmt.Fprintln(w, "# TYPE proxy_listener_retrying gauge")
+	fmt.Fprintf(w, "proxy_listener_retrying %d\n", retrying)
+	fairWaits, fairWaitBytes := fairScheduler.Waits()
+	fmt.Fprintln(w, "# HELP proxy_fair_waits_total Relay writes that waited for bandwidth under fair_scheduling.")
+	fmt.Fprintln(w, "# TYPE proxy_fair_waits_total counter")
+	fmt.Fprintf(w, "proxy_fair_waits_total %d\n", fairWaits)
+	fmt.Fprintln(w, "# HELP proxy_fair_wait_bytes_total Bytes whose relay waited for bandwidth under fair_scheduling.")
+	fmt.Fprintln(w, "# TYPE proxy_fair_wait_bytes_total counter")
+	fmt.Fprintf(w, "proxy_fair_wait_bytes_total %d\n", fairWaitBytes)
+	fmt.Fprintln(w, "# HELP proxy_fair_waiting_users Users with relay writes waiting for bandwidth.")
+	fmt.Fprintln(w, "# TYPE proxy_fair_waiting_users gauge")
+	fmt.Fprintf(w, "proxy_fair_waiting_users %d\n", fairScheduler.WaitingUsers())
+	fmt.Fprintln(w, "# HELP proxy_handshake_timeouts_total SOCKS connections closed because the handshake did not finish within handshake_timeout.")
+	fmt.Fprintln(w, "# TYPE proxy_handshake_timeouts_total counter")
+	fmt.Fprintf(w, "proxy_handshake_timeouts_total %d\n", handshakeTimeouts.Load())
+	fmt.Fprintln(w, "# HELP proxy_handshake_oversized_total SOCKS connections closed because the handshake exceeded its byte limit.")
+	fmt.Fprintln(w, "# TYPE proxy_handshake_oversized_total counter")
+	fmt.Fprintf(w, "proxy_handshake_oversized_total %d\n", handshakeOversized.Load())
+	fmt.Fprintln(w, "# HELP proxy_backups_total Backups written to backup_target, by result.")
+	fmt.Fprintln(w, "# TYPE proxy_backups_total counter")
+	fmt.Fprintf(w, "proxy_backups_total{result=\"ok\"} %d\n", backupsSucceeded.Load())
+	fmt.Fprintf(w, "proxy_backups_total{result=\"error\"} %d\n", backupsFailed.Load())
+	fmt.Fprintln(w, "# HELP proxy_last_backup_timestamp_seconds Time of the last successful backup.")
+	fmt.Fprintln(w, "# TYPE proxy_last_backup_timestamp_seconds gauge")
+	fmt.Fprintf(w, "proxy_last_backup_timestamp_seconds %d\n", lastBackupAt.Load())
+	fmt.Fprintln(w, "# HELP proxy_access_logs_shipped_total Rotated access log files uploaded to access_log_ship.")
+	fmt.Fprintln(w, "# TYPE proxy_access_logs_shipped_total counter")
+	fmt.Fprintf(w, "proxy_access_logs_shipped_total %d\n", accessLogsShipped.Load())
+	fmt.Fprintln(w, "# HELP proxy_access_log_ship_errors_total Failed access log uploads, retried later.")
+	fmt.Fprintln(w, "# TYPE proxy_access_log_ship_errors_total counter")
+	fmt.Fprintf(w, "proxy_access_log_ship_errors_total %d\n", accessLogShipErrors.Load())
+	fmt.Fprintln(w, "# HELP proxy_access_logs_pending Rotated access log files not yet uploaded.")
+	fmt.Fprintln(w, "# TYPE proxy_access_logs_pending gauge")
+	fmt.Fprintf(w, "proxy_access_logs_pending %d\n", accessLogsPending.Load())
+	fmt.Fprintln(w, "# HELP proxy_analytics_records_total Connection records for analytics_sink, by result.")
+	fmt.Fprintln(w, "# TYPE proxy_analytics_records_total counter")
+	fmt.Fprintf(w, "proxy_analytics_records_total{result=\"written\"} %d\n", analyticsWritten.Load())
+	fmt.Fprintf(w, "proxy_analytics_records_total{result=\"dropped\"} %d\n", analyticsDropped.Load())
+	fmt.Fprintln(w, "# HELP proxy_analytics_write_errors_total Failed batch writes to analytics_sink, retried with backoff.")
+	fmt.Fprintln(w, "# TYPE proxy_analytics_write_errors_total counter")
+	fmt.Fprintf(w, "proxy_analytics_write_errors_total %d\n", analyticsErrors.Load())
+	fmt.Fprintln(w, "# HELP proxy_analytics_queue Connection records waiting to be written to analytics_sink.")
+	fmt.Fprintln(w, "# TYPE proxy_analytics_queue gauge")
+	fmt.Fprintf(w, "proxy_analytics_queue %d\n", len(analyticsQueue))
+	fmt.Fprintln(w, "# HELP proxy_events_total Events for event_bus, by result.")
+	fmt.Fprintln(w, "# TYPE proxy_events_total counter")
+	fmt.Fprintf(w, "proxy_events_total{result=\"sent\"} %d\n", eventsSent.Load())
+	fmt.Fprintf(w, "proxy_events_total{result=\"dropped\"} %d\n", eventsDropped.Load())
+	fmt.Fprintln(w, "# HELP proxy_event_bus_errors_total Failed publishes to event_bus, retried with backoff.")
+	fmt.Fprintln(w, "# TYPE proxy_event_bus_errors_total counter")
+	fmt.Fprintf(w, "proxy_event_bus_errors_total %d\n", eventBusErrors.Load())
+	fmt.Fprintln(w, "# HELP proxy_event_bus_queue Events waiting to be published to event_bus.")
+	fmt.Fprintln(w, "# TYPE proxy_event_bus_queue gauge")
+	fmt.Fprintf(w, "proxy_event_bus_queue %d\n", len(eventQueue))
+	fmt.Fprintln(w, "# HELP proxy_kernel_accounted_tunnels_total Tunnels relayed with splice and counted from kernel TCP_INFO (kernel_accounting).")
+	fmt.Fprintln(w, "# TYPE proxy_kernel_accounted_tunnels_total counter")
+	fmt.Fprintf(w, "proxy_kernel_accounted_tunnels_total %d\n", kernelTunnels.Load())
+	fmt.Fprintln(w, "# HELP proxy_kernel_accounted_tunnels Open tunnels counted from kernel TCP_INFO.")
+	fmt.Fprintln(w, "# TYPE proxy_kernel_accounted_tunnels gauge")
+	fmt.Fprintf(w, "proxy_kernel_accounted_tunnels %d\n", openKernelAccounts())
+	fmt.Fprintln(w, "# HELP proxy_ktls_connections_total TLS frontend connections with ktls on, by where records are encrypted.")
+	fmt.Fprintln(w, "# TYPE proxy_ktls_connections_total counter")
+	fmt.Fprintf(w, "proxy_ktls_connections_total{mode=\"kernel\"} %d\n", ktlsKernel.Load())
+	fmt.Fprintf(w, "proxy_ktls_connections_total{mode=\"userspace\"} %d\n", ktlsUserspace.Load())
+	fmt.Fprintln(w, "# HELP proxy_usage_streams Open client usage streams on usage_listen.")
+	fmt.Fprintln(w, "# TYPE proxy_usage_streams gauge")
+	fmt.Fprintf(w, "proxy_usage_streams %d\n", usageStreams.Load())
+	fmt.Fprintln(w, "# HELP proxy_malformed_requests_total Client requests rejected because a field was empty, too long or contained invalid characters.")
+	fmt.Fprintln(w, "# TYPE proxy_malformed_requests_total counter")
+	for _, field := range []string{malformedMethods, malformedUsername, malformedPassword, malformedDomain, malformedUserID, malformedHTTP} {
+		fmt.Fprintf(w, "proxy_malformed_requests_total{field=%q} %d\n", field, malformedRequests[field].Load())
+	}
+	fmt.Fprintln(w, "# HELP proxy_panics_total Panics recovered in connection handlers (crash_dir or crash_report_url set).")
+	fmt.Fprintln(w, "# TYPE proxy_panics_total counter")
+	fmt.Fprintf(w, "proxy_panics_total %d\n", panicsTotal.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_reputation_listed_total Connections from client IPs listed by reputation_list or reputation_dnsbl.")
+	fmt.Fprintln(w, "# TYPE proxy_reputation_listed_total counter")
+	fmt.Fprintf(w, "proxy_reputation_listed_total %d\n", reputationListed.Load())
+	fmt.Fprintln(w, "# HELP proxy_reputation_denied_total Connections closed because reputation_action is deny.")
+	fmt.Fprintln(w, "# TYPE proxy_reputation_denied_total counter")
+	fmt.Fprintf(w, "proxy_reputation_denied_total %d\n", reputationDenied.Load())
+	fmt.Fprintln(w, "# HELP proxy_acl_denied_total Requests denied by the destination ACL.")
+	fmt.Fprintln(w, "# TYPE proxy_acl_denied_total counter")
+	fmt.Fprintf(w, "proxy_acl_denied_total %d\n", aclDenied.Load())
+	fmt.Fprintln(w, "# HELP proxy_unmetered_bytes_total Bytes to destinations excluded from user quotas.")
+	fmt.Fprintln(w, "# TYPE proxy_unmetered_bytes_total counter")
+	fmt.Fprintf(w, "proxy_unmetered_bytes_total %d\n", unmeteredBytes.Load())
+	fmt.Fprintln(w, "# HELP proxy_alert_firing Whether a built-in alert is firing.")
+	fmt.Fprintln(w, "# TYPE proxy_alert_firing gauge")
+	for _, alert := range alertSnapshot() {
+		firing := 0
+		if alert.State == alertFiring {
+			firing = 1
+		}
+		fmt.Fprintf(w, "proxy_alert_firing{alertname=%q} %d\n", alert.Labels["alertname"], firing)
+	}
+	if cluster := currentClusterView(); cluster.Enabled {
+		fmt.Fprintln(w, "# HELP proxy_cluster_members Cluster nodes by status, including this one.")
+		fmt.Fprintln(w, "# TYPE proxy_cluster_members gauge")
+		fmt.Fprintf(w, "proxy_cluster_members{status=\"alive\"} %d\n", cluster.Alive)
+		fmt.Fprintf(w, "proxy_cluster_members{status=\"dead\"} %d\n", len(cluster.Members)-cluster.Alive)
+	}
+	writeTagMetrics(w)
+}
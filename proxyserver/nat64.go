@@ -0,0 +1,102 @@
+package proxyserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Kiểm tra prefix NAT64 theo RFC 6052 (độ dài 32, 40, 48, 56, 64 hoặc 96)
+func parseNAT64Prefix(value string) (*net.IPNet, error) {
+	_, prefix, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, err
+	}
+	if prefix.IP.To4() != nil {
+		return nil, fmt.Errorf("%s is not an IPv6 prefix", value)
+	}
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+		return prefix, nil
+	default:
+		return nil, fmt.Errorf("unsupported NAT64 prefix length /%d", ones)
+	}
+}
+
+// Ghép địa chỉ IPv4 vào prefix NAT64 theo RFC 6052 (byte 64-71 luôn bằng 0)
+func synthesizeNAT64(prefix *net.IPNet, v4 net.IP) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16())
+	v4 = v4.To4()
+
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32:
+		copy(ip[4:8], v4)
+	case 40:
+		copy(ip[5:8], v4[:3])
+		ip[9] = v4[3]
+	case 48:
+		copy(ip[6:8], v4[:2])
+		copy(ip[9:11], v4[2:])
+	case 56:
+		ip[7] = v4[0]
+		copy(ip[9:12], v4[1:])
+	case 64:
+		copy(ip[9:13], v4)
+	default: // 96
+		copy(ip[12:16], v4)
+	}
+	return ip
+}
+
+// Chuyển đích IPv4 (hoặc domain chỉ có bản ghi A) thành địa chỉ IPv6 qua NAT64.
+// resolver nil dùng resolver mặc định của hệ thống
+func nat64Address(prefix *net.IPNet, destAddr string, deadline time.Time, resolver *net.Resolver) (string, error) {
+	host, port, err := net.SplitHostPort(destAddr)
+	if err != nil {
+		return "", err
+	}
+
+	if ip := hostIP(host); ip != nil {
+		if ip.To4() == nil {
+			return destAddr, nil
+		}
+		return net.JoinHostPort(synthesizeNAT64(prefix, ip).String(), port), nil
+	}
+
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	// Domain có bản ghi AAAA thì kết nối trực tiếp qua IPv6
+	if ips, err := resolver.LookupIP(ctx, "ip6", host); err == nil && len(ips) > 0 {
+		return net.JoinHostPort(ips[0].String(), port), nil
+	}
+
+	ips, err := resolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(synthesizeNAT64(prefix, ips[0]).String(), port), nil
+}
+
+// Kết nối qua IPv6 tới đích, dùng địa chỉ NAT64 nếu đích chỉ có IPv4
+func dialNAT64(dialer *net.Dialer, destAddr, policy string, tried *dialTried) (net.Conn, error) {
+	prefix, err := parseNAT64Prefix(systemConfig().NAT64Prefix)
+	if err != nil {
+		return nil, err
+	}
+	address, err := nat64Address(prefix, destAddr, dialer.Deadline, dialer.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	return dialFromEgress(*dialer, "tcp6", address, policy, tried)
+}
@@ -0,0 +1,126 @@
+package proxyserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client NATS tối giản chỉ để publish: INFO/CONNECT, PUB và PING/PONG sau mỗi lô để biết server đã nhận.
+// user:password trong URL là user và mật khẩu, chỉ có user thì dùng làm token
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func natsConnect(u *url.URL) (*natsConn, error) {
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	raw, err := net.DialTimeout("tcp", address, eventBusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	raw.SetDeadline(time.Now().Add(eventBusTimeout))
+	nc := &natsConn{conn: raw, r: bufio.NewReader(raw)}
+
+	line, err := nc.readLine()
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	infoJSON, found := strings.CutPrefix(line, "INFO ")
+	if !found || json.Unmarshal([]byte(infoJSON), &info) != nil {
+		raw.Close()
+		return nil, fmt.Errorf("unexpected NATS greeting %q", line)
+	}
+	if u.Scheme == "nats+tls" || info.TLSRequired {
+		tlsConn := tls.Client(raw, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		nc.conn, nc.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+	nc.w = bufio.NewWriter(nc.conn)
+
+	connect := map[string]any{
+		"verbose": false, "pedantic": false, "name": eventBusClientName, "lang": "go", "version": Version, "protocol": 1,
+		"tls_required": u.Scheme == "nats+tls" || info.TLSRequired,
+	}
+	if u.User != nil {
+		if password, set := u.User.Password(); set {
+			connect["user"], connect["pass"] = u.User.Username(), password
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+	data, _ := json.Marshal(connect)
+	fmt.Fprintf(nc.w, "CONNECT %s\r\n", data)
+	if err := nc.flushAndWait(); err != nil {
+		nc.conn.Close()
+		return nil, err
+	}
+	nc.conn.SetDeadline(time.Time{})
+	return nc, nil
+}
+
+func (nc *natsConn) readLine() (string, error) {
+	line, err := nc.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Gửi PING và đọc tới PONG; trả lời PING của server, -ERR là lỗi
+func (nc *natsConn) flushAndWait() error {
+	nc.w.WriteString("PING\r\n")
+	if err := nc.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := nc.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			nc.w.WriteString("PONG\r\n")
+			if err := nc.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK và INFO cập nhật cluster được bỏ qua
+	}
+}
+
+func (nc *natsConn) publish(messages []busMessage) error {
+	nc.conn.SetDeadline(time.Now().Add(eventBusTimeout))
+	defer nc.conn.SetDeadline(time.Time{})
+	for _, message := range messages {
+		fmt.Fprintf(nc.w, "PUB %s %d\r\n", message.topic, len(message.value))
+		nc.w.Write(message.value)
+		nc.w.WriteString("\r\n")
+	}
+	return nc.flushAndWait()
+}
+
+func (nc *natsConn) close() {
+	nc.conn.Close()
+}
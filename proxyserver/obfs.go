@@ -0,0 +1,458 @@
+package proxyserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	mathrand "math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lớp che giấu lưu lượng giữa client và proxy. Server bọc kết nối nhận trên obfs_listen,
+// Client bọc kết nối của lệnh client tới server. Kết nối trả về mang dữ liệu SOCKS gốc
+type Obfuscator interface {
+	Server(conn net.Conn) (net.Conn, error)
+	Client(conn net.Conn) (net.Conn, error)
+}
+
+// Tạo Obfuscator từ các tùy chọn key=value trong cấu hình
+type ObfuscatorFactory func(options map[string]string) (Obfuscator, error)
+
+var (
+	obfuscators = map[string]ObfuscatorFactory{
+		"padding": newPaddingObfuscator,
+		"tls":     newTLSObfuscator,
+	}
+	obfuscatorsMutex sync.Mutex
+)
+
+// RegisterObfuscator thêm một phương thức che giấu cho obfs_listen và lệnh client.
+// Gọi trước Server.Start; trùng tên thì thay phương thức có sẵn
+func RegisterObfuscator(name string, factory ObfuscatorFactory) {
+	obfuscatorsMutex.Lock()
+	obfuscators[name] = factory
+	obfuscatorsMutex.Unlock()
+}
+
+func obfuscatorFactory(method string) (ObfuscatorFactory, bool) {
+	obfuscatorsMutex.Lock()
+	defer obfuscatorsMutex.Unlock()
+	factory, exists := obfuscators[method]
+	return factory, exists
+}
+
+// Cấu hình một listener obfs_listen
+type ObfsListenerConfig struct {
+	Listen  string
+	Method  string
+	Options map[string]string
+}
+
+// Dạng hiển thị trong config diff và audit log, giá trị key bị ẩn
+func (c ObfsListenerConfig) String() string {
+	names := make([]string, 0, len(c.Options))
+	for name := range c.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{c.Listen, c.Method}
+	for _, name := range names {
+		value := c.Options[name]
+		if name == "key" {
+			value = "***"
+		}
+		parts = append(parts, name+"="+value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Dạng JSON (audit log, API), giá trị key bị ẩn như String
+func (c ObfsListenerConfig) MarshalJSON() ([]byte, error) {
+	options := make(map[string]string, len(c.Options))
+	for name, value := range c.Options {
+		if name == "key" {
+			value = "***"
+		}
+		options[name] = value
+	}
+	return json.Marshal(struct {
+		Listen  string            `json:"listen"`
+		Method  string            `json:"method"`
+		Options map[string]string `json:"options,omitempty"`
+	}{c.Listen, c.Method, options})
+}
+
+// Phân tích giá trị obfs_listen=listen,method[,key=value...]
+func parseObfsListener(value string) (ObfsListenerConfig, error) {
+	listen, spec, _ := strings.Cut(value, ",")
+	method, options, err := parseObfsSpec(spec)
+	if err != nil {
+		return ObfsListenerConfig{}, err
+	}
+	return ObfsListenerConfig{Listen: strings.TrimSpace(listen), Method: method, Options: options}, nil
+}
+
+// Phân tích "method[,key=value...]" và kiểm tra phương thức đã được đăng ký
+func parseObfsSpec(spec string) (string, map[string]string, error) {
+	parts := strings.Split(spec, ",")
+	method := strings.TrimSpace(parts[0])
+	if method == "" {
+		return "", nil, errors.New("expected listen,method[,key=value...]")
+	}
+	if _, exists := obfuscatorFactory(method); !exists {
+		return "", nil, fmt.Errorf("unknown obfuscation method %q", method)
+	}
+	options := make(map[string]string)
+	for _, part := range parts[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found || key == "" {
+			return "", nil, fmt.Errorf("invalid option %q, expected key=value", part)
+		}
+		options[key] = value
+	}
+	return method, options, nil
+}
+
+func newObfuscator(method string, options map[string]string) (Obfuscator, error) {
+	factory, exists := obfuscatorFactory(method)
+	if !exists {
+		return nil, fmt.Errorf("unknown obfuscation method %q", method)
+	}
+	return factory(options)
+}
+
+// Listener SOCKS có lớp che giấu, mỗi listener dùng một phương thức riêng
+func startObfsListener(config ObfsListenerConfig) {
+	obfuscator, err := newObfuscator(config.Method, config.Options)
+	if err != nil {
+		log.Printf("Obfuscated listener %s: %v", config.Listen, err)
+		return
+	}
+	tcpListener, err := listenTCP(listenerObfs, config.Listen)
+	if err != nil {
+		log.Printf("Obfuscated listener %s: %v", config.Listen, err)
+		return
+	}
+	// Lỗi cấu hình phía server (ví dụ file chứng chỉ) được báo ngay khi mở listener
+	if preparer, ok := obfuscator.(interface{ prepareServer() error }); ok {
+		if err := preparer.prepareServer(); err != nil {
+			closeListener(config.Listen)
+			log.Printf("Obfuscated listener %s: %v", config.Listen, err)
+			return
+		}
+	}
+	log.Printf("Obfuscated SOCKS listener (%s) started on %s", config.Method, config.Listen)
+	acceptWrappedSocks(tuneListener(tcpListener, config.Listen), config.Listen, "Obfuscated listener", obfuscator.Server)
+}
+
+// Phương thức padding: mã hóa AES-GCM bằng khóa chung và thêm phần đệm ngẫu nhiên vào mỗi khung,
+// nên cả nội dung lẫn kích thước gói không lộ dấu hiệu SOCKS
+const (
+	paddingSaltSize          = 32
+	paddingHeaderSize        = 4 // Độ dài dữ liệu và độ dài phần đệm, mỗi giá trị 2 byte
+	paddingMaxFrame          = 16 * 1024
+	defaultPaddingMaxPadding = 256
+)
+
+type paddingObfuscator struct {
+	secret     [sha256.Size]byte
+	maxPadding int
+}
+
+func newPaddingObfuscator(options map[string]string) (Obfuscator, error) {
+	if options["key"] == "" {
+		return nil, errors.New("padding requires key=<shared secret>")
+	}
+	key, err := resolveSecret(options["key"])
+	if err != nil {
+		return nil, err
+	}
+	obfuscator := &paddingObfuscator{secret: sha256.Sum256([]byte(key)), maxPadding: defaultPaddingMaxPadding}
+	if value, exists := options["max_padding"]; exists {
+		maxPadding, err := strconv.Atoi(value)
+		if err != nil || maxPadding < 0 || maxPadding > 65535 {
+			return nil, fmt.Errorf("invalid max_padding value: %s", value)
+		}
+		obfuscator.maxPadding = maxPadding
+	}
+	return obfuscator, nil
+}
+
+func (o *paddingObfuscator) Server(conn net.Conn) (net.Conn, error) {
+	return &paddingConn{Conn: conn, obfuscator: o}, nil
+}
+
+func (o *paddingObfuscator) Client(conn net.Conn) (net.Conn, error) {
+	return &paddingConn{Conn: conn, obfuscator: o}, nil
+}
+
+// Khóa cho một chiều truyền, từ khóa chung và salt ngẫu nhiên của bên gửi
+func (o *paddingObfuscator) newAEAD(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, o.secret[:])
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Mỗi chiều bắt đầu bằng salt của bên gửi, sau đó là các khung
+// [header mã hóa][dữ liệu + phần đệm mã hóa]; nonce là bộ đếm khung
+type paddingConn struct {
+	net.Conn
+	obfuscator *paddingObfuscator
+
+	reader     cipher.AEAD
+	readNonce  []byte
+	pending    []byte // Dữ liệu đã giải mã chưa được đọc
+	writer     cipher.AEAD
+	writeNonce []byte
+	writeMutex sync.Mutex
+}
+
+func incrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+func (c *paddingConn) Read(p []byte) (int, error) {
+	if c.reader == nil {
+		salt := make([]byte, paddingSaltSize)
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return 0, err
+		}
+		aead, err := c.obfuscator.newAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.reader, c.readNonce = aead, make([]byte, aead.NonceSize())
+	}
+
+	// Khung chỉ có phần đệm thì đọc tiếp
+	for len(c.pending) == 0 {
+		header := make([]byte, paddingHeaderSize+c.reader.Overhead())
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+		plainHeader, err := c.reader.Open(header[:0], c.readNonce, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		incrementNonce(c.readNonce)
+
+		dataLen := int(binary.BigEndian.Uint16(plainHeader))
+		padLen := int(binary.BigEndian.Uint16(plainHeader[2:]))
+		body := make([]byte, dataLen+padLen+c.reader.Overhead())
+		if _, err := io.ReadFull(c.Conn, body); err != nil {
+			return 0, err
+		}
+		plain, err := c.reader.Open(body[:0], c.readNonce, body, nil)
+		if err != nil {
+			return 0, err
+		}
+		incrementNonce(c.readNonce)
+		c.pending = plain[:dataLen]
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *paddingConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	var out []byte
+	if c.writer == nil {
+		salt := make([]byte, paddingSaltSize)
+		rand.Read(salt)
+		aead, err := c.obfuscator.newAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.writer, c.writeNonce = aead, make([]byte, aead.NonceSize())
+		out = salt
+	}
+
+	for remaining := p; len(remaining) > 0; {
+		chunk := remaining[:min(len(remaining), paddingMaxFrame)]
+		remaining = remaining[len(chunk):]
+		padLen := 0
+		if c.obfuscator.maxPadding > 0 {
+			padLen = mathrand.IntN(c.obfuscator.maxPadding + 1)
+		}
+
+		header := make([]byte, paddingHeaderSize)
+		binary.BigEndian.PutUint16(header, uint16(len(chunk)))
+		binary.BigEndian.PutUint16(header[2:], uint16(padLen))
+		out = c.writer.Seal(out, c.writeNonce, header, nil)
+		incrementNonce(c.writeNonce)
+
+		body := make([]byte, len(chunk)+padLen)
+		copy(body, chunk)
+		out = c.writer.Seal(out, c.writeNonce, body, nil)
+		incrementNonce(c.writeNonce)
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Đóng chiều gửi của TCP bên dưới nếu có thể
+func (c *paddingConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
+func (c *paddingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Phương thức tls: bọc kết nối trong TLS thật với SNI và ALPN như trình duyệt, DPI chỉ thấy một phiên HTTPS
+type tlsObfuscator struct {
+	options      map[string]string
+	clientConfig *tls.Config
+
+	// Cấu hình phía server chỉ được tạo khi dùng cho listener, lệnh client không cần chứng chỉ
+	serverOnce   sync.Once
+	serverConfig *tls.Config
+	serverErr    error
+}
+
+var tlsNextProtos = []string{"h2", "http/1.1"}
+
+func newTLSObfuscator(options map[string]string) (Obfuscator, error) {
+	obfuscator := &tlsObfuscator{
+		options:      options,
+		clientConfig: &tls.Config{ServerName: options["sni"], NextProtos: tlsNextProtos, MinVersion: tls.VersionTLS12},
+	}
+
+	// Có pin thì chỉ tin đúng chứng chỉ đó (dùng với chứng chỉ tự ký), ngược lại kiểm tra như thường
+	if pin := options["pin"]; pin != "" {
+		expected, err := hex.DecodeString(pin)
+		if err != nil || len(expected) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin value: %s", pin)
+		}
+		obfuscator.clientConfig.InsecureSkipVerify = true
+		obfuscator.clientConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			actual := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if subtle.ConstantTimeCompare(actual[:], expected) != 1 {
+				return errors.New("server certificate does not match pin")
+			}
+			return nil
+		}
+	}
+	return obfuscator, nil
+}
+
+// Chứng chỉ từ file (hoặc acme), không có thì tạo chứng chỉ tự ký cho SNI
+func (o *tlsObfuscator) prepareServer() error {
+	o.serverOnce.Do(func() {
+		if cert := o.options["cert"]; cert != "" {
+			o.serverConfig, o.serverErr = listenerTLSConfig(cert, o.options["key"], cert == "acme")
+		} else {
+			var certificate tls.Certificate
+			certificate, o.serverErr = selfSignedCertificate(o.options["sni"])
+			o.serverConfig = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{certificate}}
+		}
+		if o.serverErr != nil {
+			return
+		}
+		o.serverConfig.NextProtos = tlsNextProtos
+		// Pin để cấu hình cho lệnh client; chứng chỉ tự ký đổi sau mỗi lần khởi động
+		certificates := o.serverConfig.Certificates
+		if len(certificates) == 0 && o.serverConfig.GetCertificate != nil {
+			if current, err := o.serverConfig.GetCertificate(&tls.ClientHelloInfo{}); err == nil && current != nil {
+				certificates = []tls.Certificate{*current}
+			}
+		}
+		if len(certificates) > 0 {
+			pin := sha256.Sum256(certificates[0].Certificate[0])
+			log.Printf("TLS obfuscation certificate pin=%s", hex.EncodeToString(pin[:]))
+		}
+	})
+	return o.serverErr
+}
+
+func (o *tlsObfuscator) Server(conn net.Conn) (net.Conn, error) {
+	if err := o.prepareServer(); err != nil {
+		return nil, err
+	}
+	return serverTLS(conn, o.serverConfig)
+}
+
+func (o *tlsObfuscator) Client(conn net.Conn) (net.Conn, error) {
+	config := o.clientConfig
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// Tạo chứng chỉ tự ký cho tên name, dùng khi obfs_listen tls không có cert
+func selfSignedCertificate(name string) (tls.Certificate, error) {
+	if name == "" {
+		name = "localhost"
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
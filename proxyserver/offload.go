@@ -0,0 +1,164 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"proxy_server/proxyserver/auth"
+)
+
+// Cấu hình một listener TLS offload
+type TLSOffloadConfig struct {
+	Listen   string // Địa chỉ nhận kết nối TLS
+	Backend  string // Địa chỉ backend nhận dữ liệu đã giải mã
+	CertFile string // "acme" để dùng chứng chỉ ACME
+	KeyFile  string
+	Username string // User dùng để tính dữ liệu (tùy chọn)
+}
+
+// Phân tích giá trị tls_offload=listen,backend,cert,key[,user]
+func parseTLSOffload(value string) (TLSOffloadConfig, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 && len(parts) != 5 {
+		return TLSOffloadConfig{}, fmt.Errorf("expected listen,backend,cert,key[,user]")
+	}
+
+	offload := TLSOffloadConfig{
+		Listen:   strings.TrimSpace(parts[0]),
+		Backend:  strings.TrimSpace(parts[1]),
+		CertFile: strings.TrimSpace(parts[2]),
+		KeyFile:  strings.TrimSpace(parts[3]),
+	}
+	if len(parts) == 5 {
+		offload.Username = strings.TrimSpace(parts[4])
+	}
+	return offload, nil
+}
+
+// Kết nối TLS của listener offload: như tls.NewListener, bắt tay ở lần đọc ghi đầu tiên. Với ktls, bắt tay
+// ngay để chuyển mã hóa sang kernel trước khi chuyển tiếp dữ liệu
+func offloadTLSConn(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	if !systemConfig().KTLS {
+		return tls.Server(conn, config), nil
+	}
+	return serverTLS(conn, config)
+}
+
+// Nhận kết nối TLS, giải mã và chuyển tiếp dữ liệu thô tới backend
+func startTLSOffload(offload TLSOffloadConfig) {
+	tlsConfig, err := listenerTLSConfig(offload.CertFile, offload.KeyFile, offload.CertFile == "acme")
+	if err != nil {
+		log.Printf("TLS offload %s: %v", offload.Listen, err)
+		return
+	}
+
+	tcpListener, err := listenTCP(listenerOffload, offload.Listen)
+	if err != nil {
+		log.Printf("TLS offload %s: %v", offload.Listen, err)
+		return
+	}
+	listener := tuneListener(tcpListener, offload.Listen)
+	log.Printf("TLS offload started on %s -> %s", offload.Listen, offload.Backend)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("TLS offload accept error: %v", err)
+			backoffOnFDExhaustion(err)
+			continue
+		}
+		if shedIfFDExhausted(conn) || shedIfMemoryHigh(conn) || !acquireConnCredit() {
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseConnCredit()
+			defer recoverPanic("TLS offload connection", conn)
+			handleTLSOffload(conn, tlsConfig, offload)
+		}()
+	}
+}
+
+// Backend của TLS offload là chặng nội bộ: kết nối trực tiếp, không qua upstream, NAT64, pool egress,
+// ACL hay rewrite; chỉ áp dụng dial_tcp
+func dialOffloadBackend(addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	timeout := systemConfig().ConnectionTimeout
+	if systemConfig().DialTCP.Timeout > 0 {
+		timeout = systemConfig().DialTCP.Timeout
+	}
+	if timeout > 0 {
+		dialer.Timeout = time.Duration(timeout) * time.Second
+	}
+	dialer.KeepAlive = time.Duration(systemConfig().DialTCP.KeepAlive) * time.Second
+	if systemConfig().DialTCP.FastOpen {
+		dialer.Control = fastOpenDialControl
+	}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tuneTCPConn(conn, systemConfig().DialTCP)
+	return conn, nil
+}
+
+func handleTLSOffload(rawConn net.Conn, tlsConfig *tls.Config, offload TLSOffloadConfig) {
+	conn, err := offloadTLSConn(rawConn, tlsConfig)
+	if err != nil {
+		rawConn.Close()
+		return
+	}
+	defer conn.Close()
+
+	var user *User
+	if offload.Username != "" {
+		usersMutex.RLock()
+		user = users[offload.Username]
+		usersMutex.RUnlock()
+		if user == nil {
+			log.Printf("TLS offload %s: unknown accounting user %s", offload.Listen, offload.Username)
+			return
+		}
+		// Tính vào số kết nối của user nhưng không bị connection_limit từ chối
+		user.state.conns.Add(1)
+		defer user.releaseConn()
+	}
+
+	started := time.Now()
+	info := newConnInfo("tls", offload.Listen, conn, user, offload.Backend, auth.UsernameParams{})
+	info.internal = true
+	if maintenanceActive() {
+		finishConn(info, user, 0, 0, started, CloseMaintenance)
+		return
+	}
+	if err := runConnectRequestHooks(info, user); err != nil {
+		denyByHook(info, user, started, err)
+		return
+	}
+	targetConn, err := dialOffloadBackend(info.Dest)
+	if err != nil {
+		log.Printf("TLS offload %s: backend dial error: %v", offload.Listen, err)
+		failConn(info, user, started, newConnError(classifyDialError(err), err))
+		return
+	}
+	defer targetConn.Close()
+	if err := runDialedHooks(info, targetConn); err != nil {
+		denyByHook(info, user, started, err)
+		return
+	}
+	conn.SetDeadline(time.Time{}) // Bỏ hạn bắt tay của listener
+
+	// Truyền dữ liệu giữa client và backend
+	up, down, reason := transferData(conn, targetConn, user, info)
+	finishConn(info, user, up, down, started, reason)
+}
@@ -0,0 +1,195 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Giá trị mặc định cho dịch vụ policy
+const (
+	defaultPolicyTimeout  = 500 // ms
+	defaultPolicyCacheTTL = 30  // giây
+	maxPolicyCacheEntries = 10000
+)
+
+// Dữ liệu gửi tới dịch vụ policy cho mỗi yêu cầu CONNECT
+type PolicyInput struct {
+	User     string    `json:"user"`
+	Group    string    `json:"group"`
+	Protocol string    `json:"protocol"`
+	Listener string    `json:"listener"`
+	ClientIP string    `json:"client_ip"`
+	Dest     string    `json:"dest"`
+	DestHost string    `json:"dest_host"`
+	DestPort string    `json:"dest_port"`
+	Tags     tagString `json:"tags"` // Tag của user, gửi dạng object
+}
+
+// Quyết định của dịch vụ policy: cho phép/từ chối, đích thay thế (route) và IP egress nếu có
+type PolicyDecision struct {
+	Allow  bool   `json:"allow"`
+	Dest   string `json:"dest,omitempty"`
+	Egress string `json:"egress,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type cachedDecision struct {
+	decision PolicyDecision
+	expires  time.Time
+}
+
+var (
+	policyCache      = make(map[PolicyInput]cachedDecision)
+	policyCacheMutex sync.Mutex
+
+	policyAllowed atomic.Int64 // Số yêu cầu được cho phép
+	policyDenied  atomic.Int64 // Số yêu cầu bị từ chối
+	policyErrors  atomic.Int64 // Số lần gọi dịch vụ policy lỗi
+)
+
+// Hỏi dịch vụ policy (nếu được cấu hình) trước khi kết nối; có thể đổi info.Dest và info.Egress
+func checkPolicy(info *ConnInfo, user *User) error {
+	if systemConfig().PolicyURL == "" {
+		return nil
+	}
+
+	decision, err := policyDecision(newPolicyInput(info, user))
+	if err != nil {
+		policyErrors.Add(1)
+		if systemConfig().PolicyFailOpen {
+			log.Printf("Policy service error, allowing %s: %v", info.Dest, err)
+			return nil
+		}
+		return fmt.Errorf("policy service unavailable: %v", err)
+	}
+	return applyPolicyDecision(info, decision)
+}
+
+// Dữ liệu của yêu cầu CONNECT gửi cho dịch vụ policy hoặc script
+func newPolicyInput(info *ConnInfo, user *User) PolicyInput {
+	input := PolicyInput{
+		Protocol: info.Protocol,
+		Listener: info.Listener,
+		Dest:     info.Dest,
+	}
+	if user != nil {
+		input.User, input.Group, input.Tags = user.Username, user.Group, tagString(formatTags(user.Tags))
+	}
+	if host, _, err := net.SplitHostPort(info.Client.String()); err == nil {
+		input.ClientIP = host
+	}
+	input.DestHost, input.DestPort, _ = net.SplitHostPort(info.Dest)
+	return input
+}
+
+// Áp dụng quyết định: trả về lỗi khi bị từ chối, ngược lại đổi đích/IP egress nếu được chỉ định
+func applyPolicyDecision(info *ConnInfo, decision PolicyDecision) error {
+	if !decision.Allow {
+		policyDenied.Add(1)
+		if decision.Reason != "" {
+			return fmt.Errorf("denied by policy: %s", decision.Reason)
+		}
+		return errors.New("denied by policy")
+	}
+	policyAllowed.Add(1)
+	if decision.Dest != "" {
+		info.Dest = decision.Dest
+	}
+	if decision.Egress != "" {
+		info.Egress = decision.Egress
+	}
+	return nil
+}
+
+// Quyết định từ cache hoặc từ dịch vụ policy
+func policyDecision(input PolicyInput) (PolicyDecision, error) {
+	ttl := systemConfig().PolicyCacheTTL
+	if ttl == 0 {
+		ttl = defaultPolicyCacheTTL
+	}
+
+	now := time.Now()
+	if ttl > 0 {
+		policyCacheMutex.Lock()
+		cached, exists := policyCache[input]
+		policyCacheMutex.Unlock()
+		if exists && now.Before(cached.expires) {
+			return cached.decision, nil
+		}
+	}
+
+	decision, err := queryPolicy(input)
+	if err != nil {
+		return decision, err
+	}
+
+	if ttl > 0 {
+		policyCacheMutex.Lock()
+		// Cache đầy: bỏ toàn bộ thay vì theo dõi thứ tự truy cập
+		if len(policyCache) >= maxPolicyCacheEntries {
+			policyCache = make(map[PolicyInput]cachedDecision)
+		}
+		policyCache[input] = cachedDecision{decision: decision, expires: now.Add(time.Duration(ttl) * time.Second)}
+		policyCacheMutex.Unlock()
+	}
+	return decision, nil
+}
+
+// Gọi dịch vụ policy. Body gửi đi là {"input": ...} theo OPA Data API; phản hồi có thể là
+// quyết định trực tiếp ({"allow": ...}) hoặc nằm trong "result" (boolean hoặc object) như OPA trả về
+func queryPolicy(input PolicyInput) (PolicyDecision, error) {
+	var decision PolicyDecision
+	body, err := json.Marshal(map[string]PolicyInput{"input": input})
+	if err != nil {
+		return decision, err
+	}
+
+	timeout := systemConfig().PolicyTimeout
+	if timeout <= 0 {
+		timeout = defaultPolicyTimeout
+	}
+	client := http.Client{Timeout: time.Duration(timeout) * time.Millisecond}
+	resp, err := client.Post(systemConfig().PolicyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return decision, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decision, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var reply struct {
+		PolicyDecision
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return decision, fmt.Errorf("invalid response: %v", err)
+	}
+	if len(reply.Result) == 0 {
+		return reply.PolicyDecision, nil
+	}
+	if result := strings.TrimSpace(string(reply.Result)); result == "true" || result == "false" {
+		decision.Allow = result == "true"
+		return decision, nil
+	}
+	if err := json.Unmarshal(reply.Result, &decision); err != nil {
+		return decision, fmt.Errorf("invalid result: %v", err)
+	}
+	return decision, nil
+}
+
+// Xóa cache khi cấu hình policy thay đổi
+func resetPolicyCache() {
+	policyCacheMutex.Lock()
+	policyCache = make(map[PolicyInput]cachedDecision)
+	policyCacheMutex.Unlock()
+}
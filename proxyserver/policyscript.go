@@ -0,0 +1,239 @@
+package proxyserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Thời gian tối đa cho một lần gọi on_connect
+const policyScriptTimeout = 100 * time.Millisecond
+
+// Chu kỳ kiểm tra file script đã thay đổi chưa
+const policyScriptCheckInterval = 2 * time.Second
+
+// Script Lua đã biên dịch, mỗi goroutine dùng một LState riêng lấy từ pool
+type policyScript struct {
+	path    string
+	modTime time.Time
+	proto   *lua.FunctionProto
+	states  sync.Pool
+}
+
+var currentPolicyScript atomic.Pointer[policyScript]
+
+// Biên dịch script và kiểm tra nó khai báo hàm on_connect
+func compilePolicyScript(path string) (*policyScript, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(file, path)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, err
+	}
+
+	script := &policyScript{path: path, modTime: info.ModTime(), proto: proto}
+	state, err := script.newState()
+	if err != nil {
+		return nil, err
+	}
+	if state.GetGlobal("on_connect").Type() != lua.LTFunction {
+		state.Close()
+		return nil, errors.New("script does not define function on_connect")
+	}
+	script.states.Put(state)
+	return script, nil
+}
+
+// LState chỉ mở các thư viện không truy cập file hay hệ thống, rồi chạy phần thân script
+func (script *policyScript) newState() (*lua.LState, error) {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyScriptTimeout)
+	defer cancel()
+	state.SetContext(ctx)
+	state.Push(state.NewFunctionFromProto(script.proto))
+	err := state.PCall(0, 0, nil)
+	state.RemoveContext()
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
+	return state, nil
+}
+
+// Gọi on_connect(req) và chuyển kết quả thành quyết định
+func (script *policyScript) decide(input PolicyInput) (PolicyDecision, error) {
+	decision := PolicyDecision{Allow: true}
+	state, _ := script.states.Get().(*lua.LState)
+	if state == nil {
+		var err error
+		if state, err = script.newState(); err != nil {
+			return decision, err
+		}
+	}
+
+	req := state.NewTable()
+	for key, value := range map[string]string{
+		"user": input.User, "group": input.Group, "protocol": input.Protocol, "listener": input.Listener,
+		"client_ip": input.ClientIP, "dest": input.Dest, "dest_host": input.DestHost, "dest_port": input.DestPort,
+	} {
+		req.RawSetString(key, lua.LString(value))
+	}
+	tags := state.NewTable()
+	parsed, _ := parseTags(string(input.Tags))
+	for key, value := range parsed {
+		tags.RawSetString(key, lua.LString(value))
+	}
+	req.RawSetString("tags", tags)
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyScriptTimeout)
+	defer cancel()
+	state.SetContext(ctx)
+	err := state.CallByParam(lua.P{Fn: state.GetGlobal("on_connect"), NRet: 2, Protect: true}, req)
+	state.RemoveContext()
+	if err != nil {
+		// LState có thể dừng giữa chừng (ví dụ hết thời gian), không dùng lại
+		state.Close()
+		return decision, err
+	}
+	result, reason := state.Get(-2), state.Get(-1)
+	state.Pop(2)
+	script.states.Put(state)
+
+	// nil hoặc true: cho phép; false[, reason]: từ chối; table: {allow, dest, egress, reason}
+	switch result := result.(type) {
+	case *lua.LNilType:
+	case lua.LBool:
+		decision.Allow = bool(result)
+		if reason.Type() == lua.LTString {
+			decision.Reason = reason.String()
+		}
+	case *lua.LTable:
+		if allow := result.RawGetString("allow"); allow != lua.LNil {
+			decision.Allow = lua.LVAsBool(allow)
+		}
+		decision.Dest = luaString(result.RawGetString("dest"))
+		decision.Egress = luaString(result.RawGetString("egress"))
+		decision.Reason = luaString(result.RawGetString("reason"))
+	default:
+		return decision, fmt.Errorf("on_connect returned %s, expected nil, boolean or table", result.Type())
+	}
+	return decision, nil
+}
+
+func luaString(value lua.LValue) string {
+	if value.Type() != lua.LTString {
+		return ""
+	}
+	return value.String()
+}
+
+// Chạy script policy (nếu có) trước khi kết nối; có thể đổi info.Dest và info.Egress
+func checkPolicyScript(info *ConnInfo, user *User) error {
+	script := currentPolicyScript.Load()
+	if script == nil {
+		return nil
+	}
+
+	decision, err := script.decide(newPolicyInput(info, user))
+	if err != nil {
+		policyErrors.Add(1)
+		if systemConfig().PolicyFailOpen {
+			log.Printf("Policy script error, allowing %s: %v", info.Dest, err)
+			return nil
+		}
+		return fmt.Errorf("policy script error: %v", err)
+	}
+	return applyPolicyDecision(info, decision)
+}
+
+// Nạp lại script khi đường dẫn trong cấu hình hoặc nội dung file thay đổi.
+// Script mới lỗi thì giữ script đang chạy
+func reloadPolicyScript() error {
+	script, err := loadPolicyScript(systemConfig().PolicyScript)
+	if err != nil {
+		return err
+	}
+	installPolicyScript(script)
+	return nil
+}
+
+// Script tại path: nil khi path rỗng, script đang chạy khi file không thay đổi
+func loadPolicyScript(path string) (*policyScript, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if current := currentPolicyScript.Load(); current != nil && current.path == path {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Equal(current.modTime) {
+			return current, nil
+		}
+	}
+	return compilePolicyScript(path)
+}
+
+// Dùng script mới, ghi log khi script được bật, tắt hoặc nạp lại
+func installPolicyScript(script *policyScript) {
+	switch current := currentPolicyScript.Swap(script); {
+	case script == current:
+	case script == nil:
+		log.Println("Policy script disabled")
+	default:
+		log.Printf("Policy script %s loaded", script.path)
+	}
+}
+
+func runPolicyScriptWatcher() {
+	var lastError string
+	for {
+		time.Sleep(policyScriptCheckInterval)
+		// Chỉ log khi lỗi thay đổi để file lỗi không làm đầy log
+		message := ""
+		if err := reloadPolicyScript(); err != nil {
+			message = err.Error()
+		}
+		if message != "" && message != lastError {
+			log.Printf("Policy script reload error: %s", message)
+		}
+		lastError = message
+	}
+}
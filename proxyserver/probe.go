@@ -0,0 +1,313 @@
+package proxyserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Chẩn đoán đường đi từ máy chủ: ping ICMP hoặc thử kết nối TCP tới một đích từ một IP egress,
+// để kiểm tra tuyến khi khách hàng báo lỗi với một trang cụ thể
+const (
+	probeModeTCP  = "tcp"
+	probeModeICMP = "icmp"
+
+	defaultProbeCount = 4
+	maxProbeCount     = 20
+	probeInterval     = time.Second
+	probeTimeout      = 2 * time.Second
+)
+
+// Kết quả một lần thử
+type ProbeAttempt struct {
+	Seq   int     `json:"seq"`
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// Kết quả trả về qua API
+type ProbeResult struct {
+	Dest        string         `json:"dest"`
+	Address     string         `json:"address"` // IP đã phân giải
+	Port        int            `json:"port,omitempty"`
+	Mode        string         `json:"mode"`
+	Egress      string         `json:"egress,omitempty"` // IP nguồn, rỗng = hệ thống tự chọn
+	Sent        int            `json:"sent"`
+	Received    int            `json:"received"`
+	LossPercent float64        `json:"loss_percent"`
+	RTTMinMs    float64        `json:"rtt_min_ms,omitempty"`
+	RTTAvgMs    float64        `json:"rtt_avg_ms,omitempty"`
+	RTTMaxMs    float64        `json:"rtt_max_ms,omitempty"`
+	Attempts    []ProbeAttempt `json:"attempts"`
+}
+
+// GET /api/probe?dest=host&mode=tcp|icmp&port=443&egress=ip&count=4
+func handleAdminProbe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dest := query.Get("dest")
+	if dest == "" {
+		admin.WriteError(w, http.StatusBadRequest, "dest is required")
+		return
+	}
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = probeModeTCP
+	}
+	if mode != probeModeTCP && mode != probeModeICMP {
+		admin.WriteError(w, http.StatusBadRequest, "invalid mode, expected tcp or icmp")
+		return
+	}
+	port := 443
+	if value := query.Get("port"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 65535 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid port")
+			return
+		}
+		port = parsed
+	}
+	count := defaultProbeCount
+	if value := query.Get("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxProbeCount {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid count, expected 1 to %d", maxProbeCount))
+			return
+		}
+		count = parsed
+	}
+	var source net.IP
+	if value := query.Get("egress"); value != "" {
+		source = poolEgressIP(value)
+		if source == nil {
+			admin.WriteError(w, http.StatusBadRequest, "egress is not an egress_ip address")
+			return
+		}
+	}
+
+	target, err := resolveProbeTarget(r.Context(), dest, source)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	result := ProbeResult{Dest: dest, Address: target.String(), Mode: mode}
+	if source != nil {
+		result.Egress = source.String()
+	}
+	if mode == probeModeTCP {
+		result.Port = port
+		result.Attempts = tcpProbe(r.Context(), source, target, port, count)
+	} else {
+		result.Attempts, err = icmpProbe(r.Context(), source, target, count)
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	summarizeProbe(&result)
+	admin.WriteJSON(w, http.StatusOK, result)
+}
+
+// IP trong pool egress_ip khớp value, nil nếu không có
+func poolEgressIP(value string) net.IP {
+	ip := hostIP(value)
+	if ip == nil {
+		return nil
+	}
+	egressPoolMutex.Lock()
+	defer egressPoolMutex.Unlock()
+	for _, egress := range egressPool {
+		if egress.ip.Equal(ip) {
+			return egress.ip
+		}
+	}
+	return nil
+}
+
+// Phân giải đích bằng resolver chung của server, chọn địa chỉ cùng họ với IP nguồn
+func resolveProbeTarget(ctx context.Context, dest string, source net.IP) (net.IP, error) {
+	if ip := hostIP(dest); ip != nil {
+		if source != nil && (ip.To4() == nil) != (source.To4() == nil) {
+			return nil, fmt.Errorf("%s and egress %s are not the same address family", dest, source)
+		}
+		return ip, nil
+	}
+	network := "ip"
+	if source != nil {
+		network = "ip6"
+		if source.To4() != nil {
+			network = "ip4"
+		}
+	}
+	resolver := serverResolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIP(ctx, network, dest)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+// Đo thời gian bắt tay TCP; kết nối bị từ chối hoặc hết thời gian tính là mất
+func tcpProbe(ctx context.Context, source, target net.IP, port, count int) []ProbeAttempt {
+	dialer := net.Dialer{Timeout: probeTimeout}
+	if source != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: source}
+	}
+	addr := net.JoinHostPort(target.String(), strconv.Itoa(port))
+	attempts := make([]ProbeAttempt, 0, count)
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 && !sleepContext(ctx, probeInterval) {
+			break
+		}
+		started := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		attempt := ProbeAttempt{Seq: seq}
+		if err != nil {
+			attempt.Error = probeError(err)
+		} else {
+			attempt.RTTMs = durationMs(time.Since(started))
+			conn.Close()
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts
+}
+
+// Gửi ICMP echo; dùng socket ICMP không cần quyền (net.ipv4.ping_group_range) nếu được, ngược lại raw socket
+func icmpProbe(ctx context.Context, source, target net.IP, count int) ([]ProbeAttempt, error) {
+	isV4 := target.To4() != nil
+	local := "0.0.0.0"
+	datagram, raw, protocol := "udp4", "ip4:icmp", ipv4.ICMPTypeEcho.Protocol()
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if !isV4 {
+		local = "::"
+		datagram, raw, protocol = "udp6", "ip6:ipv6-icmp", ipv6.ICMPTypeEchoRequest.Protocol()
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+	if source != nil {
+		local = source.String()
+	}
+
+	privileged := false
+	conn, err := icmp.ListenPacket(datagram, local)
+	if err != nil {
+		conn, err = icmp.ListenPacket(raw, local)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open ICMP socket (run as root or allow ping_group_range): %v", err)
+		}
+		privileged = true
+	}
+	defer conn.Close()
+
+	var peer net.Addr = &net.UDPAddr{IP: target}
+	if privileged {
+		peer = &net.IPAddr{IP: target}
+	}
+	// Socket không cần quyền do kernel đặt ID; với raw socket, ID ngẫu nhiên tránh nhận nhầm reply của lần đo khác
+	idBytes := make([]byte, 2)
+	rand.Read(idBytes)
+	id := int(binary.BigEndian.Uint16(idBytes))
+
+	attempts := make([]ProbeAttempt, 0, count)
+	buf := make([]byte, 1500)
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 && !sleepContext(ctx, probeInterval) {
+			break
+		}
+		attempt := ProbeAttempt{Seq: seq}
+		message := icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("proxy-server probe")}}
+		packet, err := message.Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		if _, err := conn.WriteTo(packet, peer); err != nil {
+			attempt.Error = probeError(err)
+			attempts = append(attempts, attempt)
+			continue
+		}
+		conn.SetReadDeadline(started.Add(probeTimeout))
+		attempt.Error = "timeout"
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			reply, err := icmp.ParseMessage(protocol, buf[:n])
+			if err != nil || reply.Type != replyType {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			if !ok || echo.Seq != seq || (privileged && echo.ID != id) {
+				continue
+			}
+			attempt.RTTMs = durationMs(time.Since(started))
+			attempt.Error = ""
+			break
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+// Tính số gói đã gửi, nhận, tỉ lệ mất và RTT nhỏ nhất/trung bình/lớn nhất
+func summarizeProbe(result *ProbeResult) {
+	var total float64
+	for _, attempt := range result.Attempts {
+		result.Sent++
+		if attempt.Error != "" {
+			continue
+		}
+		result.Received++
+		total += attempt.RTTMs
+		if result.RTTMinMs == 0 || attempt.RTTMs < result.RTTMinMs {
+			result.RTTMinMs = attempt.RTTMs
+		}
+		result.RTTMaxMs = max(result.RTTMaxMs, attempt.RTTMs)
+	}
+	if result.Sent > 0 {
+		result.LossPercent = float64(result.Sent-result.Received) * 100 / float64(result.Sent)
+	}
+	if result.Received > 0 {
+		result.RTTAvgMs = total / float64(result.Received)
+	}
+}
+
+// Lỗi ngắn gọn theo nguyên nhân, như lý do đóng tunnel
+func probeError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return classifyDialError(err)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Chờ d, false khi request bị hủy trước đó
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
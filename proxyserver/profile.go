@@ -0,0 +1,9 @@
+package proxyserver
+
+import "os"
+
+// Profile cấu hình (dev, staging, prod...) trong cùng một system.conf, xem config.SelectProfile.
+// Profile được chọn bằng --profile hoặc biến môi trường PROXY_PROFILE
+const profileEnv = "PROXY_PROFILE"
+
+var configProfile = os.Getenv(profileEnv) // Profile đang dùng, rỗng = chỉ cấu hình chung
@@ -0,0 +1,324 @@
+package proxyserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// API cấp phát tài khoản cho panel thanh toán (WHMCS và tương tự): tạo, tạm khóa, mở khóa, hủy và lấy
+// lượng dùng. Các request thay đổi dữ liệu nhận header Idempotency-Key để panel gửi lại an toàn
+const (
+	idempotencyTTL        = 24 * time.Hour
+	maxIdempotencyEntries = 10000
+	maxProvisionBody      = 1 << 20
+)
+
+// Trạng thái tài khoản trả về cho panel
+const (
+	accountActive    = "active"
+	accountSuspended = "suspended"
+	accountExpired   = "expired"
+)
+
+// Response đã trả cho một Idempotency-Key
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte // Method, path và body của request gốc
+	pending     bool              // Request gốc đang được xử lý
+	status      int
+	body        []byte
+	expires     time.Time
+}
+
+var (
+	idempotentResponses = make(map[string]*idempotentResponse) // Theo token và Idempotency-Key
+	idempotencyMutex    sync.Mutex
+)
+
+// Lượng dùng của một tài khoản
+type AccountUsage struct {
+	Username      string `json:"username"`
+	Status        string `json:"status"`
+	SuspendReason string `json:"suspend_reason,omitempty"`
+	DataUsage     int64  `json:"data_usage"`
+	MaxData       int64  `json:"max_data"`
+	CurrentConns  int    `json:"current_conns"`
+	OpenTunnels   int    `json:"open_tunnels"`
+	EndDate       string `json:"end_date"`
+}
+
+// Ghi lại status và body của response để lưu cho Idempotency-Key
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// Request cùng token và Idempotency-Key trong idempotencyTTL nhận lại đúng response đầu tiên mà không
+// thực hiện lại; dùng lại key với request khác bị từ chối. Response lỗi 5xx không được lưu
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProvisionBody))
+		if err != nil {
+			admin.WriteError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+		storeKey := admin.RequestToken(r).Name + "\x00" + key
+		now := time.Now()
+
+		idempotencyMutex.Lock()
+		entry, exists := idempotentResponses[storeKey]
+		if exists && now.After(entry.expires) {
+			delete(idempotentResponses, storeKey)
+			exists = false
+		}
+		if !exists {
+			if len(idempotentResponses) >= maxIdempotencyEntries {
+				for k, e := range idempotentResponses {
+					if !e.pending && now.After(e.expires) {
+						delete(idempotentResponses, k)
+					}
+				}
+			}
+			entry = &idempotentResponse{fingerprint: fingerprint, pending: true, expires: now.Add(idempotencyTTL)}
+			idempotentResponses[storeKey] = entry
+		}
+		idempotencyMutex.Unlock()
+
+		if exists {
+			switch {
+			case entry.fingerprint != fingerprint:
+				admin.WriteError(w, http.StatusUnprocessableEntity, "idempotency key reused with a different request")
+			case entry.pending:
+				admin.WriteError(w, http.StatusConflict, "a request with this idempotency key is in progress")
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		idempotencyMutex.Lock()
+		if recorder.status >= 500 {
+			delete(idempotentResponses, storeKey)
+		} else {
+			entry.status, entry.body, entry.pending = recorder.status, recorder.body.Bytes(), false
+		}
+		idempotencyMutex.Unlock()
+	}
+}
+
+// Trạng thái tài khoản theo thứ tự ưu tiên: tạm khóa, hết hạn, đang hoạt động
+func accountStatus(user *User, now time.Time) string {
+	switch {
+	case user.isSuspended():
+		return accountSuspended
+	case !user.EndDate.IsZero() && !now.Before(user.EndDate.AddDate(0, 0, 1)):
+		return accountExpired
+	}
+	return accountActive
+}
+
+// Lượng dùng của các user; gọi khi giữ usersMutex
+func accountUsages(list []*User) []AccountUsage {
+	openTunnels := make(map[string]int)
+	for _, t := range idleTunnels(0) {
+		if t.user != nil {
+			openTunnels[t.user.Username]++
+		}
+	}
+	now := time.Now()
+	usages := make([]AccountUsage, 0, len(list))
+	for _, user := range list {
+		usages = append(usages, AccountUsage{
+			Username:      user.Username,
+			Status:        accountStatus(user, now),
+			SuspendReason: user.suspendReason(),
+			DataUsage:     user.dataUsage(),
+			MaxData:       user.MaxData,
+			CurrentConns:  user.activeConns(),
+			OpenTunnels:   openTunnels[user.Username],
+			EndDate:       user.EndDate.Format("2006-01-02"),
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Username < usages[j].Username })
+	return usages
+}
+
+// Tạo tài khoản với các trường như POST /api/users; username đã tồn tại trả về 409
+func handleProvisionCreate(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	var req userRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProvisionBody)).Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if token.Role == admin.RoleReseller {
+		req.Owner = token.Scope
+	}
+	user, err := validateUserRequest(req)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usersMutex.Lock()
+	if _, exists := users[user.Username]; exists {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusConflict, "user already exists")
+		return
+	}
+	users[user.Username] = user
+	queueUserEmail(emailAccountCreated, user, user.Password)
+	view := newUserView(user)
+	usersMutex.Unlock()
+
+	recordAudit(token.Name, "provision.create", user.Username, nil, view)
+	log.Printf("Provisioning API: account %s created by token %s", user.Username, token.Name)
+	if writeBackFailed(w, user.Username) {
+		return
+	}
+	admin.WriteJSON(w, http.StatusCreated, view)
+}
+
+// Tạm khóa tài khoản (ví dụ hóa đơn quá hạn): từ chối xác thực và đóng các tunnel đang mở.
+// Body tùy chọn {"reason": "..."}; khóa tài khoản đang bị khóa chỉ cập nhật lý do
+func handleProvisionSuspend(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.PathValue("username")
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProvisionBody)).Decode(&req); err != nil && err != io.EOF {
+		admin.WriteError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	usersMutex.Lock()
+	user, exists := users[username]
+	if !exists || !canAccessUser(token, user) {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	old := newUserView(user)
+	user.setSuspended(true, req.Reason)
+	view := newUserView(user)
+	usersMutex.Unlock()
+	clusterRecordSuspension(username, true, req.Reason)
+
+	closeUserTunnels(username, CloseAdminKick)
+	recordAudit(token.Name, "provision.suspend", username, old, view)
+	log.Printf("Provisioning API: account %s suspended by token %s", username, token.Name)
+	admin.WriteJSON(w, http.StatusOK, view)
+}
+
+func handleProvisionUnsuspend(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.PathValue("username")
+
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+
+	user, exists := users[username]
+	if !exists || !canAccessUser(token, user) {
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	old := newUserView(user)
+	user.setSuspended(false, "")
+	clusterRecordSuspension(username, false, "")
+
+	recordAudit(token.Name, "provision.unsuspend", username, old, newUserView(user))
+	log.Printf("Provisioning API: account %s unsuspended by token %s", username, token.Name)
+	admin.WriteJSON(w, http.StatusOK, newUserView(user))
+}
+
+// Hủy tài khoản: xóa user và đóng các tunnel đang mở
+func handleProvisionTerminate(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+	username := r.PathValue("username")
+
+	usersMutex.Lock()
+	user, exists := users[username]
+	if !exists || !canAccessUser(token, user) {
+		usersMutex.Unlock()
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	delete(users, username)
+	usersMutex.Unlock()
+
+	closeUserTunnels(username, CloseAdminKick)
+	recordAudit(token.Name, "provision.terminate", username, newUserView(user), nil)
+	log.Printf("Provisioning API: account %s terminated by token %s", username, token.Name)
+	if writeBackFailed(w, username) {
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]string{"username": username, "status": "terminated"})
+}
+
+func handleProvisionUsage(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	user, exists := users[r.PathValue("username")]
+	if !exists || !canAccessUser(token, user) {
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, accountUsages([]*User{user})[0])
+}
+
+// Lượng dùng của mọi tài khoản token được thấy, cho tác vụ cập nhật lượng dùng định kỳ của panel
+func handleProvisionUsageList(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	var list []*User
+	for _, user := range users {
+		if canAccessUser(token, user) {
+			list = append(list, user)
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, accountUsages(list))
+}
@@ -0,0 +1,267 @@
+package proxyserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"proxy_server/proxyserver/socks5"
+)
+
+// Lệnh test: gửi một request HTTP(S) qua một proxy đang chạy và in thời gian từng bước,
+// dùng cho hỗ trợ khách hàng và smoke test trong CI
+
+// Tên các mã reply SOCKS5 để in kết quả dễ đọc
+var socks5ReplyNames = map[byte]string{
+	socks5.GeneralFailure:      "general failure",
+	socks5.NotAllowed:          "not allowed by ruleset",
+	socks5.NetworkUnreachable:  "network unreachable",
+	socks5.HostUnreachable:     "host unreachable",
+	socks5.ConnectionRefused:   "connection refused",
+	socks5.TTLExpired:          "TTL expired",
+	socks5.CommandNotSupported: "command not supported",
+	socks5.AddressNotSupported: "address type not supported",
+}
+
+// Thời điểm kết thúc từng bước, tính từ lúc bắt đầu
+type proxyTestTimings struct {
+	connect   time.Duration // TCP tới proxy
+	handshake time.Duration // Chọn phương thức xác thực
+	auth      time.Duration // Xác thực username/password
+	dial      time.Duration // Reply CONNECT, gồm thời gian proxy kết nối tới đích
+	tls       time.Duration // Bắt tay TLS với đích (https)
+	ttfb      time.Duration // Byte đầu tiên của response
+	total     time.Duration
+}
+
+// proxy-server test --via user:pass@host:port --url URL
+func runTestCommand(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	via := flags.String("via", "", "proxy to test, as [user:pass@]host:port")
+	target := flags.String("url", "", "http or https URL to request through the proxy")
+	method := flags.String("method", http.MethodGet, "HTTP method")
+	timeout := flags.Duration("timeout", 15*time.Second, "timeout for the whole request")
+	insecure := flags.Bool("insecure", false, "do not verify the destination's TLS certificate")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *via == "" || *target == "" || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: proxy-server test --via [user:pass@]host:port --url URL [--method GET] [--timeout 15s] [--insecure]")
+		return 2
+	}
+	proxy, err := url.Parse("socks5://" + strings.TrimPrefix(*via, "socks5://"))
+	if err != nil || proxy.Host == "" {
+		fmt.Fprintf(os.Stderr, "Invalid --via value: %s\n", *via)
+		return 2
+	}
+	request, err := http.NewRequest(*method, *target, nil)
+	if err != nil || (request.URL.Scheme != "http" && request.URL.Scheme != "https") {
+		fmt.Fprintf(os.Stderr, "Invalid --url value: %s\n", *target)
+		return 2
+	}
+
+	fmt.Printf("Testing %s %s via %s\n", request.Method, request.URL, proxy.Host)
+	timings, response, size, err := proxyTestRequest(proxy, request, *timeout, *insecure)
+	printProxyTestTimings(timings)
+	if err != nil {
+		fmt.Printf("FAIL  %v\n", err)
+		return 1
+	}
+	fmt.Printf("Response: %s, %s body\n", response.Status, formatBytes(size))
+	if response.StatusCode >= 400 {
+		fmt.Println("FAIL  destination returned an error status")
+		return 1
+	}
+	fmt.Println("ok")
+	return 0
+}
+
+// Thực hiện từng bước SOCKS5 rồi request HTTP, trả về thời gian các bước đã hoàn thành
+func proxyTestRequest(proxy *url.URL, request *http.Request, timeout time.Duration, insecure bool) (proxyTestTimings, *http.Response, int64, error) {
+	var timings proxyTestTimings
+	start := time.Now()
+
+	conn, err := net.DialTimeout("tcp", proxy.Host, timeout)
+	if err != nil {
+		return timings, nil, 0, fmt.Errorf("connect to proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(timeout))
+	timings.connect = time.Since(start)
+
+	host := request.URL.Hostname()
+	port := request.URL.Port()
+	if port == "" {
+		port = "80"
+		if request.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if err := socks5ClientHandshake(conn, proxy.User, &timings, start); err != nil {
+		return timings, nil, 0, err
+	}
+	if err := socks5ClientRequest(conn, host, port); err != nil {
+		return timings, nil, 0, fmt.Errorf("connect to destination: %v", err)
+	}
+	timings.dial = time.Since(start)
+
+	var stream net.Conn = conn
+	if request.URL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: insecure})
+		if err := tlsConn.Handshake(); err != nil {
+			return timings, nil, 0, fmt.Errorf("TLS handshake: %v", err)
+		}
+		stream = tlsConn
+		timings.tls = time.Since(start)
+	}
+
+	request.Header.Set("User-Agent", "proxy-server-test")
+	request.Close = true
+	if err := request.Write(stream); err != nil {
+		return timings, nil, 0, fmt.Errorf("send request: %v", err)
+	}
+	reader := bufio.NewReader(stream)
+	if _, err := reader.Peek(1); err != nil {
+		return timings, nil, 0, fmt.Errorf("read response: %v", err)
+	}
+	timings.ttfb = time.Since(start)
+
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return timings, nil, 0, fmt.Errorf("read response: %v", err)
+	}
+	size, err := io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	timings.total = time.Since(start)
+	if err != nil {
+		return timings, response, size, fmt.Errorf("read response body: %v", err)
+	}
+	return timings, response, size, nil
+}
+
+// Chọn phương thức rồi xác thực, mỗi bước đợi reply riêng để đo thời gian
+func socks5ClientHandshake(conn net.Conn, user *url.Userinfo, timings *proxyTestTimings, start time.Time) error {
+	method := byte(0x00)
+	if user != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return fmt.Errorf("handshake: %v", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("handshake: %v", err)
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		if user == nil {
+			return errors.New("handshake: proxy requires authentication, add user:pass@ to --via")
+		}
+		return errors.New("handshake: proxy does not accept username/password authentication")
+	}
+	timings.handshake = time.Since(start)
+	if user == nil {
+		return nil
+	}
+
+	password, _ := user.Password()
+	if len(user.Username()) > 255 || len(password) > 255 {
+		return errors.New("auth: username and password must be at most 255 bytes")
+	}
+	request := []byte{0x01, byte(len(user.Username()))}
+	request = append(request, user.Username()...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("auth: %v", err)
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("auth: %v", err)
+	}
+	if reply[1] != 0x00 {
+		return fmt.Errorf("auth: %v", socks5.ErrAuthRejected)
+	}
+	timings.auth = time.Since(start)
+	return nil
+}
+
+// CONNECT theo tên miền để proxy tự phân giải, như socks5h
+func socks5ClientRequest(conn net.Conn, host, port string) error {
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", port)
+	}
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("host name too long")
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x04)
+		request = append(request, ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(portNumber))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != socks5.Succeeded {
+		if name, ok := socks5ReplyNames[reply[1]]; ok {
+			return fmt.Errorf("%s (reply 0x%02x)", name, reply[1])
+		}
+		return &socks5.ReplyError{Code: reply[1]}
+	}
+	// Phần còn lại của BND.ADDR và BND.PORT; với tên miền, reply[4] là độ dài
+	skip := net.IPv4len - 1 + 2
+	switch reply[3] {
+	case 0x03:
+		skip = int(reply[4]) + 2
+	case 0x04:
+		skip = net.IPv6len - 1 + 2
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// In thời gian từng bước; bước chưa hoàn thành hoặc không áp dụng thì bỏ qua
+func printProxyTestTimings(t proxyTestTimings) {
+	steps := []struct {
+		name string
+		at   time.Duration
+	}{
+		{"connect", t.connect},
+		{"handshake", t.handshake},
+		{"auth", t.auth},
+		{"dial", t.dial},
+		{"tls", t.tls},
+		{"ttfb", t.ttfb},
+		{"total", t.total},
+	}
+	var previous time.Duration
+	for _, step := range steps {
+		if step.at == 0 {
+			continue
+		}
+		fmt.Printf("  %-10s %10s  (+%s)\n", step.name, step.at.Round(time.Microsecond), (step.at - previous).Round(time.Microsecond))
+		previous = step.at
+	}
+}
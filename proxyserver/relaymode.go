@@ -0,0 +1,107 @@
+package proxyserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Buffer của chế độ độ trễ thấp bằng khoảng một segment TCP (MSS 1460 trên Ethernet),
+// mỗi lần đọc được ghi ngay thành một gói thay vì gom thành khối lớn
+const latencyBufferSize = 1460
+
+var latencyTunnels atomic.Int64 // Số tunnel đã chạy ở chế độ độ trễ thấp
+
+// Dải port đích, low == high khi chỉ có một port
+type portRange struct {
+	low, high int
+}
+
+// Dạng hiển thị trong config diff, giống giá trị cấu hình
+func (r portRange) String() string {
+	if r.low == r.high {
+		return strconv.Itoa(r.low)
+	}
+	return fmt.Sprintf("%d-%d", r.low, r.high)
+}
+
+// Đọc danh sách port và dải port, ví dụ "22,3389,27000-27050"
+func parsePortRanges(value string) ([]portRange, error) {
+	var ranges []portRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lowText, highText, isRange := strings.Cut(part, "-")
+		if !isRange {
+			highText = lowText
+		}
+		low, errLow := strconv.Atoi(lowText)
+		high, errHigh := strconv.Atoi(highText)
+		if errLow != nil || errHigh != nil || low < 1 || high > 65535 || low > high {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		ranges = append(ranges, portRange{low, high})
+	}
+	return ranges, nil
+}
+
+// Đọc danh sách phân cách bằng dấu phẩy, bỏ phần tử rỗng
+func parseNameList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Tunnel dùng chế độ độ trễ thấp khi port đích thuộc latency_ports hoặc nhóm của user thuộc latency_groups
+func lowLatencyRelay(user *User, dest string) bool {
+	if user != nil && user.Group != "" {
+		for _, group := range systemConfig().LatencyGroups {
+			if group == user.Group {
+				return true
+			}
+		}
+	}
+	_, portText, err := net.SplitHostPort(dest)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return false
+	}
+	for _, r := range systemConfig().LatencyPorts {
+		if port >= r.low && port <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// Chép với buffer nhỏ; bọc reader để io.CopyBuffer không chuyển sang WriteTo với buffer riêng
+func copyLowLatency(dst io.Writer, src io.Reader) (int64, error) {
+	return io.CopyBuffer(dst, struct{ io.Reader }{src}, make([]byte, latencyBufferSize))
+}
+
+// Bật TCP_NODELAY trên kết nối TCP bên dưới, kể cả khi listener_tcp hoặc dial_tcp bật Nagle
+func setNoDelay(conn net.Conn) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			c.SetNoDelay(true)
+			return
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package proxyserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Giá trị mặc định của kiểm tra uy tín IP client
+const (
+	defaultReputationRefresh  = 3600 // Chu kỳ tải lại reputation_list (giây)
+	defaultReputationCacheTTL = 3600 // Thời gian giữ kết quả kiểm tra một IP (giây)
+	reputationLookupTimeout   = 2 * time.Second
+	maxReputationCache        = 100000
+)
+
+// Hành động khi IP client nằm trong danh sách
+const (
+	reputationActionLog  = "log"
+	reputationActionDeny = "deny"
+)
+
+// Một danh sách IP xấu: IP đơn lẻ và dải CIDR
+type reputationList struct {
+	ips      map[string]struct{}
+	networks []*net.IPNet
+}
+
+type reputationEntry struct {
+	source  string // Danh sách hoặc zone DNSBL chứa IP, rỗng nếu IP sạch
+	expires time.Time
+}
+
+var (
+	reputationLists       atomic.Pointer[map[string]*reputationList] // Theo nguồn (file hoặc URL)
+	reputationCache       = make(map[string]reputationEntry)         // Theo IP client
+	reputationMutex       sync.Mutex
+	reputationListed      atomic.Int64 // Số kết nối từ IP nằm trong danh sách
+	reputationDenied      atomic.Int64 // Số kết nối bị từ chối vì reputation_action=deny
+	reputationReloadMutex sync.Mutex   // Chỉ một lần tải lại danh sách tại một thời điểm
+)
+
+func validReputationAction(action string) bool {
+	return action == reputationActionLog || action == reputationActionDeny
+}
+
+// Đọc danh sách IP/CIDR, mỗi dòng một mục; phần sau # hoặc ; là chú thích, dòng không hợp lệ bị bỏ qua
+func parseReputationList(r io.Reader) (*reputationList, error) {
+	list := &reputationList{ips: make(map[string]struct{})}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(fields[0]); err == nil {
+			list.networks = append(list.networks, network)
+		} else if ip := net.ParseIP(fields[0]); ip != nil {
+			list.ips[ip.String()] = struct{}{}
+		}
+	}
+	return list, scanner.Err()
+}
+
+func (list *reputationList) contains(ip net.IP) bool {
+	if _, exists := list.ips[ip.String()]; exists {
+		return true
+	}
+	for _, network := range list.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tải một danh sách từ file hoặc URL http(s)
+func fetchReputationList(source string) (*reputationList, error) {
+	var body io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: unexpected status %s", source, resp.Status)
+		}
+		body = resp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		body = file
+	}
+	list, err := parseReputationList(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return list, nil
+}
+
+// Tải mọi danh sách trong sources; nguồn bị lỗi giữ nội dung lần tải trước. Gọi khi giữ reputationReloadMutex
+func fetchReputationLists(sources []string) (map[string]*reputationList, error) {
+	previous := reputationLists.Load()
+	lists := make(map[string]*reputationList, len(sources))
+	var firstErr error
+	for _, source := range sources {
+		list, err := fetchReputationList(source)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if previous != nil && (*previous)[source] != nil {
+				lists[source] = (*previous)[source]
+			}
+			continue
+		}
+		lists[source] = list
+	}
+	return lists, firstErr
+}
+
+// Thay các danh sách đang dùng và xóa kết quả đã cache
+func installReputationLists(lists map[string]*reputationList) {
+	reputationLists.Store(&lists)
+
+	reputationMutex.Lock()
+	reputationCache = make(map[string]reputationEntry)
+	reputationMutex.Unlock()
+}
+
+// Tải lại mọi reputation_list theo cấu hình đang chạy
+func reloadReputationLists() error {
+	reputationReloadMutex.Lock()
+	defer reputationReloadMutex.Unlock()
+
+	lists, err := fetchReputationLists(systemConfig().ReputationLists)
+	installReputationLists(lists)
+	return err
+}
+
+// Tải lại các danh sách theo chu kỳ reputation_refresh
+func runReputationRefresher() {
+	for {
+		refresh := systemConfig().ReputationRefresh
+		if refresh <= 0 {
+			refresh = defaultReputationRefresh
+		}
+		time.Sleep(time.Duration(refresh) * time.Second)
+		if len(systemConfig().ReputationLists) == 0 {
+			continue
+		}
+		if err := reloadReputationLists(); err != nil {
+			log.Printf("Reputation list refresh error: %v", err)
+		}
+	}
+}
+
+// Tên truy vấn DNSBL của IP: các octet (IPv4) hoặc nibble (IPv6) theo thứ tự ngược, nối với zone
+func dnsblQuery(ip net.IP, zone string) string {
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(ip4[i]))
+		}
+	} else {
+		const hexDigits = "0123456789abcdef"
+		for i := len(ip) - 1; i >= 0; i-- {
+			labels = append(labels, string(hexDigits[ip[i]&0x0f]), string(hexDigits[ip[i]>>4]))
+		}
+	}
+	return strings.Join(labels, ".") + "." + strings.TrimSuffix(zone, ".") + "."
+}
+
+// Nguồn liệt kê IP: reputation_list trước, sau đó các zone reputation_dnsbl; rỗng nếu không nguồn nào liệt kê
+func lookupReputation(ip net.IP) string {
+	if lists := reputationLists.Load(); lists != nil {
+		for _, source := range systemConfig().ReputationLists {
+			if list := (*lists)[source]; list != nil && list.contains(ip) {
+				return source
+			}
+		}
+	}
+	for _, zone := range systemConfig().ReputationDNSBL {
+		ctx, cancel := context.WithTimeout(context.Background(), reputationLookupTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, dnsblQuery(ip, zone))
+		cancel()
+		// Không trả lời được (timeout, lỗi DNS) được coi là không bị liệt kê
+		if err == nil && len(addrs) > 0 {
+			return zone
+		}
+	}
+	return ""
+}
+
+// Kiểm tra IP client khi nhận kết nối; false khi kết nối phải bị đóng (reputation_action=deny).
+// Kết quả được cache theo reputation_cache_ttl, mỗi IP bị liệt kê chỉ được log một lần mỗi TTL
+func allowClientReputation(conn net.Conn) bool {
+	if len(systemConfig().ReputationLists) == 0 && len(systemConfig().ReputationDNSBL) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	now := time.Now()
+
+	reputationMutex.Lock()
+	entry, cached := reputationCache[host]
+	reputationMutex.Unlock()
+
+	if !cached || now.After(entry.expires) {
+		ttl := systemConfig().ReputationCacheTTL
+		if ttl <= 0 {
+			ttl = defaultReputationCacheTTL
+		}
+		entry = reputationEntry{source: lookupReputation(ip), expires: now.Add(time.Duration(ttl) * time.Second)}
+
+		reputationMutex.Lock()
+		if len(reputationCache) >= maxReputationCache {
+			reputationCache = make(map[string]reputationEntry)
+		}
+		reputationCache[host] = entry
+		reputationMutex.Unlock()
+
+		if entry.source != "" {
+			log.Printf("Client %s is listed by %s (action %s)", host, entry.source, reputationAction())
+		}
+	}
+	if entry.source == "" {
+		return true
+	}
+
+	reputationListed.Add(1)
+	if reputationAction() == reputationActionDeny {
+		reputationDenied.Add(1)
+		return false
+	}
+	return true
+}
+
+func reputationAction() string {
+	if systemConfig().ReputationAction == "" {
+		return reputationActionLog
+	}
+	return systemConfig().ReputationAction
+}
@@ -0,0 +1,173 @@
+package proxyserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Một quy tắc đổi đích: host (hoặc *.domain, *, dải CIDR) và port tùy chọn -> host và/hoặc port mới
+type rewriteRule struct {
+	host    string     // Host khớp chính xác; rỗng khi dùng suffix, any hoặc network
+	suffix  string     // ".domain" khi mẫu là *.domain
+	any     bool       // Mẫu *
+	network *net.IPNet // Mẫu dải IP
+	port    string     // Rỗng = mọi port
+
+	toHost string // Rỗng = giữ host
+	toPort string // Rỗng = giữ port
+}
+
+var (
+	rewriteRules atomic.Pointer[[]rewriteRule]
+	rewriteTotal atomic.Int64 // Số kết nối bị đổi đích
+)
+
+// Đọc file quy tắc, mỗi dòng "<mẫu>[:port] <host>[:port]" hoặc "<mẫu>[:port] :port";
+// dòng trống và dòng bắt đầu bằng # được bỏ qua
+func parseRewriteRules(r io.Reader) ([]rewriteRule, error) {
+	var rules []rewriteRule
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<match> <target>\"", lineNumber)
+		}
+		rule, err := parseRewriteRule(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func parseRewriteRule(match, target string) (rewriteRule, error) {
+	var rule rewriteRule
+
+	// Địa chỉ IPv6 có port phải đặt trong [], nên lỗi SplitHostPort nghĩa là mẫu không có port
+	pattern := match
+	if host, port, err := net.SplitHostPort(match); err == nil {
+		if !validPort(port) {
+			return rule, fmt.Errorf("invalid port in %q", match)
+		}
+		pattern, rule.port = host, port
+	}
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	switch {
+	case pattern == "*":
+		rule.any = true
+	case strings.HasPrefix(pattern, "*."):
+		rule.suffix = pattern[1:]
+	case strings.Contains(pattern, "/"):
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return rule, fmt.Errorf("invalid network %q", pattern)
+		}
+		rule.network = network
+	case pattern == "" || strings.Contains(pattern, "*"):
+		return rule, fmt.Errorf("invalid match %q", match)
+	default:
+		rule.host = pattern
+	}
+
+	rule.toHost = target
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		if !validPort(port) {
+			return rule, fmt.Errorf("invalid port in %q", target)
+		}
+		rule.toHost, rule.toPort = host, port
+	}
+	if rule.toHost == "" && rule.toPort == "" {
+		return rule, fmt.Errorf("invalid target %q", target)
+	}
+	return rule, nil
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+func (rule *rewriteRule) matches(host, port string) bool {
+	if rule.port != "" && rule.port != port {
+		return false
+	}
+	switch {
+	case rule.any:
+		return true
+	case rule.suffix != "":
+		return strings.HasSuffix(host, rule.suffix)
+	case rule.network != nil:
+		ip := hostIP(host)
+		return ip != nil && rule.network.Contains(ip)
+	}
+	return host == rule.host
+}
+
+// Đích sau khi áp dụng quy tắc đầu tiên khớp; trả về nguyên dest khi không có quy tắc nào khớp
+func rewriteDest(dest string) string {
+	rules := rewriteRules.Load()
+	if rules == nil {
+		return dest
+	}
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return dest
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	for i := range *rules {
+		rule := &(*rules)[i]
+		if !rule.matches(host, port) {
+			continue
+		}
+		if rule.toHost != "" {
+			host = rule.toHost
+		}
+		if rule.toPort != "" {
+			port = rule.toPort
+		}
+		rewriteTotal.Add(1)
+		return net.JoinHostPort(host, port)
+	}
+	return dest
+}
+
+// Nạp lại rewrite_file; file lỗi thì giữ bộ quy tắc đang dùng
+func reloadRewriteRules() error {
+	rules, err := loadRewriteRules(systemConfig().RewriteFile)
+	if err != nil {
+		return err
+	}
+	rewriteRules.Store(rules)
+	return nil
+}
+
+// Đọc rewrite_file, nil khi path rỗng
+func loadRewriteRules(path string) (*[]rewriteRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rules, err := parseRewriteRules(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &rules, nil
+}
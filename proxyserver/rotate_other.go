@@ -0,0 +1,5 @@
+//go:build !unix
+
+package proxyserver
+
+func watchRotationSignal() {}
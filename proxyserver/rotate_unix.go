@@ -0,0 +1,20 @@
+//go:build unix
+
+package proxyserver
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Xoay vòng secret khi nhận tín hiệu SIGHUP
+func watchRotationSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			refreshSecrets()
+		}
+	}()
+}
@@ -0,0 +1,289 @@
+package proxyserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Tiền tố đánh dấu giá trị đã được mã hóa trong file cấu hình. enc:v3: là định dạng hiện tại: một khóa gốc
+// được dẫn xuất bằng scrypt từ master key và salt chung (lưu trong mỗi giá trị, mọi giá trị mã hóa bằng
+// cùng master key dùng chung salt này), khóa AES của từng giá trị được dẫn xuất bằng HKDF với salt riêng.
+// enc:v2: (scrypt cho từng giá trị) và enc:<base64> cũ (SHA-256 không salt) vẫn đọc được
+const (
+	encryptedPrefix   = "enc:"
+	encryptedV2Prefix = "enc:v2:"
+	encryptedV3Prefix = "enc:v3:"
+)
+
+// Tham số scrypt (khuyến nghị cho dẫn xuất tương tác), đổi tham số thì phải tăng phiên bản
+const (
+	secretSaltSize = 16
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+)
+
+// Thông tin HKDF của khóa từng giá trị enc:v3:
+const secretKeyInfo = "proxy-server enc:v3"
+
+// Biến môi trường chứa master key
+const masterKeyEnv = "PROXY_MASTER_KEY"
+
+var (
+	masterSecret      string            // Master key đã đọc, giữ tới lần xoay vòng secret tiếp theo
+	derivedKeys       map[string][]byte // Salt -> khóa đã dẫn xuất, tránh chạy lại scrypt khi nạp lại file
+	secretKeySalt     []byte            // Salt của khóa gốc dùng khi mã hóa giá trị mới
+	masterKeyMutex    sync.Mutex
+	legacySecretsOnce sync.Once
+	v2SecretsOnce     sync.Once
+)
+
+// Lấy master key từ biến môi trường hoặc từ lệnh KMS trong cấu hình (master_key_command)
+func getMasterSecret() (string, error) {
+	if masterSecret != "" {
+		return masterSecret, nil
+	}
+
+	secret := os.Getenv(masterKeyEnv)
+	if secret == "" && systemConfig().MasterKeyCommand != "" {
+		output, err := exec.Command("sh", "-c", systemConfig().MasterKeyCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("master_key_command failed: %v", err)
+		}
+		secret = strings.TrimSpace(string(output))
+	}
+	if secret == "" {
+		return "", errors.New("no master key: set " + masterKeyEnv + " or master_key_command")
+	}
+	masterSecret = secret
+	derivedKeys = make(map[string][]byte)
+	return masterSecret, nil
+}
+
+// Khóa dẫn xuất từ master key và salt (khóa gốc của enc:v3: hoặc khóa AES của enc:v2:); salt nil là khóa
+// của định dạng cũ
+func secretKey(salt []byte) ([]byte, error) {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+	return secretKeyLocked(salt)
+}
+
+func secretKeyLocked(salt []byte) ([]byte, error) {
+	secret, err := getMasterSecret()
+	if err != nil {
+		return nil, err
+	}
+	if key, cached := derivedKeys[string(salt)]; cached {
+		return key, nil
+	}
+
+	var key []byte
+	if salt == nil {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else if key, err = scrypt.Key([]byte(secret), salt, scryptN, scryptR, scryptP, 32); err != nil {
+		return nil, err
+	}
+	derivedKeys[string(salt)] = key
+	return key, nil
+}
+
+// Salt và khóa gốc cho giá trị mới: salt của khóa gốc đã dẫn xuất khi đọc giá trị enc:v3:, chưa có thì
+// tạo salt mới và giữ cho các giá trị sau
+func currentSecretKey() ([]byte, []byte, error) {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+
+	if _, err := getMasterSecret(); err != nil {
+		return nil, nil, err
+	}
+	if secretKeySalt == nil {
+		salt := make([]byte, secretSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		secretKeySalt = salt
+	}
+	key, err := secretKeyLocked(secretKeySalt)
+	return secretKeySalt, key, err
+}
+
+// Khóa gốc của salt trong một giá trị enc:v3:, salt này được dùng tiếp cho giá trị mới
+func storedSecretKey(salt []byte) ([]byte, error) {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+
+	key, err := secretKeyLocked(salt)
+	if err == nil && secretKeySalt == nil {
+		secretKeySalt = salt
+	}
+	return key, err
+}
+
+// Dùng salt khóa gốc của giá trị enc:v3: đầu tiên trong các file cho giá trị mới (lệnh encrypt), để mọi
+// giá trị trong cấu hình chỉ cần một lần dẫn xuất scrypt
+func adoptSecretKeySalt(paths ...string) {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		_, rest, found := strings.Cut(string(data), encryptedV3Prefix)
+		if !found {
+			continue
+		}
+		encoded, _, _ := strings.Cut(rest, ",")
+		sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(sealed) < 2*secretSaltSize {
+			continue
+		}
+		masterKeyMutex.Lock()
+		if secretKeySalt == nil {
+			secretKeySalt = sealed[:secretSaltSize]
+		}
+		masterKeyMutex.Unlock()
+		return
+	}
+}
+
+// Quên master key và các khóa đã dẫn xuất để lần dùng sau đọc lại (khi xoay vòng secret)
+func resetMasterKey() {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+	masterSecret = ""
+	derivedKeys = nil
+	secretKeySalt = nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AES-GCM của một giá trị enc:v3:: khóa AES được dẫn xuất từ khóa gốc bằng HKDF-SHA256 với salt riêng của giá trị
+func valueCipher(rootKey, valueSalt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, rootKey, valueSalt, []byte(secretKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// Mã hóa giá trị bằng AES-256-GCM, kết quả có dạng enc:v3:<base64 của salt khóa gốc, salt giá trị, nonce và bản mã>
+func encryptSecret(plain string) (string, error) {
+	keySalt, rootKey, err := currentSecretKey()
+	if err != nil {
+		return "", err
+	}
+	valueSalt := make([]byte, secretSaltSize)
+	if _, err := rand.Read(valueSalt); err != nil {
+		return "", err
+	}
+	gcm, err := valueCipher(rootKey, valueSalt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	header := append(append(append([]byte{}, keySalt...), valueSalt...), nonce...)
+	sealed := gcm.Seal(header, nonce, []byte(plain), nil)
+	return encryptedV3Prefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Giá trị để ghi vào file cấu hình: được mã hóa khi có master key (PROXY_MASTER_KEY hoặc master_key_command),
+// không có thì giữ dạng rõ
+func storedSecret(plain string) (string, error) {
+	if os.Getenv(masterKeyEnv) == "" && systemConfig().MasterKeyCommand == "" {
+		return plain, nil
+	}
+	return encryptSecret(plain)
+}
+
+// Giải mã giá trị có tiền tố enc:, giá trị không mã hóa được trả về nguyên vẹn
+func decryptSecret(value string) (string, error) {
+	// Base64 không chứa dấu ':' nên enc:v3:/enc:v2: không nhầm với định dạng cũ
+	encoded, v3 := strings.CutPrefix(value, encryptedV3Prefix)
+	v2 := false
+	if !v3 {
+		if encoded, v2 = strings.CutPrefix(value, encryptedV2Prefix); v2 {
+			v2SecretsOnce.Do(func() {
+				log.Printf("enc:v2: values need one scrypt derivation each at startup, encrypt them again with the encrypt command")
+			})
+		} else {
+			var found bool
+			if encoded, found = strings.CutPrefix(value, encryptedPrefix); !found {
+				return value, nil
+			}
+			legacySecretsOnce.Do(func() {
+				log.Printf("Encrypted values without a salt are deprecated, encrypt them again with the encrypt command")
+			})
+		}
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %v", err)
+	}
+
+	var gcm cipher.AEAD
+	switch {
+	case v3:
+		if len(sealed) < 2*secretSaltSize {
+			return "", errors.New("invalid encrypted value: too short")
+		}
+		rootKey, err := storedSecretKey(sealed[:secretSaltSize])
+		if err != nil {
+			return "", err
+		}
+		gcm, err = valueCipher(rootKey, sealed[secretSaltSize:2*secretSaltSize])
+		if err != nil {
+			return "", err
+		}
+		sealed = sealed[2*secretSaltSize:]
+	default:
+		var salt []byte
+		if v2 {
+			if len(sealed) < secretSaltSize {
+				return "", errors.New("invalid encrypted value: too short")
+			}
+			salt, sealed = sealed[:secretSaltSize], sealed[secretSaltSize:]
+		}
+		key, err := secretKey(salt)
+		if err != nil {
+			return "", err
+		}
+		if gcm, err = newGCM(key); err != nil {
+			return "", err
+		}
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value: wrong master key or corrupted data")
+	}
+	return string(plain), nil
+}
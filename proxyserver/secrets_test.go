@@ -0,0 +1,105 @@
+package proxyserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useMasterKey(t *testing.T, key string) {
+	t.Helper()
+	t.Setenv(masterKeyEnv, key)
+	resetMasterKey()
+	t.Cleanup(resetMasterKey)
+}
+
+// Mọi giá trị enc:v3: dùng chung một khóa gốc: chỉ một lần dẫn xuất scrypt cho cả cấu hình
+func TestEncryptSecretDerivesOneKey(t *testing.T) {
+	useMasterKey(t, "test master key")
+
+	values := []string{"alice password", "bob, password", " pässword: ", ""}
+	encrypted := make([]string, len(values))
+	for i, value := range values {
+		var err error
+		if encrypted[i], err = encryptSecret(value); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encrypted[i], encryptedV3Prefix) {
+			t.Fatalf("encrypted as %q", encrypted[i])
+		}
+	}
+	if encrypted[0] == encrypted[1] {
+		t.Fatal("values share a ciphertext")
+	}
+
+	// Đọc lại sau khi xoay vòng: vẫn chỉ một khóa được dẫn xuất
+	resetMasterKey()
+	for i, value := range encrypted {
+		plain, err := decryptSecret(value)
+		if err != nil || plain != values[i] {
+			t.Fatalf("%q decrypts to %q (%v)", value, plain, err)
+		}
+	}
+	if len(derivedKeys) != 1 {
+		t.Fatalf("%d keys derived, want 1", len(derivedKeys))
+	}
+
+	useMasterKey(t, "other master key")
+	if _, err := decryptSecret(encrypted[0]); err == nil {
+		t.Fatal("decrypted with the wrong master key")
+	}
+}
+
+// Giá trị enc:v2: (scrypt cho từng giá trị) vẫn đọc được
+func TestDecryptSecretV2(t *testing.T) {
+	useMasterKey(t, "test master key")
+
+	salt := make([]byte, secretSaltSize)
+	rand.Read(salt)
+	key, err := secretKey(salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte("old password"), nil)
+
+	resetMasterKey()
+	plain, err := decryptSecret(encryptedV2Prefix + base64.RawURLEncoding.EncodeToString(sealed))
+	if err != nil || plain != "old password" {
+		t.Fatalf("decrypts to %q (%v)", plain, err)
+	}
+}
+
+// Lệnh encrypt dùng lại salt khóa gốc của giá trị đã có trong users.conf
+func TestAdoptSecretKeySalt(t *testing.T) {
+	useMasterKey(t, "test master key")
+	existing, err := encryptSecret("alice password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.conf")
+	if err := os.WriteFile(path, []byte("# users\nalice,"+existing+",2024-01-01,2030-01-01,1,0,0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	resetMasterKey()
+	adoptSecretKeySalt(filepath.Join(t.TempDir(), "missing.conf"), path)
+	added, err := encryptSecret("bob password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySalt := func(value string) string {
+		sealed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, encryptedV3Prefix))
+		return string(sealed[:secretSaltSize])
+	}
+	if keySalt(added) != keySalt(existing) {
+		t.Fatal("new value uses another key salt")
+	}
+}
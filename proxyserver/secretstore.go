@@ -0,0 +1,341 @@
+package proxyserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Secret lấy từ kho bên ngoài thay vì ghi trong file cấu hình: vault:<path>#<field> đọc một trường
+// của secret HashiCorp Vault (KV v1 hoặc v2), ssm:<name> đọc một tham số AWS SSM Parameter Store
+// (SecureString được giải mã). Giá trị được lấy khi cần và giữ trong bộ nhớ tới lần xoay vòng tiếp theo
+// (SIGHUP hoặc POST /api/secrets/refresh)
+const (
+	vaultPrefix = "vault:"
+	ssmPrefix   = "ssm:"
+
+	secretStoreTimeout = 10 * time.Second
+	maxSecretResponse  = 1 << 20
+)
+
+var (
+	vaultSecrets = make(map[string]map[string]string) // Path -> các trường của secret
+	ssmSecrets   = make(map[string]string)
+	secretsMutex sync.Mutex
+)
+
+// Giá trị là tham chiếu tới kho secret bên ngoài
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, vaultPrefix) || strings.HasPrefix(value, ssmPrefix)
+}
+
+// Giá trị thật của một secret trong cấu hình: lấy từ Vault hoặc SSM, giải mã enc:, còn lại giữ nguyên
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, vaultPrefix):
+		path, field, found := strings.Cut(strings.TrimPrefix(value, vaultPrefix), "#")
+		if !found || path == "" || field == "" {
+			return "", fmt.Errorf("invalid Vault reference %q, expected vault:<path>#<field>", value)
+		}
+		return vaultSecret(path, field)
+	case strings.HasPrefix(value, ssmPrefix):
+		name := strings.TrimPrefix(value, ssmPrefix)
+		if name == "" {
+			return "", fmt.Errorf("invalid SSM reference %q, expected ssm:<parameter name>", value)
+		}
+		return ssmSecret(name)
+	}
+	return decryptSecret(value)
+}
+
+// Nội dung của value: secret nếu value là tham chiếu vault:/ssm:, ngược lại đọc file tại đường dẫn value
+func readSecretFile(value string) ([]byte, error) {
+	if isSecretReference(value) {
+		secret, err := resolveSecret(value)
+		return []byte(secret), err
+	}
+	return os.ReadFile(value)
+}
+
+func vaultSecret(path, field string) (string, error) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	fields, cached := vaultSecrets[path]
+	if !cached {
+		var err error
+		if fields, err = fetchVaultSecret(path); err != nil {
+			return "", err
+		}
+		vaultSecrets[path] = fields
+	}
+	value, exists := fields[field]
+	if !exists {
+		return "", fmt.Errorf("vault: secret %s has no field %s", path, field)
+	}
+	return value, nil
+}
+
+func ssmSecret(name string) (string, error) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	if value, cached := ssmSecrets[name]; cached {
+		return value, nil
+	}
+	value, err := fetchSSMParameter(name)
+	if err != nil {
+		return "", err
+	}
+	ssmSecrets[name] = value
+	return value, nil
+}
+
+// Token Vault: vault_token_file (đọc lại mỗi lần, để dùng với Vault Agent) hoặc VAULT_TOKEN
+func vaultToken() (string, error) {
+	if systemConfig().VaultTokenFile != "" {
+		data, err := os.ReadFile(systemConfig().VaultTokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", errors.New("no Vault token: set vault_token_file or VAULT_TOKEN")
+}
+
+// Đọc secret tại path; với KV v2 path có dạng <mount>/data/<tên>
+func fetchVaultSecret(path string) (map[string]string, error) {
+	addr := systemConfig().VaultAddr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("vault: set vault_addr or VAULT_ADDR")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return nil, fmt.Errorf("vault: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	namespace := systemConfig().VaultNamespace
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	body, status, err := secretStoreRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s: %v", path, err)
+	}
+	if status != http.StatusOK {
+		var reply struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(body, &reply)
+		if status == http.StatusNotFound {
+			return nil, fmt.Errorf("vault: secret %s not found", path)
+		}
+		return nil, fmt.Errorf("vault: %s: status %d %s", path, status, strings.Join(reply.Errors, "; "))
+	}
+
+	var reply struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("vault: %s: %v", path, err)
+	}
+	data := reply.Data
+	// KV v2 đặt các trường trong data.data, cạnh data.metadata
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = inner
+		}
+	}
+	fields := make(map[string]string, len(data))
+	for key, value := range data {
+		if text, ok := value.(string); ok {
+			fields[key] = text
+		} else {
+			encoded, _ := json.Marshal(value)
+			fields[key] = string(encoded)
+		}
+	}
+	return fields, nil
+}
+
+// Đọc một tham số SSM bằng API GetParameter
+func fetchSSMParameter(name string) (string, error) {
+	region := awsRegion()
+	if region == "" {
+		return "", errors.New("ssm: set aws_region or AWS_REGION")
+	}
+	creds, err := getAWSCredentials()
+	if err != nil {
+		return "", fmt.Errorf("ssm: %v", err)
+	}
+	endpoint := systemConfig().SSMEndpoint
+	if endpoint == "" {
+		endpoint = "https://ssm." + region + ".amazonaws.com"
+	}
+
+	payload, _ := json.Marshal(map[string]any{"Name": name, "WithDecryption": true})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("ssm: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
+	signAWSRequest(req, payload, creds, region, "ssm", time.Now())
+
+	body, status, err := secretStoreRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("ssm: %s: %v", name, err)
+	}
+	if status != http.StatusOK {
+		var reply struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &reply)
+		return "", fmt.Errorf("ssm: %s: status %d %s %s", name, status, reply.Type, reply.Message)
+	}
+	var reply struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return "", fmt.Errorf("ssm: %s: %v", name, err)
+	}
+	return reply.Parameter.Value, nil
+}
+
+func secretStoreRequest(req *http.Request) ([]byte, int, error) {
+	client := http.Client{Timeout: secretStoreTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSecretResponse))
+	return body, resp.StatusCode, err
+}
+
+// Xoay vòng secret: lấy lại mọi secret đã dùng rồi nạp lại mật khẩu user, token admin API và chứng chỉ TLS.
+// Chỉ mật khẩu được cập nhật, các thay đổi khác trong users.conf vẫn cần config apply
+func refreshSecrets() error {
+	if err := refetchSecrets(); err != nil {
+		log.Printf("Secret refresh error: %v", err)
+		return err
+	}
+	// master_key_command được chạy lại ở lần giải mã tiếp theo
+	resetMasterKey()
+
+	var errs []error
+	if err := refreshUserPasswords(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", userFile, err))
+	}
+	if systemConfig().AdminListen != "" {
+		if err := loadAPITokens(adminTokensPath()); err != nil {
+			errs = append(errs, fmt.Errorf("admin API tokens: %v", err))
+		}
+	}
+	errs = append(errs, reloadKeyPairs()...)
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("Secret refresh error: %v", err)
+		return err
+	}
+	log.Println("Secrets refreshed.")
+	return nil
+}
+
+// Lấy lại các secret đã lưu; khi kho secret lỗi, giá trị cũ được giữ nguyên
+func refetchSecrets() error {
+	secretsMutex.Lock()
+	paths := make([]string, 0, len(vaultSecrets))
+	for path := range vaultSecrets {
+		paths = append(paths, path)
+	}
+	names := make([]string, 0, len(ssmSecrets))
+	for name := range ssmSecrets {
+		names = append(names, name)
+	}
+	secretsMutex.Unlock()
+
+	freshVault := make(map[string]map[string]string, len(paths))
+	for _, path := range paths {
+		fields, err := fetchVaultSecret(path)
+		if err != nil {
+			return err
+		}
+		freshVault[path] = fields
+	}
+	freshSSM := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := fetchSSMParameter(name)
+		if err != nil {
+			return err
+		}
+		freshSSM[name] = value
+	}
+
+	secretsMutex.Lock()
+	vaultSecrets, ssmSecrets = freshVault, freshSSM
+	secretsMutex.Unlock()
+	return nil
+}
+
+// Cập nhật mật khẩu của các user hiện có theo users.conf
+func refreshUserPasswords() error {
+	file, err := os.Open(userFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fresh, err := parseUsers(file, systemConfig().StrictUsers)
+	if err != nil {
+		return err
+	}
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	for username, user := range users {
+		if updated, exists := fresh[username]; exists {
+			user.Password = updated.Password
+		}
+	}
+	return nil
+}
+
+// POST /api/secrets/refresh
+func handleAdminRefreshSecrets(w http.ResponseWriter, r *http.Request) {
+	token := admin.RequestToken(r)
+
+	err := refreshSecrets()
+	recordAudit(token.Name, "secrets.refresh", "", nil, err == nil)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Admin API: secrets refreshed by token %s", token.Name)
+	admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "refreshed"})
+}
@@ -0,0 +1,120 @@
+package proxyserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// Server chạy proxy bên trong một chương trình Go khác thay vì exec binary.
+// Cấu hình, user và listener là trạng thái chung của package nên mỗi process chỉ chạy được một Server
+type Server struct {
+	SystemFile string // Mặc định system.conf
+	UsersFile  string // Mặc định users.conf
+	TokensFile string // Mặc định tokens.conf, dùng khi admin_tokens_file không được đặt
+	Profile    string // Section [tên] của SystemFile ghi đè cấu hình chung, mặc định PROXY_PROFILE
+	Listen     string // Địa chỉ listener SOCKS4/SOCKS5, ví dụ 0.0.0.0:1080
+
+	// Kết nối ra ngoài: Dialer thay cho upstream, NAT64 và pool egress; Resolver dùng cho domain đích.
+	// DialerForUser/ResolverForUser (trả về nil = dùng giá trị chung) chọn riêng theo user
+	Dialer          ContextDialer
+	Resolver        *net.Resolver
+	DialerForUser   func(username string) ContextDialer
+	ResolverForUser func(username string) *net.Resolver
+
+	// Hook vòng đời kết nối, chạy theo thứ tự trong danh sách
+	Hooks []Hooks
+
+	running bool
+}
+
+var (
+	errServerStarted    = errors.New("proxy server already started in this process")
+	errServerNotStarted = errors.New("proxy server is not running")
+
+	serverStarted atomic.Bool
+)
+
+// Start load cấu hình, khởi động các dịch vụ nền và bắt đầu nhận kết nối SOCKS trên Listen
+func (s *Server) Start() error {
+	if s.Listen == "" {
+		return errors.New("listen address is required")
+	}
+	if !serverStarted.CompareAndSwap(false, true) {
+		return errServerStarted
+	}
+
+	if s.SystemFile != "" {
+		systemFile = s.SystemFile
+	}
+	if s.UsersFile != "" {
+		userFile = s.UsersFile
+	}
+	if s.TokensFile != "" {
+		tokenFile = s.TokensFile
+	}
+	if s.Profile != "" {
+		configProfile = s.Profile
+	}
+	serverDialer, serverResolver = s.Dialer, s.Resolver
+	userDialerFunc, userResolverFunc = s.DialerForUser, s.ResolverForUser
+	connHooks = s.Hooks
+	if err := startServices(); err != nil {
+		serverStarted.Store(false)
+		return err
+	}
+
+	if err := startInstance(listenerSocks, s.Listen); err != nil {
+		serverStarted.Store(false)
+		return err
+	}
+	s.running = true
+	return nil
+}
+
+// Stop ngừng nhận kết nối mới và chờ các kết nối đang xử lý kết thúc.
+// Khi ctx hết hạn, các tunnel còn lại bị đóng với lý do server_stop và trả về lỗi của ctx
+func (s *Server) Stop(ctx context.Context) error {
+	if !s.running {
+		return errServerNotStarted
+	}
+	stopInstances()
+
+	handlersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(handlersDone)
+	}()
+
+	select {
+	case <-handlersDone:
+		return nil
+	case <-ctx.Done():
+		for _, t := range idleTunnels(0) {
+			t.close(CloseServerStop)
+		}
+		return ctx.Err()
+	}
+}
+
+// Reload đọc lại system.conf và users.conf rồi áp dụng các thay đổi không cần khởi động lại
+func (s *Server) Reload() (ConfigDiff, error) {
+	if !s.running {
+		return ConfigDiff{}, errServerNotStarted
+	}
+	newConfig, newUsers, err := readConfigFiles()
+	if err != nil {
+		return ConfigDiff{}, err
+	}
+	return applyConfig(newConfig, newUsers, false)
+}
+
+// RefreshSecrets lấy lại các secret vault:/ssm: và nạp lại mật khẩu user, token admin API và chứng chỉ TLS,
+// như SIGHUP với binary proxy-server
+func (s *Server) RefreshSecrets() error {
+	if !s.running {
+		return errServerNotStarted
+	}
+	return refreshSecrets()
+}
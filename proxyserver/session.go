@@ -0,0 +1,181 @@
+package proxyserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy_server/proxyserver/auth"
+)
+
+// Phiên kết thúc sau số giây này không có kết nối mới (mặc định)
+const defaultSessionTimeout = 300
+
+// Số phiên tối đa được theo dõi; khi đầy, kết nối mới không gắn với phiên
+const maxSessions = 100000
+
+// Các kết nối của cùng user từ cùng IP client, nối tiếp nhau trong session_timeout, thuộc một phiên
+type userSession struct {
+	id       string
+	username string
+	clientIP string
+	started  time.Time
+	lastSeen time.Time
+	conns    int64
+	up       int64
+	down     int64
+	egress   string // IP nguồn của kết nối ra ngoài gần nhất
+
+	name string        // ID phiên do client đặt trong username (tham số session), rỗng với phiên theo IP
+	ttl  time.Duration // Thời gian sống tối đa của phiên có tên, 0 = không giới hạn
+}
+
+// Phiên trả về qua API
+type SessionStatus struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	ClientIP    string    `json:"client_ip"`
+	Name        string    `json:"name,omitempty"`
+	Started     time.Time `json:"started"`
+	LastSeen    time.Time `json:"last_seen"`
+	Connections int64     `json:"connections"`
+	Up          int64     `json:"up"`
+	Down        int64     `json:"down"`
+	Egress      string    `json:"egress,omitempty"`
+}
+
+var (
+	sessions      = make(map[string]*userSession) // Theo username|IP client hoặc username|session=tên
+	sessionsMutex sync.Mutex
+)
+
+// Thời gian chờ của phiên, 0 khi tắt (session_timeout âm)
+func sessionTimeout() time.Duration {
+	timeout := systemConfig().SessionTimeout
+	if timeout < 0 {
+		return 0
+	}
+	if timeout == 0 {
+		timeout = defaultSessionTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// Gắn kết nối vào phiên đang mở của user từ IP này, hoặc mở phiên mới.
+// Phiên có tên (tham số session trong username) không phụ thuộc IP client và hết hạn sau ttl kể từ lúc mở
+func joinSession(user *User, client net.Addr, params auth.UsernameParams) *userSession {
+	timeout := sessionTimeout()
+	if user == nil || timeout == 0 {
+		return nil
+	}
+	clientIP := client.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	key := user.Username + "|" + clientIP
+	if params.Session != "" {
+		key = user.Username + "|session=" + params.Session
+	}
+	now := time.Now()
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	session, exists := sessions[key]
+	if !exists || session.expired(now, timeout) {
+		if !exists && len(sessions) >= maxSessions {
+			return nil
+		}
+		id := make([]byte, 8)
+		rand.Read(id)
+		session = &userSession{id: hex.EncodeToString(id), username: user.Username, clientIP: clientIP, started: now, name: params.Session, ttl: params.TTL}
+		sessions[key] = session
+	}
+	session.lastSeen = now
+	return session
+}
+
+// Phiên hết hạn khi quá timeout không có kết nối mới, hoặc phiên có tên đã sống quá ttl; gọi khi giữ sessionsMutex
+func (s *userSession) expired(now time.Time, timeout time.Duration) bool {
+	return now.Sub(s.lastSeen) > timeout || (s.ttl > 0 && now.Sub(s.started) > s.ttl)
+}
+
+// IP egress ưu tiên của phiên khi session_sticky_egress được bật; phiên có tên luôn dính IP
+func (s *userSession) stickyEgress() string {
+	if !systemConfig().SessionStickyEgress && s.name == "" {
+		return ""
+	}
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	return s.egress
+}
+
+// Ghi nhận IP nguồn của kết nối ra ngoài để các kết nối sau trong phiên dùng lại
+func (s *userSession) recordEgress(target net.Conn) {
+	addr, ok := target.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	sessionsMutex.Lock()
+	s.egress = addr.IP.String()
+	sessionsMutex.Unlock()
+}
+
+// Cộng dồn kết quả của một kết nối đã kết thúc vào phiên
+func (s *userSession) finish(up, down int64) {
+	sessionsMutex.Lock()
+	s.conns++
+	s.up += up
+	s.down += down
+	s.lastSeen = time.Now()
+	sessionsMutex.Unlock()
+}
+
+// Danh sách phiên chưa hết hạn, mới hoạt động nhất trước; username rỗng = mọi user
+func sessionStatuses(username string) []SessionStatus {
+	timeout := sessionTimeout()
+	now := time.Now()
+
+	sessionsMutex.Lock()
+	list := make([]SessionStatus, 0, len(sessions))
+	for _, session := range sessions {
+		if session.expired(now, timeout) || (username != "" && session.username != username) {
+			continue
+		}
+		list = append(list, SessionStatus{
+			ID:          session.id,
+			Username:    session.username,
+			ClientIP:    session.clientIP,
+			Name:        session.name,
+			Started:     session.started,
+			LastSeen:    session.lastSeen,
+			Connections: session.conns,
+			Up:          session.up,
+			Down:        session.down,
+			Egress:      session.egress,
+		})
+	}
+	sessionsMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
+
+// Xóa định kỳ các phiên đã hết hạn
+func runSessionJanitor() {
+	for {
+		time.Sleep(time.Minute)
+		timeout := sessionTimeout()
+		now := time.Now()
+		sessionsMutex.Lock()
+		for key, session := range sessions {
+			if timeout == 0 || session.expired(now, timeout) {
+				delete(sessions, key)
+			}
+		}
+		sessionsMutex.Unlock()
+	}
+}
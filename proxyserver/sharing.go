@@ -0,0 +1,270 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Giá trị mặc định của phát hiện chia sẻ tài khoản
+const (
+	defaultSharingWindow      = 600  // Giây
+	defaultSharingSuspendTime = 3600 // Giây
+	defaultSharingIPv4Prefix  = 32
+	defaultSharingIPv6Prefix  = 64
+)
+
+// Hành động khi một user dùng từ quá nhiều mạng client trong sharing_window
+const (
+	sharingActionAlert    = "alert"    // Chỉ log và gửi cảnh báo
+	sharingActionThrottle = "throttle" // Từ chối kết nối từ mạng mới, các mạng đã thấy vẫn dùng được
+	sharingActionSuspend  = "suspend"  // Tạm khóa user và đóng các tunnel đang mở
+)
+
+var (
+	errSharingLimit     = errors.New("too many client networks for this account")
+	errSharingSuspended = errors.New("account suspended for sharing")
+)
+
+// Các mạng client mà user đã kết nối từ đó trong cửa sổ hiện tại
+type sharingState struct {
+	networks       map[string]time.Time // Mạng client -> lần kết nối gần nhất
+	lastAlert      time.Time
+	suspendedUntil time.Time
+}
+
+// Trạng thái trả về qua API
+type SharingStatus struct {
+	Username       string    `json:"username"`
+	Networks       []string  `json:"networks"`
+	Limit          int       `json:"limit"`
+	SuspendedUntil time.Time `json:"suspended_until,omitempty"`
+}
+
+var (
+	sharingStates = make(map[string]*sharingState) // Theo username
+	sharingMutex  sync.Mutex
+)
+
+func validSharingAction(action string) bool {
+	switch action {
+	case sharingActionAlert, sharingActionThrottle, sharingActionSuspend:
+		return true
+	}
+	return false
+}
+
+// Đọc "group,số mạng tối đa" của sharing_group_max_networks
+func parseSharingGroupLimit(value string) (string, int, error) {
+	group, limitText, found := strings.Cut(value, ",")
+	if !found {
+		return "", 0, fmt.Errorf("expected group,limit, got %q", value)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitText))
+	if err != nil || limit < 0 {
+		return "", 0, fmt.Errorf("invalid limit %q", limitText)
+	}
+	return strings.TrimSpace(group), limit, nil
+}
+
+// Số mạng client tối đa của user: theo nhóm (gói) nếu có cấu hình, 0 = không giới hạn
+func sharingLimit(user *User) int {
+	if user.Group != "" {
+		if limit, exists := systemConfig().SharingGroupMaxNetworks[user.Group]; exists {
+			return limit
+		}
+	}
+	return systemConfig().SharingMaxNetworks
+}
+
+// Mạng của IP client: các IP cùng prefix (mặc định /32 với IPv4, /64 với IPv6) tính là một
+func clientNetwork(client net.Addr) string {
+	host := client.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	prefix, bits := systemConfig().SharingIPv6Prefix, 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, prefix, bits = ip4, systemConfig().SharingIPv4Prefix, 32
+		if prefix <= 0 {
+			prefix = defaultSharingIPv4Prefix
+		}
+	} else if prefix <= 0 {
+		prefix = defaultSharingIPv6Prefix
+	}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+	return network.String()
+}
+
+func sharingWindow() time.Duration {
+	if systemConfig().SharingWindow <= 0 {
+		return defaultSharingWindow * time.Second
+	}
+	return time.Duration(systemConfig().SharingWindow) * time.Second
+}
+
+func sharingSuspendTime() time.Duration {
+	if systemConfig().SharingSuspendTime <= 0 {
+		return defaultSharingSuspendTime * time.Second
+	}
+	return time.Duration(systemConfig().SharingSuspendTime) * time.Second
+}
+
+// Ghi nhận mạng client của một lần xác thực thành công; trả về lỗi khi kết nối phải bị từ chối
+func checkSharing(user *User, client net.Addr) error {
+	limit := sharingLimit(user)
+	now := time.Now()
+
+	sharingMutex.Lock()
+	state, exists := sharingStates[user.Username]
+	if !exists {
+		if limit == 0 {
+			sharingMutex.Unlock()
+			return nil
+		}
+		state = &sharingState{networks: make(map[string]time.Time)}
+		sharingStates[user.Username] = state
+	}
+	if now.Before(state.suspendedUntil) {
+		sharingMutex.Unlock()
+		return errSharingSuspended
+	}
+	if limit == 0 {
+		sharingMutex.Unlock()
+		return nil
+	}
+
+	window := sharingWindow()
+	for network, lastSeen := range state.networks {
+		if now.Sub(lastSeen) > window {
+			delete(state.networks, network)
+		}
+	}
+	network := clientNetwork(client)
+	_, known := state.networks[network]
+	state.networks[network] = now
+	count := len(state.networks)
+	if count <= limit {
+		sharingMutex.Unlock()
+		return nil
+	}
+
+	alert := now.Sub(state.lastAlert) > window
+	if alert {
+		state.lastAlert = now
+	}
+	action := systemConfig().SharingAction
+	var err error
+	switch {
+	case action == sharingActionThrottle && !known:
+		delete(state.networks, network)
+		err = errSharingLimit
+	case action == sharingActionSuspend:
+		state.suspendedUntil = now.Add(sharingSuspendTime())
+		state.networks = make(map[string]time.Time)
+		err = errSharingSuspended
+	}
+	sharingMutex.Unlock()
+
+	if alert {
+		sendAnomalyAlerts([]AnomalyAlert{{now, user.Username, "client_networks", float64(count), float64(limit)}})
+	}
+	if action == sharingActionSuspend {
+		log.Printf("User %s suspended for %s: connected from %d client networks (limit %d)", user.Username, sharingSuspendTime(), count, limit)
+		notifyOperators("User %s suspended for %s: connected from %d client networks (limit %d)", user.Username, sharingSuspendTime(), count, limit)
+		closeUserTunnels(user.Username, CloseAccountSharing)
+	}
+	return err
+}
+
+// Đóng mọi tunnel đang mở của user
+func closeUserTunnels(username, reason string) {
+	for _, t := range idleTunnels(0) {
+		if t.user != nil && t.user.Username == username {
+			t.close(reason)
+		}
+	}
+}
+
+// Trạng thái chia sẻ của các user đang được theo dõi, sắp theo username; username rỗng = mọi user
+func sharingStatuses(username string) []SharingStatus {
+	now := time.Now()
+	window := sharingWindow()
+
+	sharingMutex.Lock()
+	list := make([]SharingStatus, 0, len(sharingStates))
+	for name, state := range sharingStates {
+		if username != "" && name != username {
+			continue
+		}
+		status := SharingStatus{Username: name, Networks: []string{}}
+		for network, lastSeen := range state.networks {
+			if now.Sub(lastSeen) <= window {
+				status.Networks = append(status.Networks, network)
+			}
+		}
+		if now.Before(state.suspendedUntil) {
+			status.SuspendedUntil = state.suspendedUntil
+		}
+		if len(status.Networks) == 0 && status.SuspendedUntil.IsZero() {
+			continue
+		}
+		sort.Strings(status.Networks)
+		list = append(list, status)
+	}
+	sharingMutex.Unlock()
+
+	usersMutex.RLock()
+	for i := range list {
+		if user, exists := users[list[i].Username]; exists {
+			list[i].Limit = sharingLimit(user)
+		}
+	}
+	usersMutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// Gỡ khóa và xóa các mạng đã ghi nhận của user; false nếu user không được theo dõi
+func resetSharing(username string) bool {
+	sharingMutex.Lock()
+	defer sharingMutex.Unlock()
+
+	if _, exists := sharingStates[username]; !exists {
+		return false
+	}
+	delete(sharingStates, username)
+	return true
+}
+
+// Xóa định kỳ trạng thái của user không còn mạng nào trong cửa sổ và không bị khóa
+func runSharingJanitor() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		window := sharingWindow()
+		sharingMutex.Lock()
+		for username, state := range sharingStates {
+			for network, lastSeen := range state.networks {
+				if now.Sub(lastSeen) > window {
+					delete(state.networks, network)
+				}
+			}
+			if len(state.networks) == 0 && !now.Before(state.suspendedUntil) {
+				delete(sharingStates, username)
+			}
+		}
+		sharingMutex.Unlock()
+	}
+}
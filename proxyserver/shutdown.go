@@ -0,0 +1,79 @@
+package proxyserver
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Thời gian chờ tunnel đang mở kết thúc khi dừng bằng SIGTERM hoặc Ctrl+C; hết thời gian thì các tunnel
+// còn lại bị đóng với lý do server_stop
+const shutdownDrainTimeout = 10 * time.Second
+
+// Dừng server khi nhận SIGTERM hoặc Ctrl+C (kể cả khi đang ở menu). Nhận tín hiệu lần nữa trong lúc dừng
+// thì thoát ngay, không chờ
+func watchShutdownSignal() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down (send it again to exit immediately).", sig)
+		go func() {
+			sig := <-signals
+			log.Printf("Received %v again, exiting without draining.", sig)
+			os.Exit(1)
+		}()
+		shutdownServer()
+		os.Exit(0)
+	}()
+}
+
+// Ngừng nhận kết nối, chờ tunnel đang mở kết thúc để dữ liệu đã dùng được cộng, rồi ghi nốt mọi hệ thống con
+func shutdownServer() {
+	if !drainConnections(shutdownDrainTimeout) {
+		log.Println("Shutdown timeout reached, closing remaining tunnels.")
+		for _, t := range idleTunnels(0) {
+			t.close(CloseServerStop)
+		}
+		drainConnections(time.Second)
+	}
+	drainSubsystems()
+	log.Println("Server stopped.")
+}
+
+// Đóng mọi listener (khi nâng cấp nóng socket vẫn mở trong process mới) và chờ các kết nối đang xử lý
+// kết thúc, tối đa timeout; false khi hết thời gian
+func drainConnections(timeout time.Duration) bool {
+	listenersMutex.Lock()
+	for addr, registered := range activeListeners {
+		registered.listener.Close()
+		delete(activeListeners, addr)
+	}
+	listenersMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Ghi nốt các hệ thống con trước khi process thoát, khi dừng cũng như khi nâng cấp nóng: hàng đợi
+// analytics và event bus, usage journal, last_seen_file và audit log
+func drainSubsystems() {
+	drainAnalytics()
+	drainEventBus()
+	closeUsageJournal()
+	if err := saveLastSeen(); err != nil {
+		log.Printf("Last seen file error: %v", err)
+	}
+	closeAuditLog()
+}
@@ -0,0 +1,117 @@
+// Package socks4 đọc và tạo các thông điệp của giao thức SOCKS4 (chỉ lệnh CONNECT, đích IPv4): yêu cầu
+// của client và reply của server, cùng client tối giản dùng cho lệnh bench và các test
+package socks4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Mã reply SOCKS4
+const (
+	Granted  byte = 0x5A
+	Rejected byte = 0x5B
+)
+
+// Độ dài tối đa của user ID trong yêu cầu
+const MaxUserIDLen = 255
+
+// Trường bị từ chối khi user ID quá dài, là nhãn field của proxy_malformed_requests_total
+const MalformedUserID = "socks4_user_id"
+
+var ErrNotSocks4 = errors.New("not a SOCKS4 connection")
+
+// Yêu cầu bị từ chối: Reply là mã trả lời cho client; Field khác rỗng thì yêu cầu sai định dạng
+type RequestError struct {
+	Reply  byte
+	Field  string
+	Detail string
+}
+
+func (e *RequestError) Error() string {
+	if e.Field == "" {
+		return e.Detail
+	}
+	return e.Field + " " + e.Detail
+}
+
+// Yêu cầu CONNECT: đích ip:port, user ID bị bỏ qua
+type Request struct {
+	Dest   string
+	UserID []byte
+}
+
+// Đọc yêu cầu: VN CD DSTPORT DSTIP USERID NULL. Lệnh khác CONNECT bị từ chối trước khi đọc user ID,
+// user ID dài quá MaxUserIDLen bị từ chối để client không gửi mãi
+func ReadRequest(r io.Reader) (Request, error) {
+	var request Request
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return request, fmt.Errorf("read request: %w", err)
+	}
+	if buf[0] != 0x04 {
+		return request, ErrNotSocks4
+	}
+	if buf[1] != 0x01 {
+		return request, &RequestError{Reply: Rejected, Detail: "unsupported command"}
+	}
+	port := binary.BigEndian.Uint16(buf[2:4])
+	request.Dest = net.JoinHostPort(net.IPv4(buf[4], buf[5], buf[6], buf[7]).String(), strconv.Itoa(int(port)))
+
+	b := make([]byte, 1)
+	for {
+		if len(request.UserID) > MaxUserIDLen {
+			return request, &RequestError{Reply: Rejected, Field: MalformedUserID, Detail: "longer than 255 bytes"}
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return request, fmt.Errorf("read user ID: %w", err)
+		}
+		if b[0] == 0x00 {
+			return request, nil
+		}
+		request.UserID = append(request.UserID, b[0])
+	}
+}
+
+// Tạo reply: VN(0) CD DSTPORT DSTIP, cổng và IP được client bỏ qua với CONNECT
+func Reply(code byte) []byte {
+	return []byte{0x00, code, 0, 0, 0, 0, 0, 0}
+}
+
+// Proxy trả lời CONNECT với mã khác Granted
+type ReplyError struct {
+	Code byte
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("connect failed: reply 0x%02x", e.Code)
+}
+
+// Bắt tay phía client (chỉ IPv4) rồi CONNECT tới target
+func Connect(conn net.Conn, userID string, target *net.TCPAddr) error {
+	ip4 := target.IP.To4()
+	if ip4 == nil {
+		return errors.New("SOCKS4 requires an IPv4 target")
+	}
+	request := []byte{0x04, 0x01}
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	request = append(request, ip4...)
+	request = append(request, userID...)
+	request = append(request, 0x00)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != Granted {
+		return &ReplyError{Code: reply[1]}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package socks4
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+func FuzzReadRequest(f *testing.F) {
+	f.Add([]byte("\x04\x01\x00\x50\x7f\x00\x00\x01user\x00"))
+	f.Add([]byte("\x04\x01\x01\xbb\x0a\x00\x00\x01\x00"))
+	f.Add([]byte("\x04\x02\x00\x50\x7f\x00\x00\x01\x00"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		request, err := ReadRequest(reader)
+		consumed := len(data) - reader.Len()
+		var requestErr *RequestError
+		if errors.As(err, &requestErr) && requestErr.Field == MalformedUserID && consumed > 8+MaxUserIDLen+1 {
+			t.Fatalf("read %d bytes before rejecting a long user ID", consumed)
+		}
+		if err != nil {
+			return
+		}
+		host, port, err := net.SplitHostPort(request.Dest)
+		if err != nil {
+			t.Fatalf("destination %q: %v", request.Dest, err)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			t.Fatalf("destination %q: invalid port", request.Dest)
+		}
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.Is4() {
+			t.Fatalf("destination %q: host is not an IPv4 address", request.Dest)
+		}
+		if len(request.UserID) > MaxUserIDLen || bytes.IndexByte(request.UserID, 0) >= 0 {
+			t.Fatalf("invalid user ID %q", request.UserID)
+		}
+		if consumed != 8+len(request.UserID)+1 {
+			t.Fatalf("consumed %d bytes, want %d", consumed, 8+len(request.UserID)+1)
+		}
+	})
+}
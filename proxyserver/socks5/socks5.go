@@ -0,0 +1,235 @@
+// Package socks5 đọc và tạo các thông điệp của giao thức SOCKS5 (RFC 1928) với xác thực
+// username/password (RFC 1929), chỉ lệnh CONNECT: lời chào, đăng nhập và yêu cầu của client, reply của
+// server, cùng client tối giản dùng cho lệnh bench và các test. Các hàm chỉ đọc và kiểm tra định dạng;
+// xác thực, chuẩn hóa đích, ghi log và metric do server thực hiện
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Mã reply (RFC 1928)
+const (
+	Succeeded           byte = 0x00
+	GeneralFailure      byte = 0x01
+	NotAllowed          byte = 0x02
+	NetworkUnreachable  byte = 0x03
+	HostUnreachable     byte = 0x04
+	ConnectionRefused   byte = 0x05
+	TTLExpired          byte = 0x06
+	CommandNotSupported byte = 0x07
+	AddressNotSupported byte = 0x08
+)
+
+// Trường bị từ chối khi client không đề nghị xác thực username/password, là nhãn field của
+// proxy_malformed_requests_total
+const MalformedMethods = "methods"
+
+var (
+	ErrNotSocks5    = errors.New("not a SOCKS5 connection")
+	ErrAuthRejected = errors.New("authentication rejected")
+)
+
+// Yêu cầu bị từ chối: Reply là mã trả lời cho client; Field khác rỗng thì yêu cầu sai định dạng
+type RequestError struct {
+	Reply  byte
+	Field  string
+	Detail string
+}
+
+func (e *RequestError) Error() string {
+	if e.Field == "" {
+		return e.Detail
+	}
+	return e.Field + " " + e.Detail
+}
+
+// Đọc lời chào: VER NMETHODS METHODS. Server chỉ nhận xác thực username/password, client không
+// đề nghị phương thức này thì không tiếp tục được
+func ReadGreeting(r io.Reader) error {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("read greeting: %w", err)
+	}
+	if buf[0] != 0x05 {
+		return ErrNotSocks5
+	}
+	methods := make([]byte, int(buf[1]))
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return fmt.Errorf("read auth methods: %w", err)
+	}
+	if !bytes.Contains(methods, []byte{0x02}) {
+		return &RequestError{Reply: 0xFF, Field: MalformedMethods, Detail: "without username/password authentication"}
+	}
+	return nil
+}
+
+// Đọc thông tin đăng nhập (RFC 1929): VER ULEN UNAME PLEN PASSWD
+func ReadAuth(r io.Reader) (username, password []byte, err error) {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, fmt.Errorf("read authentication: %w", err)
+	}
+	if buf[0] != 0x01 {
+		return nil, nil, &RequestError{Reply: 0x01, Detail: "unsupported authentication version"}
+	}
+	username = make([]byte, int(buf[1]))
+	if _, err := io.ReadFull(r, username); err != nil {
+		return nil, nil, fmt.Errorf("read username: %w", err)
+	}
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return nil, nil, fmt.Errorf("read password length: %w", err)
+	}
+	password = make([]byte, int(buf[0]))
+	if _, err := io.ReadFull(r, password); err != nil {
+		return nil, nil, fmt.Errorf("read password: %w", err)
+	}
+	return username, password, nil
+}
+
+// Đích của yêu cầu CONNECT: Addr với ATYP IPv4/IPv6, Domain (đúng như client gửi) với ATYP domain
+type Request struct {
+	Addr   netip.Addr
+	Domain string
+	Port   uint16
+}
+
+// Đọc yêu cầu CONNECT: VER CMD RSV ATYP DST.ADDR DST.PORT. allowDomain sai thì ATYP domain bị từ chối
+// (listener bắt client tự phân giải)
+func ReadRequest(r io.Reader, allowDomain bool) (Request, error) {
+	var request Request
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return request, fmt.Errorf("read request: %w", err)
+	}
+	if buf[0] != 0x05 {
+		return request, &RequestError{Reply: GeneralFailure, Detail: "invalid request version"}
+	}
+	if buf[1] != 0x01 {
+		return request, &RequestError{Reply: CommandNotSupported, Detail: "unsupported command"}
+	}
+
+	var addr []byte
+	switch buf[3] {
+	case 0x01: // IPv4
+		addr = make([]byte, 4)
+	case 0x04: // IPv6
+		addr = make([]byte, 16)
+	case 0x03: // Domain name
+		if !allowDomain {
+			return request, &RequestError{Reply: AddressNotSupported, Detail: "domain names are not accepted"}
+		}
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return request, fmt.Errorf("read domain length: %w", err)
+		}
+		if buf[0] == 0 {
+			// Tên miền rỗng sẽ được dial thành địa chỉ cục bộ của server
+			return request, &RequestError{Reply: AddressNotSupported, Detail: "empty domain"}
+		}
+		addr = make([]byte, int(buf[0]))
+	default:
+		return request, &RequestError{Reply: AddressNotSupported, Detail: "unsupported address type"}
+	}
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return request, fmt.Errorf("read address: %w", err)
+	}
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return request, fmt.Errorf("read port: %w", err)
+	}
+	request.Port = binary.BigEndian.Uint16(buf[:2])
+
+	switch buf[3] {
+	case 0x01:
+		request.Addr = netip.AddrFrom4([4]byte(addr))
+	case 0x04:
+		request.Addr = netip.AddrFrom16([16]byte(addr))
+	default:
+		request.Domain = string(addr)
+	}
+	return request, nil
+}
+
+// Tạo reply đầy đủ: VER REP RSV ATYP BND.ADDR BND.PORT. bind rỗng (nil) được trả về là 0.0.0.0:0
+func Reply(code byte, bind net.Addr) []byte {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if tcpAddr, ok := bind.(*net.TCPAddr); ok {
+		ip, port = tcpAddr.IP, tcpAddr.Port
+	}
+
+	reply := []byte{0x05, code, 0x00}
+	if ip4 := ip.To4(); ip4 != nil {
+		reply = append(reply, 0x01)
+		reply = append(reply, ip4...)
+	} else {
+		reply = append(reply, 0x04)
+		reply = append(reply, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(reply, uint16(port))
+}
+
+// Đọc mã reply lỗi (0x01 tới 0x08), viết dạng thập phân hoặc 0x..
+func ParseReplyCode(value string) (byte, error) {
+	code, err := strconv.ParseUint(strings.TrimSpace(value), 0, 8)
+	if err != nil || code < uint64(GeneralFailure) || code > uint64(AddressNotSupported) {
+		return 0, fmt.Errorf("invalid reply code %q", value)
+	}
+	return byte(code), nil
+}
+
+// Proxy trả lời CONNECT với mã khác Succeeded
+type ReplyError struct {
+	Code byte
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("connect failed: reply 0x%02x", e.Code)
+}
+
+// Bắt tay phía client với xác thực username/password rồi CONNECT tới target
+func Connect(conn net.Conn, username, password string, target *net.TCPAddr) error {
+	request := []byte{0x05, 0x01, 0x02, 0x01, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	if ip4 := target.IP.To4(); ip4 != nil {
+		request = append(request, 0x05, 0x01, 0x00, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x05, 0x01, 0x00, 0x04)
+		request = append(request, target.IP.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// Chọn phương thức (2 byte), kết quả xác thực (2 byte), phần đầu reply CONNECT (4 byte)
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply[:4]); err != nil {
+		return err
+	}
+	if reply[1] != 0x02 || reply[3] != 0x00 {
+		return ErrAuthRejected
+	}
+	if _, err := io.ReadFull(conn, reply[4:8]); err != nil {
+		return err
+	}
+	if reply[5] != Succeeded {
+		return &ReplyError{Code: reply[5]}
+	}
+	skip := net.IPv4len + 2
+	if reply[7] == 0x04 {
+		skip = net.IPv6len + 2
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip))
+	return err
+}
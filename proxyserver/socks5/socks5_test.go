@@ -0,0 +1,86 @@
+package socks5
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// Connect phía client và các hàm đọc phía server hiểu nhau, kể cả khi server từ chối
+func TestConnectRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		target *net.TCPAddr
+		reply  byte
+	}{
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 443}, Succeeded},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8080}, Succeeded},
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 25}, NotAllowed},
+	} {
+		client, server := net.Pipe()
+		served := make(chan error, 1)
+		go func() {
+			defer server.Close()
+			r := bufio.NewReader(server)
+			if err := ReadGreeting(r); err != nil {
+				served <- err
+				return
+			}
+			server.Write([]byte{0x05, 0x02})
+			username, password, err := ReadAuth(r)
+			if err != nil || string(username) != "alice" || string(password) != "secret" {
+				served <- errors.New("wrong credentials " + string(username) + ":" + string(password))
+				return
+			}
+			server.Write([]byte{0x01, 0x00})
+			request, err := ReadRequest(r, false)
+			if err != nil {
+				served <- err
+				return
+			}
+			want := test.target.AddrPort()
+			if netip.AddrPortFrom(request.Addr, request.Port) != netip.AddrPortFrom(want.Addr().Unmap(), want.Port()) {
+				served <- errors.New("wrong destination " + request.Addr.String())
+				return
+			}
+			server.Write(Reply(test.reply, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1080}))
+			served <- nil
+		}()
+
+		err := Connect(client, "alice", "secret", test.target)
+		var replyErr *ReplyError
+		switch {
+		case test.reply == Succeeded && err != nil:
+			t.Errorf("%s: %v", test.target, err)
+		case test.reply != Succeeded && (!errors.As(err, &replyErr) || replyErr.Code != test.reply):
+			t.Errorf("%s: got %v, want reply 0x%02x", test.target, err, test.reply)
+		}
+		// Khi bị từ chối, Connect không đọc hết BND.ADDR nên phải đóng trước khi chờ server
+		client.Close()
+		if err := <-served; err != nil {
+			t.Errorf("%s: server: %v", test.target, err)
+		}
+	}
+}
+
+func TestReadRequestRejects(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		data        string
+		allowDomain bool
+		reply       byte
+	}{
+		{"bind command", "\x05\x02\x00\x01\x7f\x00\x00\x01\x00\x50", true, CommandNotSupported},
+		{"domain not accepted", "\x05\x01\x00\x03\x07example\x00\x50", false, AddressNotSupported},
+		{"empty domain", "\x05\x01\x00\x03\x00\x00\x50", true, AddressNotSupported},
+		{"unknown address type", "\x05\x01\x00\x02\x00\x50", true, AddressNotSupported},
+	} {
+		_, err := ReadRequest(strings.NewReader(test.data), test.allowDomain)
+		var requestErr *RequestError
+		if !errors.As(err, &requestErr) || requestErr.Reply != test.reply {
+			t.Errorf("%s: got %v, want reply 0x%02x", test.name, err, test.reply)
+		}
+	}
+}
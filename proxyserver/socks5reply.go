@@ -0,0 +1,71 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"proxy_server/proxyserver/socks5"
+)
+
+// Đọc giá trị socks5_reply: reason,code (ví dụ dial_timeout,0x04)
+func parseSOCKS5Reply(value string) (string, byte, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("expected reason,code, got %q", value)
+	}
+	reason := strings.TrimSpace(parts[0])
+	switch reason {
+	case CloseDialRefused, CloseDialTimeout, CloseDialUnreachable, CloseDialDNS, CloseDialError:
+	default:
+		return "", 0, fmt.Errorf("unknown dial error reason %q", reason)
+	}
+	code, err := socks5.ParseReplyCode(parts[1])
+	if err != nil {
+		return "", 0, err
+	}
+	return reason, code, nil
+}
+
+// Chọn mã reply SOCKS5 cho lỗi kết nối tới đích.
+// Cấu hình socks5_reply (theo loại lỗi trong access log) được ưu tiên trước ánh xạ mặc định
+func socks5ReplyCode(err error) byte {
+	if code, exists := systemConfig().SOCKS5Replies[classifyDialError(err)]; exists {
+		return code
+	}
+
+	// Upstream đã trả lời: dùng lại mã của upstream
+	var replyErr *upstreamReplyError
+	if errors.As(err, &replyErr) {
+		if replyErr.Status == "" {
+			return replyErr.Code
+		}
+		switch replyErr.StatusCode {
+		case http.StatusForbidden, http.StatusProxyAuthRequired:
+			return socks5.NotAllowed
+		case http.StatusBadGateway:
+			return socks5.HostUnreachable
+		case http.StatusGatewayTimeout:
+			return socks5.TTLExpired
+		}
+		return socks5.GeneralFailure
+	}
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return socks5.HostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5.NetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return socks5.HostUnreachable
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5.ConnectionRefused
+	case classifyDialError(err) == CloseDialTimeout:
+		return socks5.TTLExpired
+	}
+	return socks5.GeneralFailure
+}
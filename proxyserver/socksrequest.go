@@ -0,0 +1,50 @@
+package proxyserver
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"proxy_server/proxyserver/socks5"
+)
+
+// Đọc thông điệp bắt tay của client trên một io.Reader bất kỳ để kiểm thử (fuzz) tách khỏi handler.
+// Định dạng SOCKS4/SOCKS5 được đọc trong package socks4 và socks5; ở đây đích được chuẩn hóa. Hàm chỉ
+// đọc: reply, ghi log, metric và xác thực do handler thực hiện
+
+var errHTTPRequestSize = errors.New("HTTP request larger than 64 KiB")
+
+// Đọc yêu cầu CONNECT của SOCKS5, trả về đích host:port dạng chuẩn. allowDomain sai khi listener bắt
+// client tự phân giải (socks_dns reject)
+func readSocks5Request(r io.Reader, allowDomain bool) (string, error) {
+	request, err := socks5.ReadRequest(r, allowDomain)
+	if err != nil {
+		return "", err
+	}
+	if request.Domain == "" {
+		// IPv4-mapped (::ffff:a.b.c.d) được kết nối như đích IPv4
+		return ipDest(request.Addr, request.Port), nil
+	}
+	// IP dạng chữ trong trường domain (kể cả trong ngoặc vuông hoặc có zone ID) được xử lý như đích IP
+	if literal, ok := ipLiteralDest(request.Domain, request.Port); ok {
+		return literal, nil
+	}
+	if !validDomainName(request.Domain) {
+		// Tên không phân giải được: sai ký tự hoặc nhãn quá dài
+		return "", &socks5.RequestError{Reply: socks5.HostUnreachable, Field: malformedDomain, Detail: strconv.Quote(request.Domain)}
+	}
+	return net.JoinHostPort(request.Domain, strconv.Itoa(int(request.Port))), nil
+}
+
+// Đọc request HTTP gửi nhầm tới cổng SOCKS, tối đa maxHTTPRequest byte
+func readHTTPRequest(r io.Reader) (*http.Request, error) {
+	limited := &io.LimitedReader{R: r, N: maxHTTPRequest}
+	request, err := http.ReadRequest(bufio.NewReader(limited))
+	if err != nil && limited.N == 0 {
+		return nil, errHTTPRequestSize
+	}
+	return request, err
+}
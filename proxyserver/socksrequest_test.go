@@ -0,0 +1,174 @@
+package proxyserver
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+
+	"proxy_server/proxyserver/socks5"
+)
+
+// Đích host:port trả về từ parser phải dial được: cổng hợp lệ và host là IP hoặc tên miền hợp lệ
+func checkDest(t *testing.T, dest string, allowDomain bool) {
+	t.Helper()
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		t.Fatalf("destination %q: %v", dest, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		t.Fatalf("destination %q: invalid port", dest)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return
+	}
+	if !allowDomain || !validDomainName(host) {
+		t.Fatalf("destination %q: host is neither an IP nor an accepted domain", dest)
+	}
+}
+
+// Toàn bộ bắt tay SOCKS5 từ phía client: lời chào, đăng nhập rồi yêu cầu CONNECT
+func FuzzSocks5Request(f *testing.F) {
+	f.Add([]byte("\x05\x01\x02\x01\x05user1\x09password1\x05\x01\x00\x01\x7f\x00\x00\x01\x1f\x40"), true)
+	f.Add([]byte("\x05\x02\x00\x02\x01\x01u\x01p\x05\x01\x00\x03\x0bexample.com\x01\xbb"), true)
+	f.Add([]byte("\x05\x01\x02\x01\x01u\x01p\x05\x01\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\x7f\x00\x00\x01\x00\x50"), false)
+	f.Add([]byte("\x05\x01\x02\x01\x01u\x01p\x05\x01\x00\x03\x05[::1]\x00\x50"), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, allowDomain bool) {
+		reader := bytes.NewReader(data)
+		if err := socks5.ReadGreeting(reader); err != nil {
+			return
+		}
+		username, password, err := socks5.ReadAuth(reader)
+		if err != nil {
+			return
+		}
+		if len(username) > 255 || len(password) > 255 {
+			t.Fatalf("credentials longer than their length byte allows: %d, %d", len(username), len(password))
+		}
+		dest, err := readSocks5Request(reader, allowDomain)
+		var requestErr *socks5.RequestError
+		if errors.As(err, &requestErr) {
+			if requestErr.Field != "" && malformedRequests[requestErr.Field] == nil {
+				t.Fatalf("unknown malformed field %q", requestErr.Field)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		checkDest(t, dest, allowDomain)
+	})
+}
+
+// Request HTTP tới cổng SOCKS (trang bảo trì)
+func FuzzHTTPRequest(f *testing.F) {
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	f.Add([]byte("HEAD /status HTTP/1.0\r\n\r\n"))
+	f.Add([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		request, err := readHTTPRequest(reader)
+		consumed := len(data) - reader.Len()
+		if consumed > maxHTTPRequest {
+			t.Fatalf("read %d bytes, limit is %d", consumed, maxHTTPRequest)
+		}
+		if errors.Is(err, errHTTPRequestSize) && len(data) < maxHTTPRequest {
+			t.Fatalf("%d bytes reported as too large", len(data))
+		}
+		if err != nil {
+			return
+		}
+		if request.Method == "" || strings.ContainsAny(request.Method, " \r\n") {
+			t.Fatalf("invalid method %q", request.Method)
+		}
+	})
+}
+
+// Yêu cầu CONNECT của SOCKS5 với một kiểu địa chỉ, cổng 80
+func socks5ConnectRequest(atyp byte, addr []byte) []byte {
+	request := []byte{0x05, 0x01, 0x00, atyp}
+	if atyp == 0x03 {
+		request = append(request, byte(len(addr)))
+	}
+	return append(append(request, addr...), 0x00, 0x50)
+}
+
+// Đích IPv4-mapped và IPv6 có zone ID được chuẩn hóa trước khi kiểm tra ACL, nên quy tắc IPv4 và
+// IPv6 áp dụng cho mọi cách viết của cùng một địa chỉ
+func TestSocks5DestNormalization(t *testing.T) {
+	rules, err := parseACL(strings.NewReader("deny 192.0.2.0/24\ndeny fe80::/10\ndeny 2001:db8::1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRules := aclCurrent.Swap(rules)
+	t.Cleanup(func() { aclCurrent.Store(oldRules) })
+
+	mapped := netip.MustParseAddr("::ffff:192.0.2.1").As16()
+	allowedMapped := netip.MustParseAddr("::ffff:198.51.100.1").As16()
+	ipv6 := netip.MustParseAddr("2001:db8::1").As16()
+	tests := []struct {
+		name    string
+		request []byte
+		dest    string
+		denied  bool
+	}{
+		{"IPv4", socks5ConnectRequest(0x01, []byte{192, 0, 2, 1}), "192.0.2.1:80", true},
+		{"IPv4-mapped IPv6 address", socks5ConnectRequest(0x04, mapped[:]), "192.0.2.1:80", true},
+		{"IPv4-mapped, allowed", socks5ConnectRequest(0x04, allowedMapped[:]), "198.51.100.1:80", false},
+		{"IPv6 address", socks5ConnectRequest(0x04, ipv6[:]), "[2001:db8::1]:80", true},
+		{"IPv4-mapped literal", socks5ConnectRequest(0x03, []byte("::ffff:192.0.2.1")), "192.0.2.1:80", true},
+		{"IPv4-mapped literal, hex", socks5ConnectRequest(0x03, []byte("::FFFF:C000:0201")), "192.0.2.1:80", true},
+		{"IPv4-mapped literal in brackets", socks5ConnectRequest(0x03, []byte("[::ffff:192.0.2.1]")), "192.0.2.1:80", true},
+		{"IPv4-mapped literal with zone", socks5ConnectRequest(0x03, []byte("::ffff:192.0.2.1%eth0")), "192.0.2.1:80", true},
+		{"IPv4-mapped literal, allowed", socks5ConnectRequest(0x03, []byte("::ffff:198.51.100.1")), "198.51.100.1:80", false},
+		{"zoned link-local", socks5ConnectRequest(0x03, []byte("fe80::1%eth0")), "[fe80::1%eth0]:80", true},
+		{"zoned link-local in brackets", socks5ConnectRequest(0x03, []byte("[fe80::1%eth0]")), "[fe80::1%eth0]:80", true},
+		{"zoned global address", socks5ConnectRequest(0x03, []byte("2001:db8::1%2")), "[2001:db8::1%2]:80", true},
+		{"zoned, allowed", socks5ConnectRequest(0x03, []byte("2001:db8::2%eth0")), "[2001:db8::2%eth0]:80", false},
+		{"uppercase IPv6 literal", socks5ConnectRequest(0x03, []byte("2001:DB8:0:0::1")), "[2001:db8::1]:80", true},
+	}
+	for _, test := range tests {
+		dest, err := readSocks5Request(bytes.NewReader(test.request), true)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if dest != test.dest {
+			t.Errorf("%s: destination %q, want %q", test.name, dest, test.dest)
+		}
+		if denied := errors.Is(checkACL(dest), errACLDenied); denied != test.denied {
+			t.Errorf("%s: %s denied %t, want %t", test.name, dest, denied, test.denied)
+		}
+	}
+}
+
+// IP dạng chữ được viết lại như đích IP; tên miền không đổi
+func TestIPLiteralDest(t *testing.T) {
+	tests := []struct {
+		host, dest string
+		ok         bool
+	}{
+		{"192.0.2.1", "192.0.2.1:443", true},
+		{"::ffff:192.0.2.1", "192.0.2.1:443", true},
+		{"[::ffff:192.0.2.1]", "192.0.2.1:443", true},
+		{"::ffff:c000:201%eth0", "192.0.2.1:443", true},
+		{"fe80::1%eth0", "[fe80::1%eth0]:443", true},
+		{"[fe80::1%25eth0]", "[fe80::1%25eth0]:443", true},
+		{"::1", "[::1]:443", true},
+		{"example.com", "", false},
+		{"[example.com]", "", false},
+		{"fe80::1%", "", false},
+		{"192.0.2.1%eth0", "", false},
+	}
+	for _, test := range tests {
+		dest, ok := ipLiteralDest(test.host, 443)
+		if ok != test.ok || dest != test.dest {
+			t.Errorf("ipLiteralDest(%q) = %q, %t, want %q, %t", test.host, dest, ok, test.dest, test.ok)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package proxyserver
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	processStarted = time.Now()
+	connsServed    atomic.Int64 // Số kết nối SOCKS/CONNECT đã xử lý xong, kể cả bị từ chối
+	bytesUpTotal   atomic.Int64 // Tổng byte client gửi tới đích
+	bytesDownTotal atomic.Int64 // Tổng byte đích trả về client
+)
+
+// Trạng thái một listener đang mở
+type ListenerStatus struct {
+	Address     string    `json:"address"`
+	Kind        string    `json:"kind"`
+	Family      string    `json:"family,omitempty"` // ipv4, ipv6 hoặc dual
+	Started     time.Time `json:"started"`
+	Accepted    int64     `json:"accepted"`
+	Connections int64     `json:"connections"`
+	BytesUp     int64     `json:"bytes_up"`
+	BytesDown   int64     `json:"bytes_down"`
+
+	State string               `json:"state"`           // listening hoặc retrying (địa chỉ đang bị chiếm)
+	Retry *ListenerRetryStatus `json:"retry,omitempty"` // Chỉ khi đang thử lại
+}
+
+const (
+	listenerStateListening = "listening"
+	listenerStateRetrying  = "retrying"
+)
+
+// Tiến trình thử lại của listener có địa chỉ đang bị chiếm
+type ListenerRetryStatus struct {
+	Since       time.Time `json:"since"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	NextAttempt time.Time `json:"next_attempt"`
+	GiveUpAt    time.Time `json:"give_up_at"`
+}
+
+// Trạng thái server cho menu và GET /api/status
+type ServerStatus struct {
+	Running           bool               `json:"running"`
+	Users             int                `json:"users"`
+	Profile           string             `json:"profile,omitempty"` // Profile cấu hình đang dùng
+	Build             BuildInfo          `json:"build"`
+	Started           time.Time          `json:"started"`
+	UptimeSeconds     int64              `json:"uptime_seconds"`
+	ConnectionsTotal  int64              `json:"connections_total"`
+	ConnectionsActive int                `json:"connections_active"`
+	TunnelsActive     int                `json:"tunnels_active"`
+	BytesUp           int64              `json:"bytes_up"`
+	BytesDown         int64              `json:"bytes_down"`
+	Listeners         []ListenerStatus   `json:"listeners"`
+	Maintenance       *MaintenanceStatus `json:"maintenance,omitempty"` // nil khi không bảo trì
+}
+
+func currentServerStatus() ServerStatus {
+	usersMutex.RLock()
+	userCount := len(users)
+	usersMutex.RUnlock()
+
+	tunnelsMutex.Lock()
+	tunnelCount := len(tunnels)
+	tunnelsMutex.Unlock()
+
+	return ServerStatus{
+		Running:           len(runningInstances(listenerSocks)) > 0,
+		Users:             userCount,
+		Profile:           configProfile,
+		Build:             currentBuildInfo(),
+		Started:           processStarted,
+		UptimeSeconds:     int64(time.Since(processStarted).Seconds()),
+		ConnectionsTotal:  connsServed.Load(),
+		ConnectionsActive: activeConnCredits(),
+		TunnelsActive:     tunnelCount,
+		BytesUp:           bytesUpTotal.Load(),
+		BytesDown:         bytesDownTotal.Load(),
+		Listeners:         listenerStatuses(),
+		Maintenance:       maintenance.Load(),
+	}
+}
+
+// Các listener đang mở, sắp theo địa chỉ
+func listenerStatuses() []ListenerStatus {
+	listenersMutex.Lock()
+	list := make([]ListenerStatus, 0, len(activeListeners))
+	for addr, registered := range activeListeners {
+		list = append(list, ListenerStatus{
+			Address:     addr,
+			Kind:        registered.kind,
+			Family:      registered.family(),
+			Started:     registered.started,
+			Accepted:    registered.stats.accepted.Load(),
+			Connections: registered.stats.conns.Load(),
+			BytesUp:     registered.stats.bytesUp.Load(),
+			BytesDown:   registered.stats.bytesDown.Load(),
+			State:       listenerStateListening,
+		})
+	}
+	listenersMutex.Unlock()
+
+	list = append(list, retryingListenerStatuses()...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
+
+// In trạng thái server ra console (menu tùy chọn 1)
+func printServerStatus() {
+	status := currentServerStatus()
+	if status.Running {
+		fmt.Println(msg("status.running"))
+	} else {
+		fmt.Println(msg("status.stopped"))
+	}
+	fmt.Println(msg("status.version", status.Build))
+	if status.Profile != "" {
+		fmt.Println(msg("status.profile", status.Profile))
+	}
+	fmt.Println(msg("status.uptime", time.Duration(status.UptimeSeconds)*time.Second, status.Started.Format("2006-01-02 15:04:05")))
+	fmt.Println(msg("status.connections", status.ConnectionsActive, status.TunnelsActive, status.ConnectionsTotal))
+	fmt.Println(msg("status.data", formatBytes(status.BytesUp), formatBytes(status.BytesDown)))
+	fmt.Println(msg("status.users", status.Users))
+	if status.Maintenance != nil {
+		fmt.Println(msg("status.maintenance", status.Maintenance.Since.Format("2006-01-02 15:04:05"), status.Maintenance.Source))
+	}
+	if len(status.Listeners) == 0 {
+		fmt.Println(msg("status.no_listeners"))
+	}
+	for _, listener := range status.Listeners {
+		if listener.Retry != nil {
+			fmt.Println(msg("status.listener_retry", listener.Kind, listener.Address,
+				listener.Retry.Attempts, listener.Retry.GiveUpAt.Format("15:04:05"), listener.Retry.LastError))
+			continue
+		}
+		fmt.Println(msg("status.listener", listener.Kind, listener.Address, listener.Family,
+			listener.Accepted, formatBytes(listener.BytesUp), formatBytes(listener.BytesDown), listener.Started.Format("15:04:05")))
+	}
+}
+
+// Số kết nối tối đa in ra menu
+const maxMenuConnections = 50
+
+// In các tunnel đang mở theo dòng lọc dạng key=value, ví dụ "user=alice sort=bytes"
+func printConnections(line string) {
+	query := url.Values{}
+	for _, field := range strings.Fields(line) {
+		key, value, _ := strings.Cut(field, "=")
+		query.Set(key, value)
+	}
+	filter, sortBy, err := parseTunnelQuery(query)
+	if err != nil {
+		fmt.Println(msg("menu.connections_invalid", err))
+		return
+	}
+	list := tunnelStatuses(filter, sortBy)
+	if len(list) == 0 {
+		fmt.Println(msg("connections.none"))
+		return
+	}
+
+	const row = "%-6v %-14v %-22v %-30v %-22v %10v %10v %8v\n"
+	columns := strings.Split(msg("connections.columns"), "|")
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	fmt.Printf(row, header...)
+	for _, t := range list[:min(len(list), maxMenuConnections)] {
+		fmt.Printf(row, t.ID, t.Username, t.Client, t.Destination, t.Egress,
+			formatBytes(t.BytesUp), formatBytes(t.BytesDown), time.Duration(t.AgeSeconds)*time.Second)
+	}
+	if len(list) > maxMenuConnections {
+		fmt.Println(msg("connections.more", len(list)-maxMenuConnections))
+	}
+}
+
+// Số byte dạng dễ đọc, ví dụ 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,244 @@
+package proxyserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Gửi metric tới statsd hoặc DogStatsD qua UDP: các counter và gauge giống hệt /metrics (counter gửi
+// phần tăng thêm từ lần flush trước), cùng các timer thời gian kết nối tới đích và thời gian tunnel
+const (
+	statsdFlavorStatsd   = "statsd"
+	statsdFlavorDog      = "dogstatsd"
+	defaultStatsdPrefix  = "proxy."
+	defaultStatsdFlush   = 10 // Giây
+	maxStatsdPacket      = 1432
+	maxStatsdTimerPoints = 2000 // Số giá trị timer tối đa mỗi lần flush, phần còn lại được lấy mẫu
+)
+
+// Giá trị timer gom lại giữa hai lần flush
+type statsdTimer struct {
+	values []float64 // Mili giây
+	count  int64     // Tổng số giá trị đã ghi nhận, kể cả giá trị không giữ lại
+}
+
+var (
+	statsdTimers      = make(map[string]*statsdTimer)
+	statsdTimersMutex sync.Mutex
+	statsdStarted     sync.Once
+)
+
+func validStatsdFlavor(flavor string) bool {
+	return flavor == statsdFlavorStatsd || flavor == statsdFlavorDog
+}
+
+func statsdEnabled() bool {
+	return systemConfig().StatsdAddress != ""
+}
+
+// Khởi động việc gửi statsd một lần cho cả process
+func startStatsd() {
+	statsdStarted.Do(func() {
+		go runStatsd()
+	})
+}
+
+// Ghi nhận một giá trị timer, gửi ở lần flush kế tiếp
+func recordStatsdTiming(name string, d time.Duration) {
+	if !statsdEnabled() {
+		return
+	}
+	statsdTimersMutex.Lock()
+	defer statsdTimersMutex.Unlock()
+
+	timer, exists := statsdTimers[name]
+	if !exists {
+		timer = &statsdTimer{}
+		statsdTimers[name] = timer
+	}
+	timer.count++
+	if len(timer.values) < maxStatsdTimerPoints {
+		timer.values = append(timer.values, float64(d.Microseconds())/1000)
+	}
+}
+
+func runStatsd() {
+	previous := make(map[string]float64) // Giá trị counter ở lần flush trước
+	for {
+		interval := systemConfig().StatsdInterval
+		if interval <= 0 {
+			interval = defaultStatsdFlush
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		if !statsdEnabled() {
+			continue
+		}
+		if err := flushStatsd(previous); err != nil {
+			log.Printf("Statsd error: %v", err)
+		}
+	}
+}
+
+// Gửi một lần toàn bộ metric, gộp nhiều dòng vào mỗi gói UDP
+func flushStatsd(previous map[string]float64) error {
+	conn, err := net.Dial("udp", systemConfig().StatsdAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	send := func(line string) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsdPacket {
+			conn.Write(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	var metrics bytes.Buffer
+	writeMetrics(&metrics)
+	for _, line := range statsdMetricLines(metrics.Bytes(), previous) {
+		send(line)
+	}
+
+	statsdTimersMutex.Lock()
+	timers := statsdTimers
+	statsdTimers = make(map[string]*statsdTimer)
+	statsdTimersMutex.Unlock()
+	for name, timer := range timers {
+		suffix := ""
+		if int64(len(timer.values)) < timer.count {
+			suffix = "|@" + strconv.FormatFloat(float64(len(timer.values))/float64(timer.count), 'f', 4, 64)
+		}
+		for _, value := range timer.values {
+			send(statsdLine(name, nil, strconv.FormatFloat(value, 'f', 3, 64), "ms"+suffix))
+		}
+	}
+
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return err
+}
+
+// Chuyển nội dung /metrics thành các dòng statsd: gauge gửi nguyên giá trị, counter gửi phần tăng thêm
+func statsdMetricLines(metrics []byte, previous map[string]float64) []string {
+	types := make(map[string]string)
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(metrics))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			types[name] = kind
+			continue
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		series, valueText, ok := cutLast(line, " ")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(valueText, 64)
+		if err != nil {
+			continue
+		}
+		name, labels := parseSeries(series)
+
+		switch types[name] {
+		case "counter":
+			last := previous[series]
+			previous[series] = value
+			// Series mới tính từ 0; giá trị giảm (bộ đếm bị đặt lại) thì bỏ qua
+			if value <= last {
+				continue
+			}
+			lines = append(lines, statsdLine(strings.TrimSuffix(name, "_total"), labels, strconv.FormatFloat(value-last, 'f', -1, 64), "c"))
+		case "gauge":
+			lines = append(lines, statsdLine(name, labels, strconv.FormatFloat(value, 'f', -1, 64), "g"))
+		}
+	}
+	return lines
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Tách name{k="v",...} thành tên và các cặp label theo thứ tự
+func parseSeries(series string) (string, [][2]string) {
+	name, rest, found := strings.Cut(series, "{")
+	if !found {
+		return series, nil
+	}
+	rest = strings.TrimSuffix(rest, "}")
+	var labels [][2]string
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		unquoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			break
+		}
+		text, _ := strconv.Unquote(unquoted)
+		labels = append(labels, [2]string{key, text})
+		rest = strings.TrimPrefix(value[len(unquoted):], ",")
+	}
+	return name, labels
+}
+
+// Một dòng statsd: DogStatsD gửi label dưới dạng tag, statsd thường nối giá trị label vào tên metric
+func statsdLine(name string, labels [][2]string, value, kind string) string {
+	prefix := systemConfig().StatsdPrefix
+	if prefix == "" {
+		prefix = defaultStatsdPrefix
+	}
+	metric := prefix + strings.TrimPrefix(name, "proxy_")
+
+	var tags []string
+	if systemConfig().StatsdFlavor == statsdFlavorDog {
+		for _, label := range labels {
+			tags = append(tags, label[0]+":"+statsdSanitize(label[1], true))
+		}
+		tags = append(tags, systemConfig().StatsdTags...)
+	} else {
+		for _, label := range labels {
+			metric += "." + statsdSanitize(label[1], false)
+		}
+	}
+	line := fmt.Sprintf("%s:%s|%s", metric, value, kind)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// Bỏ các ký tự có nghĩa riêng trong giao thức statsd; tag giữ được dấu chấm và dấu hai chấm
+func statsdSanitize(value string, tag bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '|' || r == ',' || r == '#' || r == '@' || r == '\n' || r == ' ':
+			return '_'
+		case !tag && (r == '.' || r == ':'):
+			return '_'
+		}
+		return r
+	}, value)
+}
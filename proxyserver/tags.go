@@ -0,0 +1,195 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tag tự do (plan, datacenter, ASN, customer-id...) trên user và IP egress, dạng key=value;key=value.
+// Tag của user được ghi vào access log và gửi cho policy; egress_match chọn IP egress theo tag,
+// metric_tag_labels đưa tag thành label của metric
+const (
+	maxTags        = 16
+	maxTagKeyLen   = 64
+	maxTagValueLen = 128
+)
+
+// Tag dạng chuỗi key=value;... đã sắp xếp, so sánh được (dùng trong khóa cache của policy)
+// và được mã hóa JSON thành object
+type tagString string
+
+func (tags tagString) MarshalJSON() ([]byte, error) {
+	parsed, _ := parseTags(string(tags))
+	if parsed == nil {
+		parsed = map[string]string{}
+	}
+	return json.Marshal(parsed)
+}
+
+var (
+	tagBytes      = make(map[string]int64) // Byte đã truyền theo giá trị các label trong metric_tag_labels
+	tagBytesMutex sync.Mutex
+)
+
+func validTagKey(key string) bool {
+	if key == "" || len(key) > maxTagKeyLen {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Giá trị tag không chứa khoảng trắng và các ký tự phân cách của users.conf, system.conf và access log
+func validTagValue(value string) bool {
+	return value != "" && len(value) <= maxTagValueLen && !strings.ContainsAny(value, ",;=\"' \t\r\n")
+}
+
+// Kiểm tra tag nhận qua API
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("at most %d tags", maxTags)
+	}
+	for key, value := range tags {
+		if !validTagKey(key) {
+			return fmt.Errorf("invalid tag key %q", key)
+		}
+		if !validTagValue(value) {
+			return fmt.Errorf("invalid value for tag %s", key)
+		}
+	}
+	return nil
+}
+
+// Đọc tag dạng key=value;key=value; chuỗi rỗng là không có tag
+func parseTags(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		key, tagValue, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		if _, exists := tags[key]; exists {
+			return nil, fmt.Errorf("duplicate tag %s", key)
+		}
+		tags[key] = tagValue
+	}
+	if err := validateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Ghi tag thành key=value;key=value theo thứ tự key
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, ";")
+}
+
+// Đọc giá trị egress_match và metric_tag_labels: danh sách key tag
+func parseTagKeys(value string) ([]string, error) {
+	keys := parseNameList(value)
+	if len(keys) == 0 {
+		return nil, errors.New("expected a list of tag keys")
+	}
+	for _, key := range keys {
+		if !validTagKey(key) {
+			return nil, fmt.Errorf("invalid tag key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// Bộ lọc IP egress của kết nối: quốc gia yêu cầu và tag của user với các key trong egress_match
+func egressFilters(user *User, country string) map[string]string {
+	var filters map[string]string
+	add := func(key, value string) {
+		if filters == nil {
+			filters = make(map[string]string)
+		}
+		filters[key] = value
+	}
+	if user != nil {
+		for _, key := range systemConfig().EgressMatch {
+			if value := user.Tags[key]; value != "" {
+				add(key, value)
+			}
+		}
+	}
+	if country != "" {
+		add(egressCountryKey, country)
+	}
+	return filters
+}
+
+// Tên label Prometheus của một key tag: ký tự không hợp lệ thành dấu gạch dưới
+func tagLabelName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// Các label theo metric_tag_labels với giá trị lấy từ tags, dạng ,k="v",... để nối sau label khác
+func tagLabels(tags map[string]string) string {
+	var labels strings.Builder
+	for _, key := range systemConfig().MetricTagLabels {
+		fmt.Fprintf(&labels, ",%s=%q", tagLabelName(key), tags[key])
+	}
+	return labels.String()
+}
+
+// Cộng byte của một tunnel vào bộ đếm theo tag của user
+func recordTagBytes(user *User, bytes int64) {
+	if len(systemConfig().MetricTagLabels) == 0 || user == nil || bytes == 0 {
+		return
+	}
+	labels := tagLabels(user.Tags)
+	tagBytesMutex.Lock()
+	tagBytes[labels] += bytes
+	tagBytesMutex.Unlock()
+}
+
+func writeTagMetrics(w io.Writer) {
+	if len(systemConfig().MetricTagLabels) == 0 {
+		return
+	}
+	tagBytesMutex.Lock()
+	series := make([]string, 0, len(tagBytes))
+	for labels, bytes := range tagBytes {
+		series = append(series, fmt.Sprintf("proxy_tag_bytes_total{%s} %d", strings.TrimPrefix(labels, ","), bytes))
+	}
+	tagBytesMutex.Unlock()
+	sort.Strings(series)
+
+	fmt.Fprintln(w, "# HELP proxy_tag_bytes_total Bytes relayed, by the user tags listed in metric_tag_labels.")
+	fmt.Fprintln(w, "# TYPE proxy_tag_bytes_total counter")
+	for _, line := range series {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,137 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tùy chỉnh socket TCP cho kết nối nhận vào (listener) hoặc kết nối ra ngoài (dial)
+type TCPTuning struct {
+	KeepAlive  int  // Chu kỳ keepalive (giây): 0 = mặc định của Go, -1 = tắt
+	Nagle      bool // Bật thuật toán Nagle (tắt TCP_NODELAY)
+	SendBuffer int  // Kích thước buffer gửi (byte), 0 = mặc định hệ thống
+	RecvBuffer int  // Kích thước buffer nhận (byte), 0 = mặc định hệ thống
+	Timeout    int  // Listener: thời gian tối đa cho bắt tay; dial: timeout kết nối (giây)
+	FastOpen   bool // Bật TCP Fast Open (chỉ Linux)
+}
+
+// Tùy chỉnh TCP riêng của một listener ("*" áp dụng cho mọi listener chưa có cấu hình riêng)
+type ListenerTCPConfig struct {
+	Listen string
+	TCPTuning
+}
+
+// Đọc danh sách tùy chọn dạng key=value: keepalive, nodelay, sndbuf, rcvbuf, timeout, fastopen
+func parseTCPTuning(options []string) (TCPTuning, error) {
+	var tuning TCPTuning
+	for _, option := range options {
+		key, value, found := strings.Cut(strings.TrimSpace(option), "=")
+		if !found {
+			return tuning, fmt.Errorf("expected key=value, got %q", option)
+		}
+
+		var err error
+		switch key {
+		case "keepalive":
+			tuning.KeepAlive, err = strconv.Atoi(value)
+		case "nodelay":
+			var noDelay bool
+			noDelay, err = strconv.ParseBool(value)
+			tuning.Nagle = !noDelay
+		case "sndbuf":
+			tuning.SendBuffer, err = strconv.Atoi(value)
+		case "rcvbuf":
+			tuning.RecvBuffer, err = strconv.Atoi(value)
+		case "timeout":
+			tuning.Timeout, err = strconv.Atoi(value)
+		case "fastopen":
+			tuning.FastOpen, err = strconv.ParseBool(value)
+			if err == nil && tuning.FastOpen && !fastOpenSupported {
+				err = errors.New("TCP Fast Open is only supported on Linux")
+			}
+		default:
+			return tuning, fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return tuning, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return tuning, nil
+}
+
+// Đọc giá trị listener_tcp: listen,key=value,...
+func parseListenerTCP(value string) (ListenerTCPConfig, error) {
+	parts := strings.Split(value, ",")
+	tuning, err := parseTCPTuning(parts[1:])
+	listen := strings.TrimSpace(parts[0])
+	if listen != "*" {
+		listen = canonicalListenAddr(listen)
+	}
+	return ListenerTCPConfig{Listen: listen, TCPTuning: tuning}, err
+}
+
+// Tùy chỉnh TCP của listener tại addr
+func listenerTCPTuning(addr string) TCPTuning {
+	var fallback TCPTuning
+	for _, listenerTCP := range systemConfig().ListenerTCP {
+		if listenerTCP.Listen == addr {
+			return listenerTCP.TCPTuning
+		}
+		if listenerTCP.Listen == "*" {
+			fallback = listenerTCP.TCPTuning
+		}
+	}
+	return fallback
+}
+
+// Áp dụng tùy chỉnh lên một kết nối TCP
+func tuneTCPConn(conn net.Conn, tuning TCPTuning) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if tuning.KeepAlive < 0 {
+		tcpConn.SetKeepAlive(false)
+	} else if tuning.KeepAlive > 0 {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(time.Duration(tuning.KeepAlive) * time.Second)
+	}
+	if tuning.Nagle {
+		tcpConn.SetNoDelay(false)
+	}
+	if tuning.SendBuffer > 0 {
+		tcpConn.SetWriteBuffer(tuning.SendBuffer)
+	}
+	if tuning.RecvBuffer > 0 {
+		tcpConn.SetReadBuffer(tuning.RecvBuffer)
+	}
+}
+
+// Listener áp dụng tùy chỉnh TCP và hạn bắt tay cho mỗi kết nối nhận vào
+type tunedListener struct {
+	net.Listener
+	addr string
+}
+
+func tuneListener(listener net.Listener, addr string) net.Listener {
+	return &tunedListener{Listener: listener, addr: addr}
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tuning := listenerTCPTuning(l.addr)
+	tuneTCPConn(conn, tuning)
+	if tuning.Timeout > 0 {
+		// Hạn được xóa khi tunnel đã thiết lập
+		conn.SetDeadline(time.Now().Add(time.Duration(tuning.Timeout) * time.Second))
+	}
+	setHandshakeDeadline(conn, tuning)
+	return conn, nil
+}
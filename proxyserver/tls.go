@@ -0,0 +1,159 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	acmeManager     *autocert.Manager
+	acmeManagerOnce sync.Once
+
+	keyPairs      = make(map[string]*keyPair) // certFile + "\x00" + keyFile -> cặp đã nạp
+	keyPairsMutex sync.Mutex
+)
+
+// Cặp cert/key của một listener, nạp lại được khi xoay vòng secret mà không cần mở lại listener.
+// certFile và keyFile là đường dẫn file hoặc tham chiếu vault:/ssm: tới nội dung PEM
+type keyPair struct {
+	certFile, keyFile string
+	current           atomic.Pointer[tls.Certificate]
+}
+
+func (p *keyPair) load() error {
+	certPEM, err := readSecretFile(p.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := readSecretFile(p.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	p.current.Store(&cert)
+	return nil
+}
+
+func (p *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.current.Load(), nil
+}
+
+// Nạp cặp cert/key, dùng chung giữa các listener cùng cặp file
+func loadKeyPair(certFile, keyFile string) (*keyPair, error) {
+	keyPairsMutex.Lock()
+	defer keyPairsMutex.Unlock()
+
+	id := certFile + "\x00" + keyFile
+	if pair, exists := keyPairs[id]; exists {
+		return pair, nil
+	}
+	pair := &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := pair.load(); err != nil {
+		return nil, err
+	}
+	keyPairs[id] = pair
+	return pair, nil
+}
+
+// Nạp lại mọi cặp cert/key; cặp lỗi giữ chứng chỉ cũ
+func reloadKeyPairs() []error {
+	keyPairsMutex.Lock()
+	defer keyPairsMutex.Unlock()
+
+	var errs []error
+	for _, pair := range keyPairs {
+		if err := pair.load(); err != nil {
+			errs = append(errs, fmt.Errorf("TLS key pair %s: %v", pair.certFile, err))
+		}
+	}
+	return errs
+}
+
+// Các file cert/key trên đĩa đang được dùng, không tính tham chiếu vault:/ssm:
+func keyPairFiles() []string {
+	keyPairsMutex.Lock()
+	defer keyPairsMutex.Unlock()
+
+	var files []string
+	for _, pair := range keyPairs {
+		for _, file := range []string{pair.certFile, pair.keyFile} {
+			if !isSecretReference(file) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// Khởi tạo ACME manager (Let's Encrypt) từ cấu hình, trả về nil nếu chưa cấu hình domain
+func getACMEManager() *autocert.Manager {
+	acmeManagerOnce.Do(func() {
+		if systemConfig().ACMEDomains == "" {
+			return
+		}
+
+		var domains []string
+		for _, domain := range strings.Split(systemConfig().ACMEDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+
+		cacheDir := systemConfig().ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = "acme-cache"
+		}
+
+		acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      systemConfig().ACMEEmail,
+		}
+
+		// Challenge HTTP-01 cần lắng nghe trên cổng 80, TLS-ALPN-01 được xử lý trực tiếp trên listener TLS
+		if systemConfig().ACMEHTTPListen != "" {
+			go func() {
+				log.Printf("ACME HTTP-01 challenge listener started on %s", systemConfig().ACMEHTTPListen)
+				if err := http.ListenAndServe(systemConfig().ACMEHTTPListen, acmeManager.HTTPHandler(nil)); err != nil {
+					log.Printf("ACME HTTP-01 listener error: %v", err)
+				}
+			}()
+		}
+	})
+	return acmeManager
+}
+
+// Cấu hình TLS cho một listener: dùng chứng chỉ ACME nếu useACME, ngược lại nạp cặp cert/key từ file
+// hoặc kho secret
+func listenerTLSConfig(certFile, keyFile string, useACME bool) (*tls.Config, error) {
+	if useACME {
+		manager := getACMEManager()
+		if manager == nil {
+			return nil, errors.New("ACME requested but acme_domains is not configured")
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, nil
+	}
+
+	pair, err := loadKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: pair.getCertificate,
+	}, nil
+}
@@ -0,0 +1,57 @@
+package proxyserver
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"proxy_server/proxyserver/admin"
+)
+
+var (
+	apiTokens      map[string]*admin.Token
+	apiTokensMutex sync.RWMutex
+)
+
+// File token của admin API: admin_tokens_file hoặc tokens.conf
+func adminTokensPath() string {
+	if systemConfig().AdminTokensFile != "" {
+		return systemConfig().AdminTokensFile
+	}
+	return tokenFile
+}
+
+// Load danh sách token từ file (xem admin.ParseTokens)
+func loadAPITokens(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	newTokens, err := admin.ParseTokens(file, resolveSecret)
+	if err != nil {
+		return err
+	}
+
+	apiTokensMutex.Lock()
+	apiTokens = newTokens
+	apiTokensMutex.Unlock()
+
+	log.Printf("Loaded %d admin API tokens.", len(newTokens))
+	return nil
+}
+
+// Tìm token theo giá trị
+func lookupAPIToken(value string) (*admin.Token, bool) {
+	apiTokensMutex.RLock()
+	defer apiTokensMutex.RUnlock()
+
+	token, exists := apiTokens[value]
+	return token, exists
+}
+
+// Kiểm tra token có quyền với user cụ thể không (giới hạn theo reseller)
+func canAccessUser(token *admin.Token, user *User) bool {
+	return token.CanAccessOwner(user.Owner)
+}
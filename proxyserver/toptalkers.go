@@ -0,0 +1,186 @@
+package proxyserver
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bảng top đích theo byte và số kết nối trong cửa sổ trượt, toàn server và theo user.
+// Mỗi phút một bucket; bucket quá top_talkers_window phút bị bỏ
+const (
+	topTalkersBucket        = time.Minute
+	defaultTopTalkersWindow = 60 // Phút
+	maxTopTalkersKeys       = 20000
+	topTalkersOther         = "(other)" // Đích mới khi bucket đã đủ maxTopTalkersKeys
+)
+
+type talkerCount struct {
+	bytes int64
+	conns int64
+}
+
+type userDest struct {
+	username string
+	host     string
+}
+
+type talkerBucket struct {
+	start     time.Time
+	hosts     map[string]*talkerCount
+	userHosts map[userDest]*talkerCount
+}
+
+// Một dòng của báo cáo top đích
+type TopTalker struct {
+	Host        string `json:"host"`
+	Bytes       int64  `json:"bytes"`
+	Connections int64  `json:"connections"`
+	Users       int    `json:"users,omitempty"` // Số user khác nhau đã kết nối tới đích
+}
+
+var (
+	talkerBuckets []*talkerBucket // Cũ nhất trước
+	talkersMutex  sync.Mutex
+)
+
+func topTalkersWindow() time.Duration {
+	minutes := systemConfig().TopTalkersWindow
+	if minutes <= 0 {
+		minutes = defaultTopTalkersWindow
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func addTalker(counts map[string]*talkerCount, host string, bytes int64) {
+	count, exists := counts[host]
+	if !exists {
+		if len(counts) >= maxTopTalkersKeys {
+			host = topTalkersOther
+		}
+		if count = counts[host]; count == nil {
+			count = &talkerCount{}
+			counts[host] = count
+		}
+	}
+	count.bytes += bytes
+	count.conns++
+}
+
+// Ghi nhận một tunnel đã kết thúc
+func recordTalker(username, dest string, bytes int64) {
+	if dest == "" {
+		return
+	}
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		host = dest
+	}
+	now := time.Now()
+
+	talkersMutex.Lock()
+	defer talkersMutex.Unlock()
+
+	var bucket *talkerBucket
+	if n := len(talkerBuckets); n > 0 && now.Sub(talkerBuckets[n-1].start) < topTalkersBucket {
+		bucket = talkerBuckets[n-1]
+	} else {
+		bucket = &talkerBucket{
+			start:     now.Truncate(topTalkersBucket),
+			hosts:     make(map[string]*talkerCount),
+			userHosts: make(map[userDest]*talkerCount),
+		}
+		talkerBuckets = append(talkerBuckets, bucket)
+		expireTalkerBuckets(now)
+	}
+
+	addTalker(bucket.hosts, host, bytes)
+	if username != "" {
+		key := userDest{username, host}
+		count, exists := bucket.userHosts[key]
+		if !exists {
+			if len(bucket.userHosts) >= maxTopTalkersKeys {
+				key.host = topTalkersOther
+			}
+			if count = bucket.userHosts[key]; count == nil {
+				count = &talkerCount{}
+				bucket.userHosts[key] = count
+			}
+		}
+		count.bytes += bytes
+		count.conns++
+	}
+}
+
+// Bỏ các bucket ngoài cửa sổ; gọi khi giữ talkersMutex
+func expireTalkerBuckets(now time.Time) {
+	window := topTalkersWindow()
+	drop := 0
+	for drop < len(talkerBuckets) && now.Sub(talkerBuckets[drop].start) >= window+topTalkersBucket {
+		drop++
+	}
+	talkerBuckets = talkerBuckets[drop:]
+}
+
+// Top đích trong since gần nhất, sắp theo "bytes" (mặc định) hoặc "connections".
+// include nil = toàn server; khác nil thì chỉ cộng tunnel của các user được include chấp nhận
+func topTalkers(since time.Duration, sortBy string, limit int, include func(username string) bool) []TopTalker {
+	now := time.Now()
+	totals := make(map[string]*TopTalker)
+	users := make(map[string]map[string]bool)
+	add := func(host string, count *talkerCount) {
+		entry, exists := totals[host]
+		if !exists {
+			entry = &TopTalker{Host: host}
+			totals[host] = entry
+		}
+		entry.Bytes += count.bytes
+		entry.Connections += count.conns
+	}
+
+	talkersMutex.Lock()
+	expireTalkerBuckets(now)
+	for _, bucket := range talkerBuckets {
+		if now.Sub(bucket.start) >= since+topTalkersBucket {
+			continue
+		}
+		if include == nil {
+			for host, count := range bucket.hosts {
+				add(host, count)
+			}
+		}
+		for key, count := range bucket.userHosts {
+			if include != nil {
+				if !include(key.username) {
+					continue
+				}
+				add(key.host, count)
+			}
+			if users[key.host] == nil {
+				users[key.host] = make(map[string]bool)
+			}
+			users[key.host][key.username] = true
+		}
+	}
+	talkersMutex.Unlock()
+
+	list := make([]TopTalker, 0, len(totals))
+	for host, entry := range totals {
+		entry.Users = len(users[host])
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if sortBy == "connections" && list[i].Connections != list[j].Connections {
+			return list[i].Connections > list[j].Connections
+		}
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Host < list[j].Host
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
@@ -0,0 +1,342 @@
+package proxyserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Một tunnel đang truyền dữ liệu
+type activeTunnel struct {
+	id         uint64
+	user       *User
+	info       ConnInfo // Bản sao thông tin kết nối lúc bắt đầu truyền dữ liệu
+	client     net.Conn
+	target     net.Conn
+	started    time.Time
+	lastActive atomic.Int64 // Thời điểm có dữ liệu gần nhất (UnixNano)
+	up, down   atomic.Int64 // Số byte đã truyền theo mỗi chiều
+	metered    bool         // Tính vào max_data của user (có user, đích không unmetered)
+	usage      atomic.Int64 // Phần đã cộng vào dữ liệu đang truyền của user
+
+	closeOnce sync.Once
+	reason    atomic.Value // Lý do khi tunnel bị server chủ động đóng
+}
+
+var (
+	tunnels      = make(map[uint64]*activeTunnel) // Theo ID, dùng cho GET/DELETE /api/connections
+	tunnelsMutex sync.Mutex
+	lastTunnelID atomic.Uint64
+)
+
+// Một tunnel trong GET /api/connections và menu console
+type TunnelStatus struct {
+	ID          uint64    `json:"id"`
+	Username    string    `json:"username,omitempty"`
+	Protocol    string    `json:"protocol"`
+	Listener    string    `json:"listener"`
+	Client      string    `json:"client"`
+	Destination string    `json:"destination"`
+	Egress      string    `json:"egress"` // Địa chỉ nguồn của kết nối ra ngoài
+	Session     string    `json:"session,omitempty"`
+	Started     time.Time `json:"started"`
+	AgeSeconds  int64     `json:"age_seconds"`
+	IdleSeconds int64     `json:"idle_seconds"`
+	BytesUp     int64     `json:"bytes_up"`
+	BytesDown   int64     `json:"bytes_down"`
+}
+
+// Điều kiện lọc tunnel; trường rỗng không lọc
+type tunnelFilter struct {
+	Username    string
+	Client      netip.Prefix // IP hoặc dải IP client
+	Destination string       // Chuỗi con của host:port đích
+	Listener    string
+	MinAge      time.Duration
+	Include     func(user *User) bool // Giới hạn theo token (reseller), gọi khi giữ usersMutex
+}
+
+// Bộ lọc và thứ tự từ tham số user, client, dest, listener, min_age và sort (query của API hoặc dòng lọc trên menu)
+func parseTunnelQuery(query url.Values) (filter tunnelFilter, sortBy string, err error) {
+	filter = tunnelFilter{Username: query.Get("user"), Destination: query.Get("dest"), Listener: query.Get("listener")}
+	if value := query.Get("client"); value != "" {
+		if filter.Client, err = parseClientPrefix(value); err != nil {
+			return filter, "", errors.New("invalid client, expected an IP or CIDR")
+		}
+	}
+	if value := query.Get("min_age"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return filter, "", errors.New("invalid min_age")
+		}
+		filter.MinAge = time.Duration(seconds) * time.Second
+	}
+	sortBy = query.Get("sort")
+	if sortBy != "" && sortBy != "age" && sortBy != "bytes" && sortBy != "idle" {
+		return filter, "", errors.New("invalid sort, expected age, bytes or idle")
+	}
+	return filter, sortBy, nil
+}
+
+// IP hoặc CIDR của client trong bộ lọc
+func parseClientPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return prefix, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96), nil
+		}
+		return prefix.Masked(), nil
+	}
+	addr, ok := parseHostIP(value)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address: %s", value)
+	}
+	addr = addr.WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func registerTunnel(user *User, info *ConnInfo, client, target net.Conn) *activeTunnel {
+	t := &activeTunnel{id: lastTunnelID.Add(1), user: user, info: *info, client: client, target: target, started: time.Now()}
+	t.lastActive.Store(t.started.UnixNano())
+
+	t.metered = user != nil && !info.Unmetered
+
+	tunnelsMutex.Lock()
+	tunnels[t.id] = t
+	tunnelsMutex.Unlock()
+	return t
+}
+
+func unregisterTunnel(t *activeTunnel) {
+	tunnelsMutex.Lock()
+	delete(tunnels, t.id)
+	tunnelsMutex.Unlock()
+	// Phần đang truyền được finishConn cộng vào dữ liệu đã dùng
+	if t.metered {
+		t.user.state.inflight.Add(-t.usage.Load())
+	}
+}
+
+// Đánh dấu tunnel vừa có dữ liệu
+func (t *activeTunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// Cộng n byte vừa truyền (âm khi bù lại) vào dữ liệu đang truyền của user để max_data được áp dụng
+// trong lúc truyền, không phải chờ tunnel kết thúc
+func (t *activeTunnel) addUsage(n int64) {
+	if !t.metered || n == 0 {
+		return
+	}
+	t.usage.Add(n)
+	t.user.state.inflight.Add(n)
+}
+
+// Số byte tunnel còn được truyền theo max_data của user; limited sai khi không giới hạn
+func (t *activeTunnel) quotaRemaining() (remaining int64, limited bool) {
+	if !t.metered {
+		return 0, false
+	}
+	return t.user.quotaRemaining()
+}
+
+// Chủ động đóng tunnel với lý do được ghi vào access log
+func (t *activeTunnel) close(reason string) {
+	t.closeOnce.Do(func() {
+		t.reason.Store(reason)
+		t.client.Close()
+		t.target.Close()
+	})
+}
+
+// Đóng chiều gửi của kết nối (kể cả qua các lớp bọc), trả về false nếu kết nối không hỗ trợ half-close
+func closeWrite(conn net.Conn) bool {
+	for {
+		switch c := conn.(type) {
+		case interface{ CloseWrite() error }:
+			return c.CloseWrite() == nil
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return false
+		}
+	}
+}
+
+// Lý do đóng do server, rỗng nếu tunnel tự kết thúc
+func (t *activeTunnel) closedReason() string {
+	reason, _ := t.reason.Load().(string)
+	return reason
+}
+
+// Các tunnel không có dữ liệu ít nhất minIdle, tunnel im lặng lâu nhất đứng trước
+func idleTunnels(minIdle time.Duration) []*activeTunnel {
+	cutoff := time.Now().Add(-minIdle).UnixNano()
+
+	tunnelsMutex.Lock()
+	var idle []*activeTunnel
+	for _, t := range tunnels {
+		if t.lastActive.Load() <= cutoff {
+			idle = append(idle, t)
+		}
+	}
+	tunnelsMutex.Unlock()
+
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].lastActive.Load() < idle[j].lastActive.Load()
+	})
+	return idle
+}
+
+// Đóng định kỳ các tunnel mở lâu hơn max_tunnel_lifetime để client kết nối lại (và đổi IP egress)
+func runTunnelLifetimeSweeper() {
+	for {
+		time.Sleep(time.Second)
+		if systemConfig().MaxTunnelLifetime <= 0 {
+			continue
+		}
+		cutoff := time.Now().Add(-time.Duration(systemConfig().MaxTunnelLifetime) * time.Second)
+
+		tunnelsMutex.Lock()
+		var expired []*activeTunnel
+		for _, t := range tunnels {
+			if t.started.Before(cutoff) {
+				expired = append(expired, t)
+			}
+		}
+		tunnelsMutex.Unlock()
+
+		for _, t := range expired {
+			t.close(CloseMaxLifetime)
+		}
+	}
+}
+
+func (t *activeTunnel) status(now time.Time) TunnelStatus {
+	status := TunnelStatus{
+		ID:          t.id,
+		Protocol:    t.info.Protocol,
+		Listener:    t.info.Listener,
+		Destination: t.info.Dest,
+		Session:     t.info.Session,
+		Started:     t.started,
+		AgeSeconds:  int64(now.Sub(t.started) / time.Second),
+		IdleSeconds: int64(now.Sub(time.Unix(0, t.lastActive.Load())) / time.Second),
+		BytesUp:     t.up.Load(),
+		BytesDown:   t.down.Load(),
+	}
+	if t.user != nil {
+		status.Username = t.user.Username
+	}
+	if t.info.Client != nil {
+		status.Client = t.info.Client.String()
+	}
+	if addr := t.target.LocalAddr(); addr != nil {
+		status.Egress = addr.String()
+	}
+	return status
+}
+
+func (t *activeTunnel) matches(filter tunnelFilter, now time.Time) bool {
+	if filter.Username != "" && (t.user == nil || t.user.Username != filter.Username) {
+		return false
+	}
+	if filter.Listener != "" && t.info.Listener != filter.Listener {
+		return false
+	}
+	if filter.Destination != "" && !strings.Contains(t.info.Dest, filter.Destination) {
+		return false
+	}
+	if filter.MinAge > 0 && now.Sub(t.started) < filter.MinAge {
+		return false
+	}
+	if filter.Client.IsValid() {
+		if t.info.Client == nil {
+			return false
+		}
+		host := t.info.Client.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		ip, ok := parseHostIP(host)
+		if !ok || !filter.Client.Contains(ip.WithZone("")) {
+			return false
+		}
+	}
+	return true
+}
+
+// Các tunnel khớp điều kiện lọc, sắp theo sortBy: age (lâu nhất trước, mặc định), bytes (nhiều nhất trước)
+// hoặc idle (im lặng lâu nhất trước)
+func tunnelStatuses(filter tunnelFilter, sortBy string) []TunnelStatus {
+	now := time.Now()
+	tunnelsMutex.Lock()
+	matched := make([]*activeTunnel, 0, len(tunnels))
+	for _, t := range tunnels {
+		if t.matches(filter, now) {
+			matched = append(matched, t)
+		}
+	}
+	tunnelsMutex.Unlock()
+
+	if filter.Include != nil {
+		usersMutex.RLock()
+		visible := matched[:0]
+		for _, t := range matched {
+			if t.user == nil {
+				continue
+			}
+			if user, exists := users[t.user.Username]; exists && filter.Include(user) {
+				visible = append(visible, t)
+			}
+		}
+		usersMutex.RUnlock()
+		matched = visible
+	}
+
+	list := make([]TunnelStatus, len(matched))
+	for i, t := range matched {
+		list[i] = t.status(now)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		switch sortBy {
+		case "bytes":
+			return list[i].BytesUp+list[i].BytesDown > list[j].BytesUp+list[j].BytesDown
+		case "idle":
+			return list[i].IdleSeconds > list[j].IdleSeconds
+		}
+		return list[i].Started.Before(list[j].Started)
+	})
+	return list
+}
+
+// Số byte các tunnel đang mở đã truyền theo user, chưa cộng vào dữ liệu đã dùng; bỏ qua tunnel unmetered
+func openTunnelBytes() map[string]int64 {
+	tunnelsMutex.Lock()
+	defer tunnelsMutex.Unlock()
+	bytes := make(map[string]int64)
+	for _, t := range tunnels {
+		if t.user != nil && !t.info.Unmetered {
+			bytes[t.user.Username] += t.up.Load() + t.down.Load()
+		}
+	}
+	return bytes
+}
+
+// Tunnel đang mở theo ID
+func findTunnel(id uint64) *activeTunnel {
+	tunnelsMutex.Lock()
+	defer tunnelsMutex.Unlock()
+	return tunnels[id]
+}
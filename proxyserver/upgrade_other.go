@@ -0,0 +1,11 @@
+//go:build !unix
+
+package proxyserver
+
+import "errors"
+
+func watchUpgradeSignal() {}
+
+func performUpgrade() error {
+	return errors.New("hitless upgrade is not supported on this platform")
+}
@@ -0,0 +1,202 @@
+//go:build unix
+
+package proxyserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Thời gian chờ mặc định để các kết nối cũ kết thúc sau khi nâng cấp
+const defaultUpgradeDrainTimeout = 5 * time.Minute
+
+// Thời gian tối đa chờ process mới khởi động xong các dịch vụ
+const upgradeReadyTimeout = 2 * time.Minute
+
+// Đang có một lần nâng cấp; được bỏ khi process mới không khởi động được để có thể thử lại
+var upgradeInProgress atomic.Bool
+
+// Nâng cấp nóng khi nhận tín hiệu SIGUSR2
+func watchUpgradeSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			if err := performUpgrade(); err != nil {
+				log.Printf("Upgrade failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Khởi chạy binary mới với các listener hiện tại và chờ nó báo đã khởi động xong, sau đó ngừng nhận
+// kết nối, chờ các kết nối cũ kết thúc, chuyển bộ đếm cho process mới và thoát. Process mới lỗi thì
+// process hiện tại tiếp tục phục vụ như chưa nâng cấp
+func performUpgrade() error {
+	if !upgradeInProgress.CompareAndSwap(false, true) {
+		return errors.New("upgrade already in progress")
+	}
+	if err := startUpgradedProcess(); err != nil {
+		upgradeInProgress.Store(false)
+		return err
+	}
+	return nil
+}
+
+func startUpgradedProcess() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	listenersMutex.Lock()
+	var addrs, networks []string
+	var files []*os.File
+	for addr, registered := range activeListeners {
+		tcpListener, ok := registered.listener.(*net.TCPListener)
+		if !ok {
+			continue
+		}
+		file, err := tcpListener.File()
+		if err != nil {
+			listenersMutex.Unlock()
+			return err
+		}
+		addrs = append(addrs, registered.kind+"@"+addr)
+		if registered.network != "tcp" {
+			networks = append(networks, registered.network+"@"+addr)
+		}
+		files = append(files, file)
+	}
+	listenersMutex.Unlock()
+
+	// Kênh hai chiều với process mới: process mới báo đã sẵn sàng, process cũ gửi bộ đếm sau khi drain
+	stateConn, childState, err := upgradeStateSocket()
+	if err != nil {
+		for _, file := range files {
+			file.Close()
+		}
+		return err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, childState)
+	cmd.Env = append(os.Environ(),
+		inheritListenersEnv+"="+strings.Join(addrs, ";"),
+		inheritNetworksEnv+"="+strings.Join(networks, ";"),
+		inheritStateFDEnv+"="+strconv.Itoa(3+len(files)),
+	)
+
+	err = cmd.Start()
+	childState.Close()
+	for _, file := range files {
+		file.Close()
+	}
+	if err != nil {
+		stateConn.Close()
+		return err
+	}
+	log.Printf("Started upgraded process %d with %d listeners, waiting for it to be ready.", cmd.Process.Pid, len(addrs))
+
+	if err := waitUpgradeReady(cmd, stateConn); err != nil {
+		stateConn.Close()
+		log.Printf("Upgrade aborted, this process keeps serving: %v", err)
+		return err
+	}
+	log.Printf("Upgraded process %d is ready.", cmd.Process.Pid)
+
+	go drainAndExit(stateConn)
+	return nil
+}
+
+// Cặp socket Unix: đầu của process hiện tại và file truyền cho process mới, cả hai không bị các lệnh
+// khác chạy cùng lúc kế thừa
+func upgradeStateSocket() (net.Conn, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file := os.NewFile(uintptr(fds[0]), "upgrade-state")
+	conn, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return conn, os.NewFile(uintptr(fds[1]), "upgrade-state"), nil
+}
+
+// Chờ process mới báo đã khởi động xong các dịch vụ; lỗi khi nó thoát, gửi sai nội dung hoặc không báo
+// trong upgradeReadyTimeout (khi đó process mới bị dừng)
+func waitUpgradeReady(cmd *exec.Cmd, stateConn net.Conn) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		stateConn.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+		message, err := bufio.NewReader(stateConn).ReadString('\n')
+		if err == nil && message != upgradeReadyMessage {
+			err = fmt.Errorf("unexpected message %q", message)
+		}
+		ready <- err
+	}()
+
+	select {
+	case err := <-exited:
+		return fmt.Errorf("new process exited during startup: %v", err)
+	case err := <-ready:
+		if err == nil {
+			stateConn.SetReadDeadline(time.Time{})
+			return nil
+		}
+		cmd.Process.Kill()
+		return fmt.Errorf("new process did not report ready: %v", err)
+	}
+}
+
+func drainAndExit(stateConn net.Conn) {
+	timeout := time.Duration(systemConfig().UpgradeDrainTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultUpgradeDrainTimeout
+	}
+	// Ngừng nhận kết nối mới, socket vẫn mở trong process mới
+	if drainConnections(timeout) {
+		log.Println("All connections drained.")
+	} else {
+		log.Println("Drain timeout reached, closing remaining connections.")
+	}
+
+	// Trạng thái gửi cho process mới đóng usage journal, các hệ thống con còn lại được ghi nốt sau đó
+	if err := json.NewEncoder(stateConn).Encode(currentUpgradeState()); err != nil {
+		log.Printf("Cannot send upgrade state: %v", err)
+	}
+	stateConn.Close()
+	drainSubsystems()
+
+	log.Println("Old process exiting after upgrade.")
+	os.Exit(0)
+}
@@ -0,0 +1,523 @@
+package proxyserver
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Đích mặc định dùng để kiểm tra upstream còn hoạt động
+const defaultUpstreamCheckTarget = "1.1.1.1:443"
+
+// Chu kỳ kiểm tra (giây) và số lần lỗi liên tiếp mặc định trước khi loại upstream
+const (
+	defaultUpstreamCheckInterval   = 60
+	defaultUpstreamRefreshInterval = 300
+	defaultUpstreamMaxFailures     = 3
+)
+
+var errNoUpstream = errors.New("no healthy upstream proxy")
+
+// Upstream từ chối kết nối, Code là mã reply SOCKS5 (hoặc 0x01 với HTTP CONNECT)
+type upstreamReplyError struct {
+	Upstream   string
+	Code       byte
+	StatusCode int // Mã trạng thái HTTP CONNECT
+	Status     string
+}
+
+func (e *upstreamReplyError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("upstream %s: CONNECT failed: %s", e.Upstream, e.Status)
+	}
+	return fmt.Sprintf("upstream %s: SOCKS5 reply 0x%02x", e.Upstream, e.Code)
+}
+
+// Một proxy upstream trong pool
+type upstreamProxy struct {
+	url       *url.URL
+	country   string // Mã quốc gia viết thường của upstream, rỗng khi không gắn
+	healthy   bool
+	failures  int // Số lần lỗi liên tiếp
+	lastCheck time.Time
+	lastError string
+}
+
+// Trạng thái trả về qua API
+type UpstreamStatus struct {
+	Upstream  string    `json:"upstream"`
+	Country   string    `json:"country,omitempty"`
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"consecutive_failures"`
+	LastCheck time.Time `json:"last_check,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+var (
+	upstreamPool      []*upstreamProxy
+	upstreamNext      int
+	upstreamPoolMutex sync.Mutex
+)
+
+// Đọc một dòng danh sách upstream: socks5://[user:pass@]host:port, http://[user:pass@]host:port
+// hoặc host:port (SOCKS5)
+func parseUpstream(line string) (*url.URL, error) {
+	if !strings.Contains(line, "://") {
+		line = "socks5://" + line
+	}
+	upstream, err := url.Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	if upstream.Scheme != "socks5" && upstream.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported upstream scheme %q", upstream.Scheme)
+	}
+	if upstream.Port() == "" {
+		return nil, fmt.Errorf("upstream %s: missing port", upstream.Host)
+	}
+	return upstream, nil
+}
+
+// Một dòng của danh sách upstream
+type upstreamSpec struct {
+	url     *url.URL
+	country string
+}
+
+// Đọc danh sách upstream, mỗi dòng một proxy và có thể thêm mã quốc gia sau khoảng trắng;
+// dòng trống và dòng bắt đầu bằng # được bỏ qua
+func parseUpstreamList(r io.Reader) ([]upstreamSpec, error) {
+	var upstreams []upstreamSpec
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("expected upstream [country], got %q", line)
+		}
+		upstream, err := parseUpstream(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		spec := upstreamSpec{url: upstream}
+		if len(fields) == 2 {
+			spec.country = strings.ToLower(fields[1])
+			if !validCountryCode(spec.country) {
+				return nil, fmt.Errorf("upstream %s: invalid country code %q", upstream.Redacted(), fields[1])
+			}
+		}
+		upstreams = append(upstreams, spec)
+	}
+	return upstreams, scanner.Err()
+}
+
+// Tải danh sách upstream từ upstream_file và upstream_url của config
+func fetchUpstreams(config *SystemConfig) ([]upstreamSpec, error) {
+	var upstreams []upstreamSpec
+
+	if config.UpstreamFile != "" {
+		file, err := os.Open(config.UpstreamFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		fromFile, err := parseUpstreamList(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", config.UpstreamFile, err)
+		}
+		upstreams = append(upstreams, fromFile...)
+	}
+
+	if config.UpstreamURL != "" {
+		client := http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(config.UpstreamURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: unexpected status %s", config.UpstreamURL, resp.Status)
+		}
+		fromURL, err := parseUpstreamList(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", config.UpstreamURL, err)
+		}
+		upstreams = append(upstreams, fromURL...)
+	}
+	return upstreams, nil
+}
+
+// Tải lại pool upstream theo cấu hình đang chạy
+func reloadUpstreams() error {
+	upstreams, err := fetchUpstreams(systemConfig())
+	if err != nil {
+		return err
+	}
+	installUpstreams(upstreams)
+	return nil
+}
+
+// Thay pool upstream, giữ trạng thái của các upstream vẫn còn trong danh sách
+func installUpstreams(upstreams []upstreamSpec) {
+	upstreamPoolMutex.Lock()
+	defer upstreamPoolMutex.Unlock()
+
+	existing := make(map[string]*upstreamProxy, len(upstreamPool))
+	for _, upstream := range upstreamPool {
+		existing[upstream.url.String()] = upstream
+	}
+	pool := make([]*upstreamProxy, 0, len(upstreams))
+	for _, spec := range upstreams {
+		if upstream, exists := existing[spec.url.String()]; exists {
+			upstream.country = spec.country
+			pool = append(pool, upstream)
+			continue
+		}
+		pool = append(pool, &upstreamProxy{url: spec.url, country: spec.country, healthy: true})
+	}
+	upstreamPool = pool
+	upstreamNext = 0
+}
+
+// Có đang chuyển tiếp qua upstream hay không
+func upstreamsEnabled() bool {
+	return systemConfig().UpstreamFile != "" || systemConfig().UpstreamURL != ""
+}
+
+// Chọn upstream khỏe tiếp theo (xoay vòng theo từng kết nối), bỏ qua các upstream trong exclude.
+// country khác rỗng thì chỉ chọn các upstream của quốc gia đó
+func pickUpstream(exclude map[*upstreamProxy]bool, country string) *upstreamProxy {
+	upstreamPoolMutex.Lock()
+	defer upstreamPoolMutex.Unlock()
+
+	for i := 0; i < len(upstreamPool); i++ {
+		upstream := upstreamPool[(upstreamNext+i)%len(upstreamPool)]
+		if upstream.healthy && !exclude[upstream] && (country == "" || upstream.country == country) {
+			upstreamNext = (upstreamNext + i + 1) % len(upstreamPool)
+			return upstream
+		}
+	}
+	return nil
+}
+
+// Có upstream nào trong pool được gắn quốc gia này không
+func upstreamCountryExists(country string) bool {
+	upstreamPoolMutex.Lock()
+	defer upstreamPoolMutex.Unlock()
+	for _, upstream := range upstreamPool {
+		if upstream.country == country {
+			return true
+		}
+	}
+	return false
+}
+
+// Ghi nhận kết quả dùng upstream; lỗi liên tiếp vượt ngưỡng thì loại upstream khỏi vòng xoay.
+// Lỗi do đích từ chối (upstream vẫn trả lời) không tính là upstream hỏng
+func (upstream *upstreamProxy) record(err error) {
+	var replyErr *upstreamReplyError
+	if errors.As(err, &replyErr) {
+		err = nil
+	}
+
+	maxFailures := systemConfig().UpstreamMaxFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultUpstreamMaxFailures
+	}
+
+	upstreamPoolMutex.Lock()
+	defer upstreamPoolMutex.Unlock()
+
+	name := upstream.url.Redacted()
+	if err == nil {
+		if !upstream.healthy {
+			log.Printf("Upstream %s is back in rotation", name)
+			notifyOperators("Upstream %s is back in rotation", name)
+		}
+		upstream.healthy = true
+		upstream.failures = 0
+		upstream.lastError = ""
+		return
+	}
+	upstream.failures++
+	upstream.lastError = err.Error()
+	if upstream.healthy && upstream.failures >= maxFailures {
+		upstream.healthy = false
+		log.Printf("Upstream %s retired after %d failures: %v", name, upstream.failures, err)
+		notifyOperators("Upstream %s retired after %d failures: %v", name, upstream.failures, err)
+	}
+}
+
+// Kết nối tới đích qua một upstream đang khỏe chưa thử. Quốc gia trong bộ lọc chọn upstream,
+// các tag còn lại chọn IP egress dùng để tới upstream
+func dialViaUpstream(dialer net.Dialer, destAddr, policy string, tried *dialTried) (net.Conn, error) {
+	policy, filters := splitEgressFilters(policy)
+	country := filters[egressCountryKey]
+	delete(filters, egressCountryKey)
+	policy = joinEgressFilters(policy, filters)
+	upstream := pickUpstream(tried.upstreams, country)
+	if upstream == nil {
+		if len(tried.upstreams) > 0 {
+			return nil, errNoAlternative
+		}
+		if country != "" {
+			return nil, fmt.Errorf("no healthy upstream proxy in country %s: %w", country, syscall.ENETUNREACH)
+		}
+		return nil, errNoUpstream
+	}
+	tried.upstreams[upstream] = true
+	conn, err := dialUpstream(upstream.url, dialer, destAddr, policy)
+	upstream.record(err)
+	return conn, err
+}
+
+// Mở tunnel tới destAddr qua upstream (SOCKS5 hoặc HTTP CONNECT)
+func dialUpstream(upstream *url.URL, dialer net.Dialer, destAddr, policy string) (net.Conn, error) {
+	conn, err := dialFromEgress(dialer, "tcp", upstream.Host, policy, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(dialer.Deadline)
+
+	tunnel := conn
+	if upstream.Scheme == "http" {
+		tunnel, err = httpConnect(conn, upstream, destAddr)
+	} else {
+		err = socks5Connect(conn, upstream, destAddr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// Bắt tay SOCKS5 (RFC 1928/1929) với upstream và yêu cầu CONNECT tới destAddr
+func socks5Connect(conn net.Conn, upstream *url.URL, destAddr string) error {
+	host, portValue, err := net.SplitHostPort(destAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00)
+	if upstream.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return fmt.Errorf("upstream %s: authentication method rejected", upstream.Host)
+	}
+
+	if method == 0x02 {
+		username := upstream.User.Username()
+		password, _ := upstream.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("upstream %s: credentials too long", upstream.Host)
+		}
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("upstream %s: authentication failed", upstream.Host)
+		}
+	}
+
+	// Zone ID chỉ có nghĩa trên máy này nên không được gửi cho upstream
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := hostIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("destination host too long")
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x04)
+		request = append(request, ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return &upstreamReplyError{Upstream: upstream.Host, Code: header[1]}
+	}
+
+	// Bỏ qua địa chỉ bind trong reply
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("upstream %s: invalid reply address type 0x%02x", upstream.Host, header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// Kết nối đã đọc trước một phần dữ liệu vào bộ đệm
+type bufferedConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	handshake *handshakeReader // Chỉ với kết nối SOCKS nhận vào: giới hạn byte trước khi tunnel thiết lập
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Kết nối bên dưới, cùng quy ước với tls.Conn
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Gửi HTTP CONNECT tới upstream
+func httpConnect(conn net.Conn, upstream *url.URL, destAddr string) (net.Conn, error) {
+	request := "CONNECT " + destAddr + " HTTP/1.1\r\nHost: " + destAddr + "\r\n"
+	if upstream.User != nil {
+		password, _ := upstream.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(upstream.User.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamReplyError{Upstream: upstream.Host, Code: 0x01, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// Danh sách trạng thái các upstream
+func upstreamStatuses() []UpstreamStatus {
+	upstreamPoolMutex.Lock()
+	defer upstreamPoolMutex.Unlock()
+
+	statuses := make([]UpstreamStatus, 0, len(upstreamPool))
+	for _, upstream := range upstreamPool {
+		statuses = append(statuses, UpstreamStatus{
+			Upstream:  upstream.url.Redacted(),
+			Country:   upstream.country,
+			Healthy:   upstream.healthy,
+			Failures:  upstream.failures,
+			LastCheck: upstream.lastCheck,
+			LastError: upstream.lastError,
+		})
+	}
+	return statuses
+}
+
+func upstreamCheckInterval() time.Duration {
+	interval := systemConfig().UpstreamCheckInterval
+	if interval <= 0 {
+		interval = defaultUpstreamCheckInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// Kiểm tra định kỳ các upstream và tải lại danh sách theo upstream_refresh_interval
+func runUpstreamHealthChecks() {
+	lastRefresh := time.Now()
+	for ; ; time.Sleep(upstreamCheckInterval()) {
+		if !upstreamsEnabled() {
+			continue
+		}
+
+		refresh := systemConfig().UpstreamRefreshInterval
+		if refresh <= 0 {
+			refresh = defaultUpstreamRefreshInterval
+		}
+		if time.Since(lastRefresh) >= time.Duration(refresh)*time.Second {
+			if err := reloadUpstreams(); err != nil {
+				log.Printf("Upstream list refresh error: %v", err)
+			}
+			lastRefresh = time.Now()
+		}
+
+		target := systemConfig().UpstreamCheckTarget
+		if target == "" {
+			target = defaultUpstreamCheckTarget
+		}
+
+		upstreamPoolMutex.Lock()
+		pool := append([]*upstreamProxy(nil), upstreamPool...)
+		upstreamPoolMutex.Unlock()
+
+		var checks sync.WaitGroup
+		for _, upstream := range pool {
+			checks.Add(1)
+			go func(upstream *upstreamProxy) {
+				defer checks.Done()
+				dialer := net.Dialer{Deadline: time.Now().Add(10 * time.Second)}
+				conn, err := dialUpstream(upstream.url, dialer, target, "")
+				if err == nil {
+					conn.Close()
+				}
+				upstreamPoolMutex.Lock()
+				upstream.lastCheck = time.Now()
+				upstreamPoolMutex.Unlock()
+				upstream.record(err)
+			}(upstream)
+		}
+		checks.Wait()
+	}
+}
@@ -0,0 +1,226 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+	"proxy_server/proxyserver/auth"
+)
+
+// Kênh phụ cho phần mềm client: trên usage_listen, client xác thực bằng username/password proxy (HTTP Basic)
+// và đọc quota còn lại, số kết nối và tốc độ hiện tại để hiển thị đồng hồ dung lượng cho người dùng cuối.
+// GET /usage trả về một lần, GET /usage/stream gửi định kỳ dạng server-sent events
+const (
+	usageRateInterval     = 5 * time.Second // Chu kỳ lấy mẫu dữ liệu đã dùng để tính tốc độ
+	defaultUsageStreamGap = 5               // Giây giữa hai lần gửi của stream
+	maxUsageStreamGap     = 300
+	maxUsageStreams       = 256
+)
+
+// Lượng dùng trả cho client
+type ClientUsage struct {
+	Username        string    `json:"username"`
+	Status          string    `json:"status"` // active, suspended hoặc expired
+	DataUsage       int64     `json:"data_usage"`
+	MaxData         int64     `json:"max_data"`       // 0 = không giới hạn
+	DataRemaining   *int64    `json:"data_remaining"` // null khi không giới hạn
+	Percent         float64   `json:"percent"`        // Phần trăm max_data đã dùng
+	Connections     int       `json:"connections"`
+	ConnectionLimit int       `json:"connection_limit"`
+	Rate            int64     `json:"rate"` // Byte/giây trong usageRateInterval gần nhất
+	MaxBandwidth    int64     `json:"max_bandwidth"`
+	MaxTransfer     int64     `json:"max_transfer,omitempty"`
+	EndDate         string    `json:"end_date"`
+	ExpiresIn       int64     `json:"expires_in"` // Giây tới hết end_date
+	Time            time.Time `json:"time"`
+}
+
+var (
+	usageRates      = make(map[string]int64) // Tốc độ theo username
+	usageSamples    = make(map[string]int64) // Dữ liệu đã dùng ở lần lấy mẫu trước
+	usageRatesMutex sync.Mutex
+	usageStreams    atomic.Int32
+)
+
+// Mở usage_listen và bắt đầu lấy mẫu tốc độ
+func startUsageServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /usage", withProxyCredentials(handleClientUsage))
+	mux.HandleFunc("GET /usage/stream", withProxyCredentials(handleClientUsageStream))
+
+	listener, err := listenTCP(listenerUsage, addr)
+	if err != nil {
+		log.Printf("Usage listener error: %v", err)
+		return
+	}
+	go runUsageRateSampler()
+	log.Printf("Client usage served on %s", addr)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, MaxHeaderBytes: maxAdminHeader}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Usage listener error: %v", err)
+	}
+}
+
+// Dữ liệu đã dùng chỉ tăng khi tunnel đóng, nên mẫu gồm cả số byte của các tunnel đang mở
+func runUsageRateSampler() {
+	for {
+		time.Sleep(usageRateInterval)
+		open := openTunnelBytes()
+		usersMutex.RLock()
+		usageRatesMutex.Lock()
+		for username, user := range users {
+			usage := user.dataUsage() + open[username]
+			if previous, sampled := usageSamples[username]; sampled && usage >= previous {
+				usageRates[username] = (usage - previous) * int64(time.Second) / int64(usageRateInterval)
+			}
+			usageSamples[username] = usage
+		}
+		// Bỏ user đã bị xóa
+		for username := range usageSamples {
+			if _, exists := users[username]; !exists {
+				delete(usageSamples, username)
+				delete(usageRates, username)
+			}
+		}
+		usageRatesMutex.Unlock()
+		usersMutex.RUnlock()
+	}
+}
+
+// Xác thực HTTP Basic bằng username/password proxy; tham số trong username (username_params) được bỏ qua.
+// User bị tạm khóa hoặc hết hạn vẫn xem được trạng thái của mình
+func withProxyCredentials(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="proxy usage"`)
+			admin.WriteError(w, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+		name, _, err := resolveUsernameParams(username)
+		if err != nil {
+			name = username
+		}
+
+		usersMutex.RLock()
+		user, exists := users[name]
+		stored := ""
+		if exists {
+			stored = user.Password
+		}
+		usersMutex.RUnlock()
+		if !auth.PasswordEqual(stored, password) || !exists {
+			logAuthFailure(r.RemoteAddr, "usage", username, AuthInvalidCredentials)
+			waitAuthFailure(started)
+			w.Header().Set("WWW-Authenticate", `Basic realm="proxy usage"`)
+			admin.WriteError(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next(w, r, name)
+	}
+}
+
+// Lượng dùng hiện tại của user; ok = false khi user vừa bị xóa
+func clientUsage(username string) (ClientUsage, bool) {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	user, exists := users[username]
+	if !exists {
+		return ClientUsage{}, false
+	}
+	usageRatesMutex.Lock()
+	rate := usageRates[username]
+	usageRatesMutex.Unlock()
+
+	now := time.Now()
+	usage := ClientUsage{
+		Username:        user.Username,
+		Status:          accountStatus(user, now),
+		DataUsage:       user.dataUsage(),
+		MaxData:         user.MaxData,
+		Connections:     user.activeConns(),
+		ConnectionLimit: user.ConnectionLimit,
+		Rate:            rate,
+		MaxBandwidth:    user.MaxBandwidth,
+		MaxTransfer:     user.MaxTransfer,
+		EndDate:         user.EndDate.Format("2006-01-02"),
+		Time:            now.UTC(),
+	}
+	if user.MaxData > 0 {
+		remaining := max(user.MaxData-usage.DataUsage, 0)
+		usage.DataRemaining = &remaining
+		usage.Percent = float64(usage.DataUsage*10000/user.MaxData) / 100
+	}
+	if !user.EndDate.IsZero() {
+		usage.ExpiresIn = max(int64(user.EndDate.AddDate(0, 0, 1).Sub(now)/time.Second), 0)
+	}
+	return usage, true
+}
+
+// GET /usage
+func handleClientUsage(w http.ResponseWriter, r *http.Request, username string) {
+	usage, ok := clientUsage(username)
+	if !ok {
+		admin.WriteError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, usage)
+}
+
+// GET /usage/stream?interval=: gửi lượng dùng mỗi interval giây (mặc định 5); các lần gửi cũng giữ kết nối
+// mở qua proxy và NAT. Stream kết thúc khi user bị xóa
+func handleClientUsageStream(w http.ResponseWriter, r *http.Request, username string) {
+	interval := defaultUsageStreamGap
+	if value := r.URL.Query().Get("interval"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxUsageStreamGap {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("interval must be 1-%d seconds", maxUsageStreamGap))
+			return
+		}
+		interval = parsed
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		admin.WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	if usageStreams.Add(1) > maxUsageStreams {
+		usageStreams.Add(-1)
+		admin.WriteError(w, http.StatusServiceUnavailable, "too many usage streams")
+		return
+	}
+	defer usageStreams.Add(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		usage, ok := clientUsage(username)
+		if !ok {
+			return
+		}
+		data, _ := json.Marshal(usage)
+		fmt.Fprintf(w, "event: usage\ndata: %s\n\n", data)
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package proxyserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Journal dữ liệu đã dùng: phần tăng thêm của mỗi user được gom trong bộ nhớ và ghi nối vào usage_journal
+// mỗi usage_journal_flush_ms (kèm fsync), nên khi process chết chỉ mất phần chưa ghi. Khi khởi động, dữ liệu
+// đã dùng là tổng các bản ghi trong journal. Journal được nén định kỳ thành một bản ghi tổng cho mỗi user
+const (
+	defaultJournalFlush           = 1000 // ms
+	defaultJournalCompactInterval = 600  // Giây
+)
+
+var (
+	journalFile     *os.File
+	journalPending  = make(map[string]int64) // Phần chưa ghi xuống file
+	journalTotals   = make(map[string]int64) // Tổng đã ghi trong file
+	journalRecords  int                      // Số bản ghi trong file
+	journalClosed   bool                     // Đã chuyển cho process mới khi nâng cấp nóng
+	journalMutex    sync.Mutex
+	journalLastErr  string
+	journalAwaiting atomic.Bool // Process mới chờ process cũ ghi xong journal, chưa được nén
+)
+
+func journalFlushInterval() time.Duration {
+	if systemConfig().UsageJournalFlush > 0 {
+		return time.Duration(systemConfig().UsageJournalFlush) * time.Millisecond
+	}
+	return defaultJournalFlush * time.Millisecond
+}
+
+func journalCompactInterval() time.Duration {
+	if systemConfig().UsageJournalCompactInterval > 0 {
+		return time.Duration(systemConfig().UsageJournalCompactInterval) * time.Second
+	}
+	return defaultJournalCompactInterval * time.Second
+}
+
+// Một bản ghi: username,delta,crc32 của phần đứng trước; bản ghi ghi dở khi crash bị bỏ qua lúc đọc lại
+func journalRecord(username string, delta int64) string {
+	body := username + "," + strconv.FormatInt(delta, 10)
+	return fmt.Sprintf("%s,%08x\n", body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+// Cộng các bản ghi của journal theo user; trả về cả số bản ghi hợp lệ và số bản ghi hỏng
+func readUsageJournal(path string) (totals map[string]int64, records, damaged int, err error) {
+	totals = make(map[string]int64)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return totals, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		cut := strings.LastIndexByte(line, ',')
+		if cut < 0 {
+			damaged++
+			continue
+		}
+		sum, err := strconv.ParseUint(line[cut+1:], 16, 32)
+		if err != nil || uint32(sum) != crc32.ChecksumIEEE([]byte(line[:cut])) {
+			damaged++
+			continue
+		}
+		username, value, _ := strings.Cut(line[:cut], ",")
+		delta, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			damaged++
+			continue
+		}
+		totals[username] += delta
+		records++
+	}
+	return totals, records, damaged, scanner.Err()
+}
+
+// Mở journal, đặt dữ liệu đã dùng của user theo journal và bắt đầu ghi định kỳ
+func startUsageJournal() error {
+	path := systemConfig().UsageJournal
+	if path == "" {
+		return nil
+	}
+	totals, records, damaged, err := readUsageJournal(path)
+	if err != nil {
+		return err
+	}
+	if damaged > 0 {
+		log.Printf("Usage journal %s: skipped %d damaged records", path, damaged)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	journalMutex.Lock()
+	journalFile, journalTotals, journalRecords = file, totals, records
+	journalMutex.Unlock()
+	restoreJournalUsage(totals)
+
+	go runUsageJournal()
+	log.Printf("Usage journal %s loaded: %d users, flushed every %v", path, len(totals), journalFlushInterval())
+	return nil
+}
+
+// Đặt dữ liệu đã dùng theo tổng trong journal; khi chạy cluster, tổng này là bộ đếm của node từ các lần chạy trước
+func restoreJournalUsage(totals map[string]int64) {
+	usersMutex.Lock()
+	for username, total := range totals {
+		if user, exists := users[username]; exists {
+			user.setDataUsage(total)
+		}
+	}
+	usersMutex.Unlock()
+	if systemConfig().ClusterListen != "" {
+		seedClusterUsage(totals)
+	}
+}
+
+func runUsageJournal() {
+	lastCompact := time.Now()
+	for {
+		time.Sleep(journalFlushInterval())
+		err := flushUsageJournal()
+		if err == nil && time.Since(lastCompact) >= journalCompactInterval() && !journalAwaiting.Load() {
+			err = compactUsageJournal()
+			lastCompact = time.Now()
+		}
+
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		journalMutex.Lock()
+		if message != "" && message != journalLastErr {
+			log.Printf("Usage journal error: %s", message)
+		} else if message == "" && journalLastErr != "" {
+			log.Println("Usage journal writable again")
+		}
+		journalLastErr = message
+		closed := journalClosed
+		journalMutex.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
+// Ghi dữ liệu user vừa dùng vào lần ghi journal tiếp theo
+func journalUsage(username string, n int64) {
+	if n <= 0 || systemConfig().UsageJournal == "" {
+		return
+	}
+	journalMutex.Lock()
+	journalPending[username] += n
+	journalMutex.Unlock()
+}
+
+// Ghi phần đang gom xuống file trong một lần write rồi fsync; khi lỗi, phần đó được giữ lại cho lần sau
+func flushUsageJournal() error {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	return flushUsageJournalLocked()
+}
+
+func flushUsageJournalLocked() error {
+	if journalFile == nil || journalClosed || len(journalPending) == 0 {
+		return nil
+	}
+	var batch strings.Builder
+	for username, delta := range journalPending {
+		batch.WriteString(journalRecord(username, delta))
+	}
+	if _, err := journalFile.WriteString(batch.String()); err != nil {
+		return err
+	}
+	if err := journalFile.Sync(); err != nil {
+		return err
+	}
+	for username, delta := range journalPending {
+		journalTotals[username] += delta
+	}
+	journalRecords += len(journalPending)
+	journalPending = make(map[string]int64)
+	return nil
+}
+
+// Thay journal bằng một bản ghi tổng cho mỗi user
+func compactUsageJournal() error {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	if journalFile == nil || journalClosed || journalRecords <= len(journalTotals) {
+		return nil
+	}
+	if err := flushUsageJournalLocked(); err != nil {
+		return err
+	}
+
+	usernames := make([]string, 0, len(journalTotals))
+	for username := range journalTotals {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	var content strings.Builder
+	for _, username := range usernames {
+		content.WriteString(journalRecord(username, journalTotals[username]))
+	}
+	path := systemConfig().UsageJournal
+	if err := writeFileAtomic(path, []byte(content.String())); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	journalFile.Close()
+	journalFile, journalRecords = file, len(usernames)
+	return nil
+}
+
+// Ghi nốt phần đang gom và ngừng ghi journal; process mới đọc lại journal sau khi nhận bộ đếm
+func closeUsageJournal() bool {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	if journalFile == nil || journalClosed {
+		return false
+	}
+	if err := flushUsageJournalLocked(); err != nil {
+		log.Printf("Usage journal error: %v", err)
+	}
+	journalFile.Close()
+	journalClosed = true
+	return true
+}
+
+// Đọc lại journal sau khi process cũ đã ghi xong phần của nó khi nâng cấp nóng
+func reloadUsageJournal() error {
+	journalMutex.Lock()
+	if err := flushUsageJournalLocked(); err != nil {
+		journalMutex.Unlock()
+		return err
+	}
+	totals, records, _, err := readUsageJournal(systemConfig().UsageJournal)
+	if err != nil {
+		journalMutex.Unlock()
+		return err
+	}
+	journalTotals, journalRecords = totals, records
+	journalMutex.Unlock()
+	restoreJournalUsage(totals)
+	return nil
+}
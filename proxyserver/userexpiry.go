@@ -0,0 +1,170 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Xử lý user đã qua end_date
+const (
+	expiredUserNone    = "none"    // Không làm gì (mặc định)
+	expiredUserDisable = "disable" // Từ chối xác thực và đóng các tunnel đang mở
+	expiredUserDelete  = "delete"  // Như disable, xóa user sau expired_user_retention ngày
+)
+
+// Giá trị mặc định của việc dọn user hết hạn
+const (
+	defaultExpiredUserRetention = 30 // Ngày
+	defaultExpiredUserArchive   = "expired_users.jsonl"
+	expiredUserCheckInterval    = time.Hour
+)
+
+// Bản ghi lưu trữ của user bị xóa, mỗi dòng JSON trong expired_user_archive
+type ExpiredUserRecord struct {
+	Username  string    `json:"username"`
+	Owner     string    `json:"owner,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	MaxData   int64     `json:"max_data"`
+	DataUsage int64     `json:"data_usage"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+var (
+	expiredUsersSeen  = make(map[string]bool) // User hết hạn đã được log và đóng tunnel
+	expiredUsersMutex sync.Mutex
+)
+
+func validExpiredUserAction(action string) bool {
+	switch action {
+	case expiredUserNone, expiredUserDisable, expiredUserDelete:
+		return true
+	}
+	return false
+}
+
+// User dùng được hết ngày end_date; end_date không đọc được (zero) thì không bao giờ hết hạn
+func userExpired(user *User, now time.Time) bool {
+	if systemConfig().ExpiredUserAction == "" || systemConfig().ExpiredUserAction == expiredUserNone {
+		return false
+	}
+	return !user.EndDate.IsZero() && !now.Before(user.EndDate.AddDate(0, 0, 1))
+}
+
+func expiredUserRetention() int {
+	if systemConfig().ExpiredUserRetention <= 0 {
+		return defaultExpiredUserRetention
+	}
+	return systemConfig().ExpiredUserRetention
+}
+
+// Kiểm tra user hết hạn theo chu kỳ: đóng tunnel của user vừa hết hạn, xóa user quá thời gian giữ lại
+func runUserExpiry() {
+	for {
+		if err := expireUsers(time.Now()); err != nil {
+			log.Printf("Expired user cleanup error: %v", err)
+		}
+		time.Sleep(expiredUserCheckInterval)
+	}
+}
+
+func expireUsers(now time.Time) error {
+	var expired, deleted []*User
+	usersMutex.Lock()
+	for username, user := range users {
+		if !userExpired(user, now) {
+			continue
+		}
+		if systemConfig().ExpiredUserAction == expiredUserDelete &&
+			!now.Before(user.EndDate.AddDate(0, 0, 1+expiredUserRetention())) {
+			delete(users, username)
+			deleted = append(deleted, user)
+			continue
+		}
+		expired = append(expired, user)
+	}
+	usersMutex.Unlock()
+
+	expiredUsersMutex.Lock()
+	var newlyExpired []*User
+	for _, user := range expired {
+		if !expiredUsersSeen[user.Username] {
+			expiredUsersSeen[user.Username] = true
+			newlyExpired = append(newlyExpired, user)
+		}
+	}
+	for _, user := range deleted {
+		delete(expiredUsersSeen, user.Username)
+	}
+	expiredUsersMutex.Unlock()
+
+	for _, user := range newlyExpired {
+		log.Printf("User %s expired on %s: disabled", user.Username, user.EndDate.Format("2006-01-02"))
+		closeUserTunnels(user.Username, CloseAccountExpired)
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	for _, user := range deleted {
+		closeUserTunnels(user.Username, CloseAccountExpired)
+		recordAudit("system", "user.expire", user.Username, newUserView(user), nil)
+		log.Printf("User %s deleted: expired on %s", user.Username, user.EndDate.Format("2006-01-02"))
+	}
+	if err := archiveExpiredUsers(deleted, now); err != nil {
+		return err
+	}
+	return removeUsersFromFile(userFile, deleted)
+}
+
+// Ghi thêm bản ghi của các user bị xóa vào expired_user_archive
+func archiveExpiredUsers(deleted []*User, now time.Time) error {
+	path := systemConfig().ExpiredUserArchive
+	if path == "" {
+		path = defaultExpiredUserArchive
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, user := range deleted {
+		record := ExpiredUserRecord{
+			Username:  user.Username,
+			Owner:     user.Owner,
+			Group:     user.Group,
+			StartDate: user.StartDate.Format("2006-01-02"),
+			EndDate:   user.EndDate.Format("2006-01-02"),
+			MaxData:   user.MaxData,
+			DataUsage: user.dataUsage(),
+			DeletedAt: now,
+		}
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Viết lại file user bỏ các dòng của user đã xóa; các dòng khác (kể cả password đã mã hóa) giữ nguyên
+func removeUsersFromFile(path string, deleted []*User) error {
+	names := make(map[string]bool, len(deleted))
+	for _, user := range deleted {
+		names[user.Username] = true
+	}
+	removed, err := rewriteUserFile(path, nil, names)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Removed %d expired users from %s", removed, path)
+	}
+	return nil
+}
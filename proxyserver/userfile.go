@@ -0,0 +1,170 @@
+package proxyserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"proxy_server/proxyserver/admin"
+)
+
+// Ghi lại users.conf từ server đang chạy: chỉ các dòng của user thay đổi được sửa, chú thích, thứ tự
+// và các dòng khác giữ nguyên. File được ghi qua file tạm rồi đổi tên, trong khi giữ flock trên
+// users.conf.lock để script bên ngoài dùng cùng khóa (flock users.conf.lock ...) không ghi chen vào
+const (
+	userFileLockTimeout = 5 * time.Second
+	userFileRetries     = 3 // Số lần đọc lại khi file bị sửa từ bên ngoài trong lúc đang ghi
+)
+
+// Khóa users.conf trong process và giữa các process; gọi hàm trả về để mở khóa
+func lockUserFile(path string) (func(), error) {
+	userFileMutex.Lock()
+	unlock, err := flockFile(path+".lock", userFileLockTimeout)
+	if err != nil {
+		userFileMutex.Unlock()
+		return nil, fmt.Errorf("unable to lock %s: %v", path, err)
+	}
+	return func() {
+		unlock()
+		userFileMutex.Unlock()
+	}, nil
+}
+
+// Sửa các dòng user của file: user trong saved thay dòng của mình hoặc được thêm vào cuối,
+// dòng của username trong deleted bị bỏ. Trả về số dòng bị bỏ
+func rewriteUserFile(path string, saved []*User, deleted map[string]bool) (int, error) {
+	unlock, err := lockUserFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	return rewriteUserFileLocked(path, saved, deleted)
+}
+
+func rewriteUserFileLocked(path string, saved []*User, deleted map[string]bool) (int, error) {
+	for attempt := 1; ; attempt++ {
+		before := statFile(path)
+		data, err := readUserFile(path)
+		if err != nil {
+			return 0, err
+		}
+		content, removed, err := editUserLines(data, saved, deleted)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(content, data) {
+			return 0, nil
+		}
+		// File bị sửa bởi process không dùng khóa trong lúc đọc: đọc lại để không ghi đè thay đổi đó
+		if statFile(path) != before {
+			if attempt < userFileRetries {
+				continue
+			}
+			return 0, fmt.Errorf("%s keeps changing, not rewritten", path)
+		}
+		return removed, writeFileAtomic(path, content)
+	}
+}
+
+func editUserLines(data []byte, saved []*User, deleted map[string]bool) ([]byte, int, error) {
+	pending := make(map[string]*User, len(saved))
+	for _, user := range saved {
+		pending[user.Username] = user
+	}
+	var out bytes.Buffer
+	removed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		username := userLineName(line)
+		if username != "" && deleted[username] {
+			removed++
+			continue
+		}
+		// Chỉ dòng đầu tiên của username được thay, giống thứ tự đọc của users.conf
+		if user := pending[username]; username != "" && user != nil {
+			var err error
+			if line, err = userFileLine(user, line); err != nil {
+				return nil, 0, err
+			}
+			delete(pending, username)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	for _, user := range saved {
+		if pending[user.Username] != nil {
+			line, err := userFileLine(user, "")
+			if err != nil {
+				return nil, 0, err
+			}
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+	return out.Bytes(), removed, nil
+}
+
+// Dòng mới của user thay cho dòng current (rỗng khi thêm user). Cột password cũ (có thể đã mã hóa hoặc là
+// tham chiếu Vault/SSM) được giữ khi password không đổi; password mới được mã hóa khi có master key
+func userFileLine(user *User, current string) (string, error) {
+	fields, _ := splitUserLine(formatUserLine(user))
+	if current != "" {
+		parts, _ := splitUserLine(current)
+		if password, err := resolveSecret(parts[1]); err == nil && password == user.Password {
+			fields[1] = parts[1]
+			return joinUserLine(fields), nil
+		}
+	}
+	password, err := storedSecret(user.Password)
+	if err != nil {
+		return "", fmt.Errorf("password of %s: %v", user.Username, err)
+	}
+	fields[1] = password
+	return joinUserLine(fields), nil
+}
+
+// Ghi trạng thái hiện tại của các user vào users.conf khi bật users_write_back:
+// user còn tồn tại được ghi lại, user đã bị xóa được bỏ khỏi file
+func writeBackUsers(usernames ...string) error {
+	if !systemConfig().UsersWriteBack || len(usernames) == 0 {
+		return nil
+	}
+	unlock, err := lockUserFile(userFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Lấy trạng thái user sau khi đã giữ khóa file để hai lần ghi đồng thời không ghi ngược thứ tự
+	var saved []*User
+	deleted := make(map[string]bool)
+	usersMutex.RLock()
+	for _, username := range usernames {
+		if user, exists := users[username]; exists {
+			saved = append(saved, user)
+		} else {
+			deleted[username] = true
+		}
+	}
+	usersMutex.RUnlock()
+	_, err = rewriteUserFileLocked(userFile, saved, deleted)
+	return err
+}
+
+// Ghi lại user sau một thay đổi qua API; khi lỗi, trả 500 cho client (thay đổi trong bộ nhớ vẫn giữ) và trả về true
+func writeBackFailed(w http.ResponseWriter, usernames ...string) bool {
+	err := writeBackUsers(usernames...)
+	if err == nil {
+		return false
+	}
+	log.Printf("Unable to write back %s: %v", userFile, err)
+	admin.WriteError(w, http.StatusInternalServerError, "change applied but not written to "+userFile+": "+err.Error())
+	return true
+}
@@ -0,0 +1,68 @@
+package proxyserver
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cột password của user trong nội dung users.conf
+func userFilePassword(t *testing.T, data []byte, username string) string {
+	t.Helper()
+	for _, line := range strings.Split(string(data), "\n") {
+		if userLineName(line) == username {
+			fields, _ := splitUserLine(line)
+			return fields[1]
+		}
+	}
+	t.Fatalf("no line for %s", username)
+	return ""
+}
+
+// Có master key: password mới hoặc đã đổi được ghi dạng enc:v3:, cột của password không đổi được giữ nguyên
+func TestEditUserLinesEncryptsPasswords(t *testing.T) {
+	t.Setenv(masterKeyEnv, "test master key")
+	resetMasterKey()
+	t.Cleanup(resetMasterKey)
+
+	kept, err := encryptSecret("kept password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := []byte("kept," + kept + ",2024-01-01,2030-01-01,1,0,0\nchanged,old password,2024-01-01,2030-01-01,1,0,0\n")
+	saved := []*User{
+		{Username: "kept", Password: "kept password"},
+		{Username: "changed", Password: "new, password"},
+		{Username: "added", Password: " added: pässword "},
+	}
+
+	data, _, err := editUserLines(current, saved, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if column := userFilePassword(t, data, "kept"); column != kept {
+		t.Fatalf("unchanged password rewritten as %q", column)
+	}
+	for _, user := range saved[1:] {
+		column := userFilePassword(t, data, user.Username)
+		if !strings.HasPrefix(column, encryptedV3Prefix) {
+			t.Fatalf("password of %s written as %q", user.Username, column)
+		}
+		if plain, err := decryptSecret(column); err != nil || plain != user.Password {
+			t.Fatalf("password of %s decrypts to %q (%v)", user.Username, plain, err)
+		}
+	}
+}
+
+// Không có master key: password được ghi dạng rõ
+func TestEditUserLinesWithoutMasterKey(t *testing.T) {
+	t.Setenv(masterKeyEnv, "")
+	resetMasterKey()
+
+	data, _, err := editUserLines(nil, []*User{{Username: "added", Password: "plain"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if column := userFilePassword(t, data, "added"); column != "plain" {
+		t.Fatalf("password written as %q", column)
+	}
+}
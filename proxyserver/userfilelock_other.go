@@ -0,0 +1,10 @@
+//go:build !unix
+
+package proxyserver
+
+import "time"
+
+// Không có flock: chỉ khóa trong process
+func flockFile(path string, timeout time.Duration) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package proxyserver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Giữ flock độc quyền trên file khóa, chờ tối đa timeout khi process khác đang giữ
+func flockFile(path string, timeout time.Duration) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			file.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				err = errors.New("held by another process")
+			}
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,467 @@
+package proxyserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kích thước tối đa của body POST /api/users/import
+const maxImportBody = 64 << 20
+
+// Một dòng bị từ chối khi nhập user
+type ImportError struct {
+	Line     int    `json:"line"`
+	Username string `json:"username,omitempty"`
+	Error    string `json:"error"`
+}
+
+// Kết quả nhập user: các user hợp lệ và các dòng bị từ chối theo số dòng
+type ImportReport struct {
+	Imported []string      `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+	Applied  bool          `json:"applied"`
+}
+
+// Một bản ghi đọc được từ file nhập, chưa kiểm tra
+type importRow struct {
+	line int
+	req  userRequest
+	err  error // Lỗi đọc bản ghi (ví dụ số không hợp lệ trong CSV)
+}
+
+// Đọc file nhập dạng JSON (mảng các đối tượng như POST /api/users) hoặc CSV (các cột như users.conf,
+// dòng tiêu đề bắt đầu bằng "username" được bỏ qua). Dạng được nhận biết theo ký tự đầu tiên
+func readImportRows(data []byte) ([]importRow, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // BOM của file CSV xuất từ Excel
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return readImportJSON(data)
+	}
+	return readImportCSV(data)
+}
+
+func readImportJSON(data []byte) ([]importRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var rows []importRow
+	for decoder.More() {
+		// Vị trí bắt đầu của đối tượng: bỏ qua dấu phẩy và khoảng trắng sau token trước
+		offset := int(decoder.InputOffset())
+		for offset < len(data) && strings.IndexByte(", \t\r\n", data[offset]) >= 0 {
+			offset++
+		}
+		row := importRow{line: 1 + bytes.Count(data[:offset], []byte("\n"))}
+		if err := decoder.Decode(&row.req); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				return nil, fmt.Errorf("line %d: %v", row.line, err)
+			}
+			row.err = fmt.Errorf("invalid %s value", typeErr.Field)
+		}
+		rows = append(rows, row)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func readImportCSV(data []byte) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var rows []importRow
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rows) == 0 && strings.EqualFold(fields[0], "username") {
+			continue
+		}
+		rows = append(rows, importRowFromFields(line, fields))
+	}
+	return rows, nil
+}
+
+// Chuyển các cột của một dòng (như users.conf) thành bản ghi nhập
+func importRowFromFields(line int, fields []string) importRow {
+	row := importRow{line: line}
+	if len(fields) < 7 || len(fields) > 12 {
+		row.err = fmt.Errorf("expected 7 to 12 columns, got %d", len(fields))
+		if len(fields) > 0 {
+			row.req.Username = fields[0]
+		}
+		return row
+	}
+	for len(fields) < 12 {
+		fields = append(fields, "")
+	}
+	row.req = userRequest{
+		Username:  fields[0],
+		Password:  fields[1],
+		StartDate: fields[2],
+		EndDate:   fields[3],
+		Owner:     fields[7],
+		Group:     fields[8],
+		Email:     fields[10],
+	}
+	tags, err := parseTags(fields[11])
+	if err != nil {
+		row.err = fmt.Errorf("invalid tags: %v", err)
+		return row
+	}
+	row.req.Tags = tags
+	if fields[9] == "" {
+		fields[9] = "0"
+	}
+	var connectionLimit int64
+	numbers := []struct {
+		name   string
+		value  string
+		target *int64
+	}{
+		{"connection_limit", fields[4], &connectionLimit},
+		{"max_data", fields[5], &row.req.MaxData},
+		{"max_bandwidth", fields[6], &row.req.MaxBandwidth},
+		{"max_transfer", fields[9], &row.req.MaxTransfer},
+	}
+	for _, number := range numbers {
+		n, err := strconv.ParseInt(number.value, 10, 64)
+		if err != nil {
+			row.err = fmt.Errorf("invalid %s %q", number.name, number.value)
+			break
+		}
+		*number.target = n
+	}
+	row.req.ConnectionLimit = int(connectionLimit)
+	return row
+}
+
+// Đọc users.conf ở chế độ strict_users: mọi dòng không rỗng và không phải chú thích phải hợp lệ
+// và username không được trùng; nếu không, trả về lỗi liệt kê từng dòng và không dùng user nào
+func parseUsersStrict(r io.Reader) (map[string]*User, error) {
+	scanner := bufio.NewScanner(r)
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields, err := splitUserLine(text)
+		if err != nil {
+			rows = append(rows, importRow{line: line, err: err})
+			continue
+		}
+		rows = append(rows, importRowFromFields(line, fields))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	valid, report := validateImport(rows, func(string) bool { return false }, "")
+	if len(report.Errors) > 0 {
+		problems := make([]string, len(report.Errors))
+		for i, e := range report.Errors {
+			if e.Username != "" {
+				problems[i] = fmt.Sprintf("line %d (%s): %s", e.Line, e.Username, e.Error)
+			} else {
+				problems[i] = fmt.Sprintf("line %d: %s", e.Line, e.Error)
+			}
+		}
+		return nil, fmt.Errorf("%d invalid lines: %s", len(problems), strings.Join(problems, "; "))
+	}
+
+	newUsers := make(map[string]*User, len(valid))
+	for _, user := range valid {
+		password, err := resolveSecret(user.Password)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %v", user.Username, err)
+		}
+		user.Password = password
+		newUsers[user.Username] = user
+	}
+	return newUsers, nil
+}
+
+// Kiểm tra dữ liệu user (bản ghi nhập hoặc yêu cầu tạo qua API) và chuyển thành User
+func validateUserRequest(req userRequest) (*User, error) {
+	switch {
+	case req.Username == "" || strings.ContainsAny(req.Username, ", \t"):
+		return nil, errors.New("invalid username")
+	case req.Password == "" || strings.ContainsAny(req.Password, "\r\n"):
+		return nil, errors.New("invalid password")
+	case strings.Contains(req.Owner, ",") || strings.Contains(req.Group, ","):
+		return nil, errors.New("invalid owner or group")
+	case !validUserEmail(req.Email):
+		return nil, errors.New("invalid email")
+	case validateTags(req.Tags) != nil:
+		return nil, validateTags(req.Tags)
+	case req.ConnectionLimit < 0:
+		return nil, errors.New("connection_limit must not be negative")
+	case req.MaxData < 0:
+		return nil, errors.New("max_data must not be negative")
+	case req.MaxBandwidth < 0:
+		return nil, errors.New("max_bandwidth must not be negative")
+	case req.MaxTransfer < 0:
+		return nil, errors.New("max_transfer must not be negative")
+	}
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start_date %q, expected YYYY-MM-DD", req.StartDate)
+	}
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end_date %q, expected YYYY-MM-DD", req.EndDate)
+	}
+	if endDate.Before(startDate) {
+		return nil, errors.New("end_date is before start_date")
+	}
+	return &User{
+		Username:        req.Username,
+		Password:        req.Password,
+		StartDate:       startDate,
+		EndDate:         endDate,
+		ConnectionLimit: req.ConnectionLimit,
+		MaxData:         req.MaxData,
+		MaxBandwidth:    req.MaxBandwidth,
+		Owner:           req.Owner,
+		Group:           req.Group,
+		MaxTransfer:     req.MaxTransfer,
+		Email:           req.Email,
+		Tags:            req.Tags,
+		state:           new(userState),
+	}, nil
+}
+
+// Kiểm tra mọi bản ghi: username trùng trong file hoặc đã tồn tại (exists) bị từ chối cùng các lỗi khác.
+// owner khác rỗng thì ghi đè owner của mọi user (reseller chỉ nhập được user của mình)
+func validateImport(rows []importRow, exists func(string) bool, owner string) ([]*User, ImportReport) {
+	report := ImportReport{Imported: []string{}, Errors: []ImportError{}}
+	var valid []*User
+	firstLine := make(map[string]int)
+	for _, row := range rows {
+		if owner != "" {
+			row.req.Owner = owner
+		}
+		err := row.err
+		if err == nil {
+			if line, seen := firstLine[row.req.Username]; seen {
+				err = fmt.Errorf("duplicate username, first seen on line %d", line)
+			} else if row.req.Username != "" && exists(row.req.Username) {
+				err = errors.New("user already exists")
+			}
+		}
+		var user *User
+		if err == nil {
+			user, err = validateUserRequest(row.req)
+		}
+		if row.req.Username != "" {
+			if _, seen := firstLine[row.req.Username]; !seen {
+				firstLine[row.req.Username] = row.line
+			}
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, ImportError{row.line, row.req.Username, err.Error()})
+			continue
+		}
+		valid = append(valid, user)
+		report.Imported = append(report.Imported, user.Username)
+	}
+	return valid, report
+}
+
+// Dòng users.conf của user, bỏ các cột tùy chọn rỗng ở cuối
+func formatUserLine(user *User) string {
+	fields := []string{
+		user.Username,
+		user.Password,
+		user.StartDate.Format("2006-01-02"),
+		user.EndDate.Format("2006-01-02"),
+		strconv.Itoa(user.ConnectionLimit),
+		strconv.FormatInt(user.MaxData, 10),
+		strconv.FormatInt(user.MaxBandwidth, 10),
+		user.Owner,
+		user.Group,
+		"",
+		user.Email,
+		formatTags(user.Tags),
+	}
+	if user.MaxTransfer > 0 {
+		fields[9] = strconv.FormatInt(user.MaxTransfer, 10)
+	}
+	for len(fields) > 7 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return joinUserLine(fields)
+}
+
+// Tách một dòng users.conf theo CSV: cột có dấu phẩy, dấu nháy hoặc khoảng trắng đầu được đặt trong
+// dấu nháy kép ("p,w" hoặc "p""w"). Dấu nháy lẻ trong cột không có nháy vẫn được chấp nhận như trước
+func splitUserLine(line string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	fields, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return fields, err
+}
+
+// Ghép các cột thành một dòng users.conf, chỉ đặt trong dấu nháy các cột cần thiết
+func joinUserLine(fields []string) string {
+	var line strings.Builder
+	writer := csv.NewWriter(&line)
+	writer.Write(fields)
+	writer.Flush()
+	return strings.TrimSuffix(line.String(), "\n")
+}
+
+// Username của dòng user trong users.conf, rỗng nếu không phải dòng user
+func userLineName(line string) string {
+	fields, err := splitUserLine(line)
+	if err != nil || len(fields) < 7 || len(fields) > 12 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Username của các dòng user trong file users.conf, không cần giải mã password
+func userFileNames(data []byte) map[string]bool {
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if username := userLineName(scanner.Text()); username != "" {
+			names[username] = true
+		}
+	}
+	return names
+}
+
+// Ghi file qua file tạm rồi đổi tên, giữ quyền của file cũ; không bao giờ để lại file dở dang
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	os.Chmod(temp.Name(), mode)
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// proxy-server user import [--dry-run] <file>: kiểm tra file CSV/JSON, in các dòng lỗi
+// và thêm các user hợp lệ vào cuối users.conf trong một lần ghi
+func runUserCommand(args []string) int {
+	if len(args) < 1 || args[0] != "import" {
+		fmt.Fprintln(os.Stderr, "Usage: proxy-server user import [--dry-run] <file.csv|file.json>")
+		return 2
+	}
+	flags := flag.NewFlagSet("user import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only validate and print the report")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: proxy-server user import [--dry-run] <file.csv|file.json>")
+		return 2
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read import file: %v\n", err)
+		return 1
+	}
+	rows, err := readImportRows(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to parse %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	// Giữ khóa users.conf từ lúc đọc tới lúc ghi để server đang chạy không ghi chen vào
+	unlock, err := lockUserFile(userFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer unlock()
+	current, err := os.ReadFile(userFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Unable to read %s: %v\n", userFile, err)
+		return 1
+	}
+	existing := userFileNames(current)
+	valid, report := validateImport(rows, func(name string) bool { return existing[name] }, "")
+
+	for _, importErr := range report.Errors {
+		fmt.Printf("line %d: %s: %s\n", importErr.Line, importErr.Username, importErr.Error)
+	}
+	if len(valid) > 0 && !*dryRun {
+		var out bytes.Buffer
+		out.Write(current)
+		if len(current) > 0 && current[len(current)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		for _, user := range valid {
+			line, err := userFileLine(user, "")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to write %s: %v\n", userFile, err)
+				return 1
+			}
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+		if err := writeFileAtomic(userFile, out.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write %s: %v\n", userFile, err)
+			return 1
+		}
+		report.Applied = true
+	}
+
+	switch {
+	case report.Applied:
+		fmt.Printf("Imported %d users into %s, %d rows rejected.\n", len(valid), userFile, len(report.Errors))
+	case *dryRun:
+		fmt.Printf("Dry run: %d users valid, %d rows rejected, nothing written.\n", len(valid), len(report.Errors))
+	default:
+		fmt.Printf("No users imported, %d rows rejected.\n", len(report.Errors))
+	}
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package proxyserver
+
+import (
+	"slices"
+	"testing"
+)
+
+// Cột ghép bằng joinUserLine được splitUserLine tách lại nguyên vẹn, kể cả password có dấu phẩy, dấu hai
+// chấm, dấu nháy, unicode hoặc khoảng trắng ở đầu và cuối
+func TestUserLineRoundTrip(t *testing.T) {
+	user := func(password string) []string {
+		return []string{"alice", password, "2024-01-01", "2030-12-31", "10", "0", "0"}
+	}
+	tests := []struct {
+		name   string
+		fields []string
+	}{
+		{"plain", user("secret")},
+		{"comma", user("se,cr,et")},
+		{"colon", user("user:pass:word")},
+		{"quote", user(`say "hi"`)},
+		{"leading quote", user(`"quoted`)},
+		{"unicode", user("mật-khẩu-🔑-パスワード")},
+		{"leading space", user("  secret")},
+		{"trailing space", user("secret  ")},
+		{"only spaces", user("   ")},
+		{"comma and spaces", user(" a, b ")},
+		{"hash", user("#not-a-comment")},
+		{"empty", user("")},
+		{"encrypted", user("enc:v3:bBsiMXUYzLWs8n3F0L51o1tlIqNW2nLzO0Hxb9I9-0L-phutCV1y7HQ1WBEqbmxBFzvwacG-dbysL08BtX9w")},
+		{"vault reference", user("vault:secret/data/proxy/users#alice")},
+		{"optional columns", append(user("p,w"), "", "premium", "alice@example.com")},
+	}
+	for _, test := range tests {
+		line := joinUserLine(test.fields)
+		fields, err := splitUserLine(line)
+		if err != nil {
+			t.Errorf("%s: split %q: %v", test.name, line, err)
+			continue
+		}
+		if !slices.Equal(fields, test.fields) {
+			t.Errorf("%s: %q split into %q, want %q", test.name, line, fields, test.fields)
+		}
+		if name := userLineName(line); name != "alice" {
+			t.Errorf("%s: %q read as user %q", test.name, line, name)
+		}
+	}
+}
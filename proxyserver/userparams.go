@@ -0,0 +1,34 @@
+package proxyserver
+
+import (
+	"fmt"
+
+	"proxy_server/proxyserver/auth"
+)
+
+// Tách username thật và các tham số gắn trong username khi username_params bật (xem auth.ParseUsernameParams).
+// Username có trong users.conf được dùng nguyên vẹn; username không có tham số nào được trả về như cũ để xác
+// thực báo lỗi bình thường
+func resolveUsernameParams(username string) (string, auth.UsernameParams, error) {
+	if !systemConfig().UsernameParams {
+		return username, auth.UsernameParams{}, nil
+	}
+	usersMutex.RLock()
+	_, exists := users[username]
+	usersMutex.RUnlock()
+	if exists {
+		return username, auth.UsernameParams{}, nil
+	}
+	return auth.ParseUsernameParams(username, checkParamCountry)
+}
+
+// Quốc gia trong username phải có IP egress hoặc upstream
+func checkParamCountry(country string) error {
+	if !validCountryCode(country) {
+		return fmt.Errorf("invalid country %q", country)
+	}
+	if !countryAvailable(country) {
+		return fmt.Errorf("no egress IP or upstream in country %s", country)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package proxyserver
+
+import "sync/atomic"
+
+// Trạng thái runtime của user, tách khỏi cấu hình đọc từ users.conf hoặc nhận qua API. Các bản User của
+// cùng một username (sau khi nạp lại users.conf, config apply hoặc cập nhật qua API) dùng chung một
+// trạng thái nên dữ liệu đã dùng, số tunnel và trạng thái tạm khóa không bị mất, và tunnel đang mở với
+// bản cũ vẫn cộng vào đúng chỗ
+type userState struct {
+	dataUsage atomic.Int64 // Lượng dữ liệu đã sử dụng (byte)
+	inflight  atomic.Int64 // Dữ liệu của các tunnel đang mở, chưa cộng vào dataUsage (byte)
+	conns     atomic.Int64 // Số kết nối giữ chỗ trong connection_limit: từ khi xác thực tới khi tunnel kết thúc
+
+	// Bị tạm khóa qua API cấp phát, chỉ giữ trong bộ nhớ; đọc và ghi khi giữ usersMutex
+	suspended     bool
+	suspendReason string
+}
+
+// Lượng dữ liệu đã sử dụng (byte)
+func (u *User) dataUsage() int64 {
+	return u.state.dataUsage.Load()
+}
+
+// Cộng dữ liệu đã sử dụng, trả về giá trị mới
+func (u *User) addDataUsage(n int64) int64 {
+	return u.state.dataUsage.Add(n)
+}
+
+func (u *User) setDataUsage(n int64) {
+	u.state.dataUsage.Store(n)
+}
+
+// Số kết nối đang mở của user
+func (u *User) activeConns() int {
+	return int(u.state.conns.Load())
+}
+
+// Giữ một chỗ trong connection_limit tới khi releaseConn; false nếu đã đủ. Kiểm tra và giữ chỗ cùng
+// một bước nên nhiều client xác thực cùng lúc không vượt được giới hạn
+func (u *User) reserveConn() bool {
+	for {
+		conns := u.state.conns.Load()
+		if conns >= int64(u.ConnectionLimit) {
+			return false
+		}
+		if u.state.conns.CompareAndSwap(conns, conns+1) {
+			return true
+		}
+	}
+}
+
+func (u *User) releaseConn() {
+	u.state.conns.Add(-1)
+}
+
+// Số byte user còn được dùng tính cả các tunnel đang mở (có thể âm); limited sai khi max_data = 0
+func (u *User) quotaRemaining() (remaining int64, limited bool) {
+	if u.MaxData <= 0 {
+		return 0, false
+	}
+	return u.MaxData - u.dataUsage() - u.state.inflight.Load(), true
+}
+
+// Gọi khi giữ usersMutex
+func (u *User) isSuspended() bool {
+	return u.state.suspended
+}
+
+// Gọi khi giữ usersMutex
+func (u *User) suspendReason() string {
+	return u.state.suspendReason
+}
+
+// Gọi khi giữ usersMutex
+func (u *User) setSuspended(suspended bool, reason string) {
+	u.state.suspended, u.state.suspendReason = suspended, reason
+}
+
+// Dùng chung trạng thái runtime của bản User cũ
+func (u *User) keepState(old *User) {
+	u.state = old.state
+}
+
+// Thay danh sách user, giữ trạng thái runtime của các username còn tồn tại; gọi khi giữ usersMutex
+func replaceUsers(newUsers map[string]*User) {
+	for username, newUser := range newUsers {
+		if oldUser, exists := users[username]; exists {
+			newUser.keepState(oldUser)
+		}
+	}
+	users = newUsers
+}
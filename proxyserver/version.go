@@ -0,0 +1,207 @@
+package proxyserver
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Phiên bản của bản build, đặt khi build bằng
+// go build -ldflags "-X proxy_server/proxyserver.Version=v1.4.0 -X proxy_server/proxyserver.Commit=$(git rev-parse HEAD) -X proxy_server/proxyserver.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)".
+// Commit và BuildDate để trống thì lấy từ thông tin VCS Go ghi vào binary khi build trong git checkout
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Trang release mới nhất trên GitHub, dùng cho proxy-server version --check
+const defaultReleaseURL = "https://api.github.com/repos/coffeecms/coffee_proxy_server/releases/latest"
+
+// Thông tin bản build trong proxy-server version, GET /api/status và proxy_build_info
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // Build từ thư mục có thay đổi chưa commit
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+func currentBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// Một dòng như "proxy-server v1.4.0 (commit 1a2b3c4d5e6f, built 2026-01-02T03:04:05Z, go1.23.4 linux/amd64)"
+func (info BuildInfo) String() string {
+	details := []string{}
+	if info.Commit != "" {
+		commit := info.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if info.Modified {
+			commit += "-dirty"
+		}
+		details = append(details, "commit "+commit)
+	}
+	if info.BuildDate != "" {
+		details = append(details, "built "+info.BuildDate)
+	}
+	details = append(details, info.GoVersion+" "+info.Platform)
+	return fmt.Sprintf("proxy-server %s (%s)", info.Version, strings.Join(details, ", "))
+}
+
+// Release mới nhất theo GitHub API
+type releaseInfo struct {
+	TagName     string    `json:"tag_name"`
+	HTMLURL     string    `json:"html_url"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func fetchLatestRelease(url string) (releaseInfo, error) {
+	var release releaseInfo
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return release, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("User-Agent", "proxy-server/"+Version)
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return release, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return release, fmt.Errorf("%s returned %s", url, response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return release, fmt.Errorf("invalid release response: %v", err)
+	}
+	if release.TagName == "" {
+		return release, fmt.Errorf("release response has no tag_name")
+	}
+	return release, nil
+}
+
+// So sánh hai phiên bản dạng v1.2.3 theo từng số; phần sau dấu - (v1.2.3-rc1) đứng trước bản chính thức.
+// Trả về -1, 0 hoặc 1; ok là false khi một trong hai không phải phiên bản dạng số
+func compareVersions(a, b string) (result int, ok bool) {
+	parse := func(version string) ([]int, string, bool) {
+		version, pre, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+		var numbers []int
+		for _, part := range strings.Split(version, ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, "", false
+			}
+			numbers = append(numbers, n)
+		}
+		return numbers, pre, true
+	}
+	aNumbers, aPre, aOK := parse(a)
+	bNumbers, bPre, bOK := parse(b)
+	if !aOK || !bOK {
+		return 0, false
+	}
+	for i := 0; i < max(len(aNumbers), len(bNumbers)); i++ {
+		var x, y int
+		if i < len(aNumbers) {
+			x = aNumbers[i]
+		}
+		if i < len(bNumbers) {
+			y = bNumbers[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0, true
+	case aPre == "":
+		return 1, true
+	case bPre == "":
+		return -1, true
+	}
+	return strings.Compare(aPre, bPre), true
+}
+
+// proxy-server version [--json] [--check [--url URL]]: in thông tin bản build, --check hỏi GitHub
+// xem có release mới hơn không (mã thoát 3 khi có bản mới, 1 khi không kiểm tra được)
+func runVersionCommand(args []string) int {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print build information as JSON")
+	check := flags.Bool("check", false, "check GitHub for a newer release")
+	url := flags.String("url", defaultReleaseURL, "latest release API URL used by --check")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: proxy-server version [--json] [--check [--url URL]]")
+		return 2
+	}
+
+	info := currentBuildInfo()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(info)
+	} else {
+		fmt.Println(info)
+	}
+	if !*check {
+		return 0
+	}
+
+	release, err := fetchLatestRelease(*url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to check for updates: %v\n", err)
+		return 1
+	}
+	comparison, comparable := compareVersions(info.Version, release.TagName)
+	switch {
+	case !comparable:
+		fmt.Printf("Latest release is %s (%s); this build (%s) cannot be compared.\n", release.TagName, release.HTMLURL, info.Version)
+		return 0
+	case comparison < 0:
+		fmt.Printf("Update available: %s, released %s: %s\n", release.TagName, release.PublishedAt.Format("2006-01-02"), release.HTMLURL)
+		return 3
+	}
+	fmt.Printf("Up to date: latest release is %s.\n", release.TagName)
+	return 0
+}